@@ -0,0 +1,80 @@
+package apikey
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql/driver"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// APIKey represents a key used by machine clients, only its hash is stored
+type APIKey struct {
+	ID           int64      `db:"apikey_id" json:"apikeyId"`
+	TenantID     string     `db:"tenant_id" json:"tenantId"`
+	Name         string     `db:"name" json:"name"`
+	Prefix       string     `db:"prefix" json:"prefix"`
+	Hash         string     `db:"hash" json:"-"`
+	Scopes       Scopes     `db:"scopes" json:"scopes"`
+	Roles        Scopes     `db:"roles" json:"roles"`
+	TimeCreated  time.Time  `db:"time_created" json:"timeCreated"`
+	TimeExpires  *time.Time `db:"time_expires" json:"timeExpires,omitempty"`
+	TimeLastUsed *time.Time `db:"time_last_used" json:"timeLastUsed,omitempty"`
+	TimeRevoked  *time.Time `db:"time_revoked" json:"timeRevoked,omitempty"`
+
+	// Key is the full key, only known when it is created or rotated
+	Key string `db:"-" json:"key,omitempty"`
+}
+
+// Valid returns false if the key is revoked or expired
+func (k *APIKey) Valid(now time.Time) bool {
+	if k.TimeRevoked != nil {
+		return false
+	}
+	return k.TimeExpires == nil || now.Before(*k.TimeExpires)
+}
+
+// Scopes are stored as a space separated list, as are the roles
+type Scopes []string
+
+// Value implements driver.Valuer
+func (s Scopes) Value() (driver.Value, error) {
+	return strings.Join(s, " "), nil
+}
+
+// Scan implements sql.Scanner
+func (s *Scopes) Scan(src interface{}) error {
+	switch v := src.(type) {
+	case nil:
+		*s = Scopes{}
+	case []byte:
+		*s = strings.Fields(string(v))
+	case string:
+		*s = strings.Fields(v)
+	default:
+		return fmt.Errorf("Scopes.Scan: unsupported type %T", src)
+	}
+	return nil
+}
+
+// generate returns a new key, its prefix and its hash.
+// The key is "<prefix>.<secret>", the prefix identifies the key and can be logged.
+func generate() (key, prefix, hash string, err error) {
+	b := make([]byte, 30)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", "", fmt.Errorf("generate: %v", err)
+	}
+	prefix = hex.EncodeToString(b[:6])
+	key = prefix + "." + base64.RawURLEncoding.EncodeToString(b[6:])
+
+	return key, prefix, hashKey(key), nil
+}
+
+// hashKey hashes the key, a fast hash is enough as keys are random
+func hashKey(key string) string {
+	h := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(h[:])
+}
@@ -0,0 +1,305 @@
+package apikey
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+
+	"github.com/vincentserpoul/gorestarter/pkg/rest/mid"
+)
+
+// ErrSQLNotFound is returned when no rows affected or found
+var ErrSQLNotFound = errors.New("no apikey found")
+
+// Create will generate a new key and store its hash in the DB, k.Key holds the key.
+// The key is bound to the context tenant.
+func (k *APIKey) Create(
+	ctx context.Context,
+	db *sqlx.DB,
+) error {
+	key, prefix, hash, errG := generate()
+	if errG != nil {
+		return fmt.Errorf("Create(%s): %v", k.Name, errG)
+	}
+	if k.Scopes == nil {
+		k.Scopes = Scopes{}
+	}
+	if k.Roles == nil {
+		k.Roles = Scopes{}
+	}
+	k.TenantID = mid.GetTenant(ctx)
+
+	res, err := db.NamedExecContext(
+		ctx,
+		mid.SQLComment(ctx, `
+			INSERT INTO apikey(tenant_id, name, prefix, hash, scopes, roles, time_expires)
+			VALUES (:tenantID, :name, :prefix, :hash, :scopes, :roles, :timeExpires)
+		`),
+		map[string]interface{}{
+			"tenantID":    k.TenantID,
+			"name":        k.Name,
+			"prefix":      prefix,
+			"hash":        hash,
+			"scopes":      k.Scopes,
+			"roles":       k.Roles,
+			"timeExpires": k.TimeExpires,
+		},
+	)
+	if err != nil {
+		return fmt.Errorf("Create(%s): %v", k.Name, err)
+	}
+
+	id, errL := res.LastInsertId()
+	if errL != nil {
+		return fmt.Errorf("Create(%s): %v", k.Name, errL)
+	}
+
+	k.ID = id
+	k.Prefix = prefix
+	k.Hash = hash
+	k.Key = key
+	k.TimeCreated = time.Now()
+
+	return nil
+}
+
+// queryFilter allows for select filters
+type queryFilter struct {
+	filterSQL   string
+	namedParams map[string]interface{}
+}
+
+// filterByID will filter the select query by apikey.ID
+func filterByID(apikeyID int64) queryFilter {
+	return queryFilter{
+		filterSQL: " AND apikey_id = :apikeyID ",
+		namedParams: map[string]interface{}{
+			"apikeyID": apikeyID,
+		},
+	}
+}
+
+// filterByTenant will filter the select query by apikey.TenantID
+func filterByTenant(tenantID string) queryFilter {
+	return queryFilter{
+		filterSQL: " AND tenant_id = :tenantID ",
+		namedParams: map[string]interface{}{
+			"tenantID": tenantID,
+		},
+	}
+}
+
+// filterByPrefix will filter the select query by apikey.Prefix
+func filterByPrefix(prefix string) queryFilter {
+	return queryFilter{
+		filterSQL: " AND prefix = :prefix ",
+		namedParams: map[string]interface{}{
+			"prefix": prefix,
+		},
+	}
+}
+
+// SelectByID returns one apikey
+func SelectByID(
+	ctx context.Context,
+	db *sqlx.DB,
+	apikeyID int64,
+) (*APIKey, error) {
+	ks, err := selectsql(ctx, db, filterByID(apikeyID))
+	if err != nil {
+		return nil, fmt.Errorf("SelectByID(%d): %v", apikeyID, err)
+	}
+
+	if len(ks) == 0 {
+		return nil, ErrSQLNotFound
+	}
+
+	return ks[0], nil
+}
+
+// SelectByPrefix returns the apikey identified by prefix
+func SelectByPrefix(
+	ctx context.Context,
+	db *sqlx.DB,
+	prefix string,
+) (*APIKey, error) {
+	ks, err := selectsql(ctx, db, filterByPrefix(prefix))
+	if err != nil {
+		return nil, fmt.Errorf("SelectByPrefix(%s): %v", prefix, err)
+	}
+
+	if len(ks) == 0 {
+		return nil, ErrSQLNotFound
+	}
+
+	return ks[0], nil
+}
+
+// SelectAll returns all the apikeys of the context tenant, revoked ones included
+func SelectAll(
+	ctx context.Context,
+	db *sqlx.DB,
+) ([]*APIKey, error) {
+	ks, err := selectsql(ctx, db, filterByTenant(mid.GetTenant(ctx)))
+	if err != nil {
+		return nil, fmt.Errorf("SelectAll: %v", err)
+	}
+
+	if len(ks) == 0 {
+		return nil, ErrSQLNotFound
+	}
+
+	return ks, nil
+}
+
+// selectsql will get apikeys from the DB
+func selectsql(
+	ctx context.Context,
+	db *sqlx.DB,
+	queryFilters ...queryFilter,
+) ([]*APIKey, error) {
+	query := `SELECT apikey_id, tenant_id, name, prefix, hash, scopes, roles, time_created,
+					time_expires, time_last_used, time_revoked
+				FROM apikey
+				WHERE 0=0 `
+
+	namedParams := make(map[string]interface{})
+
+	// merge filters into the query
+	for _, filter := range queryFilters {
+		query += filter.filterSQL
+		for k, v := range filter.namedParams {
+			namedParams[k] = v
+		}
+	}
+
+	rows, err := db.NamedQueryContext(ctx, mid.SQLComment(ctx, query), namedParams)
+	if err != nil {
+		return nil, fmt.Errorf("Select(%v): %v", queryFilters, err)
+	}
+	defer rows.Close()
+
+	var ks []*APIKey
+	for rows.Next() {
+		k := &APIKey{}
+		err := rows.StructScan(k)
+		if err != nil {
+			return nil, fmt.Errorf("Select(%v): %v", queryFilters, err)
+		}
+		ks = append(ks, k)
+	}
+
+	return ks, nil
+}
+
+// Rotate replaces the key of a non revoked apikey of the context tenant,
+// the returned apikey holds the new key
+func Rotate(
+	ctx context.Context,
+	db *sqlx.DB,
+	apikeyID int64,
+) (*APIKey, error) {
+	key, prefix, hash, errG := generate()
+	if errG != nil {
+		return nil, fmt.Errorf("Rotate(%d): %v", apikeyID, errG)
+	}
+
+	res, err := db.NamedExecContext(
+		ctx,
+		mid.SQLComment(ctx, `
+			UPDATE apikey
+				SET prefix = :prefix,
+					hash = :hash
+			WHERE apikey_id = :apikeyID
+				AND tenant_id = :tenantID
+				AND time_revoked IS NULL
+		`),
+		map[string]interface{}{
+			"prefix":   prefix,
+			"hash":     hash,
+			"apikeyID": apikeyID,
+			"tenantID": mid.GetTenant(ctx),
+		},
+	)
+	if err != nil {
+		return nil, fmt.Errorf("Rotate(%d): %v", apikeyID, err)
+	}
+
+	ra, errRA := res.RowsAffected()
+	if errRA != nil {
+		return nil, fmt.Errorf("Rotate(%d): %v", apikeyID, errRA)
+	}
+	if ra == 0 {
+		return nil, ErrSQLNotFound
+	}
+
+	k, errS := SelectByID(ctx, db, apikeyID)
+	if errS != nil {
+		return nil, fmt.Errorf("Rotate(%d): %v", apikeyID, errS)
+	}
+	k.Key = key
+
+	return k, nil
+}
+
+// Revoke will revoke an apikey of the context tenant, it is kept in the DB for auditing
+func Revoke(
+	ctx context.Context,
+	db *sqlx.DB,
+	apikeyID int64,
+) error {
+	res, err := db.NamedExecContext(
+		ctx,
+		mid.SQLComment(ctx, `
+			UPDATE apikey
+				SET time_revoked = NOW()
+			WHERE apikey_id = :apikeyID
+				AND tenant_id = :tenantID
+				AND time_revoked IS NULL
+		`),
+		map[string]interface{}{
+			"apikeyID": apikeyID,
+			"tenantID": mid.GetTenant(ctx),
+		},
+	)
+	if err != nil {
+		return fmt.Errorf("Revoke(%d): %v", apikeyID, err)
+	}
+
+	ra, errRA := res.RowsAffected()
+	if errRA != nil {
+		return fmt.Errorf("Revoke(%d): %v", apikeyID, errRA)
+	}
+	if ra == 0 {
+		return ErrSQLNotFound
+	}
+
+	return nil
+}
+
+// updateLastUsed sets the last used time of an apikey to now
+func updateLastUsed(
+	ctx context.Context,
+	db *sqlx.DB,
+	apikeyID int64,
+) error {
+	_, err := db.NamedExecContext(
+		ctx,
+		mid.SQLComment(ctx, `
+			UPDATE apikey
+				SET time_last_used = NOW()
+			WHERE apikey_id = :apikeyID
+		`),
+		map[string]interface{}{
+			"apikeyID": apikeyID,
+		},
+	)
+	if err != nil {
+		return fmt.Errorf("updateLastUsed(%d): %v", apikeyID, err)
+	}
+
+	return nil
+}
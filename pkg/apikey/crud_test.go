@@ -0,0 +1,159 @@
+package apikey
+
+import (
+	"context"
+	"log"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+
+	"github.com/vincentserpoul/gorestarter/pkg/storage"
+)
+
+func TestAPIKey_Create(t *testing.T) {
+	k := &APIKey{Name: `test`, Scopes: Scopes{"read", "write"}}
+	err := k.Create(context.Background(), pool)
+	if err != nil {
+		t.Errorf("creation triggered an error %v", err)
+		return
+	}
+
+	if k.ID == 0 || k.Key == "" || k.Prefix == "" {
+		t.Errorf("creation didn't give back an id, a key and its prefix")
+		return
+	}
+
+	ks, errS := SelectByPrefix(context.Background(), pool, k.Prefix)
+	if errS != nil {
+		t.Errorf("SelectByPrefix triggered an error %v", errS)
+		return
+	}
+	if ks.Hash != hashKey(k.Key) {
+		t.Errorf("creation didn't store the key hash")
+	}
+	if !reflect.DeepEqual(ks.Scopes, k.Scopes) {
+		t.Errorf("creation didn't store the scopes, got %v", ks.Scopes)
+	}
+}
+
+func TestSelectAll(t *testing.T) {
+	k := &APIKey{Name: `test`}
+	_ = k.Create(context.Background(), pool)
+
+	ks, err := SelectAll(context.Background(), pool)
+	if err != nil {
+		t.Errorf("SelectAll triggered an error %v", err)
+		return
+	}
+
+	for _, e := range ks {
+		if e.ID == k.ID {
+			return
+		}
+	}
+	t.Errorf("SelectAll didn't give back the created apikey")
+}
+
+func TestRotate(t *testing.T) {
+	k := &APIKey{Name: `test`}
+	_ = k.Create(context.Background(), pool)
+
+	rk, err := Rotate(context.Background(), pool, k.ID)
+	if err != nil {
+		t.Errorf("Rotate triggered an error %v", err)
+		return
+	}
+	if rk.Key == k.Key || rk.Prefix == k.Prefix || rk.Hash != hashKey(rk.Key) {
+		t.Errorf("Rotate didn't replace the key")
+	}
+
+	if _, errS := SelectByPrefix(context.Background(), pool, k.Prefix); errS != ErrSQLNotFound {
+		t.Errorf("Rotate kept the old prefix")
+	}
+}
+
+func TestRevoke(t *testing.T) {
+	k := &APIKey{Name: `test`}
+	_ = k.Create(context.Background(), pool)
+
+	tests := []struct {
+		name    string
+		id      int64
+		wantErr error
+	}{
+		{name: "revoke", id: k.ID},
+		{name: "already revoked", id: k.ID, wantErr: ErrSQLNotFound},
+		{name: "unknown", id: -1, wantErr: ErrSQLNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := Revoke(context.Background(), pool, tt.id); err != tt.wantErr {
+				t.Errorf("Revoke() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+
+	rk, _ := SelectByID(context.Background(), pool, k.ID)
+	if rk == nil || rk.Valid(time.Now()) {
+		t.Errorf("Revoke didn't invalidate the apikey")
+	}
+	if _, err := Rotate(context.Background(), pool, k.ID); err != ErrSQLNotFound {
+		t.Errorf("Rotate of a revoked apikey should fail, got %v", err)
+	}
+}
+
+var pool *sqlx.DB
+
+func TestMain(m *testing.M) {
+	ctx := context.Background()
+
+	var err error
+	newConnPool, err := storage.NewMySQLDBConnPool(&storage.MySQLDBConf{
+		Protocol: "tcp",
+		Host:     "127.0.0.1",
+		Port:     "3306",
+		User:     "internal",
+		Password: "dev",
+		DbName:   "test",
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer func() {
+		errClose := newConnPool.Close()
+		if errClose != nil {
+			log.Fatalf("%v", errClose)
+		}
+	}()
+
+	pool = newConnPool
+
+	ddls := []DDL{DDL{}}
+
+	for _, ddl := range ddls {
+		errD := ddl.MigrateDown(ctx, pool)
+		if errD != nil {
+			log.Fatal(errD)
+		}
+		errU := ddl.MigrateUp(ctx, pool)
+		if errU != nil {
+			log.Fatal(errU)
+		}
+	}
+
+	retCode := m.Run()
+
+	for _, ddl := range ddls {
+		errD := ddl.MigrateDown(ctx, pool)
+		if errD != nil {
+			log.Fatal(errD)
+		}
+	}
+
+	os.Exit(retCode)
+
+}
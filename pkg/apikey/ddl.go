@@ -0,0 +1,82 @@
+package apikey
+
+import (
+	"context"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// DDL is used to do modifications in the DB
+type DDL struct{}
+
+// Version is the schema version of MigrateUp
+func (ddl *DDL) Version() int {
+	return 2
+}
+
+// MigrateUp creates the needed tables
+func (ddl *DDL) MigrateUp(ctx context.Context, db *sqlx.DB) error {
+	_, errExec := db.ExecContext(
+		ctx,
+		`
+            CREATE TABLE IF NOT EXISTS apikey (
+                apikey_id BIGINT NOT NULL AUTO_INCREMENT,
+                tenant_id VARCHAR(63) NOT NULL DEFAULT '',
+                name VARCHAR(100) NOT NULL,
+                prefix CHAR(12) NOT NULL,
+                hash CHAR(64) NOT NULL,
+                scopes VARCHAR(1000) NOT NULL DEFAULT '',
+                roles VARCHAR(1000) NOT NULL DEFAULT '',
+                time_created DATETIME NOT NULL DEFAULT NOW(),
+                time_expires DATETIME NULL,
+                time_last_used DATETIME NULL,
+                time_revoked DATETIME NULL,
+                PRIMARY KEY (apikey_id),
+                UNIQUE INDEX a_p_idx (prefix),
+                INDEX a_t_idx (tenant_id)
+            );
+    `)
+	if errExec != nil {
+		return errExec
+	}
+
+	// Tables created before the roles existed
+	return ddl.addColumn(ctx, db, "roles", `
+			ALTER TABLE apikey
+				ADD COLUMN roles VARCHAR(1000) NOT NULL DEFAULT '' AFTER scopes;
+    `)
+}
+
+// addColumn runs alterSQL if the apikey table doesn't have column yet
+func (ddl *DDL) addColumn(ctx context.Context, db *sqlx.DB, column string, alterSQL string) error {
+	var count int
+	errC := db.GetContext(
+		ctx,
+		&count,
+		`
+			SELECT COUNT(*) FROM information_schema.COLUMNS
+			WHERE TABLE_SCHEMA = DATABASE()
+				AND TABLE_NAME = 'apikey'
+				AND COLUMN_NAME = ?
+		`,
+		column,
+	)
+	if errC != nil || count > 0 {
+		return errC
+	}
+
+	_, errExec := db.ExecContext(ctx, alterSQL)
+
+	return errExec
+}
+
+// MigrateDown destroys the needed tables
+func (ddl *DDL) MigrateDown(ctx context.Context, db *sqlx.DB) error {
+	_, errExec := db.ExecContext(
+		ctx,
+		`
+        DROP TABLE IF EXISTS apikey;
+    `)
+
+	return errExec
+}
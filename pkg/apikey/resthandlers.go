@@ -0,0 +1,217 @@
+package apikey
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/go-chi/render"
+
+	"github.com/vincentserpoul/gorestarter/pkg/rest/mid"
+	"github.com/vincentserpoul/gorestarter/pkg/rest/renderer"
+)
+
+// AdminRole is the role needed to manage apikeys
+const AdminRole = "admin"
+
+// SuperAdminRole is the role needed for the admin routes exposing every tenant,
+// such as the profiles and the configuration, it can't be tied to a tenant
+const SuperAdminRole = "superadmin"
+
+// AdminRouter is returning the handler managing apikeys, it must be mounted behind
+// an authentication middleware, only principals with AdminRole are allowed.
+// They can only create keys of their tenant, with roles they have.
+func AdminRouter(s *Store) http.Handler {
+	r := chi.NewRouter()
+	r.NotFound(renderer.NotFoundHandler)
+	r.MethodNotAllowed(renderer.MethodNotAllowedHandler)
+	r.Use(requireAdmin)
+
+	r.Post("/", POSTHandler(s))
+	r.Get("/", GETListHandler(s))
+	r.Route("/{apikeyID}", func(r chi.Router) {
+		r.Post("/rotate", RotateHandler(s))
+		r.Delete("/", DELETEHandler(s))
+	})
+
+	return r
+}
+
+// requireAdmin only lets principals with AdminRole through
+func requireAdmin(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if p := mid.GetPrincipal(r.Context()); p == nil || !p.HasRole(AdminRole) {
+			errRender := render.Render(w, r, renderer.ErrForbidden(errors.New("requireAdmin: admin role needed")))
+			if errRender != nil {
+				mid.LoggerFrom(r.Context()).Errorf("requireAdmin: render error %v", errRender)
+			}
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// hasRole reports whether role is one of roles
+func hasRole(roles []string, role string) bool {
+	for _, r := range roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+// createRequest is the body of a POST
+type createRequest struct {
+	Name        string     `json:"name"`
+	Scopes      []string   `json:"scopes"`
+	Roles       []string   `json:"roles"`
+	TimeExpires *time.Time `json:"timeExpires"`
+}
+
+// POSTHandler creates an apikey, the key is only sent in this response
+func POSTHandler(s *Store) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// Handler errors after rendering
+		var errRender error
+		defer func() {
+			if errRender != nil {
+				mid.LoggerFrom(r.Context()).Errorf("POSTHandler: render error %v", errRender)
+			}
+		}()
+
+		req := &createRequest{}
+		errJSON := renderer.DecodeJSON(r, req)
+		if errJSON != nil {
+			errRender = render.Render(w, r, renderer.ErrDecode(errJSON))
+			return
+		}
+		if req.Name == "" {
+			errRender = render.Render(w, r, renderer.ErrInvalidRequest(errors.New("POSTHandler: name missing")))
+			return
+		}
+
+		// Callers only give what they have, to keys of their own tenant
+		p := mid.GetPrincipal(r.Context())
+		for _, role := range req.Roles {
+			if !p.HasRole(role) {
+				errRender = render.Render(w, r, renderer.ErrForbidden(fmt.Errorf("POSTHandler: role %s not held", role)))
+				return
+			}
+		}
+		if p.Tenant != "" && p.Tenant != mid.GetTenant(r.Context()) {
+			errRender = render.Render(w, r, renderer.ErrForbidden(fmt.Errorf("POSTHandler: tenant %s not the caller tenant", mid.GetTenant(r.Context()))))
+			return
+		}
+		if tenant := mid.GetTenant(r.Context()); tenant != "" && hasRole(req.Roles, SuperAdminRole) {
+			errRender = render.Render(w, r, renderer.ErrInvalidRequest(fmt.Errorf("POSTHandler: role %s given in tenant %s", SuperAdminRole, tenant)))
+			return
+		}
+
+		k := &APIKey{Name: req.Name, Scopes: req.Scopes, Roles: req.Roles, TimeExpires: req.TimeExpires}
+		err := k.Create(r.Context(), s.db)
+		if err != nil {
+			errRender = render.Render(w, r, renderer.ErrServer(r.Context(), err))
+			return
+		}
+
+		w.WriteHeader(http.StatusCreated)
+		renderer.ResponseJSONRender(w, r, k)
+	}
+}
+
+// GETListHandler lists the apikeys, without their keys
+func GETListHandler(s *Store) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// Handler errors after rendering
+		var errRender error
+		defer func() {
+			if errRender != nil {
+				mid.LoggerFrom(r.Context()).Errorf("GETListHandler: render error %v", errRender)
+			}
+		}()
+
+		ks, errS := SelectAll(r.Context(), s.db)
+		if errS == ErrSQLNotFound {
+			errRender = render.Render(w, r, renderer.ErrNotFound())
+			return
+		}
+		if errS != nil {
+			errRender = render.Render(w, r, renderer.ErrServer(r.Context(), errS))
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+		renderer.ResponseJSONListRender(w, r, ks)
+	}
+}
+
+// RotateHandler replaces the key of an apikey, the new key is only sent in this response
+func RotateHandler(s *Store) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// Handler errors after rendering
+		var errRender error
+		defer func() {
+			if errRender != nil {
+				mid.LoggerFrom(r.Context()).Errorf("RotateHandler: render error %v", errRender)
+			}
+		}()
+
+		apikeyIDstr := chi.URLParam(r, "apikeyID")
+		apikeyID, errConv := strconv.ParseInt(apikeyIDstr, 10, 64)
+		if apikeyIDstr == "" || errConv != nil {
+			errRender = render.Render(w, r, renderer.ErrInvalidRequest(errConv))
+			return
+		}
+
+		k, errR := Rotate(r.Context(), s.db, apikeyID)
+		if errR == ErrSQLNotFound {
+			errRender = render.Render(w, r, renderer.ErrNotFound())
+			return
+		}
+		if errR != nil {
+			errRender = render.Render(w, r, renderer.ErrServer(r.Context(), errR))
+			return
+		}
+		s.Forget(apikeyID)
+
+		w.WriteHeader(http.StatusOK)
+		renderer.ResponseJSONRender(w, r, k)
+	}
+}
+
+// DELETEHandler revokes an apikey
+func DELETEHandler(s *Store) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// Handler errors after rendering
+		var errRender error
+		defer func() {
+			if errRender != nil {
+				mid.LoggerFrom(r.Context()).Errorf("DELETEHandler: render error %v", errRender)
+			}
+		}()
+
+		apikeyIDstr := chi.URLParam(r, "apikeyID")
+		apikeyID, errConv := strconv.ParseInt(apikeyIDstr, 10, 64)
+		if apikeyIDstr == "" || errConv != nil {
+			errRender = render.Render(w, r, renderer.ErrInvalidRequest(errConv))
+			return
+		}
+
+		errD := Revoke(r.Context(), s.db, apikeyID)
+		if errD == ErrSQLNotFound {
+			errRender = render.Render(w, r, renderer.ErrNotFound())
+			return
+		}
+		if errD != nil {
+			errRender = render.Render(w, r, renderer.ErrServer(r.Context(), errD))
+			return
+		}
+		s.Forget(apikeyID)
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
@@ -0,0 +1,156 @@
+package apikey
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/vincentserpoul/gorestarter/pkg/rest/mid"
+)
+
+func adminRequest(method, url, body string, roles ...string) *http.Request {
+	request, _ := http.NewRequest(method, url, bytes.NewBufferString(body))
+	request.Header.Set("Content-Type", "application/json")
+	return request.WithContext(mid.WithPrincipal(request.Context(), &mid.Principal{ID: "admin", Roles: roles}))
+}
+
+func TestAdminRouter(t *testing.T) {
+	router := AdminRouter(NewStore(pool, time.Minute))
+
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, adminRequest("POST", "/", `{"name": "test", "scopes": ["read"]}`, AdminRole))
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("POST returned wrong status code: got %v want %v", rr.Code, http.StatusCreated)
+	}
+	created := &APIKey{}
+	if err := json.Unmarshal(rr.Body.Bytes(), created); err != nil || created.Key == "" {
+		t.Fatalf("POST didn't return the key: %s", rr.Body.String())
+	}
+
+	tests := []struct {
+		name         string
+		method       string
+		URL          string
+		body         string
+		roles        []string
+		wantedStatus int
+	}{
+		{
+			name:         "create without admin role",
+			method:       "POST",
+			URL:          "/",
+			body:         `{"name": "test"}`,
+			wantedStatus: http.StatusForbidden,
+		},
+		{
+			name:         "create without name",
+			method:       "POST",
+			URL:          "/",
+			body:         `{"scopes": ["read"]}`,
+			roles:        []string{AdminRole},
+			wantedStatus: http.StatusBadRequest,
+		},
+		{
+			name:         "create with a role the caller lacks",
+			method:       "POST",
+			URL:          "/",
+			body:         `{"name": "test", "roles": ["owner"]}`,
+			roles:        []string{AdminRole},
+			wantedStatus: http.StatusForbidden,
+		},
+		{
+			name:         "create with the caller roles",
+			method:       "POST",
+			URL:          "/",
+			body:         `{"name": "test", "roles": ["admin"]}`,
+			roles:        []string{AdminRole},
+			wantedStatus: http.StatusCreated,
+		},
+		{
+			name:         "list",
+			method:       "GET",
+			URL:          "/",
+			roles:        []string{AdminRole},
+			wantedStatus: http.StatusOK,
+		},
+		{
+			name:         "rotate",
+			method:       "POST",
+			URL:          fmt.Sprintf("/%d/rotate", created.ID),
+			roles:        []string{AdminRole},
+			wantedStatus: http.StatusOK,
+		},
+		{
+			name:         "rotate with invalid id",
+			method:       "POST",
+			URL:          "/abc/rotate",
+			roles:        []string{AdminRole},
+			wantedStatus: http.StatusBadRequest,
+		},
+		{
+			name:         "revoke",
+			method:       "DELETE",
+			URL:          fmt.Sprintf("/%d", created.ID),
+			roles:        []string{AdminRole},
+			wantedStatus: http.StatusNoContent,
+		},
+		{
+			name:         "revoke twice",
+			method:       "DELETE",
+			URL:          fmt.Sprintf("/%d", created.ID),
+			roles:        []string{AdminRole},
+			wantedStatus: http.StatusNotFound,
+		},
+		{
+			name:         "rotate revoked",
+			method:       "POST",
+			URL:          fmt.Sprintf("/%d/rotate", created.ID),
+			roles:        []string{AdminRole},
+			wantedStatus: http.StatusNotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, adminRequest(tt.method, tt.URL, tt.body, tt.roles...))
+
+			if status := rr.Code; status != tt.wantedStatus {
+				t.Errorf("AdminRouter returned wrong status code: got %v want %v",
+					status, tt.wantedStatus)
+			}
+		})
+	}
+}
+
+func TestAdminRouterOtherTenant(t *testing.T) {
+	router := AdminRouter(NewStore(pool, time.Minute))
+
+	request, _ := http.NewRequest("POST", "/", bytes.NewBufferString(`{"name": "test"}`))
+	request.Header.Set("Content-Type", "application/json")
+	ctx := mid.WithPrincipal(request.Context(), &mid.Principal{ID: "admin", Roles: []string{AdminRole}, Tenant: "acme"})
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, request.WithContext(mid.WithTenant(ctx, "other")))
+
+	if rr.Code != http.StatusForbidden {
+		t.Errorf("POST for another tenant returned wrong status code: got %v want %v", rr.Code, http.StatusForbidden)
+	}
+}
+
+func TestAdminRouterSuperAdminInTenant(t *testing.T) {
+	router := AdminRouter(NewStore(pool, time.Minute))
+
+	request, _ := http.NewRequest("POST", "/", bytes.NewBufferString(`{"name": "test", "roles": ["superadmin"]}`))
+	request.Header.Set("Content-Type", "application/json")
+	ctx := mid.WithPrincipal(request.Context(), &mid.Principal{ID: "admin", Roles: []string{AdminRole, SuperAdminRole}, Tenant: "acme"})
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, request.WithContext(mid.WithTenant(ctx, "acme")))
+
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("POST of a super admin in a tenant returned wrong status code: got %v want %v", rr.Code, http.StatusBadRequest)
+	}
+}
@@ -0,0 +1,170 @@
+package apikey
+
+import (
+	"context"
+	"crypto/subtle"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+
+	"github.com/vincentserpoul/gorestarter/pkg/rest/mid"
+)
+
+// maxCachedKeys bounds the number of keys kept in memory
+const maxCachedKeys = 1024
+
+// MaxRevocationDelay bounds how long a key revoked or rotated by another instance is
+// still accepted, its cache entry is refreshed from the DB after it, whatever the TTL
+const MaxRevocationDelay = 10 * time.Second
+
+// Store authenticates apikeys against the DB, keeping them in a small cache
+type Store struct {
+	db  *sqlx.DB
+	ttl time.Duration
+
+	// bootstrap is the hash of the key configured to create the first keys of bootstrapTenant
+	bootstrap       string
+	bootstrapTenant string
+
+	mu    sync.Mutex
+	cache map[string]*cachedKey
+}
+
+// cachedKey caches a key, or that its prefix is unknown if key is nil
+type cachedKey struct {
+	key      *APIKey
+	cachedAt time.Time
+	usedAt   time.Time
+}
+
+// NewStore returns a Store caching keys for ttl, which also bounds
+// how often their last used time is written
+func NewStore(db *sqlx.DB, ttl time.Duration) *Store {
+	return &Store{
+		db:    db,
+		ttl:   ttl,
+		cache: make(map[string]*cachedKey),
+	}
+}
+
+// BootstrapPrincipalID is the ID of the principal authenticated by the bootstrap key
+var BootstrapPrincipalID = mid.PrincipalID("apikey", "bootstrap")
+
+// SetBootstrapKey configures a key, not stored in DB, authenticated as an admin of tenant
+// so that the first keys can be created. It should be removed once they are.
+// Without tenant, it is also a super admin.
+func (s *Store) SetBootstrapKey(key string, tenant string) {
+	s.bootstrap = ""
+	s.bootstrapTenant = tenant
+	if key != "" {
+		s.bootstrap = hashKey(key)
+	}
+}
+
+// Authenticate implements mid.APIKeyAuthenticator
+func (s *Store) Authenticate(ctx context.Context, key string) (*mid.Principal, error) {
+	prefix := mid.APIKeyPrefix(key)
+	if prefix == "" {
+		return nil, mid.ErrAPIKeyInvalid
+	}
+
+	if s.bootstrap != "" &&
+		subtle.ConstantTimeCompare([]byte(hashKey(key)), []byte(s.bootstrap)) == 1 {
+		p := &mid.Principal{
+			ID:     BootstrapPrincipalID,
+			Method: "apikey",
+			Roles:  []string{AdminRole},
+			Tenant: s.bootstrapTenant,
+		}
+		// Without tenant, it is the super admin of every tenant
+		if p.Tenant == "" {
+			p.Roles = append(p.Roles, SuperAdminRole)
+		}
+		return p, nil
+	}
+
+	now := time.Now()
+	s.mu.Lock()
+	c, ok := s.cache[prefix]
+	s.mu.Unlock()
+
+	if !ok || now.Sub(c.cachedAt) > s.refreshAfter(c) {
+		k, err := SelectByPrefix(ctx, s.db, prefix)
+		if err != nil && err != ErrSQLNotFound {
+			return nil, err
+		}
+		// Unknown prefixes are cached too, so that random keys don't hit the DB
+		c = &cachedKey{key: k, cachedAt: now}
+		s.put(prefix, c)
+	}
+
+	if c.key == nil ||
+		subtle.ConstantTimeCompare([]byte(hashKey(key)), []byte(c.key.Hash)) != 1 ||
+		!c.key.Valid(now) {
+		return nil, mid.ErrAPIKeyInvalid
+	}
+
+	s.mu.Lock()
+	touch := now.Sub(c.usedAt) > s.ttl
+	if touch {
+		c.usedAt = now
+	}
+	s.mu.Unlock()
+	if touch {
+		// Best effort, a failure must not prevent the request
+		_ = updateLastUsed(ctx, s.db, c.key.ID)
+	}
+
+	return &mid.Principal{
+		ID:     mid.PrincipalID("apikey", strconv.FormatInt(c.key.ID, 10)),
+		Method: "apikey",
+		Scopes: c.key.Scopes,
+		Roles:  c.key.Roles,
+		Tenant: c.key.TenantID,
+	}, nil
+}
+
+// refreshAfter returns how long c is kept, the known keys are checked
+// against the DB at least every MaxRevocationDelay
+func (s *Store) refreshAfter(c *cachedKey) time.Duration {
+	if c.key != nil && s.ttl > MaxRevocationDelay {
+		return MaxRevocationDelay
+	}
+	return s.ttl
+}
+
+// put caches c, evicting expired keys when the cache is full
+func (s *Store) put(prefix string, c *cachedKey) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.cache) >= maxCachedKeys {
+		for p, e := range s.cache {
+			if c.cachedAt.Sub(e.cachedAt) > s.ttl {
+				delete(s.cache, p)
+			}
+		}
+	}
+	if len(s.cache) >= maxCachedKeys {
+		for p := range s.cache {
+			delete(s.cache, p)
+			break
+		}
+	}
+	s.cache[prefix] = c
+}
+
+// Forget removes an apikey from the cache, after it is rotated or revoked.
+// The other instances refuse it after MaxRevocationDelay at most.
+func (s *Store) Forget(apikeyID int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for p, c := range s.cache {
+		if c.key != nil && c.key.ID == apikeyID {
+			delete(s.cache, p)
+		}
+	}
+}
@@ -0,0 +1,121 @@
+package apikey
+
+import (
+	"context"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/vincentserpoul/gorestarter/pkg/rest/mid"
+)
+
+func TestStore_Authenticate(t *testing.T) {
+	ctx := context.Background()
+	s := NewStore(pool, time.Minute)
+
+	valid := &APIKey{Name: `valid`, Scopes: Scopes{"read"}, Roles: Scopes{AdminRole}}
+	_ = valid.Create(ctx, pool)
+	past := time.Now().Add(-time.Hour)
+	expired := &APIKey{Name: `expired`, TimeExpires: &past}
+	_ = expired.Create(ctx, pool)
+
+	tests := []struct {
+		name    string
+		key     string
+		wantID  string
+		wantErr error
+	}{
+		{name: "valid", key: valid.Key, wantID: mid.PrincipalID("apikey", strconv.FormatInt(valid.ID, 10))},
+		{name: "wrong secret", key: valid.Prefix + ".wrong", wantErr: mid.ErrAPIKeyInvalid},
+		{name: "unknown prefix", key: "000000000000.secret", wantErr: mid.ErrAPIKeyInvalid},
+		{name: "no prefix", key: "secret", wantErr: mid.ErrAPIKeyInvalid},
+		{name: "expired", key: expired.Key, wantErr: mid.ErrAPIKeyInvalid},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := s.Authenticate(ctx, tt.key)
+			if err != tt.wantErr {
+				t.Errorf("Authenticate() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if err == nil && (p.ID != tt.wantID || p.Method != "apikey" || !p.HasScope("read") || !p.HasRole(AdminRole)) {
+				t.Errorf("Authenticate() unexpected principal %+v", p)
+			}
+		})
+	}
+
+	k, _ := SelectByID(ctx, pool, valid.ID)
+	if k == nil || k.TimeLastUsed == nil {
+		t.Errorf("Authenticate() didn't set the last used time")
+	}
+
+	// Revoked keys are refused once forgotten by the cache
+	_ = Revoke(ctx, pool, valid.ID)
+	s.Forget(valid.ID)
+	if _, err := s.Authenticate(ctx, valid.Key); err != mid.ErrAPIKeyInvalid {
+		t.Errorf("Authenticate() of a revoked key error = %v", err)
+	}
+}
+
+func TestStore_AuthenticateBootstrap(t *testing.T) {
+	ctx := context.Background()
+	s := NewStore(pool, time.Minute)
+	s.SetBootstrapKey("bootstrap.secret", "acme")
+
+	p, err := s.Authenticate(ctx, "bootstrap.secret")
+	if err != nil || p.ID != BootstrapPrincipalID || !p.HasRole(AdminRole) || p.HasRole(SuperAdminRole) || p.Tenant != "acme" {
+		t.Errorf("Authenticate() of the bootstrap key = %+v, %v", p, err)
+	}
+	if _, err := s.Authenticate(ctx, "bootstrap.wrong"); err != mid.ErrAPIKeyInvalid {
+		t.Errorf("Authenticate() of a wrong bootstrap key error = %v", err)
+	}
+
+	// Without tenant, it is a super admin
+	s.SetBootstrapKey("bootstrap.secret", "")
+	if p, err := s.Authenticate(ctx, "bootstrap.secret"); err != nil || !p.HasRole(SuperAdminRole) {
+		t.Errorf("Authenticate() of the bootstrap key without tenant = %+v, %v", p, err)
+	}
+
+	// Unset, the bootstrap key is an unknown key
+	s.SetBootstrapKey("", "")
+	if _, err := s.Authenticate(ctx, "bootstrap.secret"); err != mid.ErrAPIKeyInvalid {
+		t.Errorf("Authenticate() of a removed bootstrap key error = %v", err)
+	}
+}
+
+func TestStore_AuthenticateUnknownCached(t *testing.T) {
+	ctx := context.Background()
+	s := NewStore(pool, time.Minute)
+
+	if _, err := s.Authenticate(ctx, "ffffffffffff.secret"); err != mid.ErrAPIKeyInvalid {
+		t.Fatalf("Authenticate() error = %v", err)
+	}
+	c, ok := s.cache["ffffffffffff"]
+	if !ok || c.key != nil {
+		t.Errorf("Authenticate() didn't cache the unknown prefix")
+	}
+	if _, err := s.Authenticate(ctx, "ffffffffffff.secret"); err != mid.ErrAPIKeyInvalid {
+		t.Errorf("Authenticate() from the cache error = %v", err)
+	}
+}
+
+func TestStore_RevokedElsewhere(t *testing.T) {
+	ctx := context.Background()
+	s := NewStore(pool, time.Hour)
+
+	k := &APIKey{Name: `revoked elsewhere`}
+	_ = k.Create(ctx, pool)
+	if _, err := s.Authenticate(ctx, k.Key); err != nil {
+		t.Fatalf("Authenticate() error = %v", err)
+	}
+
+	// Revoked by another instance, the cached key is checked again after MaxRevocationDelay
+	_ = Revoke(ctx, pool, k.ID)
+	s.mu.Lock()
+	s.cache[k.Prefix].cachedAt = time.Now().Add(-MaxRevocationDelay - time.Second)
+	s.mu.Unlock()
+	if _, err := s.Authenticate(ctx, k.Key); err != mid.ErrAPIKeyInvalid {
+		t.Errorf("Authenticate() of a key revoked elsewhere error = %v", err)
+	}
+}
@@ -0,0 +1,148 @@
+package health
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// Check returns an error when a dependency is unhealthy
+type Check func(ctx context.Context) error
+
+// Result is the outcome of a check
+type Result struct {
+	Name       string  `json:"name"`
+	Healthy    bool    `json:"healthy"`
+	Error      string  `json:"error,omitempty"`
+	DurationMs float64 `json:"durationMs"`
+}
+
+// Report is the outcome of all the checks
+type Report struct {
+	Healthy bool      `json:"healthy"`
+	Time    time.Time `json:"time"`
+	Checks  []Result  `json:"checks"`
+}
+
+// Err returns the error of the first failed check, nil if healthy
+func (rep *Report) Err() error {
+	for _, res := range rep.Checks {
+		if !res.Healthy {
+			return fmt.Errorf("%s: %s", res.Name, res.Error)
+		}
+	}
+	return nil
+}
+
+// copy returns a report that can be modified without changing the cached one
+func (rep *Report) copy() Report {
+	cp := *rep
+	cp.Checks = append([]Result(nil), rep.Checks...)
+	return cp
+}
+
+type namedCheck struct {
+	name  string
+	check Check
+}
+
+// Checker runs the registered checks, its reports are cached
+// so that frequent probes don't overload the dependencies
+type Checker struct {
+	ttl     time.Duration
+	timeout time.Duration
+
+	mu     sync.Mutex
+	checks []namedCheck
+	report *Report
+}
+
+// NewChecker returns a checker caching its reports for ttl,
+// each check is cancelled after timeout, 1s if 0
+func NewChecker(ttl, timeout time.Duration) *Checker {
+	if timeout <= 0 {
+		timeout = time.Second
+	}
+	return &Checker{ttl: ttl, timeout: timeout}
+}
+
+// Register adds a check, run in the next reports
+func (c *Checker) Register(name string, check Check) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.checks = append(c.checks, namedCheck{name: name, check: check})
+	c.report = nil
+}
+
+// Report runs the checks concurrently, or returns the cached report.
+// The checks don't use the context of a probe, as their report is shared.
+func (c *Checker) Report() Report {
+	// Concurrent probes wait for the same run
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	if c.report != nil && now.Sub(c.report.Time) < c.ttl {
+		return c.report.copy()
+	}
+
+	rep := &Report{Healthy: true, Time: now, Checks: make([]Result, len(c.checks))}
+	var wg sync.WaitGroup
+	for i, nc := range c.checks {
+		wg.Add(1)
+		go func(i int, nc namedCheck) {
+			defer wg.Done()
+			rep.Checks[i] = c.run(nc)
+		}(i, nc)
+	}
+	wg.Wait()
+
+	for _, res := range rep.Checks {
+		rep.Healthy = rep.Healthy && res.Healthy
+	}
+	c.report = rep
+
+	return rep.copy()
+}
+
+// run runs a check with the checker timeout
+func (c *Checker) run(nc namedCheck) Result {
+	ctx, cancel := context.WithTimeout(context.Background(), c.timeout)
+	defer cancel()
+
+	start := time.Now()
+	errC := make(chan error, 1)
+	go func() {
+		errC <- nc.check(ctx)
+	}()
+
+	var err error
+	select {
+	case err = <-errC:
+	case <-ctx.Done():
+		// The check may not follow the context
+		err = ctx.Err()
+	}
+
+	res := Result{
+		Name:       nc.name,
+		Healthy:    err == nil,
+		DurationMs: float64(time.Since(start)) / float64(time.Millisecond),
+	}
+	if err != nil {
+		res.Error = err.Error()
+	}
+
+	return res
+}
+
+// DBPing checks that the DB answers
+func DBPing(db *sqlx.DB) Check {
+	return func(ctx context.Context) error {
+		return db.PingContext(ctx)
+	}
+}
@@ -0,0 +1,91 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestChecker_Report(t *testing.T) {
+	tests := []struct {
+		name            string
+		checks          map[string]Check
+		expectedHealthy bool
+		expectedErr     string
+	}{
+		{
+			name:            "no check",
+			expectedHealthy: true,
+		},
+		{
+			name: "healthy",
+			checks: map[string]Check{
+				"db": func(ctx context.Context) error { return nil },
+			},
+			expectedHealthy: true,
+		},
+		{
+			name: "failed check",
+			checks: map[string]Check{
+				"db": func(ctx context.Context) error { return errors.New("down") },
+			},
+			expectedErr: "db: down",
+		},
+		{
+			name: "timed out check",
+			checks: map[string]Check{
+				"slow": func(ctx context.Context) error {
+					time.Sleep(time.Second)
+					return nil
+				},
+			},
+			expectedErr: "slow: context deadline exceeded",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := NewChecker(0, 10*time.Millisecond)
+			for name, check := range tt.checks {
+				c.Register(name, check)
+			}
+
+			rep := c.Report()
+			if rep.Healthy != tt.expectedHealthy {
+				t.Errorf("expected healthy %t, got %+v", tt.expectedHealthy, rep)
+			}
+			errMsg := ""
+			if err := rep.Err(); err != nil {
+				errMsg = err.Error()
+			}
+			if errMsg != tt.expectedErr {
+				t.Errorf("expected error %q, got %q", tt.expectedErr, errMsg)
+			}
+		})
+	}
+}
+
+func TestChecker_ReportCache(t *testing.T) {
+	var runs int32
+	c := NewChecker(time.Hour, 0)
+	c.Register("db", func(ctx context.Context) error {
+		atomic.AddInt32(&runs, 1)
+		return nil
+	})
+
+	rep := c.Report()
+	rep.Checks[0].Healthy = false
+	if cached := c.Report(); !cached.Checks[0].Healthy {
+		t.Errorf("expected the cached report to be unchanged")
+	}
+	if runs != 1 {
+		t.Errorf("expected the checks to run once, ran %d times", runs)
+	}
+
+	c.Register("cache", func(ctx context.Context) error { return nil })
+	if rep := c.Report(); len(rep.Checks) != 2 || runs != 2 {
+		t.Errorf("expected a new report with the registered check, got %+v", rep)
+	}
+}
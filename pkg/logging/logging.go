@@ -0,0 +1,115 @@
+package logging
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Log formats
+const (
+	FormatText   = "text"
+	FormatJSON   = "json"
+	FormatLogfmt = "logfmt"
+)
+
+// Conf is the configuration of the logger
+type Conf struct {
+	// Format is text (colored on terminals), json or logfmt, text if empty
+	Format string
+	// Level is the minimum level logged, such as debug, info or warn, info if empty
+	Level string
+	// Outputs receive every log line, stdout if empty
+	Outputs []OutputConf
+	// Redact hides secrets from the log fields, nil to log them as is
+	Redact *RedactConf
+	// Sampling limits the volume of the levels it has a configuration for, such as info
+	Sampling map[string]*SamplingConf
+}
+
+// New returns a logger configured by conf, the closer releases its outputs
+func New(conf *Conf) (*logrus.Logger, io.Closer, error) {
+	level := logrus.InfoLevel
+	if conf.Level != "" {
+		var err error
+		level, err = logrus.ParseLevel(conf.Level)
+		if err != nil {
+			return nil, nil, fmt.Errorf("New: %v", err)
+		}
+	}
+
+	var formatter logrus.Formatter
+	switch strings.ToLower(conf.Format) {
+	case FormatText, "":
+		formatter = &logrus.TextFormatter{}
+	case FormatJSON:
+		formatter = &logrus.JSONFormatter{}
+	case FormatLogfmt:
+		formatter = &logrus.TextFormatter{DisableColors: true, FullTimestamp: true}
+	default:
+		return nil, nil, fmt.Errorf("New: unknown format %s", conf.Format)
+	}
+
+	samplers := make(map[logrus.Level]*sampler, len(conf.Sampling))
+	for name, sc := range conf.Sampling {
+		l, err := logrus.ParseLevel(name)
+		if err != nil {
+			return nil, nil, fmt.Errorf("New: sampling: %v", err)
+		}
+		samplers[l] = newSampler(sc)
+	}
+
+	out, errO := newOutputs(conf.Outputs)
+	if errO != nil {
+		return nil, nil, fmt.Errorf("New: %v", errO)
+	}
+
+	logger := logrus.New()
+	logger.Out = out
+	logger.Level = level
+	logger.Formatter = &filterFormatter{
+		Formatter: formatter,
+		redactor:  newRedactor(conf.Redact),
+		samplers:  samplers,
+		out:       out,
+	}
+
+	return logger, out, nil
+}
+
+// filterFormatter drops the sampled out entries and redacts the others before formatting them.
+// The logger only writes the formatted line, so the level outputs get it from here.
+type filterFormatter struct {
+	logrus.Formatter
+	redactor *redactor
+	samplers map[logrus.Level]*sampler
+	out      outputs
+}
+
+// Format returns nothing for the dropped entries, so that nothing is written
+func (f *filterFormatter) Format(entry *logrus.Entry) ([]byte, error) {
+	if s, ok := f.samplers[entry.Level]; ok && !s.keep() {
+		return nil, nil
+	}
+
+	formatted := entry
+	if f.redactor != nil {
+		// The fields may be shared with other entries, they are copied before redaction
+		redacted := *entry
+		redacted.Data = f.redactor.redact(entry.Data)
+		formatted = &redacted
+	}
+	b, err := f.Formatter.Format(formatted)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := f.out.WriteLevel(entry.Level, b); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to write to log, %v\n", err)
+	}
+
+	return b, nil
+}
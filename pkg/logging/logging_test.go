@@ -0,0 +1,129 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+)
+
+func TestNew(t *testing.T) {
+	tests := []struct {
+		name     string
+		conf     *Conf
+		wantErr  bool
+		expected string
+	}{
+		{name: "defaults", conf: &Conf{}},
+		{name: "json", conf: &Conf{Format: "json", Level: "debug"}},
+		{name: "logfmt", conf: &Conf{Format: "logfmt", Level: "warn"}},
+		{name: "unknown format", conf: &Conf{Format: "xml"}, wantErr: true},
+		{name: "unknown level", conf: &Conf{Level: "verbose"}, wantErr: true},
+		{name: "unknown output", conf: &Conf{Outputs: []OutputConf{{Type: "kafka"}}}, wantErr: true},
+		{name: "file without path", conf: &Conf{Outputs: []OutputConf{{Type: "file"}}}, wantErr: true},
+		{name: "unknown sampling level", conf: &Conf{Sampling: map[string]*SamplingConf{"loud": {}}}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logger, closer, err := New(tt.conf)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("New() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if err == nil {
+				_ = closer.Close()
+				if logger.Formatter == nil {
+					t.Errorf("expected a formatter")
+				}
+			}
+		})
+	}
+}
+
+func testLogger(t *testing.T, conf *Conf) (*logrus.Logger, *bytes.Buffer) {
+	logger, _, err := New(conf)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	var buf bytes.Buffer
+	logger.Out = &buf
+
+	return logger, &buf
+}
+
+func TestFormats(t *testing.T) {
+	logger, buf := testLogger(t, &Conf{Format: FormatJSON})
+	logger.WithField("route", "/v1").Info("hello")
+	var line map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &line); err != nil || line["route"] != "/v1" || line["msg"] != "hello" {
+		t.Errorf("expected a JSON line, got %s", buf.String())
+	}
+
+	logger, buf = testLogger(t, &Conf{Format: FormatLogfmt})
+	logger.WithField("route", "/v1").Info("hello")
+	if s := buf.String(); !strings.Contains(s, "level=info") || !strings.Contains(s, "route=/v1") ||
+		!strings.Contains(s, "time=") || strings.Contains(s, "\x1b[") {
+		t.Errorf("expected a logfmt line, got %s", s)
+	}
+}
+
+func TestLevel(t *testing.T) {
+	logger, buf := testLogger(t, &Conf{Level: "warn"})
+	logger.Info("dropped")
+	logger.Warn("kept")
+
+	if s := buf.String(); strings.Contains(s, "dropped") || !strings.Contains(s, "kept") {
+		t.Errorf("expected only the warnings, got %s", s)
+	}
+}
+
+func TestRedaction(t *testing.T) {
+	logger, buf := testLogger(t, &Conf{Format: FormatJSON, Redact: DefaultRedactConf()})
+
+	entry := logger.WithFields(logrus.Fields{
+		"Authorization": "Bearer secret",
+		"uri":           "https://api/v1?id=1&token=secret&Access_Token=secret#top",
+		"route":         "/v1",
+	})
+	entry.Info("request")
+
+	var line map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &line); err != nil {
+		t.Fatalf("expected a JSON line, got %s", buf.String())
+	}
+	expected := map[string]interface{}{
+		"Authorization": Redacted,
+		"uri":           "https://api/v1?id=1&token=" + Redacted + "&Access_Token=" + Redacted + "#top",
+		"route":         "/v1",
+	}
+	for k, v := range expected {
+		if line[k] != v {
+			t.Errorf("expected %s %v, got %v", k, v, line[k])
+		}
+	}
+	if entry.Data["Authorization"] != "Bearer secret" {
+		t.Errorf("expected the entry fields to be left as is")
+	}
+}
+
+func TestSampling(t *testing.T) {
+	logger, buf := testLogger(t, &Conf{
+		Sampling: map[string]*SamplingConf{"info": {First: 3, Thereafter: 5}},
+	})
+
+	for i := 0; i < 20; i++ {
+		logger.Info("info")
+		logger.Error("error")
+	}
+
+	// 3 first, then the 8th, 13th and 18th
+	if n := strings.Count(buf.String(), "msg=info"); n != 6 {
+		t.Errorf("expected 6 info lines, got %d", n)
+	}
+	if n := strings.Count(buf.String(), "msg=error"); n != 20 {
+		t.Errorf("expected all the error lines, got %d", n)
+	}
+}
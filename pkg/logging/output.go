@@ -0,0 +1,262 @@
+package logging
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Output types
+const (
+	OutputStdout = "stdout"
+	OutputStderr = "stderr"
+	OutputFile   = "file"
+	OutputSyslog = "syslog"
+)
+
+// OutputConf is the configuration of an output of the logs
+type OutputConf struct {
+	// Type is stdout, stderr, file or syslog
+	Type string
+	// Path of the file. It is rotated once it reaches MaxSize bytes or is older than
+	// MaxAge, never if 0, and only MaxBackups rotated files are kept, all of them if 0.
+	Path       string
+	MaxSize    int64
+	MaxAge     time.Duration
+	MaxBackups int
+	// Network and Address of the syslog server, the local one if empty.
+	// Tag is the syslog tag, the program name if empty.
+	Network string
+	Address string
+	Tag     string
+}
+
+// levelWriter is an output which writes each line with the severity of its level, such as syslog
+type levelWriter interface {
+	WriteLevel(level logrus.Level, p []byte) (int, error)
+}
+
+// outputs writes each line to all the outputs, the levelWriters get it from WriteLevel
+type outputs []io.Writer
+
+func newOutputs(confs []OutputConf) (outputs, error) {
+	if len(confs) == 0 {
+		return outputs{os.Stdout}, nil
+	}
+
+	outs := make(outputs, 0, len(confs))
+	for _, conf := range confs {
+		var w io.Writer
+		var err error
+		switch strings.ToLower(conf.Type) {
+		case OutputStdout:
+			w = os.Stdout
+		case OutputStderr:
+			w = os.Stderr
+		case OutputFile:
+			w, err = newRotatingFile(conf.Path, conf.MaxSize, conf.MaxAge, conf.MaxBackups)
+		case OutputSyslog:
+			w, err = newSyslog(conf.Network, conf.Address, conf.Tag)
+		default:
+			err = fmt.Errorf("unknown output %s", conf.Type)
+		}
+		if err != nil {
+			_ = outs.Close()
+			return nil, fmt.Errorf("newOutputs: %v", err)
+		}
+		outs = append(outs, w)
+	}
+
+	return outs, nil
+}
+
+// Write writes p to every output but the levelWriters, even if one of them fails
+func (outs outputs) Write(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+
+	var errW error
+	for _, w := range outs {
+		if _, ok := w.(levelWriter); ok {
+			continue
+		}
+		if _, err := w.Write(p); err != nil && errW == nil {
+			errW = err
+		}
+	}
+
+	return len(p), errW
+}
+
+// WriteLevel writes p with level to every levelWriter, even if one of them fails
+func (outs outputs) WriteLevel(level logrus.Level, p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+
+	var errW error
+	for _, w := range outs {
+		lw, ok := w.(levelWriter)
+		if !ok {
+			continue
+		}
+		if _, err := lw.WriteLevel(level, p); err != nil && errW == nil {
+			errW = err
+		}
+	}
+
+	return len(p), errW
+}
+
+// Close closes the outputs which aren't the standard ones
+func (outs outputs) Close() error {
+	var errC error
+	for _, w := range outs {
+		if c, ok := w.(io.Closer); ok && w != os.Stdout && w != os.Stderr {
+			if err := c.Close(); err != nil && errC == nil {
+				errC = err
+			}
+		}
+	}
+
+	return errC
+}
+
+// rotatingFile is a log file renamed with a timestamp suffix once too big or too old
+type rotatingFile struct {
+	path       string
+	maxSize    int64
+	maxAge     time.Duration
+	maxBackups int
+
+	mu      sync.Mutex
+	f       *os.File
+	size    int64
+	created time.Time
+}
+
+func newRotatingFile(path string, maxSize int64, maxAge time.Duration, maxBackups int) (*rotatingFile, error) {
+	if path == "" {
+		return nil, fmt.Errorf("newRotatingFile: no path")
+	}
+
+	rf := &rotatingFile{path: path, maxSize: maxSize, maxAge: maxAge, maxBackups: maxBackups}
+	if err := rf.open(); err != nil {
+		return nil, fmt.Errorf("newRotatingFile(%s): %v", path, err)
+	}
+
+	return rf, nil
+}
+
+// open opens the file in append mode, an existing file keeps its age
+func (rf *rotatingFile) open() error {
+	f, err := os.OpenFile(rf.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	info, errS := f.Stat()
+	if errS != nil {
+		_ = f.Close()
+		return errS
+	}
+
+	rf.f = f
+	rf.size = info.Size()
+	rf.created = info.ModTime()
+	if rf.size == 0 {
+		rf.created = time.Now()
+	}
+
+	return nil
+}
+
+// Write rotates the file before writing p, if needed
+func (rf *rotatingFile) Write(p []byte) (int, error) {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+
+	if rf.f == nil {
+		return 0, os.ErrClosed
+	}
+
+	if rf.size > 0 && ((rf.maxSize > 0 && rf.size+int64(len(p)) > rf.maxSize) ||
+		(rf.maxAge > 0 && time.Since(rf.created) > rf.maxAge)) {
+		if err := rf.rotate(); err != nil {
+			return 0, fmt.Errorf("rotatingFile.Write: %v", err)
+		}
+	}
+
+	n, err := rf.f.Write(p)
+	rf.size += int64(n)
+
+	return n, err
+}
+
+// rotate renames the file with the current time and opens a new one
+func (rf *rotatingFile) rotate() error {
+	if err := rf.f.Close(); err != nil {
+		return err
+	}
+	rf.f = nil
+
+	backup := rf.path + "." + time.Now().UTC().Format("20060102T150405.000000000")
+	if err := os.Rename(rf.path, backup); err != nil {
+		// Logging goes on in the same file
+		if errO := rf.open(); errO != nil {
+			return errO
+		}
+		return err
+	}
+	if err := rf.open(); err != nil {
+		return err
+	}
+
+	return rf.removeBackups()
+}
+
+// removeBackups removes the oldest rotated files above maxBackups
+func (rf *rotatingFile) removeBackups() error {
+	if rf.maxBackups <= 0 {
+		return nil
+	}
+
+	backups, err := filepath.Glob(rf.path + ".*")
+	if err != nil {
+		return err
+	}
+	if len(backups) <= rf.maxBackups {
+		return nil
+	}
+
+	// The timestamps sort like the rotation times
+	sort.Strings(backups)
+	for _, backup := range backups[:len(backups)-rf.maxBackups] {
+		if err := os.Remove(backup); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Close closes the file
+func (rf *rotatingFile) Close() error {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+
+	if rf.f == nil {
+		return nil
+	}
+	err := rf.f.Close()
+	rf.f = nil
+
+	return err
+}
@@ -0,0 +1,91 @@
+package logging
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRotatingFile(t *testing.T) {
+	tests := []struct {
+		name            string
+		maxSize         int64
+		maxAge          time.Duration
+		maxBackups      int
+		writes          int
+		wait            time.Duration
+		expectedBackups int
+	}{
+		{name: "no rotation", writes: 10},
+		{name: "rotated by size", maxSize: 25, writes: 10, expectedBackups: 4},
+		{name: "backups removed", maxSize: 25, maxBackups: 2, writes: 10, expectedBackups: 2},
+		{name: "rotated by age", maxAge: time.Millisecond, writes: 3, wait: 5 * time.Millisecond, expectedBackups: 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "logs")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+			path := filepath.Join(dir, "api.log")
+
+			rf, errN := newRotatingFile(path, tt.maxSize, tt.maxAge, tt.maxBackups)
+			if errN != nil {
+				t.Fatalf("newRotatingFile() error = %v", errN)
+			}
+			for i := 0; i < tt.writes; i++ {
+				if _, errW := rf.Write([]byte("0123456789\n")); errW != nil {
+					t.Fatalf("Write() error = %v", errW)
+				}
+				time.Sleep(tt.wait)
+			}
+			if errC := rf.Close(); errC != nil {
+				t.Fatalf("Close() error = %v", errC)
+			}
+
+			backups, _ := filepath.Glob(path + ".*")
+			if len(backups) != tt.expectedBackups {
+				t.Errorf("expected %d backups, got %d", tt.expectedBackups, len(backups))
+			}
+			data, _ := ioutil.ReadFile(path)
+			if tt.maxSize > 0 && int64(len(data)) > tt.maxSize {
+				t.Errorf("expected at most %d bytes, got %d", tt.maxSize, len(data))
+			}
+		})
+	}
+}
+
+func TestOutputs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "logs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	outs, errN := newOutputs([]OutputConf{
+		{Type: "file", Path: filepath.Join(dir, "a.log")},
+		{Type: "FILE", Path: filepath.Join(dir, "b.log")},
+		{Type: "stdout"},
+	})
+	if errN != nil {
+		t.Fatalf("newOutputs() error = %v", errN)
+	}
+	if _, errW := outs.Write([]byte("line\n")); errW != nil {
+		t.Fatalf("Write() error = %v", errW)
+	}
+	if errC := outs.Close(); errC != nil {
+		t.Fatalf("Close() error = %v", errC)
+	}
+
+	for _, name := range []string{"a.log", "b.log"} {
+		data, _ := ioutil.ReadFile(filepath.Join(dir, name))
+		if strings.TrimSpace(string(data)) != "line" {
+			t.Errorf("expected the line in %s, got %q", name, data)
+		}
+	}
+}
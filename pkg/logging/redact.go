@@ -0,0 +1,92 @@
+package logging
+
+import (
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Redacted replaces the redacted values
+const Redacted = "[REDACTED]"
+
+// RedactConf are the secrets hidden from the logs
+type RedactConf struct {
+	// Fields are the fields whose value is redacted, such as authorization, case insensitive
+	Fields []string
+	// QueryParams are the query parameters whose value is redacted in the URIs logged,
+	// such as token, case insensitive
+	QueryParams []string
+}
+
+// DefaultRedactConf returns the redaction of the credentials handled by the API
+func DefaultRedactConf() *RedactConf {
+	return &RedactConf{
+		Fields:      []string{"authorization", "x-api-key", "cookie", "set-cookie", "password", "secret", "token"},
+		QueryParams: []string{"token", "access_token", "id_token", "apikey", "api_key", "password"},
+	}
+}
+
+type redactor struct {
+	fields      map[string]bool
+	queryParams map[string]bool
+}
+
+func newRedactor(conf *RedactConf) *redactor {
+	if conf == nil {
+		return nil
+	}
+
+	r := &redactor{
+		fields:      make(map[string]bool, len(conf.Fields)),
+		queryParams: make(map[string]bool, len(conf.QueryParams)),
+	}
+	for _, f := range conf.Fields {
+		r.fields[strings.ToLower(f)] = true
+	}
+	for _, p := range conf.QueryParams {
+		r.queryParams[strings.ToLower(p)] = true
+	}
+
+	return r
+}
+
+// redact returns a copy of the fields, with the secrets redacted
+func (r *redactor) redact(data logrus.Fields) logrus.Fields {
+	redacted := make(logrus.Fields, len(data))
+	for k, v := range data {
+		if r.fields[strings.ToLower(k)] {
+			redacted[k] = Redacted
+			continue
+		}
+		if s, ok := v.(string); ok && len(r.queryParams) > 0 && strings.Contains(s, "?") {
+			redacted[k] = r.redactQuery(s)
+			continue
+		}
+		redacted[k] = v
+	}
+
+	return redacted
+}
+
+// redactQuery redacts the values of the query parameters of an URI, keeping
+// the rest as is, it isn't decoded and encoded again
+func (r *redactor) redactQuery(uri string) string {
+	i := strings.IndexByte(uri, '?')
+	query, fragment := uri[i+1:], ""
+	if j := strings.IndexByte(query, '#'); j >= 0 {
+		query, fragment = query[:j], query[j:]
+	}
+
+	params := strings.Split(query, "&")
+	for n, param := range params {
+		eq := strings.IndexByte(param, '=')
+		if eq < 0 {
+			continue
+		}
+		if r.queryParams[strings.ToLower(param[:eq])] {
+			params[n] = param[:eq+1] + Redacted
+		}
+	}
+
+	return uri[:i+1] + strings.Join(params, "&") + fragment
+}
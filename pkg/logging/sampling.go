@@ -0,0 +1,48 @@
+package logging
+
+import (
+	"sync"
+	"time"
+)
+
+// SamplingConf limits the entries of a level: in each Tick, the First entries are
+// logged, then one every Thereafter, the others are dropped
+type SamplingConf struct {
+	Tick       time.Duration
+	First      int
+	Thereafter int
+}
+
+type sampler struct {
+	conf SamplingConf
+
+	mu    sync.Mutex
+	reset time.Time
+	count int
+}
+
+func newSampler(conf *SamplingConf) *sampler {
+	s := &sampler{conf: *conf}
+	if s.conf.Tick <= 0 {
+		s.conf.Tick = time.Second
+	}
+	return s
+}
+
+// keep decides if an entry is logged
+func (s *sampler) keep() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	if now.After(s.reset) {
+		s.reset = now.Add(s.conf.Tick)
+		s.count = 0
+	}
+	s.count++
+
+	if s.count <= s.conf.First {
+		return true
+	}
+	return s.conf.Thereafter > 0 && (s.count-s.conf.First)%s.conf.Thereafter == 0
+}
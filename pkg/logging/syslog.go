@@ -0,0 +1,48 @@
+//go:build !windows && !nacl && !plan9
+// +build !windows,!nacl,!plan9
+
+package logging
+
+import (
+	"io"
+	"log/syslog"
+
+	"github.com/sirupsen/logrus"
+)
+
+// syslogWriter sends each line to syslog with the severity of its level
+type syslogWriter struct {
+	*syslog.Writer
+}
+
+func newSyslog(network, address, tag string) (io.Writer, error) {
+	w, err := syslog.Dial(network, address, syslog.LOG_INFO|syslog.LOG_USER, tag)
+	if err != nil {
+		return nil, err
+	}
+
+	return syslogWriter{w}, nil
+}
+
+// WriteLevel sends p with the severity of level, debug for the levels below it
+func (w syslogWriter) WriteLevel(level logrus.Level, p []byte) (int, error) {
+	line := string(p)
+	var err error
+	switch level {
+	case logrus.PanicLevel, logrus.FatalLevel:
+		err = w.Crit(line)
+	case logrus.ErrorLevel:
+		err = w.Err(line)
+	case logrus.WarnLevel:
+		err = w.Warning(line)
+	case logrus.InfoLevel:
+		err = w.Info(line)
+	default:
+		err = w.Debug(line)
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	return len(p), nil
+}
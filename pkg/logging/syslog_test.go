@@ -0,0 +1,63 @@
+//go:build !windows && !nacl && !plan9
+// +build !windows,!nacl,!plan9
+
+package logging
+
+import (
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSyslogPriorities(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("ListenPacket() error = %v", err)
+	}
+	defer conn.Close()
+
+	logger, closer, err := New(&Conf{
+		Level:    "debug",
+		Outputs:  []OutputConf{{Type: OutputSyslog, Network: "udp", Address: conn.LocalAddr().String(), Tag: "test"}},
+		Sampling: map[string]*SamplingConf{"debug": {Tick: time.Minute, First: 1}},
+	})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer closer.Close()
+
+	logger.Error("failed")
+	logger.Warn("slow")
+	logger.Info("started")
+	logger.Debug("sampled")
+	logger.Debug("dropped")
+
+	// The priority is the facility user (8) plus the severity
+	tests := []struct {
+		priority string
+		msg      string
+	}{
+		{priority: "<11>", msg: "failed"},
+		{priority: "<12>", msg: "slow"},
+		{priority: "<14>", msg: "started"},
+		{priority: "<15>", msg: "sampled"},
+	}
+
+	buf := make([]byte, 4096)
+	for _, tt := range tests {
+		_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		n, _, errR := conn.ReadFrom(buf)
+		if errR != nil {
+			t.Fatalf("ReadFrom() error = %v", errR)
+		}
+		if line := string(buf[:n]); !strings.HasPrefix(line, tt.priority) || !strings.Contains(line, tt.msg) {
+			t.Errorf("expected %s with priority %s, got %s", tt.msg, tt.priority, line)
+		}
+	}
+
+	_ = conn.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+	if n, _, errR := conn.ReadFrom(buf); errR == nil {
+		t.Errorf("expected the sampled out entry to be dropped, got %s", buf[:n])
+	}
+}
@@ -0,0 +1,14 @@
+//go:build windows || nacl || plan9
+// +build windows nacl plan9
+
+package logging
+
+import (
+	"errors"
+	"io"
+)
+
+// newSyslog fails, syslog isn't supported on this system
+func newSyslog(network, address, tag string) (io.Writer, error) {
+	return nil, errors.New("syslog is not supported on this system")
+}
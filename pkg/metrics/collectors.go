@@ -0,0 +1,83 @@
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"runtime"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// sample is a metric family of a single sample
+type sample struct {
+	name  string
+	help  string
+	kind  string
+	value float64
+}
+
+// writeSamples writes single sample families
+func writeSamples(w io.Writer, samples []sample) error {
+	for _, s := range samples {
+		d := desc{name: s.name, help: s.help, kind: s.kind}
+		if err := d.header(w); err != nil {
+			return err
+		}
+		if _, err := fmt.Fprintf(w, "%s %s\n", s.name, formatFloat(s.value)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type runtimeCollector struct{}
+
+// NewRuntimeCollector returns the Go runtime metrics, goroutines, memory and GC
+func NewRuntimeCollector() Collector {
+	return runtimeCollector{}
+}
+
+// Collect reads the memory stats once for all the metrics
+func (runtimeCollector) Collect(w io.Writer) error {
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+
+	return writeSamples(w, []sample{
+		{"go_goroutines", "Number of goroutines.", "gauge", float64(runtime.NumGoroutine())},
+		{"go_memstats_alloc_bytes", "Bytes of allocated heap objects.", "gauge", float64(ms.Alloc)},
+		{"go_memstats_heap_inuse_bytes", "Bytes in in-use heap spans.", "gauge", float64(ms.HeapInuse)},
+		{"go_memstats_heap_objects", "Number of allocated heap objects.", "gauge", float64(ms.HeapObjects)},
+		{"go_memstats_sys_bytes", "Bytes of memory obtained from the OS.", "gauge", float64(ms.Sys)},
+		{"go_memstats_mallocs_total", "Number of heap objects allocated.", "counter", float64(ms.Mallocs)},
+		{"go_gc_cycles_total", "Number of completed GC cycles.", "counter", float64(ms.NumGC)},
+		{"go_gc_pause_seconds_total", "Total GC stop-the-world pause time.", "counter",
+			float64(ms.PauseTotalNs) / float64(time.Second)},
+	})
+}
+
+type dbStatsCollector struct {
+	db *sqlx.DB
+}
+
+// NewDBStatsCollector returns the connection pool metrics of db
+func NewDBStatsCollector(db *sqlx.DB) Collector {
+	return dbStatsCollector{db: db}
+}
+
+// Collect reads the pool stats, the connections in use, idle and waited for need Go 1.11
+func (c dbStatsCollector) Collect(w io.Writer) error {
+	st := c.db.Stats()
+
+	samples := []sample{
+		{"db_open_connections", "Number of open connections.", "gauge", float64(st.OpenConnections)},
+	}
+	return writeSamples(w, append(samples, dbPoolSamples(st)...))
+}
+
+// NewBuildInfo returns the build_info gauge, always 1, labelled by version, revision and Go version
+func NewBuildInfo(version, revision string) Collector {
+	g := NewGaugeVec("build_info", "Build information.", "version", "revision", "goversion")
+	g.Set(1, version, revision, runtime.Version())
+	return g
+}
@@ -0,0 +1,31 @@
+package metrics
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	// driver to open a pool without connecting
+	_ "github.com/go-sql-driver/mysql"
+	"github.com/jmoiron/sqlx"
+)
+
+func TestDBStatsCollector(t *testing.T) {
+	db, err := sqlx.Open("mysql", "internal:dev@tcp(127.0.0.1:1)/test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	var buf bytes.Buffer
+	if errC := NewDBStatsCollector(db).Collect(&buf); errC != nil {
+		t.Fatalf("Collect() error = %v", errC)
+	}
+	for _, expected := range []string{
+		"# TYPE db_open_connections gauge\n",
+		"db_open_connections 0\n",
+	} {
+		if !strings.Contains(buf.String(), expected) {
+			t.Errorf("expected %q in\n%s", expected, buf.String())
+		}
+	}
+}
@@ -0,0 +1,11 @@
+//go:build !go1.11
+// +build !go1.11
+
+package metrics
+
+import "database/sql"
+
+// dbPoolSamples returns nothing, Go 1.10 only has OpenConnections
+func dbPoolSamples(st sql.DBStats) []sample {
+	return nil
+}
@@ -0,0 +1,24 @@
+//go:build go1.11
+// +build go1.11
+
+package metrics
+
+import (
+	"database/sql"
+	"time"
+)
+
+// dbPoolSamples returns the pool stats added in Go 1.11
+func dbPoolSamples(st sql.DBStats) []sample {
+	return []sample{
+		{"db_max_open_connections", "Maximum number of open connections.", "gauge", float64(st.MaxOpenConnections)},
+		{"db_in_use_connections", "Number of connections in use.", "gauge", float64(st.InUse)},
+		{"db_idle_connections", "Number of idle connections.", "gauge", float64(st.Idle)},
+		{"db_wait_count_total", "Number of connections waited for.", "counter", float64(st.WaitCount)},
+		{"db_wait_duration_seconds_total", "Total time waited for new connections.", "counter",
+			float64(st.WaitDuration) / float64(time.Second)},
+		{"db_max_idle_closed_total", "Number of connections closed by the idle limit.", "counter", float64(st.MaxIdleClosed)},
+		{"db_max_lifetime_closed_total", "Number of connections closed by the lifetime limit.", "counter",
+			float64(st.MaxLifetimeClosed)},
+	}
+}
@@ -0,0 +1,38 @@
+//go:build go1.11
+// +build go1.11
+
+package metrics
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/jmoiron/sqlx"
+)
+
+func TestDBStatsCollectorPool(t *testing.T) {
+	db, err := sqlx.Open("mysql", "internal:dev@tcp(127.0.0.1:1)/test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	db.SetMaxOpenConns(3)
+	var buf bytes.Buffer
+	if errC := NewDBStatsCollector(db).Collect(&buf); errC != nil {
+		t.Fatalf("Collect() error = %v", errC)
+	}
+	for _, expected := range []string{
+		"db_max_open_connections 3\n",
+		"db_in_use_connections 0\n",
+		"db_idle_connections 0\n",
+		"# TYPE db_wait_count_total counter\n",
+		"db_wait_duration_seconds_total 0\n",
+		"db_max_idle_closed_total 0\n",
+		"db_max_lifetime_closed_total 0\n",
+	} {
+		if !strings.Contains(buf.String(), expected) {
+			t.Errorf("expected %q in\n%s", expected, buf.String())
+		}
+	}
+}
@@ -0,0 +1,345 @@
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// DefBuckets are the default buckets of the duration histograms, in seconds
+var DefBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// ExponentialBuckets returns count buckets, starting at start and multiplied by factor
+func ExponentialBuckets(start, factor float64, count int) []float64 {
+	buckets := make([]float64, count)
+	for i := range buckets {
+		buckets[i] = start
+		start *= factor
+	}
+	return buckets
+}
+
+// Collector writes metric families in the Prometheus text format
+type Collector interface {
+	Collect(w io.Writer) error
+}
+
+// Registry exposes the metrics of its collectors
+type Registry struct {
+	mu         sync.Mutex
+	collectors []Collector
+}
+
+// NewRegistry returns an empty registry
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+// Register adds collectors to the registry
+func (reg *Registry) Register(cs ...Collector) {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+
+	reg.collectors = append(reg.collectors, cs...)
+}
+
+// Collect writes the metrics of all the collectors
+func (reg *Registry) Collect(w io.Writer) error {
+	reg.mu.Lock()
+	cs := append([]Collector(nil), reg.collectors...)
+	reg.mu.Unlock()
+
+	for _, c := range cs {
+		if err := c.Collect(w); err != nil {
+			return fmt.Errorf("Collect: %v", err)
+		}
+	}
+	return nil
+}
+
+// ServeHTTP exposes the metrics in the Prometheus text format
+func (reg *Registry) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+
+	bw := bufio.NewWriter(w)
+	if err := reg.Collect(bw); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	_ = bw.Flush()
+}
+
+// desc is the name, help and labels of a metric family
+type desc struct {
+	name   string
+	help   string
+	kind   string
+	labels []string
+}
+
+// header writes the HELP and TYPE lines of the family
+func (d *desc) header(w io.Writer) error {
+	_, err := fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n",
+		d.name, strings.Replace(d.help, "\n", " ", -1), d.name, d.kind)
+	return err
+}
+
+// seriesKey identifies a series by its label values
+func (d *desc) seriesKey(values []string) string {
+	if len(values) != len(d.labels) {
+		panic(fmt.Sprintf("metrics: %s expects %d label values, got %d", d.name, len(d.labels), len(values)))
+	}
+	return strings.Join(values, "\xff")
+}
+
+// formatLabels returns {name="value",...}, with the extra label if any
+func formatLabels(names, values []string, extraName, extraValue string) string {
+	if len(names) == 0 && extraName == "" {
+		return ""
+	}
+
+	pairs := make([]string, 0, len(names)+1)
+	for i, name := range names {
+		pairs = append(pairs, name+`="`+escapeLabel(values[i])+`"`)
+	}
+	if extraName != "" {
+		pairs = append(pairs, extraName+`="`+escapeLabel(extraValue)+`"`)
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeLabel(v string) string {
+	return labelEscaper.Replace(v)
+}
+
+// formatFloat formats a sample value
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// vec holds the values of a family, by label values
+type vec struct {
+	desc
+	mu     sync.Mutex
+	series map[string][]string
+	values map[string]float64
+}
+
+func newVec(kind, name, help string, labels []string) vec {
+	return vec{
+		desc:   desc{name: name, help: help, kind: kind, labels: labels},
+		series: map[string][]string{},
+		values: map[string]float64{},
+	}
+}
+
+func (v *vec) add(delta float64, values []string, set bool) {
+	key := v.seriesKey(values)
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	if _, ok := v.series[key]; !ok {
+		v.series[key] = append([]string(nil), values...)
+	}
+	if set {
+		v.values[key] = delta
+		return
+	}
+	v.values[key] += delta
+}
+
+// Collect writes the samples, sorted by label values
+func (v *vec) Collect(w io.Writer) error {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	if err := v.header(w); err != nil {
+		return err
+	}
+	for _, key := range sortedKeys(v.series) {
+		_, err := fmt.Fprintf(w, "%s%s %s\n",
+			v.name, formatLabels(v.labels, v.series[key], "", ""), formatFloat(v.values[key]))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func sortedKeys(m map[string][]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// CounterVec is a counter, by label values
+type CounterVec struct {
+	vec
+}
+
+// NewCounterVec returns a counter with the label names
+func NewCounterVec(name, help string, labels ...string) *CounterVec {
+	return &CounterVec{vec: newVec("counter", name, help, labels)}
+}
+
+// Inc adds 1 to the counter of the label values
+func (c *CounterVec) Inc(values ...string) {
+	c.add(1, values, false)
+}
+
+// Add adds delta, which must not be negative, to the counter of the label values
+func (c *CounterVec) Add(delta float64, values ...string) {
+	if delta < 0 {
+		panic(fmt.Sprintf("metrics: %s counter can't decrease", c.name))
+	}
+	c.add(delta, values, false)
+}
+
+// GaugeVec is a gauge, by label values
+type GaugeVec struct {
+	vec
+}
+
+// NewGaugeVec returns a gauge with the label names
+func NewGaugeVec(name, help string, labels ...string) *GaugeVec {
+	return &GaugeVec{vec: newVec("gauge", name, help, labels)}
+}
+
+// Set sets the gauge of the label values
+func (g *GaugeVec) Set(v float64, values ...string) {
+	g.add(v, values, true)
+}
+
+// Add adds delta to the gauge of the label values
+func (g *GaugeVec) Add(delta float64, values ...string) {
+	g.add(delta, values, false)
+}
+
+// histogram is the state of a histogram series
+type histogram struct {
+	values []string
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+// HistogramVec is a histogram, by label values
+type HistogramVec struct {
+	desc
+	buckets []float64
+
+	mu     sync.Mutex
+	series map[string]*histogram
+}
+
+// NewHistogramVec returns a histogram with the bucket upper bounds and the label names
+func NewHistogramVec(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	buckets = append([]float64(nil), buckets...)
+	sort.Float64s(buckets)
+
+	return &HistogramVec{
+		desc:    desc{name: name, help: help, kind: "histogram", labels: labels},
+		buckets: buckets,
+		series:  map[string]*histogram{},
+	}
+}
+
+// Observe adds v to the histogram of the label values
+func (h *HistogramVec) Observe(v float64, values ...string) {
+	key := h.seriesKey(values)
+	i := sort.SearchFloat64s(h.buckets, v)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	s, ok := h.series[key]
+	if !ok {
+		s = &histogram{values: append([]string(nil), values...), counts: make([]uint64, len(h.buckets))}
+		h.series[key] = s
+	}
+	if i < len(h.buckets) {
+		s.counts[i]++
+	}
+	s.count++
+	s.sum += v
+}
+
+// Collect writes the cumulative buckets, sum and count of each series
+func (h *HistogramVec) Collect(w io.Writer) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if err := h.header(w); err != nil {
+		return err
+	}
+
+	keys := make([]string, 0, len(h.series))
+	for k := range h.series {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		s := h.series[key]
+		var cumulative uint64
+		for i, le := range h.buckets {
+			cumulative += s.counts[i]
+			if _, err := fmt.Fprintf(w, "%s_bucket%s %d\n",
+				h.name, formatLabels(h.labels, s.values, "le", formatFloat(le)), cumulative); err != nil {
+				return err
+			}
+		}
+		labels := formatLabels(h.labels, s.values, "", "")
+		_, err := fmt.Fprintf(w, "%s_bucket%s %d\n%s_sum%s %s\n%s_count%s %d\n",
+			h.name, formatLabels(h.labels, s.values, "le", "+Inf"), s.count,
+			h.name, labels, formatFloat(s.sum),
+			h.name, labels, s.count)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// funcMetric is a metric without labels read when collected
+type funcMetric struct {
+	desc
+	f func() float64
+}
+
+// NewGaugeFunc returns a gauge reading f when collected
+func NewGaugeFunc(name, help string, f func() float64) Collector {
+	return &funcMetric{desc: desc{name: name, help: help, kind: "gauge"}, f: f}
+}
+
+// NewCounterFunc returns a counter reading f when collected
+func NewCounterFunc(name, help string, f func() float64) Collector {
+	return &funcMetric{desc: desc{name: name, help: help, kind: "counter"}, f: f}
+}
+
+// Collect writes the value of f
+func (m *funcMetric) Collect(w io.Writer) error {
+	if err := m.header(w); err != nil {
+		return err
+	}
+	_, err := fmt.Fprintf(w, "%s %s\n", m.name, formatFloat(m.f()))
+	return err
+}
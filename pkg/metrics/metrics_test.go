@@ -0,0 +1,143 @@
+package metrics
+
+import (
+	"bytes"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestCollect(t *testing.T) {
+	tests := []struct {
+		name     string
+		metric   func() Collector
+		expected string
+	}{
+		{
+			name: "counter",
+			metric: func() Collector {
+				c := NewCounterVec("requests_total", "Number of requests.", "method", "status")
+				c.Inc("POST", "201")
+				c.Inc("GET", "200")
+				c.Add(2, "GET", "200")
+				return c
+			},
+			expected: `# HELP requests_total Number of requests.
+# TYPE requests_total counter
+requests_total{method="GET",status="200"} 3
+requests_total{method="POST",status="201"} 1
+`,
+		},
+		{
+			name: "gauge without labels",
+			metric: func() Collector {
+				g := NewGaugeVec("in_flight", "Requests in flight.")
+				g.Add(2)
+				g.Add(-1)
+				return g
+			},
+			expected: `# HELP in_flight Requests in flight.
+# TYPE in_flight gauge
+in_flight 1
+`,
+		},
+		{
+			name: "escaped label",
+			metric: func() Collector {
+				g := NewGaugeVec("info", "Info.", "value")
+				g.Set(1, "a \"quoted\"\\\nvalue")
+				return g
+			},
+			expected: `# HELP info Info.
+# TYPE info gauge
+info{value="a \"quoted\"\\\nvalue"} 1
+`,
+		},
+		{
+			name: "histogram",
+			metric: func() Collector {
+				h := NewHistogramVec("duration_seconds", "Duration.", []float64{1, 0.1}, "route")
+				h.Observe(0.05, "/a")
+				h.Observe(0.1, "/a")
+				h.Observe(0.5, "/a")
+				h.Observe(3, "/a")
+				return h
+			},
+			expected: `# HELP duration_seconds Duration.
+# TYPE duration_seconds histogram
+duration_seconds_bucket{route="/a",le="0.1"} 2
+duration_seconds_bucket{route="/a",le="1"} 3
+duration_seconds_bucket{route="/a",le="+Inf"} 4
+duration_seconds_sum{route="/a"} 3.65
+duration_seconds_count{route="/a"} 4
+`,
+		},
+		{
+			name: "gauge func",
+			metric: func() Collector {
+				return NewGaugeFunc("temperature", "Temperature.", func() float64 { return math.Inf(1) })
+			},
+			expected: `# HELP temperature Temperature.
+# TYPE temperature gauge
+temperature +Inf
+`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := tt.metric().Collect(&buf); err != nil {
+				t.Errorf("Collect() error = %v", err)
+				return
+			}
+			if buf.String() != tt.expected {
+				t.Errorf("expected\n%s\ngot\n%s", tt.expected, buf.String())
+			}
+		})
+	}
+}
+
+func TestLabelValuesCount(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Errorf("expected a panic on a wrong number of label values")
+		}
+	}()
+	NewCounterVec("requests_total", "Number of requests.", "method").Inc("GET", "200")
+}
+
+func TestExponentialBuckets(t *testing.T) {
+	expected := []float64{100, 1000, 10000}
+	if b := ExponentialBuckets(100, 10, 3); !reflect.DeepEqual(b, expected) {
+		t.Errorf("expected %v, got %v", expected, b)
+	}
+}
+
+func TestRegistry_ServeHTTP(t *testing.T) {
+	reg := NewRegistry()
+	c := NewCounterVec("requests_total", "Number of requests.")
+	c.Inc()
+	reg.Register(c, NewRuntimeCollector(), NewBuildInfo("1.2.0", "abc123"))
+
+	rr := httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodGet, "/metrics", nil)
+	reg.ServeHTTP(rr, req)
+
+	if ct := rr.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain; version=0.0.4") {
+		t.Errorf("expected the Prometheus text format, got %s", ct)
+	}
+	for _, expected := range []string{
+		"requests_total 1\n",
+		"# TYPE go_goroutines gauge\n",
+		"go_gc_cycles_total ",
+		`build_info{version="1.2.0",revision="abc123",goversion="go`,
+	} {
+		if !strings.Contains(rr.Body.String(), expected) {
+			t.Errorf("expected %q in\n%s", expected, rr.Body.String())
+		}
+	}
+}
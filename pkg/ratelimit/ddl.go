@@ -0,0 +1,43 @@
+package ratelimit
+
+import (
+	"context"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// DDL is used to do modifications in the DB
+type DDL struct{}
+
+// Version is the schema version of MigrateUp
+func (ddl *DDL) Version() int {
+	return 1
+}
+
+// MigrateUp creates the needed tables
+func (ddl *DDL) MigrateUp(ctx context.Context, db *sqlx.DB) error {
+	_, errExec := db.ExecContext(
+		ctx,
+		`
+            CREATE TABLE IF NOT EXISTS ratelimit (
+                bucket_key CHAR(64) NOT NULL,
+                tokens DOUBLE NOT NULL,
+                time_updated DATETIME(6) NOT NULL,
+                PRIMARY KEY (bucket_key),
+                INDEX r_tu_idx (time_updated)
+            );
+    `)
+
+	return errExec
+}
+
+// MigrateDown destroys the needed tables
+func (ddl *DDL) MigrateDown(ctx context.Context, db *sqlx.DB) error {
+	_, errExec := db.ExecContext(
+		ctx,
+		`
+        DROP TABLE IF EXISTS ratelimit;
+    `)
+
+	return errExec
+}
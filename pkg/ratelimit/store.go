@@ -0,0 +1,119 @@
+package ratelimit
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+
+	"github.com/vincentserpoul/gorestarter/pkg/rest/mid"
+)
+
+// SQLStore keeps the rate limit buckets in the DB, so that replicas share them
+type SQLStore struct {
+	db *sqlx.DB
+	// idle buckets are purged after idle, which must be longer than any limit period
+	idle time.Duration
+
+	mu     sync.Mutex
+	purged time.Time
+}
+
+// NewSQLStore returns a SQLStore purging the buckets unused for idle
+func NewSQLStore(db *sqlx.DB, idle time.Duration) *SQLStore {
+	return &SQLStore{db: db, idle: idle}
+}
+
+// Take implements mid.RateLimitStore
+func (s *SQLStore) Take(ctx context.Context, key string, limit mid.Limit) (mid.RateLimitResult, error) {
+	now := time.Now().UTC()
+	bucketKey := hashKey(key)
+
+	s.purgeIdle(ctx, now)
+
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return mid.RateLimitResult{}, fmt.Errorf("Take(%s): %v", key, err)
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	// Creating a missing bucket full, and locking it until the end of tx.
+	// Taking the row lock first avoids deadlocks between replicas on new buckets.
+	_, errI := tx.ExecContext(
+		ctx,
+		`
+			INSERT INTO ratelimit(bucket_key, tokens, time_updated) VALUES (?, ?, ?)
+			ON DUPLICATE KEY UPDATE bucket_key = bucket_key
+		`,
+		bucketKey, float64(limit.BucketSize()), now,
+	)
+	if errI != nil {
+		return mid.RateLimitResult{}, fmt.Errorf("Take(%s): %v", key, errI)
+	}
+
+	b := &mid.Bucket{}
+	errS := tx.QueryRowxContext(
+		ctx,
+		`SELECT tokens, time_updated FROM ratelimit WHERE bucket_key = ? FOR UPDATE`,
+		bucketKey,
+	).Scan(&b.Tokens, &b.Time)
+	if errS != nil {
+		return mid.RateLimitResult{}, fmt.Errorf("Take(%s): %v", key, errS)
+	}
+
+	res := b.Take(limit, now)
+
+	_, errU := tx.ExecContext(
+		ctx,
+		`UPDATE ratelimit SET tokens = ?, time_updated = ? WHERE bucket_key = ?`,
+		b.Tokens, b.Time, bucketKey,
+	)
+	if errU != nil {
+		return mid.RateLimitResult{}, fmt.Errorf("Take(%s): %v", key, errU)
+	}
+
+	if errC := tx.Commit(); errC != nil {
+		return mid.RateLimitResult{}, fmt.Errorf("Take(%s): %v", key, errC)
+	}
+
+	return res, nil
+}
+
+// purgeIdle deletes the buckets unused for s.idle, at most once per s.idle
+func (s *SQLStore) purgeIdle(ctx context.Context, now time.Time) {
+	s.mu.Lock()
+	purge := now.Sub(s.purged) > s.idle
+	if purge {
+		s.purged = now
+	}
+	s.mu.Unlock()
+
+	if purge {
+		// Best effort, idle buckets are only wasted space
+		_ = Purge(ctx, s.db, now.Add(-s.idle))
+	}
+}
+
+// Purge deletes the buckets not updated since before
+func Purge(ctx context.Context, db *sqlx.DB, before time.Time) error {
+	_, err := db.ExecContext(
+		ctx,
+		`DELETE FROM ratelimit WHERE time_updated < ?`,
+		before.UTC(),
+	)
+	if err != nil {
+		return fmt.Errorf("Purge(%s): %v", before, err)
+	}
+
+	return nil
+}
+
+// hashKey bounds the size of the keys, which may hold route patterns and principal IDs
+func hashKey(key string) string {
+	h := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(h[:])
+}
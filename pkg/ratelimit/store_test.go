@@ -0,0 +1,147 @@
+package ratelimit
+
+import (
+	"context"
+	"log"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+
+	"github.com/vincentserpoul/gorestarter/pkg/rest/mid"
+	"github.com/vincentserpoul/gorestarter/pkg/storage"
+)
+
+func TestSQLStore_Take(t *testing.T) {
+	ctx := context.Background()
+	s := NewSQLStore(pool, time.Hour)
+	l := mid.Limit{Requests: 2, Period: time.Minute}
+
+	tests := []struct {
+		name          string
+		key           string
+		wantAllowed   bool
+		wantRemaining int
+	}{
+		{name: "new bucket", key: "ip:10.0.0.1", wantAllowed: true, wantRemaining: 1},
+		{name: "last token", key: "ip:10.0.0.1", wantAllowed: true, wantRemaining: 0},
+		{name: "empty bucket", key: "ip:10.0.0.1", wantAllowed: false, wantRemaining: 0},
+		{name: "other bucket", key: "ip:10.0.0.2", wantAllowed: true, wantRemaining: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res, err := s.Take(ctx, tt.key, l)
+			if err != nil {
+				t.Errorf("Take() error = %v", err)
+				return
+			}
+			if res.Allowed != tt.wantAllowed || res.Remaining != tt.wantRemaining {
+				t.Errorf("Take() = %+v, want allowed %t and %d remaining",
+					res, tt.wantAllowed, tt.wantRemaining)
+			}
+		})
+	}
+}
+
+func TestSQLStore_TakeShared(t *testing.T) {
+	// Two stores stand for two replicas
+	stores := []*SQLStore{NewSQLStore(pool, time.Hour), NewSQLStore(pool, time.Hour)}
+	l := mid.Limit{Requests: 10, Period: time.Hour}
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	allowed := 0
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(s *SQLStore) {
+			defer wg.Done()
+			res, err := s.Take(context.Background(), "user:shared", l)
+			if err != nil {
+				t.Errorf("Take() error = %v", err)
+				return
+			}
+			if res.Allowed {
+				mu.Lock()
+				allowed++
+				mu.Unlock()
+			}
+		}(stores[i%2])
+	}
+	wg.Wait()
+
+	if allowed != 10 {
+		t.Errorf("expected 10 requests allowed across replicas, got %d", allowed)
+	}
+}
+
+func TestPurge(t *testing.T) {
+	ctx := context.Background()
+	s := NewSQLStore(pool, time.Hour)
+	l := mid.Limit{Requests: 1, Period: time.Hour}
+	_, _ = s.Take(ctx, "ip:purged", l)
+
+	if err := Purge(ctx, pool, time.Now().Add(time.Second)); err != nil {
+		t.Errorf("Purge() error = %v", err)
+		return
+	}
+
+	res, _ := s.Take(ctx, "ip:purged", l)
+	if !res.Allowed {
+		t.Errorf("expected a purged bucket to be full again")
+	}
+}
+
+var pool *sqlx.DB
+
+func TestMain(m *testing.M) {
+	ctx := context.Background()
+
+	var err error
+	newConnPool, err := storage.NewMySQLDBConnPool(&storage.MySQLDBConf{
+		Protocol: "tcp",
+		Host:     "127.0.0.1",
+		Port:     "3306",
+		User:     "internal",
+		Password: "dev",
+		DbName:   "test",
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer func() {
+		errClose := newConnPool.Close()
+		if errClose != nil {
+			log.Fatalf("%v", errClose)
+		}
+	}()
+
+	pool = newConnPool
+
+	ddls := []DDL{DDL{}}
+
+	for _, ddl := range ddls {
+		errD := ddl.MigrateDown(ctx, pool)
+		if errD != nil {
+			log.Fatal(errD)
+		}
+		errU := ddl.MigrateUp(ctx, pool)
+		if errU != nil {
+			log.Fatal(errU)
+		}
+	}
+
+	retCode := m.Run()
+
+	for _, ddl := range ddls {
+		errD := ddl.MigrateDown(ctx, pool)
+		if errD != nil {
+			log.Fatal(errD)
+		}
+	}
+
+	os.Exit(retCode)
+
+}
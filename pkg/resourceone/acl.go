@@ -0,0 +1,165 @@
+package resourceone
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/sirupsen/logrus"
+
+	"github.com/vincentserpoul/gorestarter/pkg/rest/mid"
+)
+
+// ErrInvalidShare is returned when sharing with the owner or with an unknown access
+var ErrInvalidShare = errors.New("invalid share")
+
+// isOwner returns ErrSQLNotFound if ownerID doesn't own the resourceone in the context tenant
+func isOwner(
+	ctx context.Context,
+	db *sqlx.DB,
+	ownerID string,
+	resourceoneID int64,
+) error {
+	var count int
+	err := db.GetContext(
+		ctx,
+		&count,
+		mid.SQLComment(ctx, `
+			SELECT COUNT(*) FROM resourceone
+			WHERE resourceone_id = ? AND tenant_id = ? AND owner_id = ?
+		`),
+		resourceoneID, mid.GetTenant(ctx), ownerID,
+	)
+	if err != nil {
+		return fmt.Errorf("isOwner(%s, %d): %v", ownerID, resourceoneID, err)
+	}
+
+	if count == 0 {
+		return ErrSQLNotFound
+	}
+
+	return nil
+}
+
+// Share gives principalID access to a resourceone, only its owner can share it
+func Share(
+	ctx context.Context,
+	db *sqlx.DB,
+	ownerID string,
+	resourceoneID int64,
+	principalID string,
+	access Access,
+) error {
+	if principalID == "" || principalID == ownerID ||
+		(access != AccessRead && access != AccessWrite) {
+		return ErrInvalidShare
+	}
+
+	errO := isOwner(ctx, db, ownerID, resourceoneID)
+	if errO != nil {
+		return errO
+	}
+
+	_, err := db.NamedExecContext(
+		ctx,
+		mid.SQLComment(ctx, `
+			INSERT INTO resourceone_acl(resourceone_id, tenant_id, principal_id, access)
+			VALUES (:resourceoneID, :tenantID, :principalID, :access)
+			ON DUPLICATE KEY UPDATE access = VALUES(access)
+		`),
+		map[string]interface{}{
+			"resourceoneID": resourceoneID,
+			"tenantID":      mid.GetTenant(ctx),
+			"principalID":   principalID,
+			"access":        access,
+		},
+	)
+	if err != nil {
+		return fmt.Errorf("Share(%d, %s, %s): %v", resourceoneID, principalID, access, err)
+	}
+
+	mid.LoggerFrom(ctx).WithFields(logrus.Fields{
+		"resourceone_id": resourceoneID, "shared_with": principalID, "access": access,
+	}).Info("resourceone shared")
+
+	return nil
+}
+
+// Unshare removes the access of principalID to a resourceone, only its owner can unshare it
+func Unshare(
+	ctx context.Context,
+	db *sqlx.DB,
+	ownerID string,
+	resourceoneID int64,
+	principalID string,
+) error {
+	errO := isOwner(ctx, db, ownerID, resourceoneID)
+	if errO != nil {
+		return errO
+	}
+
+	res, err := db.NamedExecContext(
+		ctx,
+		mid.SQLComment(ctx, `
+			DELETE FROM resourceone_acl
+			WHERE resourceone_id = :resourceoneID
+				AND tenant_id = :tenantID
+				AND principal_id = :principalID
+		`),
+		map[string]interface{}{
+			"resourceoneID": resourceoneID,
+			"tenantID":      mid.GetTenant(ctx),
+			"principalID":   principalID,
+		},
+	)
+	if err != nil {
+		return fmt.Errorf("Unshare(%d, %s): %v", resourceoneID, principalID, err)
+	}
+
+	ra, errRA := res.RowsAffected()
+	if errRA != nil {
+		return fmt.Errorf("Unshare(%d, %s): %v", resourceoneID, principalID, errRA)
+	}
+
+	if ra == 0 {
+		return ErrSQLNotFound
+	}
+
+	mid.LoggerFrom(ctx).WithFields(logrus.Fields{
+		"resourceone_id": resourceoneID, "unshared_with": principalID,
+	}).Info("resourceone unshared")
+
+	return nil
+}
+
+// SelectACL returns the ACL of a resourceone, only its owner can see it
+func SelectACL(
+	ctx context.Context,
+	db *sqlx.DB,
+	ownerID string,
+	resourceoneID int64,
+) ([]*ACL, error) {
+	errO := isOwner(ctx, db, ownerID, resourceoneID)
+	if errO != nil {
+		return nil, errO
+	}
+
+	acl := []*ACL{}
+	err := db.SelectContext(
+		ctx,
+		&acl,
+		mid.SQLComment(ctx, `
+			SELECT resourceone_id, principal_id, access, time_created
+			FROM resourceone_acl
+			WHERE resourceone_id = ? AND tenant_id = ?
+			ORDER BY principal_id
+		`),
+		resourceoneID, mid.GetTenant(ctx),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("SelectACL(%d): %v", resourceoneID, err)
+	}
+
+	return acl, nil
+}
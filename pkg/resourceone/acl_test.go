@@ -0,0 +1,129 @@
+package resourceone
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestShare(t *testing.T) {
+	ctx := context.Background()
+	ec := &Resourceone{OwnerID: `owner`, Label: `test`}
+	_ = ec.Create(ctx, pool)
+
+	tests := []struct {
+		name        string
+		ownerID     string
+		principalID string
+		access      Access
+		wantErr     error
+	}{
+		{name: "share read", ownerID: `owner`, principalID: `reader`, access: AccessRead},
+		{name: "share write", ownerID: `owner`, principalID: `writer`, access: AccessWrite},
+		{name: "share again", ownerID: `owner`, principalID: `writer`, access: AccessWrite},
+		{name: "share by non owner", ownerID: `reader`, principalID: `other`, access: AccessRead, wantErr: ErrSQLNotFound},
+		{name: "share with owner", ownerID: `owner`, principalID: `owner`, access: AccessRead, wantErr: ErrInvalidShare},
+		{name: "unknown access", ownerID: `owner`, principalID: `other`, access: `admin`, wantErr: ErrInvalidShare},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := Share(ctx, pool, tt.ownerID, ec.ID, tt.principalID, tt.access)
+			if err != tt.wantErr {
+				t.Errorf("Share() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+
+	acl, err := SelectACL(ctx, pool, `owner`, ec.ID)
+	if err != nil || len(acl) != 2 {
+		t.Errorf("SelectACL() = %v, %v, wanted 2 entries", acl, err)
+	}
+	if _, err := SelectACL(ctx, pool, `reader`, ec.ID); err != ErrSQLNotFound {
+		t.Errorf("SelectACL() by non owner error = %v", err)
+	}
+}
+
+func TestAccessFor(t *testing.T) {
+	ctx := context.Background()
+	ec := &Resourceone{OwnerID: `owner`, Label: `test`}
+	_ = ec.Create(ctx, pool)
+	_ = Share(ctx, pool, `owner`, ec.ID, `reader`, AccessRead)
+	_ = Share(ctx, pool, `owner`, ec.ID, `writer`, AccessWrite)
+
+	tests := []struct {
+		name       string
+		callerID   string
+		wantRead   bool
+		wantUpdate bool
+	}{
+		{name: "owner", callerID: `owner`, wantRead: true, wantUpdate: true},
+		{name: "reader", callerID: `reader`, wantRead: true},
+		{name: "writer", callerID: `writer`, wantRead: true, wantUpdate: true},
+		{name: "stranger", callerID: `stranger`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, errS := SelectByIDFor(ctx, pool, tt.callerID, ec.ID)
+			if (errS == nil) != tt.wantRead {
+				t.Errorf("SelectByIDFor() error = %v, wantRead %v", errS, tt.wantRead)
+			}
+
+			found := false
+			es, _ := SelectByTimeUpdatedFor(ctx, pool, tt.callerID, time.Now().Add(-time.Hour))
+			for _, e := range es {
+				found = found || e.ID == ec.ID
+			}
+			if found != tt.wantRead {
+				t.Errorf("SelectByTimeUpdatedFor() found %v, wantRead %v", found, tt.wantRead)
+			}
+
+			errU := UpdateFor(ctx, pool, tt.callerID, ec.ID, &Resourceone{Label: tt.callerID})
+			if (errU == nil) != tt.wantUpdate {
+				t.Errorf("UpdateFor() error = %v, wantUpdate %v", errU, tt.wantUpdate)
+			}
+		})
+	}
+
+	// Only the owner can delete, the ACL goes with the row
+	if err := DeleteFor(ctx, pool, `writer`, ec.ID); err != ErrSQLNotFound {
+		t.Errorf("DeleteFor() by writer error = %v", err)
+	}
+	if err := DeleteFor(ctx, pool, `owner`, ec.ID); err != nil {
+		t.Errorf("DeleteFor() by owner error = %v", err)
+	}
+	if err := Unshare(ctx, pool, `owner`, ec.ID, `reader`); err != ErrSQLNotFound {
+		t.Errorf("Unshare() after delete error = %v", err)
+	}
+}
+
+func TestUnshare(t *testing.T) {
+	ctx := context.Background()
+	ec := &Resourceone{OwnerID: `owner`, Label: `test`}
+	_ = ec.Create(ctx, pool)
+	_ = Share(ctx, pool, `owner`, ec.ID, `reader`, AccessRead)
+
+	tests := []struct {
+		name        string
+		ownerID     string
+		principalID string
+		wantErr     error
+	}{
+		{name: "by non owner", ownerID: `reader`, principalID: `reader`, wantErr: ErrSQLNotFound},
+		{name: "unshare", ownerID: `owner`, principalID: `reader`},
+		{name: "unshare twice", ownerID: `owner`, principalID: `reader`, wantErr: ErrSQLNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := Unshare(ctx, pool, tt.ownerID, ec.ID, tt.principalID); err != tt.wantErr {
+				t.Errorf("Unshare() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+
+	if _, err := SelectByIDFor(ctx, pool, `reader`, ec.ID); err != ErrSQLNotFound {
+		t.Errorf("SelectByIDFor() after unshare error = %v", err)
+	}
+}
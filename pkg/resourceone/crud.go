@@ -7,6 +7,9 @@ import (
 	"time"
 
 	"github.com/jmoiron/sqlx"
+
+	"github.com/vincentserpoul/gorestarter/pkg/rest/mid"
+	"github.com/vincentserpoul/gorestarter/pkg/tracing"
 )
 
 // ErrSQLNotFound is returned when no rows affected or found
@@ -18,7 +21,7 @@ func (e *Resourceone) Create(
 	db *sqlx.DB,
 ) error {
 	// Insert in DB
-	resourceoneID, errIns := insertOne(ctx, db, e.Label)
+	resourceoneID, errIns := insertOne(ctx, db, e.OwnerID, e.Label)
 	if errIns != nil {
 		return fmt.Errorf("Create(%s): %v", e.Label, errIns)
 	}
@@ -33,20 +36,28 @@ func (e *Resourceone) Create(
 func insertOne(
 	ctx context.Context,
 	db *sqlx.DB,
+	ownerID string,
 	label string,
 ) (int64, error) {
 
+	query := `
+		INSERT INTO resourceone(tenant_id, owner_id, label)
+		VALUES (:tenantID, :ownerID, :label)
+		`
+	ctx, span := tracing.StartSQL(ctx, "resourceone.insertOne", query)
+	defer span.End()
+
 	res, err := db.NamedExecContext(
 		ctx,
-		`
-			INSERT INTO resourceone(label)
-			VALUES (:label)
-		`,
+		mid.SQLComment(ctx, query),
 		map[string]interface{}{
-			"label": label,
+			"tenantID": mid.GetTenant(ctx),
+			"ownerID":  ownerID,
+			"label":    label,
 		},
 	)
 	if err != nil {
+		span.SetError(err)
 		return 0, fmt.Errorf("insertOne(%s): %v", label, err)
 	}
 
@@ -67,7 +78,7 @@ type queryFilter struct {
 // filterByID will filter the select query by resourceone.ID
 func filterByID(resourceoneID int64) queryFilter {
 	return queryFilter{
-		filterSQL: " AND resourceone_id = :resourceoneID ",
+		filterSQL: " AND r.resourceone_id = :resourceoneID ",
 		namedParams: map[string]interface{}{
 			"resourceoneID": resourceoneID,
 		},
@@ -92,10 +103,29 @@ func SelectByID(
 	return es[0], nil
 }
 
+// SelectByIDFor returns one resourceone entity, if callerID owns it or was shared it
+func SelectByIDFor(
+	ctx context.Context,
+	db *sqlx.DB,
+	callerID string,
+	resourceoneID int64,
+) (*Resourceone, error) {
+	es, err := selectsqlFor(ctx, db, callerID, filterByID(resourceoneID))
+	if err != nil {
+		return nil, fmt.Errorf("SelectByIDFor(%s, %d): %v", callerID, resourceoneID, err)
+	}
+
+	if len(es) == 0 {
+		return nil, ErrSQLNotFound
+	}
+
+	return es[0], nil
+}
+
 // FilterByTimeUpdated will filter the select query by resourceone.ID
 func filterByTimeUpdated(updatedAfter time.Time) queryFilter {
 	return queryFilter{
-		filterSQL: " AND time_updated > :updatedAfter ",
+		filterSQL: " AND r.time_updated > :updatedAfter ",
 		namedParams: map[string]interface{}{
 			"updatedAfter": updatedAfter,
 		},
@@ -120,17 +150,40 @@ func SelectByTimeUpdated(
 	return es, nil
 }
 
-// selectsql will get a specific resourceone from the DB and return an resourceone struct
+// SelectByTimeUpdatedFor will get all the entityone updated after a certain date,
+// that callerID owns or was shared
+func SelectByTimeUpdatedFor(
+	ctx context.Context,
+	db *sqlx.DB,
+	callerID string,
+	updatedAfter time.Time,
+) ([]*Resourceone, error) {
+	es, err := selectsqlFor(ctx, db, callerID, filterByTimeUpdated(updatedAfter))
+	if err != nil {
+		return nil, fmt.Errorf("SelectByTimeUpdatedFor(%s, %v): %v", callerID, updatedAfter, err)
+	}
+
+	if len(es) == 0 {
+		return nil, ErrSQLNotFound
+	}
+
+	return es, nil
+}
+
+// selectsql will get a specific resourceone from the DB and return an resourceone struct,
+// only the resourceones of the context tenant are selected
 func selectsql(
 	ctx context.Context,
 	db *sqlx.DB,
 	queryFilters ...queryFilter,
 ) ([]*Resourceone, error) {
 
-	query := `SELECT resourceone_id, label, time_created, time_updated
-				FROM resourceone
-				WHERE 0=0 `
-	namedParams := make(map[string]interface{})
+	query := `SELECT ` + selectColumns + `
+				FROM resourceone r
+				WHERE r.tenant_id = :tenantID `
+	namedParams := map[string]interface{}{
+		"tenantID": mid.GetTenant(ctx),
+	}
 
 	// merge filters into the query
 	for _, filter := range queryFilters {
@@ -142,21 +195,82 @@ func selectsql(
 		}
 	}
 
-	rows, err := db.NamedQueryContext(ctx, query, namedParams)
+	return query2es(ctx, db, query, namedParams, queryFilters)
+}
+
+// selectColumns are the resourceone columns, r being the resourceone table
+const selectColumns = `r.resourceone_id, r.owner_id, r.label, r.time_created, r.time_updated`
+
+// selectsqlFor will get the resourceones callerID owns or was shared.
+// Both parts of the union use an index on their caller column.
+func selectsqlFor(
+	ctx context.Context,
+	db *sqlx.DB,
+	callerID string,
+	queryFilters ...queryFilter,
+) ([]*Resourceone, error) {
+
+	filterSQL := ""
+	namedParams := map[string]interface{}{
+		"tenantID": mid.GetTenant(ctx),
+		"callerID": callerID,
+	}
+
+	// merge filters into the query
+	for _, filter := range queryFilters {
+		filterSQL += filter.filterSQL
+		for k, v := range filter.namedParams {
+			namedParams[k] = v
+		}
+	}
+
+	query := `SELECT ` + selectColumns + `
+				FROM resourceone r
+				WHERE r.tenant_id = :tenantID
+					AND r.owner_id = :callerID ` + filterSQL + `
+			UNION
+			SELECT ` + selectColumns + `
+				FROM resourceone_acl a
+				JOIN resourceone r ON r.resourceone_id = a.resourceone_id
+				WHERE a.tenant_id = :tenantID
+					AND a.principal_id = :callerID
+					AND r.tenant_id = :tenantID ` + filterSQL
+
+	return query2es(ctx, db, query, namedParams, queryFilters)
+}
+
+// query2es runs a resourceone select query
+func query2es(
+	ctx context.Context,
+	db *sqlx.DB,
+	query string,
+	namedParams map[string]interface{},
+	queryFilters []queryFilter,
+) ([]*Resourceone, error) {
+
+	ctx, span := tracing.StartSQL(ctx, "resourceone.Select", query)
+	defer span.End()
+
+	rows, err := db.NamedQueryContext(ctx, mid.SQLComment(ctx, query), namedParams)
 	if err != nil {
+		span.SetError(err)
 		return nil, fmt.Errorf("Select(%v): %v", queryFilters, err)
 	}
 
+	defer rows.Close()
+
 	var es []*Resourceone
 
 	for rows.Next() {
 		e := &Resourceone{}
 		err := rows.StructScan(e)
 		if err != nil {
+			span.SetError(err)
 			return nil, fmt.Errorf("Select(%v): %v", queryFilters, err)
 		}
 		es = append(es, e)
 	}
+	mid.LoggerFrom(ctx).WithField("rows", len(es)).Debug("resourceone selected")
 
 	return es, nil
 }
@@ -169,20 +283,27 @@ func Update(
 	e *Resourceone,
 ) error {
 
+	query := `
+		UPDATE resourceone
+			SET label = :label,
+				time_updated = NOW()
+		WHERE resourceone_id = :resourceoneID
+			AND tenant_id = :tenantID
+		`
+	ctx, span := tracing.StartSQL(ctx, "resourceone.Update", query)
+	defer span.End()
+
 	res, err := db.NamedExecContext(
 		ctx,
-		`
-			UPDATE resourceone
-				SET label = :label,
-					time_updated = NOW()
-			WHERE resourceone_id = :resourceoneID
-		`,
+		mid.SQLComment(ctx, query),
 		map[string]interface{}{
 			"label":         e.Label,
 			"resourceoneID": resourceoneID,
+			"tenantID":      mid.GetTenant(ctx),
 		},
 	)
 	if err != nil {
+		span.SetError(err)
 		return fmt.Errorf("Update(%d, %s): %v", e.ID, e.Label, err)
 	}
 
@@ -205,17 +326,24 @@ func Delete(
 	resourceoneID int64,
 ) error {
 
+	query := `
+		DELETE FROM resourceone
+		WHERE resourceone_id = :resourceoneID
+			AND tenant_id = :tenantID
+		`
+	ctx, span := tracing.StartSQL(ctx, "resourceone.Delete", query)
+	defer span.End()
+
 	res, err := db.NamedExecContext(
 		ctx,
-		`
-			DELETE FROM resourceone
-			WHERE resourceone_id = :resourceoneID
-		`,
+		mid.SQLComment(ctx, query),
 		map[string]interface{}{
 			"resourceoneID": resourceoneID,
+			"tenantID":      mid.GetTenant(ctx),
 		},
 	)
 	if err != nil {
+		span.SetError(err)
 		return fmt.Errorf("Delete(%d): %v", resourceoneID, err)
 	}
 
@@ -230,3 +358,104 @@ func Delete(
 
 	return nil
 }
+
+// UpdateFor will update an specific resourceone in the DB,
+// if callerID owns it or was shared it with write access
+func UpdateFor(
+	ctx context.Context,
+	db *sqlx.DB,
+	callerID string,
+	resourceoneID int64,
+	e *Resourceone,
+) error {
+
+	query := `
+		UPDATE resourceone
+			SET label = :label,
+				time_updated = NOW()
+		WHERE resourceone_id = :resourceoneID
+			AND tenant_id = :tenantID
+			AND (
+				owner_id = :callerID
+				OR EXISTS (
+					SELECT 1 FROM resourceone_acl
+					WHERE resourceone_id = :resourceoneID
+						AND tenant_id = :tenantID
+						AND principal_id = :callerID
+						AND access = :access
+				)
+			)
+		`
+	ctx, span := tracing.StartSQL(ctx, "resourceone.UpdateFor", query)
+	defer span.End()
+
+	res, err := db.NamedExecContext(
+		ctx,
+		mid.SQLComment(ctx, query),
+		map[string]interface{}{
+			"label":         e.Label,
+			"resourceoneID": resourceoneID,
+			"tenantID":      mid.GetTenant(ctx),
+			"callerID":      callerID,
+			"access":        AccessWrite,
+		},
+	)
+	if err != nil {
+		span.SetError(err)
+		return fmt.Errorf("UpdateFor(%s, %d, %s): %v", callerID, resourceoneID, e.Label, err)
+	}
+
+	ra, errRA := res.RowsAffected()
+	if errRA != nil {
+		return fmt.Errorf("UpdateFor(%s, %d): %v", callerID, resourceoneID, errRA)
+	}
+
+	if ra == 0 {
+		return ErrSQLNotFound
+	}
+
+	return nil
+}
+
+// DeleteFor will delete an resourceone from the DB, only if callerID owns it
+func DeleteFor(
+	ctx context.Context,
+	db *sqlx.DB,
+	callerID string,
+	resourceoneID int64,
+) error {
+
+	query := `
+		DELETE FROM resourceone
+		WHERE resourceone_id = :resourceoneID
+			AND tenant_id = :tenantID
+			AND owner_id = :callerID
+		`
+	ctx, span := tracing.StartSQL(ctx, "resourceone.DeleteFor", query)
+	defer span.End()
+
+	res, err := db.NamedExecContext(
+		ctx,
+		mid.SQLComment(ctx, query),
+		map[string]interface{}{
+			"resourceoneID": resourceoneID,
+			"tenantID":      mid.GetTenant(ctx),
+			"callerID":      callerID,
+		},
+	)
+	if err != nil {
+		span.SetError(err)
+		return fmt.Errorf("DeleteFor(%s, %d): %v", callerID, resourceoneID, err)
+	}
+
+	ra, errRA := res.RowsAffected()
+	if errRA != nil {
+		return fmt.Errorf("DeleteFor(%s, %d): %v", callerID, resourceoneID, errRA)
+	}
+
+	if ra == 0 {
+		return ErrSQLNotFound
+	}
+
+	return nil
+}
@@ -9,6 +9,11 @@ import (
 // DDL is used to do modifications in the DB
 type DDL struct{}
 
+// Version is the schema version of MigrateUp
+func (ddl *DDL) Version() int {
+	return 2
+}
+
 // MigrateUp creates the needed tables
 func (ddl *DDL) MigrateUp(ctx context.Context, db *sqlx.DB) error {
 	_, errExec := db.ExecContext(
@@ -16,13 +21,88 @@ func (ddl *DDL) MigrateUp(ctx context.Context, db *sqlx.DB) error {
 		`
             CREATE TABLE IF NOT EXISTS resourceone (
                 resourceone_id BIGINT NOT NULL AUTO_INCREMENT,
+                tenant_id VARCHAR(63) NOT NULL DEFAULT '',
+                owner_id VARCHAR(255) NOT NULL DEFAULT '',
                 label VARCHAR(50),
                 time_created DATETIME NOT NULL DEFAULT NOW(),
 				time_updated DATETIME NOT NULL DEFAULT NOW(),
                 PRIMARY KEY (resourceone_id),
-				INDEX r_tu_idx (time_updated ASC)
+				INDEX r_t_tu_idx (tenant_id, time_updated),
+				INDEX r_t_o_tu_idx (tenant_id, owner_id, time_updated)
+            );
+    `)
+	if errExec != nil {
+		return errExec
+	}
+
+	// Tables created before these columns existed
+	errO := ddl.addColumn(ctx, db, "resourceone", "owner_id", `
+			ALTER TABLE resourceone
+				ADD COLUMN owner_id VARCHAR(255) NOT NULL DEFAULT '' AFTER resourceone_id;
+    `)
+	if errO != nil {
+		return errO
+	}
+	errT := ddl.addColumn(ctx, db, "resourceone", "tenant_id", `
+			ALTER TABLE resourceone
+				ADD COLUMN tenant_id VARCHAR(63) NOT NULL DEFAULT '' AFTER resourceone_id,
+				ADD INDEX r_t_tu_idx (tenant_id, time_updated),
+				ADD INDEX r_t_o_tu_idx (tenant_id, owner_id, time_updated);
+    `)
+	if errT != nil {
+		return errT
+	}
+
+	_, errExec = db.ExecContext(
+		ctx,
+		`
+            CREATE TABLE IF NOT EXISTS resourceone_acl (
+                resourceone_id BIGINT NOT NULL,
+                tenant_id VARCHAR(63) NOT NULL DEFAULT '',
+                principal_id VARCHAR(255) NOT NULL,
+                access VARCHAR(5) NOT NULL,
+                time_created DATETIME NOT NULL DEFAULT NOW(),
+                PRIMARY KEY (resourceone_id, principal_id),
+				INDEX ra_t_p_idx (tenant_id, principal_id),
+				FOREIGN KEY (resourceone_id) REFERENCES resourceone (resourceone_id)
+					ON DELETE CASCADE
             );
     `)
+	if errExec != nil {
+		return errExec
+	}
+
+	// Shares created before they were scoped by tenant, in the tenant of their resourceone
+	return ddl.addColumn(ctx, db, "resourceone_acl", "tenant_id", `
+			ALTER TABLE resourceone_acl
+				ADD COLUMN tenant_id VARCHAR(63) NOT NULL DEFAULT '' AFTER resourceone_id,
+				DROP INDEX ra_p_idx,
+				ADD INDEX ra_t_p_idx (tenant_id, principal_id);
+			UPDATE resourceone_acl a
+				JOIN resourceone r ON r.resourceone_id = a.resourceone_id
+				SET a.tenant_id = r.tenant_id;
+    `)
+}
+
+// addColumn runs alterSQL if table doesn't have column yet
+func (ddl *DDL) addColumn(ctx context.Context, db *sqlx.DB, table string, column string, alterSQL string) error {
+	var count int
+	errC := db.GetContext(
+		ctx,
+		&count,
+		`
+			SELECT COUNT(*) FROM information_schema.COLUMNS
+			WHERE TABLE_SCHEMA = DATABASE()
+				AND TABLE_NAME = ?
+				AND COLUMN_NAME = ?
+		`,
+		table, column,
+	)
+	if errC != nil || count > 0 {
+		return errC
+	}
+
+	_, errExec := db.ExecContext(ctx, alterSQL)
 
 	return errExec
 }
@@ -32,6 +112,7 @@ func (ddl *DDL) MigrateDown(ctx context.Context, db *sqlx.DB) error {
 	_, errExec := db.ExecContext(
 		ctx,
 		`
+        DROP TABLE IF EXISTS resourceone_acl;
         DROP TABLE IF EXISTS resourceone;
     `)
 
@@ -5,7 +5,25 @@ import "time"
 // Resourceone represents an entity
 type Resourceone struct {
 	ID          int64     `db:"resourceone_id" json:"resourceoneId"`
+	OwnerID     string    `db:"owner_id" json:"ownerId"`
 	Label       string    `db:"label" json:"label"`
 	TimeCreated time.Time `db:"time_created" json:"timeCreated"`
 	TimeUpdated time.Time `db:"time_updated" json:"timeUpdated"`
 }
+
+// Access is the access level granted by an ACL entry
+type Access string
+
+// Access levels, write implies read
+const (
+	AccessRead  Access = "read"
+	AccessWrite Access = "write"
+)
+
+// ACL is an entry sharing a resourceone with a principal
+type ACL struct {
+	ResourceoneID int64     `db:"resourceone_id" json:"resourceoneId"`
+	PrincipalID   string    `db:"principal_id" json:"principalId"`
+	Access        Access    `db:"access" json:"access"`
+	TimeCreated   time.Time `db:"time_created" json:"timeCreated"`
+}
@@ -1,11 +1,11 @@
 package resourceone
 
 import (
-	"encoding/json"
-	"log"
+	"errors"
 	"net/http"
 	"strconv"
 
+	"github.com/vincentserpoul/gorestarter/pkg/rest/mid"
 	"github.com/vincentserpoul/gorestarter/pkg/rest/renderer"
 
 	"time"
@@ -15,24 +15,50 @@ import (
 	"github.com/jmoiron/sqlx"
 )
 
+// Permissions needed on the resourceone routes, see mid.Policy
+const (
+	PermissionRead  = "resourceone:read"
+	PermissionWrite = "resourceone:write"
+)
+
 // Router is returning the handler for resourceone rest handler
 func Router(db *sqlx.DB) http.Handler {
 	r := chi.NewRouter()
+	r.NotFound(renderer.NotFoundHandler)
+	r.MethodNotAllowed(renderer.MethodNotAllowedHandler)
+
 	// RESTy routes for resourceone resource
 	r.Route("/resourceone", func(r chi.Router) {
-		r.Post("/", POSTHandler(db))
-		r.Get("/", GETListHandler(db))
+		r.With(mid.Require(PermissionWrite)).Post("/", POSTHandler(db))
+		r.With(mid.Require(PermissionRead)).Get("/", GETListHandler(db))
 
 		// Subrouters:
 		r.Route("/{resourceoneID}", func(r chi.Router) {
-			r.Get("/", GETHandler(db))
-			r.Put("/", PUTHandler(db))
-			r.Delete("/", DELETEHandler(db))
+			r.With(mid.Require(PermissionRead)).Get("/", GETHandler(db))
+			r.With(mid.Require(PermissionWrite)).Put("/", PUTHandler(db))
+			r.With(mid.Require(PermissionWrite)).Delete("/", DELETEHandler(db))
+
+			// Sharing, only the owner can see and change the ACL
+			r.With(mid.Require(PermissionWrite)).Get("/acl", GETACLHandler(db))
+			r.With(mid.Require(PermissionWrite)).Put("/acl/{principalID}", PUTACLHandler(db))
+			r.With(mid.Require(PermissionWrite)).Delete("/acl/{principalID}", DELETEACLHandler(db))
 		})
 	})
 	return r
 }
 
+// callerID returns the ID of the authenticated caller, restricted is false
+// when authentication is disabled: every row is then accessible to anyone,
+// the rows created before ownership, owned by ”, only in that case
+func callerID(r *http.Request) (id string, restricted bool) {
+	p := mid.GetPrincipal(r.Context())
+	if p == nil {
+		return "", false
+	}
+
+	return p.ID, true
+}
+
 // POSTHandler will handle data from request and returns bytes to be written to response
 func POSTHandler(db *sqlx.DB) func(w http.ResponseWriter, r *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
@@ -40,17 +66,19 @@ func POSTHandler(db *sqlx.DB) func(w http.ResponseWriter, r *http.Request) {
 		var errRender error
 		defer func() {
 			if errRender != nil {
-				log.Fatalf("PUTHandler: render error %v", errRender)
+				mid.LoggerFrom(r.Context()).Errorf("POSTHandler: render error %v", errRender)
 			}
 		}()
 
 		e := &Resourceone{}
 
-		errJSON := json.NewDecoder(r.Body).Decode(e)
+		errJSON := renderer.DecodeJSON(r, e)
 		if errJSON != nil {
-			errRender = render.Render(w, r, renderer.ErrInvalidRequest(errJSON))
+			errRender = render.Render(w, r, renderer.ErrDecode(errJSON))
 			return
 		}
+		// The caller owns what it creates
+		e.OwnerID, _ = callerID(r)
 
 		err := e.Create(
 			r.Context(),
@@ -58,7 +86,7 @@ func POSTHandler(db *sqlx.DB) func(w http.ResponseWriter, r *http.Request) {
 		)
 
 		if err != nil {
-			errRender = render.Render(w, r, renderer.ErrRender(err))
+			errRender = render.Render(w, r, renderer.ErrServer(r.Context(), err))
 			return
 		}
 
@@ -74,22 +102,36 @@ func GETListHandler(db *sqlx.DB) func(w http.ResponseWriter, r *http.Request) {
 		var errRender error
 		defer func() {
 			if errRender != nil {
-				log.Fatalf("PUTHandler: render error %v", errRender)
+				mid.LoggerFrom(r.Context()).Errorf("GETListHandler: render error %v", errRender)
 			}
 		}()
 
-		es, errS := SelectByTimeUpdated(r.Context(), db, time.Now().Add(-6*time.Hour*24))
+		updatedAfter := time.Now().Add(-6 * time.Hour * 24)
+		var es []*Resourceone
+		var errS error
+		if caller, restricted := callerID(r); restricted {
+			es, errS = SelectByTimeUpdatedFor(r.Context(), db, caller, updatedAfter)
+		} else {
+			es, errS = SelectByTimeUpdated(r.Context(), db, updatedAfter)
+		}
 		if errS == ErrSQLNotFound {
-			errRender = render.Render(w, r, renderer.ErrNotFound)
+			errRender = render.Render(w, r, renderer.ErrNotFound())
 			return
 		}
 		if errS != nil {
-			errRender = render.Render(w, r, renderer.ErrRender(errS))
+			errRender = render.Render(w, r, renderer.ErrServer(r.Context(), errS))
+			return
+		}
+
+		if renderer.WantsNDJSON(r) {
+			w.Header().Set("Content-Type", renderer.ContentTypeNDJSON)
+			w.WriteHeader(http.StatusOK)
+			renderer.ResponseNDJSONRender(w, r, es)
 			return
 		}
 
 		w.WriteHeader(http.StatusOK)
-		renderer.ResponseJSONRender(w, r, es)
+		renderer.ResponseJSONListRender(w, r, es)
 	}
 }
 
@@ -100,7 +142,7 @@ func GETHandler(db *sqlx.DB) func(w http.ResponseWriter, r *http.Request) {
 		var errRender error
 		defer func() {
 			if errRender != nil {
-				log.Fatalf("PUTHandler: render error %v", errRender)
+				mid.LoggerFrom(r.Context()).Errorf("GETHandler: render error %v", errRender)
 			}
 		}()
 
@@ -111,13 +153,19 @@ func GETHandler(db *sqlx.DB) func(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
-		e, errS := SelectByID(r.Context(), db, resourceoneID)
+		var e *Resourceone
+		var errS error
+		if caller, restricted := callerID(r); restricted {
+			e, errS = SelectByIDFor(r.Context(), db, caller, resourceoneID)
+		} else {
+			e, errS = SelectByID(r.Context(), db, resourceoneID)
+		}
 		if errS != nil && errS != ErrSQLNotFound {
-			errRender = render.Render(w, r, renderer.ErrRender(errS))
+			errRender = render.Render(w, r, renderer.ErrServer(r.Context(), errS))
 			return
 		}
 		if e == nil && errS == ErrSQLNotFound {
-			errRender = render.Render(w, r, renderer.ErrNotFound)
+			errRender = render.Render(w, r, renderer.ErrNotFound())
 			return
 		}
 
@@ -133,7 +181,7 @@ func PUTHandler(db *sqlx.DB) func(w http.ResponseWriter, r *http.Request) {
 		var errRender error
 		defer func() {
 			if errRender != nil {
-				log.Fatalf("PUTHandler: render error %v", errRender)
+				mid.LoggerFrom(r.Context()).Errorf("PUTHandler: render error %v", errRender)
 			}
 		}()
 
@@ -148,19 +196,24 @@ func PUTHandler(db *sqlx.DB) func(w http.ResponseWriter, r *http.Request) {
 			ID: resourceoneID,
 		}
 
-		errJSON := json.NewDecoder(r.Body).Decode(e)
+		errJSON := renderer.DecodeJSON(r, e)
 		if errJSON != nil {
-			errRender = render.Render(w, r, renderer.ErrInvalidRequest(errJSON))
+			errRender = render.Render(w, r, renderer.ErrDecode(errJSON))
 			return
 		}
 
-		errU := Update(r.Context(), db, resourceoneID, e)
+		var errU error
+		if caller, restricted := callerID(r); restricted {
+			errU = UpdateFor(r.Context(), db, caller, resourceoneID, e)
+		} else {
+			errU = Update(r.Context(), db, resourceoneID, e)
+		}
 		if errU != nil && errU != ErrSQLNotFound {
-			errRender = render.Render(w, r, renderer.ErrRender(errU))
+			errRender = render.Render(w, r, renderer.ErrServer(r.Context(), errU))
 			return
 		}
 		if errU == ErrSQLNotFound {
-			errRender = render.Render(w, r, renderer.ErrNotFound)
+			errRender = render.Render(w, r, renderer.ErrNotFound())
 			return
 		}
 
@@ -176,7 +229,7 @@ func DELETEHandler(db *sqlx.DB) func(w http.ResponseWriter, r *http.Request) {
 		var errRender error
 		defer func() {
 			if errRender != nil {
-				log.Fatalf("PUTHandler: render error %v", errRender)
+				mid.LoggerFrom(r.Context()).Errorf("DELETEHandler: render error %v", errRender)
 			}
 		}()
 
@@ -187,17 +240,144 @@ func DELETEHandler(db *sqlx.DB) func(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
-		errD := Delete(
-			r.Context(),
-			db,
-			resourceoneID,
-		)
+		var errD error
+		if caller, restricted := callerID(r); restricted {
+			errD = DeleteFor(r.Context(), db, caller, resourceoneID)
+		} else {
+			errD = Delete(r.Context(), db, resourceoneID)
+		}
 		if errD != nil && errD != ErrSQLNotFound {
-			errRender = render.Render(w, r, renderer.ErrRender(errD))
+			errRender = render.Render(w, r, renderer.ErrServer(r.Context(), errD))
 			return
 		}
 		if errD == ErrSQLNotFound {
-			errRender = render.Render(w, r, renderer.ErrNotFound)
+			errRender = render.Render(w, r, renderer.ErrNotFound())
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// aclRequest is the body of a PUT on the ACL
+type aclRequest struct {
+	Access Access `json:"access"`
+}
+
+// aclParams returns the owner, the resourceone and the principal of an ACL request
+func aclParams(r *http.Request) (ownerID string, resourceoneID int64, principalID string, errP render.Renderer) {
+	ownerID, restricted := callerID(r)
+	if !restricted {
+		return "", 0, "", renderer.ErrForbidden(errors.New("aclParams: sharing needs authentication"))
+	}
+
+	resourceoneIDstr := chi.URLParam(r, "resourceoneID")
+	resourceoneID, errConv := strconv.ParseInt(resourceoneIDstr, 10, 64)
+	if resourceoneIDstr == "" || errConv != nil {
+		return "", 0, "", renderer.ErrInvalidRequest(errConv)
+	}
+
+	return ownerID, resourceoneID, chi.URLParam(r, "principalID"), nil
+}
+
+// GETACLHandler lists the principals a resourceone is shared with
+func GETACLHandler(db *sqlx.DB) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// Handler errors after rendering
+		var errRender error
+		defer func() {
+			if errRender != nil {
+				mid.LoggerFrom(r.Context()).Errorf("GETACLHandler: render error %v", errRender)
+			}
+		}()
+
+		ownerID, resourceoneID, _, errP := aclParams(r)
+		if errP != nil {
+			errRender = render.Render(w, r, errP)
+			return
+		}
+
+		acl, errS := SelectACL(r.Context(), db, ownerID, resourceoneID)
+		if errS == ErrSQLNotFound {
+			errRender = render.Render(w, r, renderer.ErrNotFound())
+			return
+		}
+		if errS != nil {
+			errRender = render.Render(w, r, renderer.ErrServer(r.Context(), errS))
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+		renderer.ResponseJSONListRender(w, r, acl)
+	}
+}
+
+// PUTACLHandler shares a resourceone with a principal
+func PUTACLHandler(db *sqlx.DB) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// Handler errors after rendering
+		var errRender error
+		defer func() {
+			if errRender != nil {
+				mid.LoggerFrom(r.Context()).Errorf("PUTACLHandler: render error %v", errRender)
+			}
+		}()
+
+		ownerID, resourceoneID, principalID, errP := aclParams(r)
+		if errP != nil {
+			errRender = render.Render(w, r, errP)
+			return
+		}
+
+		req := &aclRequest{}
+		errJSON := renderer.DecodeJSON(r, req)
+		if errJSON != nil {
+			errRender = render.Render(w, r, renderer.ErrDecode(errJSON))
+			return
+		}
+
+		errS := Share(r.Context(), db, ownerID, resourceoneID, principalID, req.Access)
+		if errS == ErrInvalidShare {
+			errRender = render.Render(w, r, renderer.ErrInvalidRequest(errS))
+			return
+		}
+		if errS == ErrSQLNotFound {
+			errRender = render.Render(w, r, renderer.ErrNotFound())
+			return
+		}
+		if errS != nil {
+			errRender = render.Render(w, r, renderer.ErrServer(r.Context(), errS))
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// DELETEACLHandler stops sharing a resourceone with a principal
+func DELETEACLHandler(db *sqlx.DB) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// Handler errors after rendering
+		var errRender error
+		defer func() {
+			if errRender != nil {
+				mid.LoggerFrom(r.Context()).Errorf("DELETEACLHandler: render error %v", errRender)
+			}
+		}()
+
+		ownerID, resourceoneID, principalID, errP := aclParams(r)
+		if errP != nil {
+			errRender = render.Render(w, r, errP)
+			return
+		}
+
+		errU := Unshare(r.Context(), db, ownerID, resourceoneID, principalID)
+		if errU == ErrSQLNotFound {
+			errRender = render.Render(w, r, renderer.ErrNotFound())
+			return
+		}
+		if errU != nil {
+			errRender = render.Render(w, r, renderer.ErrServer(r.Context(), errU))
 			return
 		}
 
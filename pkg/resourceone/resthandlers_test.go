@@ -12,28 +12,54 @@ import (
 	"testing"
 
 	"github.com/go-chi/chi"
+
+	"github.com/vincentserpoul/gorestarter/pkg/rest/mid"
 )
 
 func TestPOSTHandler(t *testing.T) {
 
 	tests := []struct {
 		name         string
+		contentType  string
 		requestBody  string
 		wantedStatus int
 		wantedLabel  string
 	}{
 		{
 			name:         "Working POST",
+			contentType:  "application/json",
 			requestBody:  `{"label": "test"}`,
 			wantedStatus: http.StatusCreated,
 			wantedLabel:  `test`,
 		},
 		{
 			name:         "Non Working POST",
+			contentType:  "application/json",
 			requestBody:  `"label": "test"}`,
 			wantedStatus: http.StatusBadRequest,
 			wantedLabel:  ``,
 		},
+		{
+			name:         "Non Working POST unknown field",
+			contentType:  "application/json",
+			requestBody:  `{"label": "test", "unknown": 1}`,
+			wantedStatus: http.StatusBadRequest,
+			wantedLabel:  ``,
+		},
+		{
+			name:         "Non Working POST trailing data",
+			contentType:  "application/json",
+			requestBody:  `{"label": "test"}{"label": "test"}`,
+			wantedStatus: http.StatusBadRequest,
+			wantedLabel:  ``,
+		},
+		{
+			name:         "Non Working POST not JSON",
+			contentType:  "text/plain",
+			requestBody:  `{"label": "test"}`,
+			wantedStatus: http.StatusUnsupportedMediaType,
+			wantedLabel:  ``,
+		},
 	}
 
 	for _, tt := range tests {
@@ -44,6 +70,7 @@ func TestPOSTHandler(t *testing.T) {
 			if errR != nil {
 				t.Fatalf("request creation failed %v", errR)
 			}
+			request.Header.Set("Content-Type", tt.contentType)
 			POSTHandler(pool)(rr, request)
 			res := rr.Result()
 			defer func() {
@@ -73,13 +100,13 @@ func TestPOSTHandler(t *testing.T) {
 var testResourceoneIDsHandler []int64
 
 func BenchmarkPOSTHandler(b *testing.B) {
-	jsonRequestOK, errR := http.NewRequest("POST", `http://dummy/resourceone`,
-		bytes.NewBufferString(`{"label": "test"}`))
-	if errR != nil {
-		b.Fatal(errR)
-	}
-
 	for i := 0; i < b.N; i++ {
+		jsonRequestOK, errR := http.NewRequest("POST", `http://dummy/resourceone`,
+			bytes.NewBufferString(`{"label": "test"}`))
+		if errR != nil {
+			b.Fatal(errR)
+		}
+		jsonRequestOK.Header.Set("Content-Type", "application/json")
 		rr := httptest.NewRecorder()
 		POSTHandler(pool)(rr, jsonRequestOK)
 		res := rr.Result()
@@ -104,17 +131,24 @@ func TestGETListHandler(t *testing.T) {
 
 	tests := []struct {
 		name         string
+		accept       string
 		wantedStatus int
 	}{
 		{
 			name:         "Working GETList",
 			wantedStatus: http.StatusOK,
 		},
+		{
+			name:         "Working GETList as NDJSON",
+			accept:       "application/x-ndjson",
+			wantedStatus: http.StatusOK,
+		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			request, _ := http.NewRequest("GET", ``, nil)
+			request.Header.Set("Accept", tt.accept)
 			rr := httptest.NewRecorder()
 
 			GETListHandler(pool)(rr, request)
@@ -131,7 +165,18 @@ func TestGETListHandler(t *testing.T) {
 			}
 
 			var e []*Resourceone
-			_ = json.NewDecoder(rr.Body).Decode(&e)
+			if tt.accept == "application/x-ndjson" {
+				dec := json.NewDecoder(rr.Body)
+				for dec.More() {
+					ed := &Resourceone{}
+					if errJSON := dec.Decode(ed); errJSON != nil {
+						t.Fatal(errJSON)
+					}
+					e = append(e, ed)
+				}
+			} else {
+				_ = json.NewDecoder(rr.Body).Decode(&e)
+			}
 			if len(e) < 3 {
 				t.Errorf("GETListHandler only rendered %d entities", len(e))
 				return
@@ -275,6 +320,7 @@ func TestPUTHandler(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			request, _ := http.NewRequest("PUT", ``, bytes.NewBufferString(tt.requestURLBody))
+			request.Header.Set("Content-Type", "application/json")
 			rr := httptest.NewRecorder()
 			ctx := getTestContextWithResourceID(tt.resourceID)
 			PUTHandler(pool)(rr, request.WithContext(ctx))
@@ -310,6 +356,7 @@ func TestPUTHandler(t *testing.T) {
 func BenchmarkPUTHandler(b *testing.B) {
 	for i := 0; i < b.N; i++ {
 		request, _ := http.NewRequest("PUT", ``, bytes.NewBufferString(`{"label": "testUpdate"}`))
+		request.Header.Set("Content-Type", "application/json")
 		rr := httptest.NewRecorder()
 		ctx := getTestContextWithResourceID(strconv.FormatInt(testResourceoneIDsHandler[b.N%len(testResourceoneIDsHandler)], 10))
 		PUTHandler(pool)(rr, request.WithContext(ctx))
@@ -394,27 +441,205 @@ func getTestContextWithResourceID(resourceID string) context.Context {
 	return ctx
 }
 
-// func TestRouter(t *testing.T) {
-// 	db := &sqlx.DB{}
-// 	router := Router(db)
-// 	tests := []struct {
-// 		name   string
-// 		method string
-// 		URL    string
-// 		want   http.HandlerFunc
-// 	}{
-// 		{
-// 			name:   `GET`,
-// 			method: `GET`,
-// 			URL:    `/resourceone`,
-// 			want:   GETHandler(db),
-// 		},
-// 	}
-// 	for _, tt := range tests {
-// 		t.Run(tt.name, func(t *testing.T) {
-// 			if got := Router(tt.args.db); !reflect.DeepEqual(got, tt.want) {
-// 				t.Errorf("Router() = %v, want %v", got, tt.want)
-// 			}
-// 		})
-// 	}
-// }
+func TestRouter(t *testing.T) {
+	router := mid.Authorization(mid.AllowAllPolicy())(Router(pool))
+
+	tests := []struct {
+		name         string
+		method       string
+		URL          string
+		wantedStatus int
+		wantedAllow  string
+	}{
+		{
+			name:         "unknown route",
+			method:       "GET",
+			URL:          "/unknown",
+			wantedStatus: http.StatusNotFound,
+		},
+		{
+			name:         "method not allowed on the list",
+			method:       "DELETE",
+			URL:          "/resourceone",
+			wantedStatus: http.StatusMethodNotAllowed,
+			wantedAllow:  "GET, HEAD, POST, OPTIONS",
+		},
+		{
+			name:         "method not allowed on one resourceone",
+			method:       "POST",
+			URL:          "/resourceone/1",
+			wantedStatus: http.StatusMethodNotAllowed,
+			wantedAllow:  "GET, HEAD, PUT, DELETE, OPTIONS",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			request, _ := http.NewRequest(tt.method, tt.URL, nil)
+			router.ServeHTTP(rr, request)
+
+			if status := rr.Code; status != tt.wantedStatus {
+				t.Errorf("Router returned wrong status code: got %v want %v",
+					status, tt.wantedStatus)
+			}
+			if allow := rr.Header().Get("Allow"); allow != tt.wantedAllow {
+				t.Errorf("Router returned wrong Allow header: got %s want %s",
+					allow, tt.wantedAllow)
+			}
+		})
+	}
+}
+
+func TestRouterPermissions(t *testing.T) {
+	policy := &mid.Policy{
+		Permissions: map[string]mid.Grant{
+			PermissionRead: {Roles: []string{"reader"}},
+		},
+	}
+	router := mid.Authorization(policy)(Router(pool))
+
+	tests := []struct {
+		name         string
+		method       string
+		URL          string
+		principal    *mid.Principal
+		wantedStatus int
+	}{
+		{
+			name:         "read without permission",
+			method:       "GET",
+			URL:          "/resourceone/1",
+			principal:    &mid.Principal{ID: "test"},
+			wantedStatus: http.StatusForbidden,
+		},
+		{
+			name:         "write with the read role",
+			method:       "DELETE",
+			URL:          "/resourceone/1",
+			principal:    &mid.Principal{ID: "test", Roles: []string{"reader"}},
+			wantedStatus: http.StatusForbidden,
+		},
+		{
+			name:         "create with the read role",
+			method:       "POST",
+			URL:          "/resourceone",
+			principal:    &mid.Principal{ID: "test", Roles: []string{"reader"}},
+			wantedStatus: http.StatusForbidden,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			request, _ := http.NewRequest(tt.method, tt.URL, nil)
+			request = request.WithContext(mid.WithPrincipal(request.Context(), tt.principal))
+			router.ServeHTTP(rr, request)
+
+			if status := rr.Code; status != tt.wantedStatus {
+				t.Errorf("Router returned wrong status code: got %v want %v",
+					status, tt.wantedStatus)
+			}
+		})
+	}
+}
+
+func TestACLHandlers(t *testing.T) {
+	ec := &Resourceone{OwnerID: `owner`, Label: `test`}
+	_ = ec.Create(context.Background(), pool)
+	router := mid.Authorization(mid.AllowAllPolicy())(Router(pool))
+	aclURL := fmt.Sprintf("/resourceone/%d/acl", ec.ID)
+
+	tests := []struct {
+		name         string
+		method       string
+		URL          string
+		body         string
+		principal    *mid.Principal
+		wantedStatus int
+	}{
+		{
+			name:         "share without authentication",
+			method:       "PUT",
+			URL:          aclURL + "/reader",
+			body:         `{"access": "read"}`,
+			wantedStatus: http.StatusForbidden,
+		},
+		{
+			name:         "share",
+			method:       "PUT",
+			URL:          aclURL + "/reader",
+			body:         `{"access": "read"}`,
+			principal:    &mid.Principal{ID: "owner"},
+			wantedStatus: http.StatusNoContent,
+		},
+		{
+			name:         "share with an unknown access",
+			method:       "PUT",
+			URL:          aclURL + "/reader",
+			body:         `{"access": "all"}`,
+			principal:    &mid.Principal{ID: "owner"},
+			wantedStatus: http.StatusBadRequest,
+		},
+		{
+			name:         "read shared",
+			method:       "GET",
+			URL:          fmt.Sprintf("/resourceone/%d", ec.ID),
+			principal:    &mid.Principal{ID: "reader"},
+			wantedStatus: http.StatusOK,
+		},
+		{
+			name:         "update shared read only",
+			method:       "PUT",
+			URL:          fmt.Sprintf("/resourceone/%d", ec.ID),
+			body:         `{"label": "test"}`,
+			principal:    &mid.Principal{ID: "reader"},
+			wantedStatus: http.StatusNotFound,
+		},
+		{
+			name:         "ACL by non owner",
+			method:       "GET",
+			URL:          aclURL,
+			principal:    &mid.Principal{ID: "reader"},
+			wantedStatus: http.StatusNotFound,
+		},
+		{
+			name:         "ACL",
+			method:       "GET",
+			URL:          aclURL,
+			principal:    &mid.Principal{ID: "owner"},
+			wantedStatus: http.StatusOK,
+		},
+		{
+			name:         "unshare",
+			method:       "DELETE",
+			URL:          aclURL + "/reader",
+			principal:    &mid.Principal{ID: "owner"},
+			wantedStatus: http.StatusNoContent,
+		},
+		{
+			name:         "read unshared",
+			method:       "GET",
+			URL:          fmt.Sprintf("/resourceone/%d", ec.ID),
+			principal:    &mid.Principal{ID: "reader"},
+			wantedStatus: http.StatusNotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			request, _ := http.NewRequest(tt.method, tt.URL, bytes.NewBufferString(tt.body))
+			request.Header.Set("Content-Type", "application/json")
+			if tt.principal != nil {
+				request = request.WithContext(mid.WithPrincipal(request.Context(), tt.principal))
+			}
+			router.ServeHTTP(rr, request)
+
+			if status := rr.Code; status != tt.wantedStatus {
+				t.Errorf("Router returned wrong status code: got %v want %v",
+					status, tt.wantedStatus)
+			}
+		})
+	}
+}
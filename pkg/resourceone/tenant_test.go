@@ -0,0 +1,177 @@
+package resourceone
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/vincentserpoul/gorestarter/pkg/rest/mid"
+)
+
+func TestTenantIsolation(t *testing.T) {
+	acme := mid.WithTenant(context.Background(), "acme")
+	other := mid.WithTenant(context.Background(), "other")
+
+	e := &Resourceone{OwnerID: `owner`, Label: `acme`}
+	if err := e.Create(acme, pool); err != nil {
+		t.Fatalf("creation triggered an error %v", err)
+	}
+	_ = Share(acme, pool, `owner`, e.ID, `reader`, AccessWrite)
+
+	// Shares are scoped by tenant too
+	var shareTenant string
+	errT := pool.Get(&shareTenant, `SELECT tenant_id FROM resourceone_acl WHERE resourceone_id = ? AND principal_id = ?`, e.ID, `reader`)
+	if errT != nil || shareTenant != "acme" {
+		t.Errorf("Share() stored tenant %q, error %v", shareTenant, errT)
+	}
+
+	tests := []struct {
+		name string
+		do   func(ctx context.Context) error
+	}{
+		{
+			name: "SelectByID",
+			do: func(ctx context.Context) error {
+				_, err := SelectByID(ctx, pool, e.ID)
+				return err
+			},
+		},
+		{
+			name: "SelectByIDFor owner",
+			do: func(ctx context.Context) error {
+				_, err := SelectByIDFor(ctx, pool, `owner`, e.ID)
+				return err
+			},
+		},
+		{
+			name: "SelectByIDFor shared",
+			do: func(ctx context.Context) error {
+				_, err := SelectByIDFor(ctx, pool, `reader`, e.ID)
+				return err
+			},
+		},
+		{
+			name: "SelectByTimeUpdated",
+			do: func(ctx context.Context) error {
+				es, err := SelectByTimeUpdated(ctx, pool, time.Now().Add(-time.Hour))
+				for _, f := range es {
+					if f.ID == e.ID {
+						return nil
+					}
+				}
+				if err == nil {
+					err = ErrSQLNotFound
+				}
+				return err
+			},
+		},
+		{
+			name: "SelectByTimeUpdatedFor",
+			do: func(ctx context.Context) error {
+				es, err := SelectByTimeUpdatedFor(ctx, pool, `reader`, time.Now().Add(-time.Hour))
+				for _, f := range es {
+					if f.ID == e.ID {
+						return nil
+					}
+				}
+				if err == nil {
+					err = ErrSQLNotFound
+				}
+				return err
+			},
+		},
+		{
+			name: "Update",
+			do: func(ctx context.Context) error {
+				return Update(ctx, pool, e.ID, &Resourceone{Label: `update`})
+			},
+		},
+		{
+			name: "UpdateFor",
+			do: func(ctx context.Context) error {
+				return UpdateFor(ctx, pool, `reader`, e.ID, &Resourceone{Label: `update`})
+			},
+		},
+		{
+			name: "SelectACL",
+			do: func(ctx context.Context) error {
+				_, err := SelectACL(ctx, pool, `owner`, e.ID)
+				return err
+			},
+		},
+		{
+			name: "Share",
+			do: func(ctx context.Context) error {
+				return Share(ctx, pool, `owner`, e.ID, `other`, AccessRead)
+			},
+		},
+		{
+			name: "DeleteFor",
+			do: func(ctx context.Context) error {
+				return DeleteFor(ctx, pool, `owner`, e.ID)
+			},
+		},
+		{
+			name: "Delete",
+			do: func(ctx context.Context) error {
+				return Delete(ctx, pool, e.ID)
+			},
+		},
+	}
+
+	// Nothing is visible from the other tenant, nor without tenant
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, ctx := range []context.Context{other, context.Background()} {
+				if err := tt.do(ctx); err != ErrSQLNotFound {
+					t.Errorf("%s from tenant %q error = %v, want %v", tt.name, mid.GetTenant(ctx), err, ErrSQLNotFound)
+				}
+			}
+		})
+	}
+
+	// Everything works within the tenant, Delete last
+	for _, tt := range tests[:len(tests)-1] {
+		t.Run(tt.name+" same tenant", func(t *testing.T) {
+			if err := tt.do(acme); err != nil {
+				t.Errorf("%s from tenant acme error = %v", tt.name, err)
+			}
+		})
+	}
+}
+
+func TestRouterTenant(t *testing.T) {
+	e := &Resourceone{Label: `acme`}
+	_ = e.Create(mid.WithTenant(context.Background(), "acme"), pool)
+
+	router := mid.Tenant(&mid.TenantConf{Header: "X-Tenant-ID", Required: true, AllowAnonymous: true})(mid.Authorization(mid.AllowAllPolicy())(Router(pool)))
+
+	tests := []struct {
+		name         string
+		tenant       string
+		wantedStatus int
+	}{
+		{name: "same tenant", tenant: "acme", wantedStatus: http.StatusOK},
+		{name: "other tenant", tenant: "other", wantedStatus: http.StatusNotFound},
+		{name: "no tenant", wantedStatus: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			request, _ := http.NewRequest("GET", fmt.Sprintf("/resourceone/%d", e.ID), nil)
+			if tt.tenant != "" {
+				request.Header.Set("X-Tenant-ID", tt.tenant)
+			}
+			router.ServeHTTP(rr, request)
+
+			if status := rr.Code; status != tt.wantedStatus {
+				t.Errorf("Router returned wrong status code: got %v want %v",
+					status, tt.wantedStatus)
+			}
+		})
+	}
+}
@@ -0,0 +1,302 @@
+package rest
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/pprof"
+	"runtime"
+	rpprof "runtime/pprof"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/go-chi/render"
+	"github.com/jmoiron/sqlx"
+	"github.com/sirupsen/logrus"
+
+	"github.com/vincentserpoul/gorestarter/pkg/rest/mid"
+	"github.com/vincentserpoul/gorestarter/pkg/rest/renderer"
+)
+
+// Redacted replaces the secret values of the configuration on /admin/config
+const Redacted = "[REDACTED]"
+
+// AdminConf is the configuration of the debug endpoints of /admin
+type AdminConf struct {
+	// Port serves /admin on a separate port, 0 to serve it with the API
+	Port int
+	// Version and Revision are answered on /admin/buildinfo
+	Version  string
+	Revision string
+	// Config is the effective configuration answered on /admin/config, the values
+	// whose key contains one of RedactKeys, case insensitive, are redacted with all
+	// their nested values, such as the headers sent to a tracing backend
+	Config     map[string]interface{}
+	RedactKeys []string
+}
+
+// DefaultAdminConf returns the admin configuration redacting the usual secrets
+func DefaultAdminConf() *AdminConf {
+	return &AdminConf{
+		RedactKeys: []string{"password", "secret", "token", "authorization", "privatekey", "apikey", "api-key", "dsn", "headers"},
+	}
+}
+
+// admin serves the debug endpoints
+type admin struct {
+	conf    *AdminConf
+	db      *sqlx.DB
+	logger  *logrus.Logger
+	config  map[string]interface{}
+	started time.Time
+}
+
+// mountDebug adds the debug endpoints to the admin router
+func mountDebug(r chi.Router, conf *AdminConf, db *sqlx.DB, logger *logrus.Logger) {
+	a := &admin{
+		conf:    conf,
+		db:      db,
+		logger:  logger,
+		config:  redactConfig(conf.Config, conf.RedactKeys),
+		started: time.Now(),
+	}
+
+	r.Get("/buildinfo", a.buildInfo)
+	r.Get("/runtime", a.runtimeInfo)
+	r.Get("/goroutines", a.goroutines)
+	r.Get("/config", a.effectiveConfig)
+	r.Get("/db", a.dbStats)
+	r.Get("/loglevel", a.logLevel)
+	r.Put("/loglevel", a.setLogLevel)
+
+	// pprof.Index serves the named profiles on /debug/pprof/ only, they are routed here
+	r.Get("/debug/pprof/", pprof.Index)
+	r.Get("/debug/pprof/cmdline", pprof.Cmdline)
+	r.Get("/debug/pprof/profile", pprof.Profile)
+	r.Get("/debug/pprof/symbol", pprof.Symbol)
+	r.Post("/debug/pprof/symbol", pprof.Symbol)
+	r.Get("/debug/pprof/trace", pprof.Trace)
+	r.Get("/debug/pprof/{profile}", func(w http.ResponseWriter, r *http.Request) {
+		name := chi.URLParam(r, "profile")
+		if rpprof.Lookup(name) == nil {
+			renderer.NotFoundHandler(w, r)
+			return
+		}
+		pprof.Handler(name).ServeHTTP(w, r)
+	})
+}
+
+// buildInfo answers the version of the server and of go
+func (a *admin) buildInfo(w http.ResponseWriter, r *http.Request) {
+	renderer.ResponseJSONRender(w, r, map[string]string{
+		"version":   a.conf.Version,
+		"revision":  a.conf.Revision,
+		"goVersion": runtime.Version(),
+		"os":        runtime.GOOS,
+		"arch":      runtime.GOARCH,
+		"started":   a.started.UTC().Format(time.RFC3339),
+		"uptime":    time.Since(a.started).Round(time.Second).String(),
+	})
+}
+
+// runtimeStats is a summary of the go runtime
+type runtimeStats struct {
+	Goroutines  int    `json:"goroutines"`
+	NumCPU      int    `json:"numCPU"`
+	GOMAXPROCS  int    `json:"gomaxprocs"`
+	HeapAlloc   uint64 `json:"heapAlloc"`
+	HeapSys     uint64 `json:"heapSys"`
+	HeapObjects uint64 `json:"heapObjects"`
+	TotalAlloc  uint64 `json:"totalAlloc"`
+	Sys         uint64 `json:"sys"`
+	NumGC       uint32 `json:"numGC"`
+	LastGC      string `json:"lastGC,omitempty"`
+	LastGCPause string `json:"lastGCPause"`
+	PauseTotal  string `json:"pauseTotal"`
+	NextGC      uint64 `json:"nextGC"`
+}
+
+// runtimeInfo answers the goroutines, memory and GC stats
+func (a *admin) runtimeInfo(w http.ResponseWriter, r *http.Request) {
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+
+	stats := runtimeStats{
+		Goroutines:  runtime.NumGoroutine(),
+		NumCPU:      runtime.NumCPU(),
+		GOMAXPROCS:  runtime.GOMAXPROCS(0),
+		HeapAlloc:   m.HeapAlloc,
+		HeapSys:     m.HeapSys,
+		HeapObjects: m.HeapObjects,
+		TotalAlloc:  m.TotalAlloc,
+		Sys:         m.Sys,
+		NumGC:       m.NumGC,
+		LastGCPause: time.Duration(m.PauseNs[(m.NumGC+255)%256]).String(),
+		PauseTotal:  time.Duration(m.PauseTotalNs).String(),
+		NextGC:      m.NextGC,
+	}
+	if m.LastGC != 0 {
+		stats.LastGC = time.Unix(0, int64(m.LastGC)).UTC().Format(time.RFC3339Nano)
+	}
+	renderer.ResponseJSONRender(w, r, stats)
+}
+
+// goroutines answers the stacks of all the goroutines, as text
+func (a *admin) goroutines(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	if err := rpprof.Lookup("goroutine").WriteTo(w, 2); err != nil {
+		renderAdminError(w, r, renderer.ErrServer(r.Context(), fmt.Errorf("goroutines: %v", err)))
+	}
+}
+
+// effectiveConfig answers the configuration, redacted
+func (a *admin) effectiveConfig(w http.ResponseWriter, r *http.Request) {
+	config := a.config
+	if config == nil {
+		config = map[string]interface{}{}
+	}
+	renderer.ResponseJSONRender(w, r, config)
+}
+
+// dbStats answers the DB pool stats
+func (a *admin) dbStats(w http.ResponseWriter, r *http.Request) {
+	if a.db == nil {
+		renderer.NotFoundHandler(w, r)
+		return
+	}
+	renderer.ResponseJSONRender(w, r, a.db.Stats())
+}
+
+// logLevelBody is the body of /admin/loglevel
+type logLevelBody struct {
+	Level string `json:"level"`
+}
+
+// logLevel answers the level of the logger
+func (a *admin) logLevel(w http.ResponseWriter, r *http.Request) {
+	level := logrus.Level(atomic.LoadUint32((*uint32)(&a.logger.Level)))
+	renderer.ResponseJSONRender(w, r, logLevelBody{Level: level.String()})
+}
+
+// setLogLevel changes the level of the logger and of the standard logger,
+// that the background tasks log to, until the next restart
+func (a *admin) setLogLevel(w http.ResponseWriter, r *http.Request) {
+	var req logLevelBody
+	if err := renderer.DecodeJSON(r, &req); err != nil {
+		renderAdminError(w, r, renderer.ErrDecode(err))
+		return
+	}
+	level, err := logrus.ParseLevel(req.Level)
+	if err != nil {
+		renderAdminError(w, r, renderer.ErrInvalidRequest(fmt.Errorf("setLogLevel: %v", err)))
+		return
+	}
+
+	// The level is read by the logging goroutines
+	atomic.StoreUint32((*uint32)(&a.logger.Level), uint32(level))
+	if std := logrus.StandardLogger(); std != a.logger {
+		atomic.StoreUint32((*uint32)(&std.Level), uint32(level))
+	}
+	mid.LoggerFrom(r.Context()).WithField("level", level.String()).Warn("log level changed")
+
+	renderer.ResponseJSONRender(w, r, logLevelBody{Level: level.String()})
+}
+
+// renderAdminError renders the error of a debug endpoint
+func renderAdminError(w http.ResponseWriter, r *http.Request, rd render.Renderer) {
+	if err := render.Render(w, r, rd); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// redactConfig returns a copy of config, the values whose key contains one of keys redacted
+func redactConfig(config map[string]interface{}, keys []string) map[string]interface{} {
+	if config == nil {
+		return nil
+	}
+	lower := make([]string, len(keys))
+	for i, k := range keys {
+		lower[i] = strings.ToLower(k)
+	}
+
+	return redactMap(config, lower)
+}
+
+// redactMap redacts the values of m whose key contains one of keys, with their nested values
+func redactMap(m map[string]interface{}, keys []string) map[string]interface{} {
+	redacted := make(map[string]interface{}, len(m))
+	for name, v := range m {
+		if isSecret(name, keys) {
+			redacted[name] = redactAll(v)
+			continue
+		}
+		redacted[name] = redactValue(v, keys)
+	}
+
+	return redacted
+}
+
+// redactValue redacts the nested values of v whose key contains one of keys
+func redactValue(v interface{}, keys []string) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		return redactMap(v, keys)
+	case map[interface{}]interface{}:
+		// As decoded from YAML
+		m := make(map[string]interface{}, len(v))
+		for k, vv := range v {
+			m[fmt.Sprint(k)] = vv
+		}
+		return redactMap(m, keys)
+	case []interface{}:
+		values := make([]interface{}, len(v))
+		for i, vv := range v {
+			values[i] = redactValue(vv, keys)
+		}
+		return values
+	}
+
+	return v
+}
+
+// redactAll redacts v and its nested values, whatever their keys
+func redactAll(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for k, vv := range v {
+			m[k] = redactAll(vv)
+		}
+		return m
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for k, vv := range v {
+			m[fmt.Sprint(k)] = redactAll(vv)
+		}
+		return m
+	case []interface{}:
+		values := make([]interface{}, len(v))
+		for i, vv := range v {
+			values[i] = redactAll(vv)
+		}
+		return values
+	}
+	if v == nil || v == "" {
+		return v
+	}
+
+	return Redacted
+}
+
+// isSecret returns if name contains one of keys
+func isSecret(name string, keys []string) bool {
+	lower := strings.ToLower(name)
+	for _, k := range keys {
+		if strings.Contains(lower, k) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,289 @@
+package rest
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/vincentserpoul/gorestarter/pkg/apikey"
+	"github.com/vincentserpoul/gorestarter/pkg/rest/mid"
+)
+
+// asAdmin authenticates every request as a super admin
+func asAdmin(h http.Handler) http.Handler {
+	return as(&mid.Principal{ID: "admin-1", Roles: []string{apikey.AdminRole, apikey.SuperAdminRole}})(h)
+}
+
+// as authenticates every request as p
+func as(p *mid.Principal) func(http.Handler) http.Handler {
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			h.ServeHTTP(w, r.WithContext(mid.WithPrincipal(r.Context(), p)))
+		})
+	}
+}
+
+func TestAdminEndpoints(t *testing.T) {
+	conf := DefaultAdminConf()
+	conf.Version = "1.2.3"
+	conf.Config = map[string]interface{}{"http": map[string]interface{}{"port": 8080}}
+	s := testServer(t, &Conf{Admin: conf}, WithModules(), WithMiddlewares(asAdmin))
+
+	tests := []struct {
+		name            string
+		path            string
+		expectedStatus  int
+		expectedContent string
+	}{
+		{name: "build info", path: "/admin/buildinfo", expectedStatus: http.StatusOK, expectedContent: `"version":"1.2.3"`},
+		{name: "runtime", path: "/admin/runtime", expectedStatus: http.StatusOK, expectedContent: `"goroutines":`},
+		{name: "goroutines", path: "/admin/goroutines", expectedStatus: http.StatusOK, expectedContent: "goroutine "},
+		{name: "config", path: "/admin/config", expectedStatus: http.StatusOK, expectedContent: `{"http":{"port":8080}}`},
+		{name: "no db", path: "/admin/db", expectedStatus: http.StatusNotFound},
+		{name: "pprof index", path: "/admin/debug/pprof/", expectedStatus: http.StatusOK, expectedContent: "heap"},
+		{name: "pprof profile", path: "/admin/debug/pprof/heap?debug=1", expectedStatus: http.StatusOK, expectedContent: "heap profile"},
+		{name: "pprof unknown profile", path: "/admin/debug/pprof/unknown", expectedStatus: http.StatusNotFound},
+		{name: "health", path: "/admin/health", expectedStatus: http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			s.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, tt.path, nil))
+			if rr.Code != tt.expectedStatus {
+				t.Errorf("expected status %d, got %d: %s", tt.expectedStatus, rr.Code, rr.Body.String())
+			}
+			if !strings.Contains(rr.Body.String(), tt.expectedContent) {
+				t.Errorf("expected %q in %s", tt.expectedContent, rr.Body.String())
+			}
+		})
+	}
+}
+
+func TestAdminForbidden(t *testing.T) {
+	s := testServer(t, &Conf{Admin: DefaultAdminConf()}, WithModules())
+
+	for _, path := range []string{"/admin/buildinfo", "/admin/debug/pprof/", "/admin/loglevel"} {
+		rr := httptest.NewRecorder()
+		s.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, path, nil))
+		if rr.Code != http.StatusForbidden {
+			t.Errorf("expected %s to answer %d, got %d", path, http.StatusForbidden, rr.Code)
+		}
+	}
+}
+
+func TestAdminSuperAdminOnly(t *testing.T) {
+	conf := &Conf{Admin: DefaultAdminConf(), Capture: mid.DefaultCaptureConf(), Tenant: &mid.TenantConf{Header: "X-Tenant-ID"}}
+
+	tests := []struct {
+		name           string
+		principal      *mid.Principal
+		path           string
+		expectedStatus int
+	}{
+		{name: "tenant admin on the debug routes", principal: &mid.Principal{ID: "admin-1", Roles: []string{apikey.AdminRole}, Tenant: "acme"},
+			path: "/admin/debug/pprof/", expectedStatus: http.StatusForbidden},
+		{name: "tenant admin on the captures", principal: &mid.Principal{ID: "admin-1", Roles: []string{apikey.AdminRole}, Tenant: "acme"},
+			path: "/admin/captures", expectedStatus: http.StatusOK},
+		{name: "super admin bound to a tenant", principal: &mid.Principal{ID: "admin-2", Roles: []string{apikey.SuperAdminRole}, Tenant: "acme"},
+			path: "/admin/config", expectedStatus: http.StatusForbidden},
+		{name: "super admin", principal: &mid.Principal{ID: "admin-3", Roles: []string{apikey.SuperAdminRole}},
+			path: "/admin/config", expectedStatus: http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := testServer(t, conf, WithModules(), WithMiddlewares(as(tt.principal)))
+
+			rr := httptest.NewRecorder()
+			s.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, tt.path, nil))
+			if rr.Code != tt.expectedStatus {
+				t.Errorf("expected status %d, got %d: %s", tt.expectedStatus, rr.Code, rr.Body.String())
+			}
+		})
+	}
+}
+
+func TestAdminLogLevel(t *testing.T) {
+	logger := logrus.New()
+	logger.Out = httptest.NewRecorder()
+	s, err := New(&Conf{Admin: DefaultAdminConf()}, nil, logger, WithModules(), WithMiddlewares(asAdmin))
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	std := logrus.StandardLogger()
+	defer func(level logrus.Level) { std.Level = level }(std.Level)
+
+	tests := []struct {
+		name           string
+		body           string
+		expectedStatus int
+		expectedLevel  logrus.Level
+	}{
+		{name: "debug", body: `{"level":"debug"}`, expectedStatus: http.StatusOK, expectedLevel: logrus.DebugLevel},
+		{name: "warn", body: `{"level":"warning"}`, expectedStatus: http.StatusOK, expectedLevel: logrus.WarnLevel},
+		{name: "unknown level", body: `{"level":"verbose"}`, expectedStatus: http.StatusBadRequest, expectedLevel: logrus.WarnLevel},
+		{name: "invalid body", body: `{"level":`, expectedStatus: http.StatusBadRequest, expectedLevel: logrus.WarnLevel},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodPut, "/admin/loglevel", strings.NewReader(tt.body))
+			req.Header.Set("Content-Type", "application/json")
+			s.ServeHTTP(rr, req)
+			if rr.Code != tt.expectedStatus {
+				t.Errorf("expected status %d, got %d: %s", tt.expectedStatus, rr.Code, rr.Body.String())
+			}
+			if logger.Level != tt.expectedLevel || std.Level != tt.expectedLevel {
+				t.Errorf("expected level %s, got %s and %s on the standard logger", tt.expectedLevel, logger.Level, std.Level)
+			}
+
+			rr = httptest.NewRecorder()
+			s.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/admin/loglevel", nil))
+			var got logLevelBody
+			if err := json.Unmarshal(rr.Body.Bytes(), &got); err != nil {
+				t.Fatalf("Unmarshal() error = %v: %s", err, rr.Body.String())
+			}
+			if got.Level != tt.expectedLevel.String() {
+				t.Errorf("expected GET to answer %s, got %s", tt.expectedLevel, got.Level)
+			}
+		})
+	}
+}
+
+func TestAdminListener(t *testing.T) {
+	l, errL := net.Listen("tcp", "127.0.0.1:0")
+	if errL != nil {
+		t.Fatal(errL)
+	}
+	al, errA := net.Listen("tcp", "127.0.0.1:0")
+	if errA != nil {
+		t.Fatal(errA)
+	}
+
+	s := testServer(t, &Conf{Admin: &AdminConf{Port: 1}}, WithModules(), WithListener(l), WithAdminListener(al))
+	if errS := s.Start(context.Background()); errS != nil {
+		t.Fatalf("Start() error = %v", errS)
+	}
+	defer s.Shutdown(context.Background())
+
+	tests := []struct {
+		name           string
+		addr           string
+		expectedStatus int
+	}{
+		{name: "not on the API", addr: l.Addr().String(), expectedStatus: http.StatusNotFound},
+		// Still authenticated on its own port
+		{name: "on the admin port", addr: al.Addr().String(), expectedStatus: http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, err := http.Get("http://" + tt.addr + "/admin/buildinfo")
+			if err != nil {
+				t.Fatalf("Get() error = %v", err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != tt.expectedStatus {
+				t.Errorf("expected status %d, got %d", tt.expectedStatus, resp.StatusCode)
+			}
+		})
+	}
+}
+
+func TestAdminListenerTLS(t *testing.T) {
+	dir, err := ioutil.TempDir("", "certs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ca := newTestCert(t, "ca", nil)
+	certFile, keyFile := newTestCert(t, "localhost", ca).write(t, dir, time.Now())
+
+	l, errL := net.Listen("tcp", "127.0.0.1:0")
+	if errL != nil {
+		t.Fatal(errL)
+	}
+	al, errA := net.Listen("tcp", "127.0.0.1:0")
+	if errA != nil {
+		t.Fatal(errA)
+	}
+
+	s := testServer(t, &Conf{Admin: &AdminConf{Port: 1}, TLS: &TLSConf{CertFile: certFile, KeyFile: keyFile}},
+		WithModules(), WithListener(l), WithAdminListener(al))
+	if errS := s.Start(context.Background()); errS != nil {
+		t.Fatalf("Start() error = %v", errS)
+	}
+	defer s.Shutdown(context.Background())
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+	c := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: roots, ServerName: "localhost"}}}
+
+	resp, errG := c.Get("https://" + al.Addr().String() + "/admin/buildinfo")
+	if errG != nil {
+		t.Fatalf("Get() error = %v", errG)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusForbidden {
+		t.Errorf("expected status %d, got %d", http.StatusForbidden, resp.StatusCode)
+	}
+
+	if resp, errG := http.Get("http://" + al.Addr().String() + "/admin/buildinfo"); errG == nil {
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("expected the admin port to refuse plain HTTP, got %d", resp.StatusCode)
+		}
+	}
+}
+
+func TestRedactConfig(t *testing.T) {
+	config := map[string]interface{}{
+		"mysql": map[string]interface{}{"user": "internal", "password": "pass"},
+		"jwt":   map[string]interface{}{"hmacsecret": "secret", "issuer": "me", "leeway": 0},
+		"tracing": map[interface{}]interface{}{
+			"otlp": map[string]interface{}{
+				"endpoint": "api.honeycomb.io:443",
+				"headers":  map[string]interface{}{"x-honeycomb-team": "key", "X-Env": "prod", "X-Empty": ""},
+			},
+		},
+		"apikeys": map[string]interface{}{"bootstrapkey": []interface{}{"k1", "k2"}},
+		"outputs": []interface{}{map[string]interface{}{"token": "t", "type": "stdout"}},
+		"empty":   map[string]interface{}{"password": ""},
+	}
+	expected := map[string]interface{}{
+		"mysql": map[string]interface{}{"user": "internal", "password": Redacted},
+		"jwt":   map[string]interface{}{"hmacsecret": Redacted, "issuer": "me", "leeway": 0},
+		"tracing": map[string]interface{}{
+			"otlp": map[string]interface{}{
+				"endpoint": "api.honeycomb.io:443",
+				"headers":  map[string]interface{}{"x-honeycomb-team": Redacted, "X-Env": Redacted, "X-Empty": ""},
+			},
+		},
+		"apikeys": map[string]interface{}{"bootstrapkey": []interface{}{Redacted, Redacted}},
+		"outputs": []interface{}{map[string]interface{}{"token": Redacted, "type": "stdout"}},
+		"empty":   map[string]interface{}{"password": ""},
+	}
+
+	got := redactConfig(config, DefaultAdminConf().RedactKeys)
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected %v, got %v", expected, got)
+	}
+	if config["mysql"].(map[string]interface{})["password"] != "pass" {
+		t.Errorf("expected the config to be left unchanged")
+	}
+}
@@ -0,0 +1,27 @@
+package rest
+
+import (
+	"github.com/jmoiron/sqlx"
+
+	"github.com/vincentserpoul/gorestarter/pkg/metrics"
+)
+
+// MetricsConf is the configuration of the Prometheus metrics
+type MetricsConf struct {
+	// Port serves /metrics on a separate port, 0 to serve it with the API
+	Port int
+	// Version and Revision are exposed in build_info
+	Version  string
+	Revision string
+}
+
+// newMetricsRegistry returns the registry of the runtime, DB pool and build metrics
+func newMetricsRegistry(conf *MetricsConf, db *sqlx.DB) *metrics.Registry {
+	reg := metrics.NewRegistry()
+	reg.Register(metrics.NewBuildInfo(conf.Version, conf.Revision), metrics.NewRuntimeCollector())
+	if db != nil {
+		reg.Register(metrics.NewDBStatsCollector(db))
+	}
+
+	return reg
+}
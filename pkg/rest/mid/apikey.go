@@ -0,0 +1,104 @@
+package mid
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+)
+
+const (
+	contextKeyAPIKeyPrefix = ContextKey("api key prefix")
+)
+
+// ErrAPIKeyInvalid is returned by APIKeyAuthenticator for unknown, expired or revoked keys
+var ErrAPIKeyInvalid = errors.New("invalid api key")
+
+// APIKeyAuthenticator returns the principal owning an API key
+type APIKeyAuthenticator interface {
+	Authenticate(ctx context.Context, key string) (*Principal, error)
+}
+
+// APIKeyConf is the configuration of the APIKeyAuth middleware
+type APIKeyConf struct {
+	Keys APIKeyAuthenticator
+	// Realm is sent in the WWW-Authenticate challenge
+	Realm string
+	// Optional lets requests without an API key through, so that
+	// another authentication middleware, such as JWTAuth, can handle them
+	Optional bool
+}
+
+// APIKeyPrefix returns the public part of an API key, which identifies it
+func APIKeyPrefix(key string) string {
+	i := strings.IndexByte(key, '.')
+	if i <= 0 {
+		return ""
+	}
+	return key[:i]
+}
+
+// GetAPIKeyPrefix will retrieve the prefix of the API key used by the request if there is one
+func GetAPIKeyPrefix(ctx context.Context) string {
+	if p, ok := ctx.Value(contextKeyAPIKeyPrefix).(string); ok {
+		return p
+	}
+
+	return ""
+}
+
+// APIKeyAuth authenticates requests with an API key, sent in the X-API-Key header
+// or as "Authorization: ApiKey <key>". Invalid keys get a 401.
+func APIKeyAuth(conf *APIKeyConf) func(http.Handler) http.Handler {
+	realm := conf.Realm
+	if realm == "" {
+		realm = "api"
+	}
+
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			key := apiKey(r)
+			if key == "" {
+				// The caller may be authenticated already, with a client certificate
+				if conf.Optional || GetPrincipal(r.Context()) != nil {
+					h.ServeHTTP(w, r)
+					return
+				}
+				renderUnauthorized(w, r, "ApiKey", realm, nil)
+				return
+			}
+
+			prefix := APIKeyPrefix(key)
+			if prefix != "" {
+				// Changing the request in place, so that Logger sees the prefix
+				*r = *r.WithContext(context.WithValue(r.Context(), contextKeyAPIKeyPrefix, prefix))
+			}
+
+			p, err := conf.Keys.Authenticate(r.Context(), key)
+			if err == ErrAPIKeyInvalid {
+				renderUnauthorized(w, r, "ApiKey", realm, err)
+				return
+			}
+			if err != nil {
+				renderError(w, r, http.StatusServiceUnavailable, "Service unavailable.", err)
+				return
+			}
+
+			setPrincipal(r, p)
+
+			h.ServeHTTP(w, r)
+		})
+	}
+}
+
+// apiKey extracts the API key from the request headers
+func apiKey(r *http.Request) string {
+	if key := r.Header.Get("X-API-Key"); key != "" {
+		return strings.TrimSpace(key)
+	}
+	auth := r.Header.Get("Authorization")
+	if len(auth) < 7 || !strings.EqualFold(auth[:7], "ApiKey ") {
+		return ""
+	}
+	return strings.TrimSpace(auth[7:])
+}
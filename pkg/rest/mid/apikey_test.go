@@ -0,0 +1,149 @@
+package mid
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus/hooks/test"
+)
+
+// testKeys accepts a single key
+type testKeys struct{}
+
+func (testKeys) Authenticate(ctx context.Context, key string) (*Principal, error) {
+	switch key {
+	case "abc.secret":
+		return &Principal{ID: "machine", Method: "apikey", Scopes: []string{"read"}}, nil
+	case "db.down":
+		return nil, errors.New("db down")
+	}
+	return nil, ErrAPIKeyInvalid
+}
+
+func TestAPIKeyAuth(t *testing.T) {
+	jwtToken := signJWT(t, "HS256", "", []byte("secret"), Claims{"sub": "user1", "exp": time.Now().Add(time.Hour).Unix()})
+
+	tc := []struct {
+		name              string
+		optional          bool
+		headers           map[string]string
+		expectedStatus    int
+		expectedPrincipal string
+		expectedChallenge string
+	}{
+		{
+			name:              "X-API-Key",
+			headers:           map[string]string{"X-API-Key": "abc.secret"},
+			expectedStatus:    http.StatusOK,
+			expectedPrincipal: "machine",
+		},
+		{
+			name:              "Authorization",
+			headers:           map[string]string{"Authorization": "ApiKey abc.secret"},
+			expectedStatus:    http.StatusOK,
+			expectedPrincipal: "machine",
+		},
+		{
+			name:              "invalid key",
+			headers:           map[string]string{"X-API-Key": "abc.wrong"},
+			expectedStatus:    http.StatusUnauthorized,
+			expectedChallenge: `ApiKey realm="api", error="invalid_token"`,
+		},
+		{
+			name:              "missing key",
+			expectedStatus:    http.StatusUnauthorized,
+			expectedChallenge: `ApiKey realm="api"`,
+		},
+		{
+			name:           "authenticator failure",
+			headers:        map[string]string{"X-API-Key": "db.down"},
+			expectedStatus: http.StatusServiceUnavailable,
+		},
+		{
+			name:              "optional, falls back to JWT",
+			optional:          true,
+			headers:           map[string]string{"Authorization": "Bearer " + jwtToken},
+			expectedStatus:    http.StatusOK,
+			expectedPrincipal: "jwt::user1",
+		},
+		{
+			name:              "optional, key used before JWT",
+			optional:          true,
+			headers:           map[string]string{"X-API-Key": "abc.secret"},
+			expectedStatus:    http.StatusOK,
+			expectedPrincipal: "machine",
+		},
+		{
+			name:              "optional, no credentials",
+			optional:          true,
+			expectedStatus:    http.StatusUnauthorized,
+			expectedChallenge: `Bearer realm="api"`,
+		},
+	}
+
+	for _, tt := range tc {
+		t.Run(tt.name, func(t *testing.T) {
+			var principal *Principal
+			h := http.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				principal = GetPrincipal(r.Context())
+			}))
+			if tt.optional {
+				h = JWTAuth(&JWTConf{Keys: &StaticKeys{HMACSecret: []byte("secret")}})(h)
+			}
+			h = APIKeyAuth(&APIKeyConf{Keys: testKeys{}, Optional: tt.optional})(h)
+
+			rr := httptest.NewRecorder()
+			req, _ := http.NewRequest(http.MethodGet, "/", nil)
+			for k, v := range tt.headers {
+				req.Header.Set(k, v)
+			}
+			h.ServeHTTP(rr, req)
+
+			if rr.Code != tt.expectedStatus {
+				t.Errorf("expected status %d, got %d", tt.expectedStatus, rr.Code)
+				return
+			}
+			if !strings.HasPrefix(rr.Header().Get("WWW-Authenticate"), tt.expectedChallenge) {
+				t.Errorf("expected challenge %q, got %q", tt.expectedChallenge, rr.Header().Get("WWW-Authenticate"))
+			}
+			if tt.expectedPrincipal != "" && (principal == nil || principal.ID != tt.expectedPrincipal) {
+				t.Errorf("expected principal %s, got %+v", tt.expectedPrincipal, principal)
+			}
+		})
+	}
+}
+
+func TestAPIKeyAuthLogged(t *testing.T) {
+	logger, hook := test.NewNullLogger()
+	h := Logger(logger)(APIKeyAuth(&APIKeyConf{Keys: testKeys{}})(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}),
+	))
+
+	for _, key := range []string{"abc.secret", "abc.wrong"} {
+		req, _ := http.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("X-API-Key", key)
+		h.ServeHTTP(httptest.NewRecorder(), req)
+
+		if hook.LastEntry().Data["api_key_prefix"] != "abc" {
+			t.Errorf("expected the key prefix to be logged for %s, got %v", key, hook.LastEntry().Data["api_key_prefix"])
+		}
+	}
+}
+
+func TestAPIKeyPrefix(t *testing.T) {
+	tc := map[string]string{
+		"abc.secret": "abc",
+		"secret":     "",
+		".secret":    "",
+	}
+	for key, expected := range tc {
+		if got := APIKeyPrefix(key); got != expected {
+			t.Errorf("APIKeyPrefix(%s): expected %q, got %q", key, expected, got)
+		}
+	}
+}
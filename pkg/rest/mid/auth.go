@@ -0,0 +1,109 @@
+package mid
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+const (
+	contextKeyPrincipal = ContextKey("principal")
+)
+
+// principalIDEscaper escapes the separator of the parts of the principal IDs
+var principalIDEscaper = strings.NewReplacer("%", "%25", ":", "%3A")
+
+// PrincipalID returns the ID of a principal authenticated by method, namespaced
+// so that callers authenticated differently, or by different issuers, never collide
+func PrincipalID(method string, parts ...string) string {
+	id := method
+	for _, p := range parts {
+		id += ":" + principalIDEscaper.Replace(p)
+	}
+
+	return id
+}
+
+// Principal is the authenticated caller of a request
+type Principal struct {
+	// ID uniquely identifies the caller, see PrincipalID, "jwt:issuer:subject" for example
+	ID string
+	// Method is the authentication method used, such as "jwt"
+	Method string
+	// Scopes are the permissions granted to the caller
+	Scopes []string
+	// Roles are the roles of the caller
+	Roles []string
+	// Tenant, if set, is the only tenant the caller can access
+	Tenant string
+}
+
+// HasScope returns true if the principal was granted scope
+func (p *Principal) HasScope(scope string) bool {
+	for _, s := range p.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// HasRole returns true if the principal has role
+func (p *Principal) HasRole(role string) bool {
+	for _, r := range p.Roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+// GetPrincipal will retrieve the authenticated caller from the context if there is one
+func GetPrincipal(ctx context.Context) *Principal {
+	if p, ok := ctx.Value(contextKeyPrincipal).(*Principal); ok {
+		return p
+	}
+
+	return nil
+}
+
+// WithPrincipal returns a copy of ctx holding the authenticated caller
+func WithPrincipal(ctx context.Context, p *Principal) context.Context {
+	return context.WithValue(ctx, contextKeyPrincipal, p)
+}
+
+// setPrincipal changes the request in place, so that Logger sees the principal
+func setPrincipal(r *http.Request, p *Principal) {
+	*r = *r.WithContext(WithPrincipal(r.Context(), p))
+}
+
+// publicErrors are the authentication errors described to the caller as they are
+var publicErrors = map[error]bool{
+	errTokenMalformed:   true,
+	errTokenSignature:   true,
+	errTokenExpired:     true,
+	errTokenNoExpiry:    true,
+	errTokenNotYetValid: true,
+	errTokenAudience:    true,
+	errTokenIssuer:      true,
+	errTokenSubject:     true,
+	ErrAPIKeyInvalid:    true,
+}
+
+// renderUnauthorized answers with a 401 and the WWW-Authenticate challenge
+// for scheme, as described in RFC 6750 for bearer tokens. The other errors than
+// publicErrors, such as an unknown key, are only logged and described as a signature error.
+func renderUnauthorized(w http.ResponseWriter, r *http.Request, scheme, realm string, err error) {
+	challenge := fmt.Sprintf(`%s realm=%q`, scheme, realm)
+	if err != nil {
+		description := errTokenSignature.Error()
+		if publicErrors[err] {
+			description = err.Error()
+		}
+		challenge += fmt.Sprintf(`, error="invalid_token", error_description=%q`, description)
+	}
+	w.Header().Add("WWW-Authenticate", challenge)
+
+	renderError(w, r, http.StatusUnauthorized, "Unauthorized.", err)
+}
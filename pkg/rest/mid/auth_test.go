@@ -0,0 +1,25 @@
+package mid
+
+import "testing"
+
+func TestPrincipalID(t *testing.T) {
+	tc := []struct {
+		name     string
+		method   string
+		parts    []string
+		expected string
+	}{
+		{name: "jwt", method: "jwt", parts: []string{"issuer", "user1"}, expected: "jwt:issuer:user1"},
+		{name: "no issuer", method: "jwt", parts: []string{"", "user1"}, expected: "jwt::user1"},
+		{name: "separator escaped", method: "jwt", parts: []string{"https://issuer", "a:b%"}, expected: "jwt:https%3A//issuer:a%3Ab%25"},
+		{name: "apikey", method: "apikey", parts: []string{"42"}, expected: "apikey:42"},
+	}
+
+	for _, tt := range tc {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := PrincipalID(tt.method, tt.parts...); got != tt.expected {
+				t.Errorf("expected %s, got %s", tt.expected, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,156 @@
+package mid
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+)
+
+const (
+	contextKeyPolicy = ContextKey("policy")
+)
+
+// Grant lists the scopes and the roles granting a permission, any of them is enough
+type Grant struct {
+	Scopes []string `json:"scopes"`
+	Roles  []string `json:"roles"`
+}
+
+// Policy maps permissions, such as "resourceone:read", to the scopes and roles granting them.
+// A permission missing from the policy is only granted by the scope of the same name.
+type Policy struct {
+	Permissions map[string]Grant `json:"permissions"`
+	// AllowAll grants every permission to everyone, authorization is disabled
+	AllowAll bool `json:"allowAll"`
+}
+
+// AllowAllPolicy returns the policy granting every permission, to disable authorization
+func AllowAllPolicy() *Policy {
+	return &Policy{AllowAll: true}
+}
+
+// LoadPolicy reads a JSON policy file
+func LoadPolicy(file string) (*Policy, error) {
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("LoadPolicy(%s): %v", file, err)
+	}
+
+	p := &Policy{}
+	if err := json.Unmarshal(b, p); err != nil {
+		return nil, fmt.Errorf("LoadPolicy(%s): %v", file, err)
+	}
+
+	return p, nil
+}
+
+// Allowed returns true if the principal is granted the permission
+func (p *Policy) Allowed(pr *Principal, permission string) bool {
+	if p.AllowAll {
+		return true
+	}
+	if pr == nil {
+		return false
+	}
+
+	grant, ok := p.Permissions[permission]
+	if !ok {
+		return pr.HasScope(permission)
+	}
+	for _, s := range grant.Scopes {
+		if pr.HasScope(s) {
+			return true
+		}
+	}
+	for _, r := range grant.Roles {
+		if pr.HasRole(r) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Authorization enables the permission checks done by Require, with policy.
+// Without it, Require refuses every request, AllowAllPolicy disables the checks.
+func Authorization(policy *Policy) func(http.Handler) http.Handler {
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			// Changing the request in place, so that Logger sees what comes after
+			*r = *r.WithContext(context.WithValue(r.Context(), contextKeyPolicy, policy))
+			h.ServeHTTP(w, r)
+		})
+	}
+}
+
+// Require declares the permissions needed by a route, the principal must be granted
+// all of them by the policy set by Authorization, otherwise a 403 is sent
+func Require(permissions ...string) func(http.Handler) http.Handler {
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			policy, ok := r.Context().Value(contextKeyPolicy).(*Policy)
+			if !ok || policy == nil {
+				// Fail closed, the route would be open by mistake
+				renderError(w, r, http.StatusForbidden, "Forbidden.",
+					fmt.Errorf("Require(%v): no authorization policy", permissions))
+				return
+			}
+
+			p := GetPrincipal(r.Context())
+			for _, permission := range permissions {
+				if !policy.Allowed(p, permission) {
+					id := ""
+					if p != nil {
+						id = p.ID
+					}
+					renderError(w, r, http.StatusForbidden, "Forbidden.",
+						fmt.Errorf("Require(%s): %q not allowed", permission, id))
+					return
+				}
+			}
+
+			h.ServeHTTP(w, r)
+		})
+	}
+}
+
+// RequireRole only lets principals with role through, otherwise a 403 is sent.
+// Unlike Require, it doesn't depend on a policy, it protects the admin routes.
+func RequireRole(role string) func(http.Handler) http.Handler {
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if p := GetPrincipal(r.Context()); p == nil || !p.HasRole(role) {
+				renderError(w, r, http.StatusForbidden, "Forbidden.",
+					fmt.Errorf("RequireRole(%s): role needed", role))
+				return
+			}
+
+			h.ServeHTTP(w, r)
+		})
+	}
+}
+
+// RequireUnboundRole only lets principals with role and not bound to a tenant through,
+// otherwise a 403 is sent. It protects the admin routes exposing every tenant.
+func RequireUnboundRole(role string) func(http.Handler) http.Handler {
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			p := GetPrincipal(r.Context())
+			if p == nil || !p.HasRole(role) {
+				renderError(w, r, http.StatusForbidden, "Forbidden.",
+					fmt.Errorf("RequireUnboundRole(%s): role needed", role))
+				return
+			}
+			// A role tied to a tenant doesn't grant the others
+			if p.Tenant != "" {
+				renderError(w, r, http.StatusForbidden, "Forbidden.",
+					fmt.Errorf("RequireUnboundRole(%s): %q bound to tenant %s", role, p.ID, p.Tenant))
+				return
+			}
+
+			h.ServeHTTP(w, r)
+		})
+	}
+}
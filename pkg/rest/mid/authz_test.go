@@ -0,0 +1,195 @@
+package mid
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRequire(t *testing.T) {
+	policy := &Policy{
+		Permissions: map[string]Grant{
+			"resource:read": {
+				Scopes: []string{"resource:read", "resource:write"},
+				Roles:  []string{"reader"},
+			},
+		},
+	}
+
+	tc := []struct {
+		name           string
+		policy         *Policy
+		principal      *Principal
+		permissions    []string
+		expectedStatus int
+	}{
+		{
+			name:           "no policy",
+			principal:      &Principal{ID: "user", Scopes: []string{"resource:read"}},
+			permissions:    []string{"resource:read"},
+			expectedStatus: http.StatusForbidden,
+		},
+		{
+			name:           "allow all",
+			policy:         AllowAllPolicy(),
+			permissions:    []string{"resource:read", "resource:write"},
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "granted by scope",
+			policy:         policy,
+			principal:      &Principal{ID: "user", Scopes: []string{"resource:write"}},
+			permissions:    []string{"resource:read"},
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "granted by role",
+			policy:         policy,
+			principal:      &Principal{ID: "user", Roles: []string{"reader"}},
+			permissions:    []string{"resource:read"},
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "not in the policy, granted by the scope of the same name",
+			policy:         policy,
+			principal:      &Principal{ID: "user", Scopes: []string{"resource:write"}},
+			permissions:    []string{"resource:write"},
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "not in the policy, role is not enough",
+			policy:         policy,
+			principal:      &Principal{ID: "user", Roles: []string{"resource:write"}},
+			permissions:    []string{"resource:write"},
+			expectedStatus: http.StatusForbidden,
+		},
+		{
+			name:           "all permissions needed",
+			policy:         policy,
+			principal:      &Principal{ID: "user", Roles: []string{"reader"}},
+			permissions:    []string{"resource:read", "resource:write"},
+			expectedStatus: http.StatusForbidden,
+		},
+		{
+			name:           "no principal",
+			policy:         policy,
+			permissions:    []string{"resource:read"},
+			expectedStatus: http.StatusForbidden,
+		},
+	}
+
+	for _, tt := range tc {
+		t.Run(tt.name, func(t *testing.T) {
+			h := Require(tt.permissions...)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+			if tt.policy != nil {
+				h = Authorization(tt.policy)(h)
+			}
+
+			rr := httptest.NewRecorder()
+			req, _ := http.NewRequest(http.MethodGet, "/", nil)
+			if tt.principal != nil {
+				req = req.WithContext(WithPrincipal(req.Context(), tt.principal))
+			}
+			h.ServeHTTP(rr, req)
+
+			if rr.Code != tt.expectedStatus {
+				t.Errorf("expected status %d, got %d", tt.expectedStatus, rr.Code)
+			}
+		})
+	}
+}
+
+func TestRequireRole(t *testing.T) {
+	tc := []struct {
+		name           string
+		principal      *Principal
+		expectedStatus int
+	}{
+		{name: "role granted", principal: &Principal{ID: "user", Roles: []string{"admin"}}, expectedStatus: http.StatusOK},
+		{name: "role missing", principal: &Principal{ID: "user", Roles: []string{"reader"}}, expectedStatus: http.StatusForbidden},
+		{name: "no principal", expectedStatus: http.StatusForbidden},
+	}
+
+	for _, tt := range tc {
+		t.Run(tt.name, func(t *testing.T) {
+			h := RequireRole("admin")(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+			rr := httptest.NewRecorder()
+			req, _ := http.NewRequest(http.MethodGet, "/", nil)
+			if tt.principal != nil {
+				req = req.WithContext(WithPrincipal(req.Context(), tt.principal))
+			}
+			h.ServeHTTP(rr, req)
+
+			if rr.Code != tt.expectedStatus {
+				t.Errorf("expected status %d, got %d", tt.expectedStatus, rr.Code)
+			}
+		})
+	}
+}
+
+func TestRequireUnboundRole(t *testing.T) {
+	tc := []struct {
+		name           string
+		principal      *Principal
+		expectedStatus int
+	}{
+		{name: "role granted", principal: &Principal{ID: "ops", Roles: []string{"superadmin"}}, expectedStatus: http.StatusOK},
+		{name: "role bound to a tenant", principal: &Principal{ID: "ops", Roles: []string{"superadmin"}, Tenant: "acme"}, expectedStatus: http.StatusForbidden},
+		{name: "role missing", principal: &Principal{ID: "user", Roles: []string{"admin"}}, expectedStatus: http.StatusForbidden},
+		{name: "no principal", expectedStatus: http.StatusForbidden},
+	}
+
+	for _, tt := range tc {
+		t.Run(tt.name, func(t *testing.T) {
+			h := RequireUnboundRole("superadmin")(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+			rr := httptest.NewRecorder()
+			req, _ := http.NewRequest(http.MethodGet, "/", nil)
+			if tt.principal != nil {
+				req = req.WithContext(WithPrincipal(req.Context(), tt.principal))
+			}
+			h.ServeHTTP(rr, req)
+
+			if rr.Code != tt.expectedStatus {
+				t.Errorf("expected status %d, got %d", tt.expectedStatus, rr.Code)
+			}
+		})
+	}
+}
+
+func TestLoadPolicy(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "policy")
+	defer os.RemoveAll(dir)
+
+	valid := filepath.Join(dir, "valid.json")
+	_ = ioutil.WriteFile(valid, []byte(`{"permissions": {"resource:read": {"roles": ["reader"]}}}`), 0600)
+	invalid := filepath.Join(dir, "invalid.json")
+	_ = ioutil.WriteFile(invalid, []byte(`{"permissions": [`), 0600)
+
+	tc := []struct {
+		name          string
+		file          string
+		expectedError bool
+	}{
+		{name: "valid", file: valid},
+		{name: "invalid", file: invalid, expectedError: true},
+		{name: "missing", file: filepath.Join(dir, "missing.json"), expectedError: true},
+	}
+
+	for _, tt := range tc {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := LoadPolicy(tt.file)
+			if (err != nil) != tt.expectedError {
+				t.Errorf("expected error %t, got %v", tt.expectedError, err)
+				return
+			}
+			if err == nil && !p.Allowed(&Principal{Roles: []string{"reader"}}, "resource:read") {
+				t.Errorf("expected the loaded policy to grant resource:read to readers")
+			}
+		})
+	}
+}
@@ -0,0 +1,67 @@
+package mid
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// ErrBodyTooLarge is returned when reading more than the allowed request body size
+var ErrBodyTooLarge = errors.New("request body too large")
+
+// BodyLimit limits the size of request bodies to maxBytes.
+// Requests announcing a bigger Content-Length are answered right away with a 413,
+// others get ErrBodyTooLarge when reading past the limit.
+func BodyLimit(maxBytes int64) func(http.Handler) http.Handler {
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if maxBytes <= 0 || r.Body == nil || r.Body == http.NoBody {
+				h.ServeHTTP(w, r)
+				return
+			}
+
+			if r.ContentLength > maxBytes {
+				renderError(w, r, http.StatusRequestEntityTooLarge, "Request body too large.",
+					fmt.Errorf("BodyLimit: %d bytes announced, %d allowed", r.ContentLength, maxBytes))
+				return
+			}
+
+			r.Body = &limitedBody{ReadCloser: r.Body, remaining: maxBytes}
+			h.ServeHTTP(w, r)
+		})
+	}
+}
+
+// limitedBody is like http.MaxBytesReader, with an error which can be checked
+type limitedBody struct {
+	io.ReadCloser
+	remaining int64
+	err       error
+}
+
+func (b *limitedBody) Read(p []byte) (int, error) {
+	if b.err != nil {
+		return 0, b.err
+	}
+	if len(p) == 0 {
+		return 0, nil
+	}
+
+	// read one more byte than remaining, to know if the body goes over the limit
+	if int64(len(p)) > b.remaining+1 {
+		p = p[:b.remaining+1]
+	}
+	n, err := b.ReadCloser.Read(p)
+
+	if int64(n) <= b.remaining {
+		b.remaining -= int64(n)
+		b.err = err
+		return n, err
+	}
+
+	n = int(b.remaining)
+	b.remaining = 0
+	b.err = ErrBodyTooLarge
+	return n, b.err
+}
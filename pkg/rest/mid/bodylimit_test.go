@@ -0,0 +1,73 @@
+package mid
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestBodyLimit(t *testing.T) {
+	tc := []struct {
+		name           string
+		body           string
+		contentLength  int64
+		expectedStatus int
+		expectedErr    error
+		expectedBody   string
+	}{
+		{
+			name:           "body under the limit",
+			body:           "0123456789",
+			contentLength:  10,
+			expectedStatus: http.StatusOK,
+			expectedBody:   "0123456789",
+		},
+		{
+			name:           "announced body over the limit",
+			body:           "0123456789a",
+			contentLength:  11,
+			expectedStatus: http.StatusRequestEntityTooLarge,
+		},
+		{
+			name:           "unannounced body over the limit",
+			body:           "0123456789a",
+			contentLength:  -1,
+			expectedStatus: http.StatusOK,
+			expectedErr:    ErrBodyTooLarge,
+			expectedBody:   "0123456789",
+		},
+	}
+
+	for _, tt := range tc {
+		t.Run(tt.name, func(t *testing.T) {
+			var (
+				got    []byte
+				errGot error
+			)
+			handler := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+				got, errGot = ioutil.ReadAll(req.Body)
+			})
+			rr := httptest.NewRecorder()
+			r, _ := http.NewRequest("POST", ``, strings.NewReader(tt.body))
+			r.ContentLength = tt.contentLength
+
+			BodyLimit(10)(handler).ServeHTTP(rr, r)
+
+			if rr.Code != tt.expectedStatus {
+				t.Errorf("expected status %d, got %d", tt.expectedStatus, rr.Code)
+				return
+			}
+			if tt.expectedStatus != http.StatusOK {
+				return
+			}
+			if errGot != tt.expectedErr {
+				t.Errorf("expected error %v, got %v", tt.expectedErr, errGot)
+			}
+			if string(got) != tt.expectedBody {
+				t.Errorf("expected body %s, got %s", tt.expectedBody, got)
+			}
+		})
+	}
+}
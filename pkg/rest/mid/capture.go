@@ -0,0 +1,374 @@
+package mid
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const contextKeyExchange = ContextKey("captured exchange")
+
+// bodyRedacted replaces the values of the redacted fields, and the JSON bodies
+// which can't be redacted because they were truncated
+const bodyRedacted = "[REDACTED]"
+
+// CaptureConf is the configuration of a Capturer
+type CaptureConf struct {
+	// Routes are always captured, keyed by route pattern ("/v1/resourceone")
+	// or method and route pattern ("POST /v1/resourceone"), case insensitive
+	Routes []string
+	// Header triggers the capture of a request, for the principals with one of TrustedRoles
+	Header       string
+	TrustedRoles []string
+	// MaxBodySize is the number of bytes captured of each body, 64KiB if 0
+	MaxBodySize int
+	// RedactFields are the JSON fields and query parameters redacted, case insensitive
+	RedactFields []string
+	// RedactHeaders are the headers redacted in the HAR
+	RedactHeaders []string
+	// Keep is the number of exchanges kept for the HAR, 100 if 0
+	Keep int
+}
+
+// DefaultCaptureConf returns a configuration capturing the requests of admins
+// sending X-Debug-Capture, with the credentials redacted
+func DefaultCaptureConf() *CaptureConf {
+	return &CaptureConf{
+		Header:        "X-Debug-Capture",
+		TrustedRoles:  []string{"admin"},
+		MaxBodySize:   64 << 10,
+		RedactFields:  []string{"password", "secret", "token", "access_token", "refresh_token", "apikey", "key"},
+		RedactHeaders: []string{"Authorization", "Cookie", "Set-Cookie", "X-API-Key"},
+		Keep:          100,
+	}
+}
+
+// Exchange is a captured request and its response, with the redacted fields and headers
+type Exchange struct {
+	// Tenant and PrincipalID are those of the caller, empty if unknown
+	Tenant          string
+	PrincipalID     string
+	Started         time.Time
+	Duration        time.Duration
+	Method          string
+	URL             string
+	Proto           string
+	RequestHeader   http.Header
+	RequestBody     string
+	Status          int
+	ResponseHeader  http.Header
+	ResponseBody    string
+	ResponseSize    int
+	BodiesTruncated bool
+}
+
+// Capturer captures the bodies of the requests, for the access logs,
+// and keeps the last exchanges, served as a HAR
+type Capturer struct {
+	conf          CaptureConf
+	routes        map[string]bool
+	redactFields  map[string]bool
+	redactHeaders map[string]bool
+
+	mu        sync.Mutex
+	exchanges []*Exchange
+	next      int
+}
+
+// NewCapturer returns a Capturer configured by conf
+func NewCapturer(conf *CaptureConf) *Capturer {
+	if conf == nil {
+		conf = DefaultCaptureConf()
+	}
+	c := &Capturer{
+		conf:          *conf,
+		routes:        make(map[string]bool, len(conf.Routes)),
+		redactFields:  make(map[string]bool, len(conf.RedactFields)),
+		redactHeaders: make(map[string]bool, len(conf.RedactHeaders)),
+	}
+	if c.conf.MaxBodySize <= 0 {
+		c.conf.MaxBodySize = 64 << 10
+	}
+	if c.conf.Keep <= 0 {
+		c.conf.Keep = 100
+	}
+	for _, route := range conf.Routes {
+		c.routes[strings.ToLower(route)] = true
+	}
+	for _, f := range conf.RedactFields {
+		c.redactFields[strings.ToLower(f)] = true
+	}
+	for _, h := range conf.RedactHeaders {
+		c.redactHeaders[http.CanonicalHeaderKey(h)] = true
+	}
+
+	return c
+}
+
+// Capture captures the bodies, as read and written by the handlers, of the routes to
+// capture and of the requests with the capture header from trusted principals. The bodies are logged by Logger, it must be
+// used on a chi router, after Logger and Compress.
+func Capture(c *Capturer) func(http.Handler) http.Handler {
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			byRoute := c.isCapturedRoute(r)
+			if !byRoute && (c.conf.Header == "" || r.Header.Get(c.conf.Header) == "") {
+				h.ServeHTTP(w, r)
+				return
+			}
+
+			e := &Exchange{
+				Started:       time.Now(),
+				Method:        r.Method,
+				URL:           Scheme(r) + "://" + r.Host + r.URL.RequestURI(),
+				Proto:         r.Proto,
+				RequestHeader: c.redactHeader(r.Header),
+			}
+			reqBody := &cappedBuffer{max: c.conf.MaxBodySize}
+			if r.Body != nil && r.Body != http.NoBody {
+				r.Body = &captureReader{ReadCloser: r.Body, buf: reqBody}
+			}
+			cw := &captureResponseWriter{ResponseWriter: w, buf: &cappedBuffer{max: c.conf.MaxBodySize}}
+
+			h.ServeHTTP(cw, r)
+
+			// The principal is known once the request is authenticated
+			if !byRoute && !c.isTrusted(r) {
+				return
+			}
+
+			e.Duration = time.Since(e.Started)
+			e.Tenant = GetTenant(r.Context())
+			if p := GetPrincipal(r.Context()); p != nil {
+				e.PrincipalID = p.ID
+			}
+			e.Status = cw.status
+			if e.Status == 0 {
+				e.Status = http.StatusOK
+			}
+			e.ResponseHeader = c.redactHeader(cw.Header())
+			e.ResponseSize = cw.size
+			e.BodiesTruncated = reqBody.truncated || cw.buf.truncated
+			e.RequestBody = c.redactBody(reqBody, r.Header.Get("Content-Type"))
+			e.ResponseBody = c.redactBody(cw.buf, cw.Header().Get("Content-Type"))
+			e.URL = c.redactURL(e.URL)
+
+			// In place, so that Logger sees the exchange
+			*r = *r.WithContext(context.WithValue(r.Context(), contextKeyExchange, e))
+			c.keep(e)
+		})
+	}
+}
+
+// isCapturedRoute reports whether the route of r is always captured
+func (c *Capturer) isCapturedRoute(r *http.Request) bool {
+	if len(c.routes) == 0 {
+		return false
+	}
+	pattern := strings.ToLower(RoutePattern(r))
+	return pattern != "" && (c.routes[pattern] || c.routes[strings.ToLower(r.Method)+" "+pattern])
+}
+
+// isTrusted reports whether the principal of r has one of the trusted roles
+func (c *Capturer) isTrusted(r *http.Request) bool {
+	p := GetPrincipal(r.Context())
+	if p == nil {
+		return false
+	}
+	for _, role := range c.conf.TrustedRoles {
+		if p.HasRole(role) {
+			return true
+		}
+	}
+	return false
+}
+
+// keep adds the exchange to the ring of the last exchanges
+func (c *Capturer) keep(e *Exchange) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if len(c.exchanges) < c.conf.Keep {
+		c.exchanges = append(c.exchanges, e)
+		return
+	}
+	c.exchanges[c.next] = e
+	c.next = (c.next + 1) % c.conf.Keep
+}
+
+// Exchanges returns the exchanges kept, oldest first
+func (c *Capturer) Exchanges() []*Exchange {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	exchanges := make([]*Exchange, 0, len(c.exchanges))
+	exchanges = append(exchanges, c.exchanges[c.next:]...)
+	return append(exchanges, c.exchanges[:c.next]...)
+}
+
+// redactBody returns the captured body, with the redacted fields of JSON bodies
+func (c *Capturer) redactBody(buf *cappedBuffer, contentType string) string {
+	if buf.Len() == 0 {
+		return ""
+	}
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	if len(c.redactFields) == 0 || !strings.HasSuffix(mediaType, "json") {
+		return buf.String()
+	}
+
+	// Newline delimited JSON lists are redacted line by line
+	var out bytes.Buffer
+	dec := json.NewDecoder(bytes.NewReader(buf.Bytes()))
+	dec.UseNumber()
+	for {
+		var v interface{}
+		err := dec.Decode(&v)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			// A truncated or invalid body can't be redacted
+			return bodyRedacted
+		}
+		if out.Len() > 0 {
+			out.WriteByte('\n')
+		}
+		redacted, _ := json.Marshal(c.redactValue(v))
+		out.Write(redacted)
+	}
+
+	return out.String()
+}
+
+// redactValue replaces the values of the redacted fields, at any depth
+func (c *Capturer) redactValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for k, field := range v {
+			if c.redactFields[strings.ToLower(k)] {
+				v[k] = bodyRedacted
+				continue
+			}
+			v[k] = c.redactValue(field)
+		}
+	case []interface{}:
+		for i, item := range v {
+			v[i] = c.redactValue(item)
+		}
+	}
+	return v
+}
+
+// redactURL redacts the query parameters named as redacted fields
+func (c *Capturer) redactURL(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.RawQuery == "" {
+		return rawURL
+	}
+
+	q := u.Query()
+	redacted := false
+	for k := range q {
+		if c.redactFields[strings.ToLower(k)] {
+			q[k] = []string{bodyRedacted}
+			redacted = true
+		}
+	}
+	if redacted {
+		u.RawQuery = q.Encode()
+	}
+
+	return u.String()
+}
+
+// redactHeader returns a copy of the headers, with the redacted ones
+func (c *Capturer) redactHeader(header http.Header) http.Header {
+	redacted := make(http.Header, len(header))
+	for k, v := range header {
+		if c.redactHeaders[http.CanonicalHeaderKey(k)] {
+			v = []string{bodyRedacted}
+		}
+		redacted[k] = v
+	}
+	return redacted
+}
+
+// GetExchange returns the exchange captured for the request, nil if it wasn't captured
+func GetExchange(ctx context.Context) *Exchange {
+	e, _ := ctx.Value(contextKeyExchange).(*Exchange)
+	return e
+}
+
+// cappedBuffer keeps the first max bytes written
+type cappedBuffer struct {
+	bytes.Buffer
+	max       int
+	truncated bool
+}
+
+func (b *cappedBuffer) capture(p []byte) {
+	if room := b.max - b.Len(); len(p) > room {
+		p = p[:room]
+		b.truncated = true
+	}
+	b.Write(p)
+}
+
+// captureReader captures the request body as it is read by the handlers
+type captureReader struct {
+	io.ReadCloser
+	buf *cappedBuffer
+}
+
+func (cr *captureReader) Read(p []byte) (int, error) {
+	n, err := cr.ReadCloser.Read(p)
+	cr.buf.capture(p[:n])
+	return n, err
+}
+
+// captureResponseWriter captures the response body
+type captureResponseWriter struct {
+	http.ResponseWriter
+	buf    *cappedBuffer
+	status int
+	size   int
+}
+
+func (w *captureResponseWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *captureResponseWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(b)
+	w.buf.capture(b[:n])
+	w.size += n
+	return n, err
+}
+
+// Flush lets the streamed responses through
+func (w *captureResponseWriter) Flush() {
+	flush(w.ResponseWriter)
+}
+
+func (w *captureResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return hijack(w.ResponseWriter)
+}
+
+func (w *captureResponseWriter) Push(target string, opts *http.PushOptions) error {
+	return push(w.ResponseWriter, target, opts)
+}
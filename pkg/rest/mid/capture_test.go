@@ -0,0 +1,285 @@
+package mid
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi"
+	"github.com/sirupsen/logrus/hooks/test"
+)
+
+func captureRouter(c *Capturer) (http.Handler, *test.Hook) {
+	logger, hook := test.NewNullLogger()
+
+	r := chi.NewRouter()
+	r.Use(Logger(logger), Capture(c))
+	// Authenticates the callers sending a role, of the tenant they send
+	r.Use(func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			if role := req.Header.Get("X-Role"); role != "" {
+				tenant := req.Header.Get("X-Tenant")
+				setPrincipal(req, &Principal{ID: "user-1", Roles: []string{role}, Tenant: tenant})
+				*req = *req.WithContext(WithTenant(req.Context(), tenant))
+			}
+			h.ServeHTTP(w, req)
+		})
+	})
+	echo := func(w http.ResponseWriter, req *http.Request) {
+		body, _ := ioutil.ReadAll(req.Body)
+		w.Header().Set("Content-Type", req.Header.Get("Content-Type"))
+		w.Header().Set("Set-Cookie", "session=secret")
+		w.WriteHeader(http.StatusCreated)
+		_, _ = w.Write(body)
+	}
+	r.Post("/v1/resource", echo)
+	r.Post("/v1/other", echo)
+
+	return r, hook
+}
+
+func TestCapture(t *testing.T) {
+	tests := []struct {
+		name                 string
+		path                 string
+		headers              map[string]string
+		body                 string
+		expectedCapture      bool
+		expectedRequestBody  string
+		expectedResponseBody string
+		expectedTruncated    bool
+	}{
+		{
+			name:                 "captured route",
+			path:                 "/v1/resource",
+			headers:              map[string]string{"Content-Type": "text/plain"},
+			body:                 "hello",
+			expectedCapture:      true,
+			expectedRequestBody:  "hello",
+			expectedResponseBody: "hello",
+		},
+		{
+			name: "other route",
+			path: "/v1/other",
+			body: "hello",
+		},
+		{
+			name:                 "trusted header",
+			path:                 "/v1/other",
+			headers:              map[string]string{"X-Debug-Capture": "1", "X-Role": "admin", "Content-Type": "text/plain"},
+			body:                 "hello",
+			expectedCapture:      true,
+			expectedRequestBody:  "hello",
+			expectedResponseBody: "hello",
+		},
+		{
+			name:    "untrusted header",
+			path:    "/v1/other",
+			headers: map[string]string{"X-Debug-Capture": "1", "X-Role": "user"},
+			body:    "hello",
+		},
+		{
+			name:    "unauthenticated header",
+			path:    "/v1/other",
+			headers: map[string]string{"X-Debug-Capture": "1"},
+			body:    "hello",
+		},
+		{
+			name:                 "redacted JSON fields",
+			path:                 "/v1/resource",
+			headers:              map[string]string{"Content-Type": "application/json; charset=utf-8"},
+			body:                 `{"label":"one","Password":"x","nested":[{"token":"y","n":1}]}`,
+			expectedCapture:      true,
+			expectedRequestBody:  `{"Password":"[REDACTED]","label":"one","nested":[{"n":1,"token":"[REDACTED]"}]}`,
+			expectedResponseBody: `{"Password":"[REDACTED]","label":"one","nested":[{"n":1,"token":"[REDACTED]"}]}`,
+		},
+		{
+			name:                 "truncated JSON",
+			path:                 "/v1/resource",
+			headers:              map[string]string{"Content-Type": "application/json"},
+			body:                 `{"label":"` + strings.Repeat("a", 100) + `","password":"secret"}`,
+			expectedCapture:      true,
+			expectedRequestBody:  "[REDACTED]",
+			expectedResponseBody: "[REDACTED]",
+			expectedTruncated:    true,
+		},
+		{
+			name:                 "truncated text",
+			path:                 "/v1/resource",
+			headers:              map[string]string{"Content-Type": "text/plain"},
+			body:                 strings.Repeat("a", 100),
+			expectedCapture:      true,
+			expectedRequestBody:  strings.Repeat("a", 64),
+			expectedResponseBody: strings.Repeat("a", 64),
+			expectedTruncated:    true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conf := DefaultCaptureConf()
+			conf.Routes = []string{"POST /v1/resource"}
+			conf.MaxBodySize = 64
+			c := NewCapturer(conf)
+			h, hook := captureRouter(c)
+
+			req, _ := http.NewRequest(http.MethodPost, tt.path, strings.NewReader(tt.body))
+			for k, v := range tt.headers {
+				req.Header.Set(k, v)
+			}
+			rr := httptest.NewRecorder()
+			h.ServeHTTP(rr, req)
+
+			if rr.Body.String() != tt.body {
+				t.Errorf("expected the response to be unchanged, got %s", rr.Body.String())
+			}
+
+			entry := hook.LastEntry()
+			_, logged := entry.Data["request_body"]
+			if logged != tt.expectedCapture || (len(c.Exchanges()) == 1) != tt.expectedCapture {
+				t.Fatalf("expected capture %t, got logged %t with %d exchanges", tt.expectedCapture, logged, len(c.Exchanges()))
+			}
+			if !tt.expectedCapture {
+				return
+			}
+
+			if entry.Data["request_body"] != tt.expectedRequestBody {
+				t.Errorf("expected request body %q, got %q", tt.expectedRequestBody, entry.Data["request_body"])
+			}
+			if entry.Data["response_body"] != tt.expectedResponseBody {
+				t.Errorf("expected response body %q, got %q", tt.expectedResponseBody, entry.Data["response_body"])
+			}
+			if _, truncated := entry.Data["bodies_truncated"]; truncated != tt.expectedTruncated {
+				t.Errorf("expected truncated %t", tt.expectedTruncated)
+			}
+
+			e := c.Exchanges()[0]
+			if e.Status != http.StatusCreated || e.ResponseSize != len(tt.body) {
+				t.Errorf("expected the status and size of the response, got %d %d", e.Status, e.ResponseSize)
+			}
+			if e.ResponseHeader.Get("Set-Cookie") != "[REDACTED]" {
+				t.Errorf("expected the cookie to be redacted, got %v", e.ResponseHeader)
+			}
+		})
+	}
+}
+
+func TestCapturerKeep(t *testing.T) {
+	c := NewCapturer(&CaptureConf{Keep: 2})
+	for _, u := range []string{"1", "2", "3"} {
+		c.keep(&Exchange{URL: u})
+	}
+
+	exchanges := c.Exchanges()
+	if len(exchanges) != 2 || exchanges[0].URL != "2" || exchanges[1].URL != "3" {
+		t.Errorf("expected the last 2 exchanges, oldest first, got %v", exchanges)
+	}
+}
+
+func TestCapturerHAR(t *testing.T) {
+	conf := DefaultCaptureConf()
+	conf.Routes = []string{"/v1/resource"}
+	c := NewCapturer(conf)
+	h, _ := captureRouter(c)
+
+	req := httptest.NewRequest(http.MethodPost, "/v1/resource?id=1&token=secret", strings.NewReader(`{"label":"one"}`))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer secret")
+	h.ServeHTTP(httptest.NewRecorder(), req)
+
+	rr := httptest.NewRecorder()
+	c.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/admin/captures", nil))
+
+	var har struct {
+		Log struct {
+			Version string
+			Entries []struct {
+				Request struct {
+					Method      string
+					URL         string
+					Headers     []harNameValue
+					QueryString []harNameValue
+					PostData    harPostData
+				}
+				Response struct {
+					Status  int
+					Content harContent
+				}
+			}
+		}
+	}
+	if err := json.NewDecoder(rr.Body).Decode(&har); err != nil {
+		t.Fatalf("expected a HAR, got %v", err)
+	}
+	if har.Log.Version != "1.2" || len(har.Log.Entries) != 1 {
+		t.Fatalf("expected a HAR 1.2 entry, got %+v", har.Log)
+	}
+
+	e := har.Log.Entries[0]
+	if e.Request.Method != http.MethodPost || e.Request.URL != "http://example.com/v1/resource?id=1&token=%5BREDACTED%5D" {
+		t.Errorf("unexpected request %s %s", e.Request.Method, e.Request.URL)
+	}
+	expectedQuery := []harNameValue{{Name: "id", Value: "1"}, {Name: "token", Value: "[REDACTED]"}}
+	if len(e.Request.QueryString) != 2 || e.Request.QueryString[0] != expectedQuery[0] || e.Request.QueryString[1] != expectedQuery[1] {
+		t.Errorf("expected query %v, got %v", expectedQuery, e.Request.QueryString)
+	}
+	for _, header := range e.Request.Headers {
+		if header.Name == "Authorization" && header.Value != "[REDACTED]" {
+			t.Errorf("expected the authorization to be redacted, got %s", header.Value)
+		}
+	}
+	if e.Request.PostData.Text != `{"label":"one"}` || e.Response.Status != http.StatusCreated ||
+		e.Response.Content.Text != `{"label":"one"}` {
+		t.Errorf("expected the bodies, got %+v %+v", e.Request.PostData, e.Response)
+	}
+}
+
+func TestCapturerHARTenant(t *testing.T) {
+	conf := DefaultCaptureConf()
+	conf.Routes = []string{"/v1/resource"}
+	c := NewCapturer(conf)
+	h, _ := captureRouter(c)
+
+	for _, tenant := range []string{"acme", "globex", ""} {
+		req := httptest.NewRequest(http.MethodPost, "/v1/resource", strings.NewReader("hello"))
+		req.Header.Set("X-Role", "user")
+		req.Header.Set("X-Tenant", tenant)
+		h.ServeHTTP(httptest.NewRecorder(), req)
+	}
+	if e := c.Exchanges()[0]; e.Tenant != "acme" || e.PrincipalID != "user-1" {
+		t.Errorf("expected the exchange of user-1 in acme, got %q in %q", e.PrincipalID, e.Tenant)
+	}
+
+	tests := []struct {
+		name            string
+		principal       *Principal
+		expectedTenants []string
+	}{
+		{name: "tenant admin", principal: &Principal{ID: "admin-1", Tenant: "acme"}, expectedTenants: []string{"acme"}},
+		{name: "admin without tenant", principal: &Principal{ID: "admin-2"}, expectedTenants: []string{"acme", "globex", ""}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/admin/captures", nil)
+			req = req.WithContext(WithPrincipal(req.Context(), tt.principal))
+			rr := httptest.NewRecorder()
+			c.ServeHTTP(rr, req)
+
+			var har struct {
+				Log struct {
+					Entries []json.RawMessage
+				}
+			}
+			if err := json.NewDecoder(rr.Body).Decode(&har); err != nil {
+				t.Fatalf("expected a HAR, got %v", err)
+			}
+			if len(har.Log.Entries) != len(tt.expectedTenants) {
+				t.Errorf("expected the exchanges of %v, got %d", tt.expectedTenants, len(har.Log.Entries))
+			}
+		})
+	}
+}
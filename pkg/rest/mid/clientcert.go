@@ -0,0 +1,102 @@
+package mid
+
+import (
+	"crypto/x509"
+	"errors"
+	"net/http"
+	"strings"
+)
+
+var (
+	errCertSubject = errors.New("client certificate subject missing")
+	errCertMissing = errors.New("client certificate missing")
+)
+
+// ClientCertConf is the configuration of the ClientCertAuth middleware
+type ClientCertConf struct {
+	// Roles and Scopes are granted to the clients, keyed by certificate subject,
+	// case insensitive as the configuration keys are lowercased
+	Roles  map[string][]string
+	Scopes map[string][]string
+	// Tenants binds the clients to a tenant, keyed by certificate subject, case insensitive.
+	// TenantFromOU binds the others to the first organizational unit of their certificate.
+	Tenants      map[string]string
+	TenantFromOU bool
+	// Required refuses the requests without client certificate with a 401,
+	// when no other authentication middleware follows
+	Required bool
+}
+
+// ClientCertAuth authenticates the requests with a client certificate verified by the TLS server (mTLS).
+// The principal ID is the subject common name, or the first DNS or URI subject alternative name,
+// its tenant is configured by subject or is the organizational unit, see ClientCertConf.
+// Requests without client certificate go through, for other authentication middlewares, unless required.
+func ClientCertAuth(conf *ClientCertConf) func(http.Handler) http.Handler {
+	if conf == nil {
+		conf = &ClientCertConf{}
+	}
+	roles, scopes := lowerKeys(conf.Roles), lowerKeys(conf.Scopes)
+	tenants := make(map[string]string, len(conf.Tenants))
+	for k, v := range conf.Tenants {
+		tenants[strings.ToLower(k)] = v
+	}
+
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			// Only the chains verified against the client CAs are trusted
+			if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.VerifiedChains[0]) == 0 {
+				if conf.Required {
+					renderError(w, r, http.StatusUnauthorized, "Unauthorized.", errCertMissing)
+					return
+				}
+				h.ServeHTTP(w, r)
+				return
+			}
+
+			id := certSubject(r.TLS.VerifiedChains[0][0])
+			if id == "" {
+				renderError(w, r, http.StatusUnauthorized, "Unauthorized.", errCertSubject)
+				return
+			}
+
+			tenant, ok := tenants[strings.ToLower(id)]
+			if ou := r.TLS.VerifiedChains[0][0].Subject.OrganizationalUnit; !ok && conf.TenantFromOU && len(ou) > 0 {
+				tenant = strings.ToLower(ou[0])
+			}
+
+			setPrincipal(r, &Principal{
+				ID:     PrincipalID("mtls", id),
+				Method: "mtls",
+				Roles:  roles[strings.ToLower(id)],
+				Scopes: scopes[strings.ToLower(id)],
+				Tenant: tenant,
+			})
+
+			h.ServeHTTP(w, r)
+		})
+	}
+}
+
+// lowerKeys returns a copy of m with lowercased keys
+func lowerKeys(m map[string][]string) map[string][]string {
+	lower := make(map[string][]string, len(m))
+	for k, v := range m {
+		lower[strings.ToLower(k)] = v
+	}
+
+	return lower
+}
+
+// certSubject returns the identity of a client certificate
+func certSubject(cert *x509.Certificate) string {
+	if cert.Subject.CommonName != "" {
+		return cert.Subject.CommonName
+	}
+	if len(cert.DNSNames) > 0 {
+		return cert.DNSNames[0]
+	}
+	if len(cert.URIs) > 0 {
+		return cert.URIs[0].String()
+	}
+	return ""
+}
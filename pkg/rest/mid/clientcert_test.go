@@ -0,0 +1,153 @@
+package mid
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"testing"
+)
+
+func TestClientCertAuth(t *testing.T) {
+	conf := &ClientCertConf{
+		Roles:        map[string][]string{"billing": {"admin"}},
+		Scopes:       map[string][]string{"billing": {"resource:read"}},
+		Tenants:      map[string]string{"Reporting": "acme"},
+		TenantFromOU: true,
+	}
+	spiffe, _ := url.Parse("spiffe://example.com/billing")
+
+	tc := []struct {
+		name              string
+		tls               *tls.ConnectionState
+		required          bool
+		expectedStatus    int
+		expectedPrincipal *Principal
+	}{
+		{
+			name: "common name",
+			tls: &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{
+				{Subject: pkix.Name{CommonName: "billing"}},
+			}}},
+			expectedStatus: http.StatusOK,
+			expectedPrincipal: &Principal{ID: "mtls:billing", Method: "mtls",
+				Roles: []string{"admin"}, Scopes: []string{"resource:read"}},
+		},
+		{
+			// The configuration keys are lowercased
+			name: "mixed case common name",
+			tls: &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{
+				{Subject: pkix.Name{CommonName: "Billing"}},
+			}}},
+			expectedStatus: http.StatusOK,
+			expectedPrincipal: &Principal{ID: "mtls:Billing", Method: "mtls",
+				Roles: []string{"admin"}, Scopes: []string{"resource:read"}},
+		},
+		{
+			name: "tenant by subject",
+			tls: &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{
+				{Subject: pkix.Name{CommonName: "reporting", OrganizationalUnit: []string{"other"}}},
+			}}},
+			expectedStatus:    http.StatusOK,
+			expectedPrincipal: &Principal{ID: "mtls:reporting", Method: "mtls", Tenant: "acme"},
+		},
+		{
+			name: "tenant by organizational unit",
+			tls: &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{
+				{Subject: pkix.Name{CommonName: "exports", OrganizationalUnit: []string{"Globex"}}},
+			}}},
+			expectedStatus:    http.StatusOK,
+			expectedPrincipal: &Principal{ID: "mtls:exports", Method: "mtls", Tenant: "globex"},
+		},
+		{
+			name: "dns name",
+			tls: &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{
+				{DNSNames: []string{"billing.internal"}},
+			}}},
+			expectedStatus:    http.StatusOK,
+			expectedPrincipal: &Principal{ID: "mtls:billing.internal", Method: "mtls"},
+		},
+		{
+			name: "uri",
+			tls: &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{
+				{URIs: []*url.URL{spiffe}},
+			}}},
+			expectedStatus:    http.StatusOK,
+			expectedPrincipal: &Principal{ID: "mtls:spiffe%3A//example.com/billing", Method: "mtls"},
+		},
+		{
+			name: "no subject",
+			tls: &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{
+				{},
+			}}},
+			expectedStatus: http.StatusUnauthorized,
+		},
+		{
+			name: "unverified certificate",
+			tls: &tls.ConnectionState{PeerCertificates: []*x509.Certificate{
+				{Subject: pkix.Name{CommonName: "billing"}},
+			}},
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "plain http",
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "required",
+			required:       true,
+			expectedStatus: http.StatusUnauthorized,
+		},
+	}
+
+	for _, tt := range tc {
+		t.Run(tt.name, func(t *testing.T) {
+			var principal *Principal
+			c := *conf
+			c.Required = tt.required
+			h := ClientCertAuth(&c)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				principal = GetPrincipal(r.Context())
+			}))
+
+			rr := httptest.NewRecorder()
+			req, _ := http.NewRequest(http.MethodGet, "/", nil)
+			req.TLS = tt.tls
+			h.ServeHTTP(rr, req)
+
+			if rr.Code != tt.expectedStatus {
+				t.Errorf("expected status %d, got %d", tt.expectedStatus, rr.Code)
+				return
+			}
+			if !reflect.DeepEqual(principal, tt.expectedPrincipal) {
+				t.Errorf("expected principal %+v, got %+v", tt.expectedPrincipal, principal)
+			}
+		})
+	}
+}
+
+func TestClientCertAuthBeforeOtherAuth(t *testing.T) {
+	var principal *Principal
+	h := ClientCertAuth(nil)(
+		APIKeyAuth(&APIKeyConf{Keys: testKeys{}})(
+			JWTAuth(&JWTConf{Keys: &StaticKeys{HMACSecret: []byte("secret")}})(
+				http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					principal = GetPrincipal(r.Context())
+				}),
+			),
+		),
+	)
+
+	rr := httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodGet, "/", nil)
+	req.TLS = &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{
+		{Subject: pkix.Name{CommonName: "billing"}},
+	}}}
+	h.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK || principal == nil || principal.ID != "mtls:billing" {
+		t.Errorf("expected the client certificate to authenticate the request, got %d %+v", rr.Code, principal)
+	}
+}
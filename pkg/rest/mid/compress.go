@@ -0,0 +1,447 @@
+package mid
+
+import (
+	"bufio"
+	"compress/gzip"
+	"compress/zlib"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+)
+
+// CompressWriter is a pooled compressing writer, gzip.Writer, zlib.Writer,
+// brotli.Writer and zstd.Encoder satisfy it
+type CompressWriter interface {
+	io.WriteCloser
+	Flush() error
+	Reset(w io.Writer)
+}
+
+// Encoder is a content coding which can be negotiated by Compress
+type Encoder struct {
+	// Name is the content-coding token, as found in Accept-Encoding
+	Name string
+	// New returns a writer compressing into w with the given level,
+	// 0 being the encoder's default
+	New func(w io.Writer, level int) (CompressWriter, error)
+}
+
+// BrotliEncoder compresses with brotli, levels go from 1 to 11
+var BrotliEncoder = Encoder{
+	Name: "br",
+	New: func(w io.Writer, level int) (CompressWriter, error) {
+		if level <= 0 {
+			level = brotli.DefaultCompression
+		}
+		if level > brotli.BestCompression {
+			level = brotli.BestCompression
+		}
+		return brotli.NewWriterLevel(w, level), nil
+	},
+}
+
+// ZstdEncoder compresses with zstd, levels being zstd levels from 1 to 22
+var ZstdEncoder = Encoder{
+	Name: "zstd",
+	New: func(w io.Writer, level int) (CompressWriter, error) {
+		encoderLevel := zstd.SpeedDefault
+		if level > 0 {
+			encoderLevel = zstd.EncoderLevelFromZstd(level)
+		}
+		// One goroutine per response, the responses are compressed concurrently already
+		return zstd.NewWriter(w, zstd.WithEncoderLevel(encoderLevel), zstd.WithEncoderConcurrency(1))
+	},
+}
+
+// GzipEncoder compresses with gzip, levels go from 1 to 9
+var GzipEncoder = Encoder{
+	Name: "gzip",
+	New: func(w io.Writer, level int) (CompressWriter, error) {
+		if level == 0 {
+			level = gzip.DefaultCompression
+		}
+		return gzip.NewWriterLevel(w, level)
+	},
+}
+
+// DeflateEncoder compresses with deflate, which is zlib in HTTP (RFC 1950),
+// levels go from 1 to 9
+var DeflateEncoder = Encoder{
+	Name: "deflate",
+	New: func(w io.Writer, level int) (CompressWriter, error) {
+		if level == 0 {
+			level = zlib.DefaultCompression
+		}
+		return zlib.NewWriterLevel(w, level)
+	},
+}
+
+// defaultEncoders are the encoders by order of preference, the best compression first
+var defaultEncoders = []Encoder{BrotliEncoder, ZstdEncoder, GzipEncoder, DeflateEncoder}
+
+// CompressConf is the configuration of the Compress middleware
+type CompressConf struct {
+	// Level is the compression level, in the scale of each encoder,
+	// 0 for the default level of each encoder
+	Level int
+	// MinSize is the response size under which nothing is compressed
+	MinSize int
+	// ContentTypes are the compressible media types, "text/*" matches every text type
+	ContentTypes []string
+	// Encoders are the available content codings, by order of preference.
+	// Defaults to brotli, zstd, gzip then deflate
+	Encoders []Encoder
+}
+
+// DefaultCompressConf returns a conf compressing JSON and text responses bigger than 1KB
+func DefaultCompressConf() *CompressConf {
+	return &CompressConf{
+		MinSize: 1024,
+		ContentTypes: []string{
+			"application/json",
+			"application/x-ndjson",
+			"application/problem+json",
+			"application/javascript",
+			"application/xml",
+			"text/*",
+		},
+		Encoders: append([]Encoder(nil), defaultEncoders...),
+	}
+}
+
+// uncompressedLengthRecorder is implemented by response writers interested
+// in the length of the response before compression, such as the logger's
+type uncompressedLengthRecorder interface {
+	addUncompressedLength(n int)
+}
+
+// pooledEncoder keeps the writers of one encoder for reuse
+type pooledEncoder struct {
+	Encoder
+	level int
+	pool  sync.Pool
+}
+
+func (e *pooledEncoder) get(w io.Writer) (CompressWriter, error) {
+	if cw, ok := e.pool.Get().(CompressWriter); ok {
+		cw.Reset(w)
+		return cw, nil
+	}
+
+	return e.New(w, e.level)
+}
+
+func (e *pooledEncoder) put(cw CompressWriter) {
+	cw.Reset(ioutil.Discard)
+	e.pool.Put(cw)
+}
+
+// Compress negotiates the response content coding with the client and
+// decodes gzip request bodies.
+// It should be used right after Logger, so that both sizes get logged.
+func Compress(conf *CompressConf) func(http.Handler) http.Handler {
+	if conf == nil {
+		conf = DefaultCompressConf()
+	}
+	encoders := conf.Encoders
+	if len(encoders) == 0 {
+		encoders = defaultEncoders
+	}
+
+	pooled := make([]*pooledEncoder, len(encoders))
+	for i, e := range encoders {
+		pooled[i] = &pooledEncoder{Encoder: e, level: conf.Level}
+	}
+
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !decompressRequest(w, r) {
+				return
+			}
+
+			cw := &compressResponseWriter{
+				ResponseWriter: w,
+				conf:           conf,
+				encoder:        negotiateEncoder(r.Header.Get("Accept-Encoding"), pooled),
+				isHead:         r.Method == http.MethodHead,
+			}
+			defer func() {
+				if errC := cw.close(); errC != nil && r.Context().Value(ErrRequestContextKey) == nil {
+					*r = *r.WithContext(context.WithValue(r.Context(), ErrRequestContextKey, errC))
+				}
+			}()
+
+			h.ServeHTTP(cw, r)
+		})
+	}
+}
+
+// decompressRequest replaces a gzip encoded body by its decoded stream.
+// Returns false if the request has been answered with an error.
+func decompressRequest(w http.ResponseWriter, r *http.Request) bool {
+	switch strings.ToLower(strings.TrimSpace(r.Header.Get("Content-Encoding"))) {
+	case "", "identity":
+		return true
+	case "gzip", "x-gzip":
+		zr, err := gzip.NewReader(r.Body)
+		if err != nil {
+			renderError(w, r, http.StatusBadRequest, "Invalid gzip request body.", fmt.Errorf("Compress: %v", err))
+			return false
+		}
+		r.Body = &gzipRequestBody{Reader: zr, body: r.Body}
+		r.Header.Del("Content-Encoding")
+		r.Header.Del("Content-Length")
+		r.ContentLength = -1
+		return true
+	default:
+		renderError(w, r, http.StatusUnsupportedMediaType, "Unsupported request content encoding.", nil)
+		return false
+	}
+}
+
+type gzipRequestBody struct {
+	*gzip.Reader
+	body io.ReadCloser
+}
+
+func (b *gzipRequestBody) Close() error {
+	errZ := b.Reader.Close()
+	errB := b.body.Close()
+	if errZ != nil {
+		return errZ
+	}
+	return errB
+}
+
+// negotiateEncoder returns the encoder with the highest quality value,
+// ties being broken by server preference. Returns nil for identity.
+func negotiateEncoder(acceptEncoding string, encoders []*pooledEncoder) *pooledEncoder {
+	if acceptEncoding == "" {
+		return nil
+	}
+
+	qualities := make(map[string]float64)
+	for _, part := range strings.Split(acceptEncoding, ",") {
+		coding, params, err := mime.ParseMediaType("x/" + strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		q := 1.0
+		if qs, ok := params["q"]; ok {
+			if qv, errQ := strconv.ParseFloat(qs, 64); errQ == nil {
+				q = qv
+			}
+		}
+		qualities[strings.TrimPrefix(coding, "x/")] = q
+	}
+
+	var best *pooledEncoder
+	bestQ := 0.0
+	for _, e := range encoders {
+		q, ok := qualities[e.Name]
+		if !ok {
+			if q, ok = qualities["*"]; !ok {
+				continue
+			}
+		}
+		if q > bestQ {
+			best, bestQ = e, q
+		}
+	}
+
+	return best
+}
+
+// compressResponseWriter buffers the beginning of the response until it knows
+// whether it is worth compressing
+type compressResponseWriter struct {
+	http.ResponseWriter
+	conf    *CompressConf
+	encoder *pooledEncoder
+	isHead  bool
+
+	cw           CompressWriter
+	buf          []byte
+	httpStatus   int
+	decided      bool
+	uncompressed int
+}
+
+// WriteHeader delays the status until the content coding is decided, the
+// informational ones such as 103 Early Hints go through as they come
+func (w *compressResponseWriter) WriteHeader(httpStatus int) {
+	if w.httpStatus != 0 {
+		return
+	}
+	if httpStatus >= 100 && httpStatus < 200 && httpStatus != http.StatusSwitchingProtocols {
+		w.ResponseWriter.WriteHeader(httpStatus)
+		return
+	}
+	w.httpStatus = httpStatus
+	if !bodyAllowed(httpStatus) {
+		_ = w.decide(false)
+	}
+}
+
+// Write buffers b until MinSize is reached, then compresses if possible
+func (w *compressResponseWriter) Write(b []byte) (int, error) {
+	if w.httpStatus == 0 {
+		w.httpStatus = http.StatusOK
+	}
+	w.uncompressed += len(b)
+
+	if !w.decided {
+		w.buf = append(w.buf, b...)
+		if len(w.buf) < w.conf.MinSize {
+			return len(b), nil
+		}
+		if err := w.decide(true); err != nil {
+			return 0, err
+		}
+		return len(b), nil
+	}
+
+	if w.cw != nil {
+		return w.cw.Write(b)
+	}
+
+	return w.ResponseWriter.Write(b)
+}
+
+// Flush compresses what has been buffered so far and flushes it to the client
+func (w *compressResponseWriter) Flush() {
+	if !w.decided {
+		if w.httpStatus == 0 {
+			w.httpStatus = http.StatusOK
+		}
+		if err := w.decide(true); err != nil {
+			return
+		}
+	}
+	if w.cw != nil {
+		if err := w.cw.Flush(); err != nil {
+			return
+		}
+	}
+	flush(w.ResponseWriter)
+}
+
+// Hijack hands the connection over, nothing is compressed after
+func (w *compressResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	w.decided = true
+	return hijack(w.ResponseWriter)
+}
+
+func (w *compressResponseWriter) Push(target string, opts *http.PushOptions) error {
+	return push(w.ResponseWriter, target, opts)
+}
+
+// decide writes the headers, choosing whether the response gets compressed,
+// and writes what has been buffered
+func (w *compressResponseWriter) decide(bigEnough bool) error {
+	w.decided = true
+
+	h := w.Header()
+	if w.compressible() {
+		addVary(h, "Accept-Encoding")
+		if bigEnough && w.encoder != nil {
+			cw, err := w.encoder.get(w.ResponseWriter)
+			if err != nil {
+				return fmt.Errorf("Compress: %s %v", w.encoder.Name, err)
+			}
+			w.cw = cw
+			h.Set("Content-Encoding", w.encoder.Name)
+			h.Del("Content-Length")
+		}
+	}
+
+	if w.httpStatus != 0 {
+		w.ResponseWriter.WriteHeader(w.httpStatus)
+	}
+
+	buf := w.buf
+	w.buf = nil
+	if len(buf) == 0 {
+		return nil
+	}
+	if w.cw != nil {
+		_, err := w.cw.Write(buf)
+		return err
+	}
+	_, err := w.ResponseWriter.Write(buf)
+	return err
+}
+
+// compressible checks the response is a body worth compressing
+func (w *compressResponseWriter) compressible() bool {
+	h := w.Header()
+	if w.isHead || !bodyAllowed(w.httpStatus) || w.httpStatus == http.StatusPartialContent ||
+		h.Get("Content-Encoding") != "" || h.Get("Content-Range") != "" {
+		return false
+	}
+
+	mediaType, _, err := mime.ParseMediaType(h.Get("Content-Type"))
+	if err != nil {
+		return false
+	}
+	for _, ct := range w.conf.ContentTypes {
+		if ct == mediaType ||
+			(strings.HasSuffix(ct, "/*") && strings.HasPrefix(mediaType, strings.TrimSuffix(ct, "*"))) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// close finishes the compressed stream and gives the writer back to the pool
+func (w *compressResponseWriter) close() error {
+	if !w.decided && (w.httpStatus != 0 || len(w.buf) > 0) {
+		if err := w.decide(false); err != nil {
+			return err
+		}
+	}
+	if w.cw == nil {
+		return nil
+	}
+
+	err := w.cw.Close()
+	w.encoder.put(w.cw)
+	w.cw = nil
+
+	if rec, ok := w.ResponseWriter.(uncompressedLengthRecorder); ok {
+		rec.addUncompressedLength(w.uncompressed)
+	}
+
+	return err
+}
+
+// bodyAllowed reports whether a response with this status can have a body
+func bodyAllowed(httpStatus int) bool {
+	return (httpStatus >= 200 || httpStatus == 0) &&
+		httpStatus != http.StatusNoContent &&
+		httpStatus != http.StatusNotModified
+}
+
+// addVary adds value to the Vary header, unless it is already there
+func addVary(h http.Header, value string) {
+	for _, v := range h["Vary"] {
+		for _, existing := range strings.Split(v, ",") {
+			existing = strings.TrimSpace(existing)
+			if existing == "*" || strings.EqualFold(existing, value) {
+				return
+			}
+		}
+	}
+	h.Add("Vary", value)
+}
@@ -0,0 +1,334 @@
+package mid
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+	"github.com/sirupsen/logrus/hooks/test"
+)
+
+func TestCompress(t *testing.T) {
+	bigBody := strings.Repeat(`{"label":"test"}`, 200)
+
+	tc := []struct {
+		name                   string
+		acceptEncoding         string
+		contentType            string
+		body                   string
+		status                 int
+		expectedContentEncode  string
+		expectedVary           bool
+		expectedUncompressBody string
+	}{
+		{
+			name:                  "gzip big json",
+			acceptEncoding:        "gzip, deflate",
+			contentType:           "application/json",
+			body:                  bigBody,
+			status:                http.StatusOK,
+			expectedContentEncode: "gzip",
+			expectedVary:          true,
+		},
+		{
+			name:                  "deflate preferred by quality",
+			acceptEncoding:        "gzip;q=0.5, deflate",
+			contentType:           "application/json",
+			body:                  bigBody,
+			status:                http.StatusOK,
+			expectedContentEncode: "deflate",
+			expectedVary:          true,
+		},
+		{
+			name:                  "brotli preferred by the server",
+			acceptEncoding:        "gzip, deflate, br, zstd",
+			contentType:           "application/json",
+			body:                  bigBody,
+			status:                http.StatusOK,
+			expectedContentEncode: "br",
+			expectedVary:          true,
+		},
+		{
+			name:                  "zstd",
+			acceptEncoding:        "gzip;q=0.8, zstd",
+			contentType:           "application/json",
+			body:                  bigBody,
+			status:                http.StatusOK,
+			expectedContentEncode: "zstd",
+			expectedVary:          true,
+		},
+		{
+			name:                  "small body is not compressed",
+			acceptEncoding:        "gzip",
+			contentType:           "application/json",
+			body:                  `{"label":"test"}`,
+			status:                http.StatusOK,
+			expectedContentEncode: "",
+			expectedVary:          true,
+		},
+		{
+			name:                  "client without compression",
+			acceptEncoding:        "",
+			contentType:           "application/json",
+			body:                  bigBody,
+			status:                http.StatusOK,
+			expectedContentEncode: "",
+			expectedVary:          true,
+		},
+		{
+			name:                  "gzip refused",
+			acceptEncoding:        "gzip;q=0, deflate;q=0",
+			contentType:           "application/json",
+			body:                  bigBody,
+			status:                http.StatusOK,
+			expectedContentEncode: "",
+			expectedVary:          true,
+		},
+		{
+			name:                  "content type not in the allowlist",
+			acceptEncoding:        "gzip",
+			contentType:           "image/png",
+			body:                  bigBody,
+			status:                http.StatusOK,
+			expectedContentEncode: "",
+			expectedVary:          false,
+		},
+		{
+			name:                  "no content",
+			acceptEncoding:        "gzip",
+			contentType:           "application/json",
+			status:                http.StatusNoContent,
+			expectedContentEncode: "",
+			expectedVary:          false,
+		},
+	}
+
+	for _, tt := range tc {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+				w.Header().Set("Content-Type", tt.contentType)
+				w.WriteHeader(tt.status)
+				_, _ = w.Write([]byte(tt.body))
+			})
+			rr := httptest.NewRecorder()
+			r, _ := http.NewRequest("GET", ``, nil)
+			r.Header.Set("Accept-Encoding", tt.acceptEncoding)
+
+			Compress(nil)(handler).ServeHTTP(rr, r)
+
+			if rr.Code != tt.status {
+				t.Errorf("expected status %d, got %d", tt.status, rr.Code)
+				return
+			}
+			if got := rr.Header().Get("Content-Encoding"); got != tt.expectedContentEncode {
+				t.Errorf("expected Content-Encoding %q, got %q", tt.expectedContentEncode, got)
+				return
+			}
+			if got := rr.Header().Get("Vary") == "Accept-Encoding"; got != tt.expectedVary {
+				t.Errorf("expected Vary %v, got %v", tt.expectedVary, rr.Header().Get("Vary"))
+				return
+			}
+
+			body := rr.Body.Bytes()
+			switch tt.expectedContentEncode {
+			case "gzip":
+				zr, err := gzip.NewReader(bytes.NewReader(body))
+				if err != nil {
+					t.Fatalf("invalid gzip body %v", err)
+				}
+				body, _ = ioutil.ReadAll(zr)
+			case "deflate":
+				zr, err := zlib.NewReader(bytes.NewReader(body))
+				if err != nil {
+					t.Fatalf("invalid zlib body %v", err)
+				}
+				body, _ = ioutil.ReadAll(zr)
+			case "br":
+				body, _ = ioutil.ReadAll(brotli.NewReader(bytes.NewReader(body)))
+			case "zstd":
+				zr, err := zstd.NewReader(bytes.NewReader(body))
+				if err != nil {
+					t.Fatalf("invalid zstd body %v", err)
+				}
+				body, _ = ioutil.ReadAll(zr)
+				zr.Close()
+			}
+			if string(body) != tt.body {
+				t.Errorf("expected body of length %d, got %d", len(tt.body), len(body))
+			}
+		})
+	}
+}
+
+func TestCompressDefaultLevel(t *testing.T) {
+	body := strings.Repeat(`{"label":"test"}`, 200)
+	handler := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(body))
+	})
+
+	// The zero level is the default of each encoder, not no compression
+	for _, e := range []string{"br", "zstd", "gzip", "deflate"} {
+		t.Run(e, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			r, _ := http.NewRequest("GET", ``, nil)
+			r.Header.Set("Accept-Encoding", e)
+
+			Compress(&CompressConf{ContentTypes: []string{"application/json"}})(handler).ServeHTTP(rr, r)
+
+			if got := rr.Header().Get("Content-Encoding"); got != e {
+				t.Fatalf("expected Content-Encoding %q, got %q", e, got)
+			}
+			if rr.Body.Len() >= len(body)/4 {
+				t.Errorf("expected %s to compress %d bytes below %d, got %d", e, len(body), len(body)/4, rr.Body.Len())
+			}
+		})
+	}
+}
+
+// statusRecorder records every status written, the informational ones included
+type statusRecorder struct {
+	*httptest.ResponseRecorder
+	statuses []int
+}
+
+func (r *statusRecorder) WriteHeader(httpStatus int) {
+	r.statuses = append(r.statuses, httpStatus)
+	if httpStatus >= 200 {
+		r.ResponseRecorder.WriteHeader(httpStatus)
+	}
+}
+
+// statusEarlyHints is http.StatusEarlyHints, missing before Go 1.13
+const statusEarlyHints = 103
+
+func TestCompressEarlyHints(t *testing.T) {
+	body := strings.Repeat(`{"label":"test"}`, 200)
+	handler := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Link", "</style.css>; rel=preload; as=style")
+		w.WriteHeader(statusEarlyHints)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		_, _ = w.Write([]byte(body))
+	})
+	rr := &statusRecorder{ResponseRecorder: httptest.NewRecorder()}
+	r, _ := http.NewRequest("GET", ``, nil)
+	r.Header.Set("Accept-Encoding", "gzip")
+
+	Compress(nil)(handler).ServeHTTP(rr, r)
+
+	if len(rr.statuses) != 2 || rr.statuses[0] != statusEarlyHints || rr.statuses[1] != http.StatusCreated {
+		t.Fatalf("expected the statuses 103 then 201, got %v", rr.statuses)
+	}
+	if got := rr.Header().Get("Content-Encoding"); got != "gzip" {
+		t.Errorf("expected Content-Encoding gzip, got %q", got)
+	}
+}
+
+func TestCompressGzipRequest(t *testing.T) {
+	var gzBody bytes.Buffer
+	zw := gzip.NewWriter(&gzBody)
+	_, _ = zw.Write([]byte(`{"label":"test"}`))
+	_ = zw.Close()
+
+	tc := []struct {
+		name            string
+		contentEncoding string
+		body            []byte
+		expectedStatus  int
+		expectedBody    string
+	}{
+		{
+			name:            "gzip request body",
+			contentEncoding: "gzip",
+			body:            gzBody.Bytes(),
+			expectedStatus:  http.StatusOK,
+			expectedBody:    `{"label":"test"}`,
+		},
+		{
+			name:            "plain request body",
+			contentEncoding: "",
+			body:            []byte(`{"label":"test"}`),
+			expectedStatus:  http.StatusOK,
+			expectedBody:    `{"label":"test"}`,
+		},
+		{
+			name:            "invalid gzip request body",
+			contentEncoding: "gzip",
+			body:            []byte(`{"label":"test"}`),
+			expectedStatus:  http.StatusBadRequest,
+		},
+		{
+			name:            "unsupported request encoding",
+			contentEncoding: "compress",
+			body:            []byte(`{"label":"test"}`),
+			expectedStatus:  http.StatusUnsupportedMediaType,
+		},
+	}
+
+	for _, tt := range tc {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []byte
+			handler := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+				got, _ = ioutil.ReadAll(req.Body)
+			})
+			rr := httptest.NewRecorder()
+			r, _ := http.NewRequest("POST", ``, bytes.NewReader(tt.body))
+			r.Header.Set("Content-Encoding", tt.contentEncoding)
+
+			Compress(nil)(handler).ServeHTTP(rr, r)
+
+			if rr.Code != tt.expectedStatus {
+				t.Errorf("expected status %d, got %d", tt.expectedStatus, rr.Code)
+				return
+			}
+			if tt.expectedStatus == http.StatusOK && string(got) != tt.expectedBody {
+				t.Errorf("expected request body %s, got %s", tt.expectedBody, got)
+			}
+		})
+	}
+}
+
+func TestCompressLoggedLengths(t *testing.T) {
+	body := strings.Repeat(`{"label":"test"}`, 200)
+	handler := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(body))
+	})
+
+	logger, hook := test.NewNullLogger()
+	rr := httptest.NewRecorder()
+	r, _ := http.NewRequest("GET", ``, nil)
+	r.Header.Set("Accept-Encoding", "gzip")
+
+	Logger(logger)(Compress(nil)(handler)).ServeHTTP(rr, r)
+
+	if hook.LastEntry().Data["resp_length"] != rr.Body.Len() {
+		t.Errorf("expected compressed length %d, got %v", rr.Body.Len(), hook.LastEntry().Data["resp_length"])
+	}
+	if hook.LastEntry().Data["resp_uncompressed_length"] != len(body) {
+		t.Errorf("expected uncompressed length %d, got %v", len(body), hook.LastEntry().Data["resp_uncompressed_length"])
+	}
+}
+
+func BenchmarkCompress(b *testing.B) {
+	body := []byte(strings.Repeat(`{"label":"test"}`, 200))
+	handler := Compress(nil)(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write(body)
+	}))
+	r, _ := http.NewRequest("GET", ``, nil)
+	r.Header.Set("Accept-Encoding", "gzip")
+
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		handler.ServeHTTP(httptest.NewRecorder(), r)
+	}
+}
@@ -0,0 +1,259 @@
+package mid
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Default route classes, requests are in ConcurrencyRead or ConcurrencyWrite depending on their method
+const (
+	ConcurrencyRead  = "read"
+	ConcurrencyWrite = "write"
+)
+
+// congestionWindow is how long a class is considered congested after its limit decreased or it shed requests
+const congestionWindow = time.Second
+
+// ConcurrencyClass is the configuration of the in-flight requests limit of a route class.
+// The limit is adaptive (AIMD): it grows by one when requests complete within Target
+// while the class is busy, and is multiplied by Backoff when they don't or time out.
+type ConcurrencyClass struct {
+	// Priority orders the classes, while a class is congested,
+	// the classes of lower priority are limited to their Min
+	Priority int
+	Initial  int
+	Min      int
+	Max      int
+	// Target is the latency above which the limit decreases
+	Target time.Duration
+	// Backoff is the decrease factor of the limit, 0.9 if 0
+	Backoff float64
+}
+
+// ConcurrencyConf is the configuration of a ConcurrencyLimiter
+type ConcurrencyConf struct {
+	// Classes are keyed by name, requests of a class not listed here are not limited
+	Classes map[string]ConcurrencyClass
+	// Routes sets the class of routes, keyed by route pattern ("/v1/resourceone")
+	// or method and route pattern ("POST /v1/resourceone"), case insensitive.
+	// Other routes are in ConcurrencyRead or ConcurrencyWrite.
+	Routes map[string]string
+	// RetryAfter is sent with shed requests, 1s if 0
+	RetryAfter time.Duration
+}
+
+// DefaultConcurrencyConf returns a configuration prioritising reads over writes
+func DefaultConcurrencyConf() *ConcurrencyConf {
+	return &ConcurrencyConf{
+		Classes: map[string]ConcurrencyClass{
+			ConcurrencyRead:  {Priority: 1, Initial: 50, Min: 5, Max: 500, Target: 500 * time.Millisecond},
+			ConcurrencyWrite: {Priority: 0, Initial: 20, Min: 2, Max: 200, Target: 500 * time.Millisecond},
+		},
+		Routes:     map[string]string{},
+		RetryAfter: time.Second,
+	}
+}
+
+// ConcurrencyLimiter limits the in-flight requests of each route class
+type ConcurrencyLimiter struct {
+	routes     map[string]string
+	retryAfter time.Duration
+
+	mu      sync.Mutex
+	classes map[string]*classLimiter
+}
+
+type classLimiter struct {
+	ConcurrencyClass
+	limit     float64
+	inFlight  int
+	served    uint64
+	shed      uint64
+	latency   time.Duration
+	congested time.Time
+}
+
+// NewConcurrencyLimiter returns a ConcurrencyLimiter, DefaultConcurrencyConf is used if conf is nil
+func NewConcurrencyLimiter(conf *ConcurrencyConf) *ConcurrencyLimiter {
+	if conf == nil {
+		conf = DefaultConcurrencyConf()
+	}
+	l := &ConcurrencyLimiter{
+		routes:     make(map[string]string, len(conf.Routes)),
+		retryAfter: conf.RetryAfter,
+		classes:    make(map[string]*classLimiter, len(conf.Classes)),
+	}
+	if l.retryAfter <= 0 {
+		l.retryAfter = time.Second
+	}
+	for route, class := range conf.Routes {
+		l.routes[strings.ToLower(route)] = class
+	}
+	for name, c := range conf.Classes {
+		if c.Min < 1 {
+			c.Min = 1
+		}
+		if c.Max < c.Min {
+			c.Max = c.Min
+		}
+		if c.Initial < c.Min || c.Initial > c.Max {
+			c.Initial = c.Max
+		}
+		if c.Backoff <= 0 || c.Backoff >= 1 {
+			c.Backoff = 0.9
+		}
+		l.classes[name] = &classLimiter{ConcurrencyClass: c, limit: float64(c.Initial)}
+	}
+
+	return l
+}
+
+// class returns the class of the request
+func (l *ConcurrencyLimiter) class(r *http.Request) string {
+	if pattern := strings.ToLower(RoutePattern(r)); pattern != "" {
+		if class, ok := l.routes[strings.ToLower(r.Method)+" "+pattern]; ok {
+			return class
+		}
+		if class, ok := l.routes[pattern]; ok {
+			return class
+		}
+	}
+
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return ConcurrencyRead
+	}
+	return ConcurrencyWrite
+}
+
+// acquire returns false if the request must be shed
+func (l *ConcurrencyLimiter) acquire(name string, now time.Time) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	c := l.classes[name]
+	limit := int(c.limit)
+	for _, o := range l.classes {
+		if o.Priority > c.Priority && now.Sub(o.congested) < congestionWindow {
+			limit = c.Min
+			break
+		}
+	}
+
+	if c.inFlight >= limit {
+		c.shed++
+		c.congested = now
+		return false
+	}
+	c.inFlight++
+
+	return true
+}
+
+// release adapts the limit of the class to the latency of a completed request
+func (l *ConcurrencyLimiter) release(name string, latency time.Duration, timedOut bool, now time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	c := l.classes[name]
+	inFlight := c.inFlight
+	c.inFlight--
+	c.served++
+	if c.latency == 0 {
+		c.latency = latency
+	} else {
+		c.latency = (c.latency*9 + latency) / 10
+	}
+
+	switch {
+	case timedOut || latency > c.Target:
+		c.limit = math.Max(float64(c.Min), c.limit*c.Backoff)
+		c.congested = now
+	case inFlight*2 >= int(c.limit):
+		c.limit = math.Min(float64(c.Max), c.limit+1)
+	}
+}
+
+// ConcurrencyState is the state of the limiter of a class
+type ConcurrencyState struct {
+	Class     string  `json:"class"`
+	Priority  int     `json:"priority"`
+	Limit     int     `json:"limit"`
+	InFlight  int     `json:"inFlight"`
+	Served    uint64  `json:"served"`
+	Shed      uint64  `json:"shed"`
+	LatencyMS float64 `json:"latencyMs"`
+	Congested bool    `json:"congested"`
+}
+
+// State returns the state of every class, by decreasing priority
+func (l *ConcurrencyLimiter) State() []ConcurrencyState {
+	now := time.Now()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	states := make([]ConcurrencyState, 0, len(l.classes))
+	for name, c := range l.classes {
+		states = append(states, ConcurrencyState{
+			Class:     name,
+			Priority:  c.Priority,
+			Limit:     int(c.limit),
+			InFlight:  c.inFlight,
+			Served:    c.served,
+			Shed:      c.shed,
+			LatencyMS: float64(c.latency) / float64(time.Millisecond),
+			Congested: now.Sub(c.congested) < congestionWindow,
+		})
+	}
+	sort.Slice(states, func(i, j int) bool {
+		if states[i].Priority != states[j].Priority {
+			return states[i].Priority > states[j].Priority
+		}
+		return states[i].Class < states[j].Class
+	})
+
+	return states
+}
+
+// ServeHTTP renders the state of the limiter as JSON, for the admin routes
+func (l *ConcurrencyLimiter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(l.State())
+}
+
+// ConcurrencyLimit sheds the requests over the in-flight limit of their class with a 503 and Retry-After.
+// It must be used on a chi router, after Timeout so that timed out requests decrease the limit.
+func ConcurrencyLimit(l *ConcurrencyLimiter) func(http.Handler) http.Handler {
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			class := l.class(r)
+			if _, ok := l.classes[class]; !ok {
+				h.ServeHTTP(w, r)
+				return
+			}
+
+			start := time.Now()
+			if !l.acquire(class, start) {
+				w.Header().Set("Retry-After", strconv.Itoa(ceilSeconds(l.retryAfter)))
+				renderError(w, r, http.StatusServiceUnavailable, "Service overloaded.",
+					fmt.Errorf("ConcurrencyLimit: %s requests over the limit", class))
+				return
+			}
+
+			defer func() {
+				now := time.Now()
+				l.release(class, now.Sub(start), r.Context().Err() == context.DeadlineExceeded, now)
+			}()
+			h.ServeHTTP(w, r)
+		})
+	}
+}
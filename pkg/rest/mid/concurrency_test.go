@@ -0,0 +1,203 @@
+package mid
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi"
+)
+
+func TestConcurrencyLimit(t *testing.T) {
+	tc := []struct {
+		name           string
+		conf           *ConcurrencyConf
+		busy           string
+		method         string
+		path           string
+		expectedStatus int
+	}{
+		{
+			name: "under the limit",
+			conf: &ConcurrencyConf{Classes: map[string]ConcurrencyClass{
+				ConcurrencyRead: {Initial: 2, Min: 1, Max: 2, Target: time.Minute},
+			}},
+			busy:           "/v1/resource",
+			method:         http.MethodGet,
+			path:           "/v1/resource",
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name: "over the limit",
+			conf: &ConcurrencyConf{Classes: map[string]ConcurrencyClass{
+				ConcurrencyRead: {Initial: 1, Min: 1, Max: 1, Target: time.Minute},
+			}},
+			busy:           "/v1/resource",
+			method:         http.MethodGet,
+			path:           "/v1/resource",
+			expectedStatus: http.StatusServiceUnavailable,
+		},
+		{
+			name: "other class",
+			conf: &ConcurrencyConf{Classes: map[string]ConcurrencyClass{
+				ConcurrencyRead:  {Initial: 1, Min: 1, Max: 1, Target: time.Minute},
+				ConcurrencyWrite: {Initial: 1, Min: 1, Max: 1, Target: time.Minute},
+			}},
+			busy:           "/v1/resource",
+			method:         http.MethodPost,
+			path:           "/v1/resource",
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name: "route class",
+			conf: &ConcurrencyConf{
+				Classes: map[string]ConcurrencyClass{
+					"health":        {Initial: 1, Min: 1, Max: 1, Target: time.Minute},
+					ConcurrencyRead: {Initial: 1, Min: 1, Max: 1, Target: time.Minute},
+				},
+				Routes: map[string]string{"/health": "health"},
+			},
+			busy:           "/v1/resource",
+			method:         http.MethodGet,
+			path:           "/health",
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name: "unlimited class",
+			conf: &ConcurrencyConf{Classes: map[string]ConcurrencyClass{
+				ConcurrencyWrite: {Initial: 1, Min: 1, Max: 1, Target: time.Minute},
+			}},
+			busy:           "/v1/resource",
+			method:         http.MethodGet,
+			path:           "/v1/resource",
+			expectedStatus: http.StatusOK,
+		},
+	}
+
+	for _, tt := range tc {
+		t.Run(tt.name, func(t *testing.T) {
+			release := make(chan struct{})
+			started := make(chan struct{})
+			handler := func(w http.ResponseWriter, req *http.Request) {}
+
+			r := chi.NewRouter()
+			r.Use(ConcurrencyLimit(NewConcurrencyLimiter(tt.conf)))
+			r.Get("/busy", func(w http.ResponseWriter, req *http.Request) {
+				close(started)
+				<-release
+			})
+			r.Get("/v1/resource", handler)
+			r.Post("/v1/resource", handler)
+			r.Get("/health", handler)
+
+			// A request in flight on the busy route, in the read class
+			done := make(chan struct{})
+			go func() {
+				req, _ := http.NewRequest(http.MethodGet, "/busy", nil)
+				r.ServeHTTP(httptest.NewRecorder(), req)
+				close(done)
+			}()
+			<-started
+
+			rr := httptest.NewRecorder()
+			req, _ := http.NewRequest(tt.method, tt.path, nil)
+			r.ServeHTTP(rr, req)
+			close(release)
+			<-done
+
+			if rr.Code != tt.expectedStatus {
+				t.Errorf("expected status %d, got %d", tt.expectedStatus, rr.Code)
+				return
+			}
+			if rr.Code == http.StatusServiceUnavailable && rr.Header().Get("Retry-After") != "1" {
+				t.Errorf("expected Retry-After 1, got %q", rr.Header().Get("Retry-After"))
+			}
+		})
+	}
+}
+
+func TestConcurrencyLimiterAdapt(t *testing.T) {
+	l := NewConcurrencyLimiter(&ConcurrencyConf{Classes: map[string]ConcurrencyClass{
+		ConcurrencyRead: {Initial: 10, Min: 2, Max: 11, Target: 100 * time.Millisecond, Backoff: 0.5},
+	}})
+	now := time.Now()
+
+	tc := []struct {
+		name          string
+		inFlight      int
+		latency       time.Duration
+		timedOut      bool
+		expectedLimit int
+	}{
+		{name: "idle", inFlight: 1, latency: time.Millisecond, expectedLimit: 10},
+		{name: "busy", inFlight: 5, latency: time.Millisecond, expectedLimit: 11},
+		{name: "capped by max", inFlight: 10, latency: time.Millisecond, expectedLimit: 11},
+		{name: "slow", inFlight: 1, latency: time.Second, expectedLimit: 5},
+		{name: "timed out", inFlight: 1, latency: time.Millisecond, timedOut: true, expectedLimit: 2},
+		{name: "capped by min", inFlight: 1, latency: time.Second, expectedLimit: 2},
+	}
+
+	for _, tt := range tc {
+		t.Run(tt.name, func(t *testing.T) {
+			for i := 0; i < tt.inFlight; i++ {
+				l.classes[ConcurrencyRead].inFlight++
+			}
+			l.release(ConcurrencyRead, tt.latency, tt.timedOut, now)
+			l.classes[ConcurrencyRead].inFlight = 0
+
+			if limit := int(l.classes[ConcurrencyRead].limit); limit != tt.expectedLimit {
+				t.Errorf("expected a limit of %d, got %d", tt.expectedLimit, limit)
+			}
+		})
+	}
+}
+
+func TestConcurrencyLimiterPriority(t *testing.T) {
+	l := NewConcurrencyLimiter(&ConcurrencyConf{Classes: map[string]ConcurrencyClass{
+		ConcurrencyRead:  {Priority: 1, Initial: 10, Min: 1, Max: 10, Target: 100 * time.Millisecond},
+		ConcurrencyWrite: {Priority: 0, Initial: 10, Min: 1, Max: 10, Target: 100 * time.Millisecond},
+	}})
+	now := time.Now()
+
+	if !l.acquire(ConcurrencyWrite, now) || !l.acquire(ConcurrencyWrite, now) {
+		t.Errorf("expected writes to be allowed while reads are fine")
+	}
+
+	// Reads get slow, writes are limited to their minimum
+	l.acquire(ConcurrencyRead, now)
+	l.release(ConcurrencyRead, time.Second, false, now)
+
+	if l.acquire(ConcurrencyWrite, now) {
+		t.Errorf("expected writes to be shed while reads are congested")
+	}
+	if !l.acquire(ConcurrencyRead, now) {
+		t.Errorf("expected reads to be allowed")
+	}
+	if !l.acquire(ConcurrencyWrite, now.Add(congestionWindow)) {
+		t.Errorf("expected writes to be allowed once reads recovered")
+	}
+}
+
+func TestConcurrencyLimiterState(t *testing.T) {
+	l := NewConcurrencyLimiter(nil)
+	l.acquire(ConcurrencyWrite, time.Now())
+
+	rr := httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodGet, "/admin/concurrency", nil)
+	l.ServeHTTP(rr, req)
+
+	var states []ConcurrencyState
+	if err := json.NewDecoder(rr.Body).Decode(&states); err != nil {
+		t.Errorf("expected a JSON state, got %v", err)
+		return
+	}
+	if len(states) != 2 || states[0].Class != ConcurrencyRead || states[1].Class != ConcurrencyWrite {
+		t.Errorf("expected the classes by priority, got %+v", states)
+		return
+	}
+	if states[1].InFlight != 1 || states[1].Limit != 20 {
+		t.Errorf("expected one write in flight under a limit of 20, got %+v", states[1])
+	}
+}
@@ -0,0 +1,186 @@
+package mid
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrCORSAnyOriginCredentials is returned by CORSConf.Validate when credentials are allowed
+// for any origin, which would let any site make authenticated requests
+var ErrCORSAnyOriginCredentials = errors.New("credentials can't be allowed for any origin")
+
+// CORSConf is the configuration of the CORS middleware
+type CORSConf struct {
+	// AllowedOrigins are exact origins ("https://app.example.com"), patterns with
+	// one wildcard ("https://*.example.com") or "*" for any origin, case insensitive
+	AllowedOrigins []string
+	// AllowedMethods are the methods allowed by preflights, the methods of the route if empty
+	AllowedMethods []string
+	// AllowedHeaders are the request headers allowed by preflights, "*" for any
+	AllowedHeaders []string
+	// ExposedHeaders are the response headers the browser can read
+	ExposedHeaders []string
+	// AllowCredentials lets the browser send cookies and authorization headers
+	AllowCredentials bool
+	// MaxAge is how long browsers can cache preflights, not sent if 0
+	MaxAge time.Duration
+}
+
+// DefaultCORSConf returns a configuration allowing the headers used by the API, for no origin.
+// rest.New adds the request ID header when it isn't RequestIDHeader.
+func DefaultCORSConf() *CORSConf {
+	return &CORSConf{
+		AllowedHeaders: []string{"Accept", "Accept-Language", "Content-Language", "Content-Type",
+			"Authorization", "X-API-Key", "X-Tenant-ID", RequestIDHeader},
+		ExposedHeaders: []string{RequestIDHeader, "RateLimit-Limit", "RateLimit-Remaining",
+			"RateLimit-Reset", "RateLimit-Policy", "Retry-After"},
+		MaxAge: 10 * time.Minute,
+	}
+}
+
+// Validate refuses AllowCredentials with the "*" origin
+func (conf *CORSConf) Validate() error {
+	if !conf.AllowCredentials {
+		return nil
+	}
+	for _, allowed := range conf.AllowedOrigins {
+		if allowed == "*" {
+			return ErrCORSAnyOriginCredentials
+		}
+	}
+
+	return nil
+}
+
+// CORS adds the CORS headers to the requests of allowed origins and answers preflights,
+// which don't reach the handlers. It must be used on a chi router, before Options.
+// The configuration should be validated, "*" never gets credentials.
+func CORS(conf *CORSConf) func(http.Handler) http.Handler {
+	if conf == nil {
+		conf = DefaultCORSConf()
+	}
+	allowedHeaders := make(map[string]bool, len(conf.AllowedHeaders))
+	for _, header := range conf.AllowedHeaders {
+		allowedHeaders[http.CanonicalHeaderKey(header)] = true
+	}
+	exposed := strings.Join(conf.ExposedHeaders, ", ")
+
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			origin := r.Header.Get("Origin")
+			preflight := r.Method == http.MethodOptions &&
+				r.Header.Get("Access-Control-Request-Method") != ""
+
+			w.Header().Add("Vary", "Origin")
+			if preflight {
+				w.Header().Add("Vary", "Access-Control-Request-Method")
+				w.Header().Add("Vary", "Access-Control-Request-Headers")
+			}
+			if origin == "" {
+				h.ServeHTTP(w, r)
+				return
+			}
+
+			allowOrigin, allowed := conf.allowOrigin(origin)
+
+			if !preflight {
+				if allowed {
+					w.Header().Set("Access-Control-Allow-Origin", allowOrigin)
+					if conf.AllowCredentials && allowOrigin != "*" {
+						w.Header().Set("Access-Control-Allow-Credentials", "true")
+					}
+					if exposed != "" {
+						w.Header().Set("Access-Control-Expose-Headers", exposed)
+					}
+				}
+				h.ServeHTTP(w, r)
+				return
+			}
+
+			methods := conf.AllowedMethods
+			if len(methods) == 0 {
+				methods = AllowedMethods(r)
+				if len(methods) == 0 {
+					// let the router answer with its not found handler
+					h.ServeHTTP(w, r)
+					return
+				}
+			}
+
+			// Refused preflights get no CORS headers, the browser blocks the request
+			requested, headersAllowed := requestedHeaders(r, allowedHeaders)
+			if !allowed || !headersAllowed ||
+				!contains(methods, r.Header.Get("Access-Control-Request-Method")) {
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+
+			w.Header().Set("Access-Control-Allow-Origin", allowOrigin)
+			w.Header().Set("Access-Control-Allow-Methods", strings.Join(methods, ", "))
+			if len(requested) > 0 {
+				w.Header().Set("Access-Control-Allow-Headers", strings.Join(requested, ", "))
+			}
+			if conf.AllowCredentials && allowOrigin != "*" {
+				w.Header().Set("Access-Control-Allow-Credentials", "true")
+			}
+			if conf.MaxAge > 0 {
+				w.Header().Set("Access-Control-Max-Age", strconv.Itoa(int(conf.MaxAge.Seconds())))
+			}
+			w.WriteHeader(http.StatusNoContent)
+		})
+	}
+}
+
+// allowOrigin returns the Access-Control-Allow-Origin value for origin, if it is allowed
+func (conf *CORSConf) allowOrigin(origin string) (string, bool) {
+	o := strings.ToLower(origin)
+	for _, allowed := range conf.AllowedOrigins {
+		allowed = strings.ToLower(allowed)
+		if allowed == "*" {
+			return "*", true
+		}
+		if matchOrigin(allowed, o) {
+			return origin, true
+		}
+	}
+
+	return "", false
+}
+
+// matchOrigin matches origin with pattern, the wildcard of pattern matching
+// at least one character of the host, so it can't cover a scheme, port or path
+func matchOrigin(pattern, origin string) bool {
+	i := strings.IndexByte(pattern, '*')
+	if i < 0 {
+		return pattern == origin
+	}
+	prefix, suffix := pattern[:i], pattern[i+1:]
+	if len(origin) <= len(prefix)+len(suffix) ||
+		!strings.HasPrefix(origin, prefix) || !strings.HasSuffix(origin, suffix) {
+		return false
+	}
+
+	return !strings.ContainsAny(origin[len(prefix):len(origin)-len(suffix)], "/:")
+}
+
+// requestedHeaders returns the headers requested by a preflight, and if they are all allowed
+func requestedHeaders(r *http.Request, allowed map[string]bool) ([]string, bool) {
+	var headers []string
+	for _, value := range r.Header["Access-Control-Request-Headers"] {
+		for _, header := range strings.Split(value, ",") {
+			header = http.CanonicalHeaderKey(strings.TrimSpace(header))
+			if header == "" {
+				continue
+			}
+			if !allowed["*"] && !allowed[header] {
+				return nil, false
+			}
+			headers = append(headers, header)
+		}
+	}
+
+	return headers, true
+}
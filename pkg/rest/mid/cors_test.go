@@ -0,0 +1,256 @@
+package mid
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi"
+)
+
+func TestCORS(t *testing.T) {
+	conf := &CORSConf{
+		AllowedOrigins:   []string{"https://app.example.com", "https://*.preview.example.com"},
+		AllowedHeaders:   []string{"Content-Type", "Authorization"},
+		ExposedHeaders:   []string{"X-Request-ID"},
+		AllowCredentials: true,
+		MaxAge:           time.Hour,
+	}
+
+	tc := []struct {
+		name            string
+		conf            *CORSConf
+		method          string
+		path            string
+		headers         map[string]string
+		expectedStatus  int
+		expectedHeaders map[string]string
+		expectedHandled bool
+	}{
+		{
+			name:            "no origin",
+			conf:            conf,
+			method:          http.MethodGet,
+			path:            "/v1/resource",
+			expectedStatus:  http.StatusOK,
+			expectedHeaders: map[string]string{"Access-Control-Allow-Origin": ""},
+			expectedHandled: true,
+		},
+		{
+			name:           "allowed origin",
+			conf:           conf,
+			method:         http.MethodGet,
+			path:           "/v1/resource",
+			headers:        map[string]string{"Origin": "https://app.example.com"},
+			expectedStatus: http.StatusOK,
+			expectedHeaders: map[string]string{
+				"Access-Control-Allow-Origin":      "https://app.example.com",
+				"Access-Control-Allow-Credentials": "true",
+				"Access-Control-Expose-Headers":    "X-Request-ID",
+				"Vary":                             "Origin",
+			},
+			expectedHandled: true,
+		},
+		{
+			name:            "allowed origin pattern",
+			conf:            conf,
+			method:          http.MethodGet,
+			path:            "/v1/resource",
+			headers:         map[string]string{"Origin": "https://PR-12.preview.example.com"},
+			expectedStatus:  http.StatusOK,
+			expectedHeaders: map[string]string{"Access-Control-Allow-Origin": "https://PR-12.preview.example.com"},
+			expectedHandled: true,
+		},
+		{
+			name:            "pattern not matching",
+			conf:            conf,
+			method:          http.MethodGet,
+			path:            "/v1/resource",
+			headers:         map[string]string{"Origin": "https://evil.com/.preview.example.com"},
+			expectedStatus:  http.StatusOK,
+			expectedHeaders: map[string]string{"Access-Control-Allow-Origin": ""},
+			expectedHandled: true,
+		},
+		{
+			name:            "origin not allowed",
+			conf:            conf,
+			method:          http.MethodGet,
+			path:            "/v1/resource",
+			headers:         map[string]string{"Origin": "https://evil.com"},
+			expectedStatus:  http.StatusOK,
+			expectedHeaders: map[string]string{"Access-Control-Allow-Origin": ""},
+			expectedHandled: true,
+		},
+		{
+			name:            "any origin",
+			conf:            &CORSConf{AllowedOrigins: []string{"*"}},
+			method:          http.MethodGet,
+			path:            "/v1/resource",
+			headers:         map[string]string{"Origin": "https://evil.com"},
+			expectedStatus:  http.StatusOK,
+			expectedHeaders: map[string]string{"Access-Control-Allow-Origin": "*"},
+			expectedHandled: true,
+		},
+		{
+			name:            "any origin never gets credentials",
+			conf:            &CORSConf{AllowedOrigins: []string{"*"}, AllowCredentials: true},
+			method:          http.MethodGet,
+			path:            "/v1/resource",
+			headers:         map[string]string{"Origin": "https://evil.com"},
+			expectedStatus:  http.StatusOK,
+			expectedHeaders: map[string]string{"Access-Control-Allow-Origin": "*", "Access-Control-Allow-Credentials": ""},
+			expectedHandled: true,
+		},
+		{
+			name:   "preflight",
+			conf:   conf,
+			method: http.MethodOptions,
+			path:   "/v1/resource",
+			headers: map[string]string{
+				"Origin":                         "https://app.example.com",
+				"Access-Control-Request-Method":  "POST",
+				"Access-Control-Request-Headers": "content-type, authorization",
+			},
+			expectedStatus: http.StatusNoContent,
+			expectedHeaders: map[string]string{
+				"Access-Control-Allow-Origin":      "https://app.example.com",
+				"Access-Control-Allow-Methods":     "GET, HEAD, POST, OPTIONS",
+				"Access-Control-Allow-Headers":     "Content-Type, Authorization",
+				"Access-Control-Allow-Credentials": "true",
+				"Access-Control-Max-Age":           "3600",
+			},
+		},
+		{
+			name:   "preflight with configured methods",
+			conf:   &CORSConf{AllowedOrigins: []string{"*"}, AllowedMethods: []string{"GET"}},
+			method: http.MethodOptions,
+			path:   "/v1/resource",
+			headers: map[string]string{
+				"Origin":                        "https://app.example.com",
+				"Access-Control-Request-Method": "GET",
+			},
+			expectedStatus: http.StatusNoContent,
+			expectedHeaders: map[string]string{
+				"Access-Control-Allow-Origin":  "*",
+				"Access-Control-Allow-Methods": "GET",
+			},
+		},
+		{
+			name:   "preflight of a method not allowed",
+			conf:   conf,
+			method: http.MethodOptions,
+			path:   "/v1/resource",
+			headers: map[string]string{
+				"Origin":                        "https://app.example.com",
+				"Access-Control-Request-Method": "DELETE",
+			},
+			expectedStatus:  http.StatusNoContent,
+			expectedHeaders: map[string]string{"Access-Control-Allow-Origin": ""},
+		},
+		{
+			name:   "preflight of a header not allowed",
+			conf:   conf,
+			method: http.MethodOptions,
+			path:   "/v1/resource",
+			headers: map[string]string{
+				"Origin":                         "https://app.example.com",
+				"Access-Control-Request-Method":  "POST",
+				"Access-Control-Request-Headers": "X-Custom",
+			},
+			expectedStatus:  http.StatusNoContent,
+			expectedHeaders: map[string]string{"Access-Control-Allow-Origin": ""},
+		},
+		{
+			name:   "preflight of an origin not allowed",
+			conf:   conf,
+			method: http.MethodOptions,
+			path:   "/v1/resource",
+			headers: map[string]string{
+				"Origin":                        "https://evil.com",
+				"Access-Control-Request-Method": "GET",
+			},
+			expectedStatus:  http.StatusNoContent,
+			expectedHeaders: map[string]string{"Access-Control-Allow-Origin": ""},
+		},
+		{
+			name:   "preflight of an unknown path",
+			conf:   conf,
+			method: http.MethodOptions,
+			path:   "/v1/unknown",
+			headers: map[string]string{
+				"Origin":                        "https://app.example.com",
+				"Access-Control-Request-Method": "GET",
+			},
+			expectedStatus: http.StatusNotFound,
+		},
+		{
+			name:            "plain options",
+			conf:            conf,
+			method:          http.MethodOptions,
+			path:            "/v1/resource",
+			headers:         map[string]string{"Origin": "https://app.example.com"},
+			expectedStatus:  http.StatusNoContent,
+			expectedHeaders: map[string]string{"Allow": "GET, HEAD, POST, OPTIONS"},
+		},
+	}
+
+	for _, tt := range tc {
+		t.Run(tt.name, func(t *testing.T) {
+			handled := false
+			handler := func(w http.ResponseWriter, req *http.Request) { handled = true }
+
+			r := chi.NewRouter()
+			r.Use(CORS(tt.conf))
+			r.Use(Options())
+			r.Route("/v1", func(r chi.Router) {
+				r.Get("/resource", handler)
+				r.Post("/resource", handler)
+			})
+
+			rr := httptest.NewRecorder()
+			req, _ := http.NewRequest(tt.method, tt.path, nil)
+			for k, v := range tt.headers {
+				req.Header.Set(k, v)
+			}
+			r.ServeHTTP(rr, req)
+
+			if rr.Code != tt.expectedStatus {
+				t.Errorf("expected status %d, got %d", tt.expectedStatus, rr.Code)
+				return
+			}
+			for k, v := range tt.expectedHeaders {
+				if rr.Header().Get(k) != v {
+					t.Errorf("expected %s %q, got %q", k, v, rr.Header().Get(k))
+				}
+			}
+			if handled != tt.expectedHandled {
+				t.Errorf("expected the handler to be called %t, got %t", tt.expectedHandled, handled)
+			}
+		})
+	}
+}
+
+func TestCORSConfValidate(t *testing.T) {
+	tc := []struct {
+		name          string
+		conf          *CORSConf
+		expectedError error
+	}{
+		{name: "origins with credentials", conf: &CORSConf{AllowedOrigins: []string{"https://app.example.com"}, AllowCredentials: true}},
+		{name: "any origin", conf: &CORSConf{AllowedOrigins: []string{"*"}}},
+		{
+			name:          "any origin with credentials",
+			conf:          &CORSConf{AllowedOrigins: []string{"https://app.example.com", "*"}, AllowCredentials: true},
+			expectedError: ErrCORSAnyOriginCredentials,
+		},
+	}
+
+	for _, tt := range tc {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.conf.Validate(); err != tt.expectedError {
+				t.Errorf("expected error %v, got %v", tt.expectedError, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,36 @@
+package mid
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+
+	"github.com/vincentserpoul/gorestarter/pkg/tracing"
+)
+
+// errorResponse has the same shape as renderer.ErrResponse,
+// which can't be used from here without an import cycle
+type errorResponse struct {
+	StatusText string `json:"status"`
+	ErrorText  string `json:"error,omitempty"`
+	TraceID    string `json:"traceId,omitempty"`
+}
+
+// renderError writes a JSON error and puts err in the context, so it can be picked up by logging
+func renderError(w http.ResponseWriter, r *http.Request, httpStatus int, statusText string, err error) {
+	if err != nil {
+		*r = *r.WithContext(context.WithValue(r.Context(), ErrRequestContextKey, err))
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(httpStatus)
+	_ = json.NewEncoder(w).Encode(errorResponse{StatusText: statusText, TraceID: traceID(r)})
+}
+
+// traceID returns the trace ID of the request, "" if it isn't traced
+func traceID(r *http.Request) string {
+	if sc := tracing.SpanContextFromContext(r.Context()); sc.IsValid() {
+		return sc.TraceID.String()
+	}
+	return ""
+}
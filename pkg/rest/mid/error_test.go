@@ -0,0 +1,33 @@
+package mid
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRenderError(t *testing.T) {
+	errTest := errors.New("test error")
+	rr := httptest.NewRecorder()
+	r, _ := http.NewRequest("GET", ``, nil)
+
+	renderError(rr, r, http.StatusTeapot, "Teapot.", errTest)
+
+	if rr.Code != http.StatusTeapot {
+		t.Errorf("expected status %d, got %d", http.StatusTeapot, rr.Code)
+	}
+	if rr.Header().Get("Content-Type") != "application/json" {
+		t.Errorf("expected JSON content type, got %s", rr.Header().Get("Content-Type"))
+	}
+
+	e := errorResponse{}
+	if err := json.NewDecoder(rr.Body).Decode(&e); err != nil || e.StatusText != "Teapot." {
+		t.Errorf("expected status text Teapot., got %s (%v)", e.StatusText, err)
+	}
+
+	if err, ok := r.Context().Value(ErrRequestContextKey).(error); !ok || err != errTest {
+		t.Errorf("expected error in request context, got %v", err)
+	}
+}
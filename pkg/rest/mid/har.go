@@ -0,0 +1,186 @@
+package mid
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"sort"
+	"time"
+)
+
+// The HAR 1.2 format, see http://www.softwareishard.com/blog/har-12-spec/
+type har struct {
+	Log harLog `json:"log"`
+}
+
+type harLog struct {
+	Version string     `json:"version"`
+	Creator harCreator `json:"creator"`
+	Entries []harEntry `json:"entries"`
+}
+
+type harCreator struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+type harEntry struct {
+	StartedDateTime string      `json:"startedDateTime"`
+	Time            float64     `json:"time"`
+	Request         harRequest  `json:"request"`
+	Response        harResponse `json:"response"`
+	Cache           struct{}    `json:"cache"`
+	Timings         harTimings  `json:"timings"`
+}
+
+type harRequest struct {
+	Method      string         `json:"method"`
+	URL         string         `json:"url"`
+	HTTPVersion string         `json:"httpVersion"`
+	Cookies     []harNameValue `json:"cookies"`
+	Headers     []harNameValue `json:"headers"`
+	QueryString []harNameValue `json:"queryString"`
+	PostData    *harPostData   `json:"postData,omitempty"`
+	HeadersSize int            `json:"headersSize"`
+	BodySize    int            `json:"bodySize"`
+}
+
+type harPostData struct {
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text"`
+}
+
+type harResponse struct {
+	Status      int            `json:"status"`
+	StatusText  string         `json:"statusText"`
+	HTTPVersion string         `json:"httpVersion"`
+	Cookies     []harNameValue `json:"cookies"`
+	Headers     []harNameValue `json:"headers"`
+	Content     harContent     `json:"content"`
+	RedirectURL string         `json:"redirectURL"`
+	HeadersSize int            `json:"headersSize"`
+	BodySize    int            `json:"bodySize"`
+}
+
+type harContent struct {
+	Size     int    `json:"size"`
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text,omitempty"`
+}
+
+type harTimings struct {
+	Send    float64 `json:"send"`
+	Wait    float64 `json:"wait"`
+	Receive float64 `json:"receive"`
+}
+
+type harNameValue struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// ServeHTTP serves the exchanges kept as a HAR file, which can be replayed
+// by the browsers and most HTTP tools. A caller bound to a tenant only gets
+// the exchanges of its tenant.
+func (c *Capturer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	tenant := GetTenant(r.Context())
+	if p := GetPrincipal(r.Context()); p != nil {
+		tenant = p.Tenant
+	}
+
+	h := har{Log: harLog{
+		Version: "1.2",
+		Creator: harCreator{Name: "gorestarter", Version: "1.0"},
+		Entries: []harEntry{},
+	}}
+	for _, e := range c.Exchanges() {
+		if tenant != "" && e.Tenant != tenant {
+			continue
+		}
+		h.Log.Entries = append(h.Log.Entries, harEntryOf(e))
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Disposition", `attachment; filename="captures.har"`)
+	_ = json.NewEncoder(w).Encode(h)
+}
+
+func harEntryOf(e *Exchange) harEntry {
+	ms := float64(e.Duration) / float64(time.Millisecond)
+	entry := harEntry{
+		StartedDateTime: e.Started.Format(time.RFC3339Nano),
+		Time:            ms,
+		Request: harRequest{
+			Method:      e.Method,
+			URL:         e.URL,
+			HTTPVersion: e.Proto,
+			Cookies:     []harNameValue{},
+			Headers:     harHeaders(e.RequestHeader),
+			QueryString: harQuery(e.URL),
+			HeadersSize: -1,
+			BodySize:    len(e.RequestBody),
+		},
+		Response: harResponse{
+			Status:      e.Status,
+			StatusText:  http.StatusText(e.Status),
+			HTTPVersion: e.Proto,
+			Cookies:     []harNameValue{},
+			Headers:     harHeaders(e.ResponseHeader),
+			Content: harContent{
+				Size:     e.ResponseSize,
+				MimeType: e.ResponseHeader.Get("Content-Type"),
+				Text:     e.ResponseBody,
+			},
+			HeadersSize: -1,
+			BodySize:    e.ResponseSize,
+		},
+		Timings: harTimings{Send: 0, Wait: ms, Receive: 0},
+	}
+	if e.RequestBody != "" {
+		entry.Request.PostData = &harPostData{
+			MimeType: e.RequestHeader.Get("Content-Type"),
+			Text:     e.RequestBody,
+		}
+	}
+
+	return entry
+}
+
+// harHeaders lists the headers, sorted by name
+func harHeaders(header http.Header) []harNameValue {
+	names := make([]string, 0, len(header))
+	for name := range header {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	headers := []harNameValue{}
+	for _, name := range names {
+		for _, v := range header[name] {
+			headers = append(headers, harNameValue{Name: name, Value: v})
+		}
+	}
+	return headers
+}
+
+// harQuery lists the query parameters of the URL, sorted by name
+func harQuery(rawURL string) []harNameValue {
+	params := []harNameValue{}
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return params
+	}
+
+	q := u.Query()
+	names := make([]string, 0, len(q))
+	for name := range q {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		for _, v := range q[name] {
+			params = append(params, harNameValue{Name: name, Value: v})
+		}
+	}
+	return params
+}
@@ -0,0 +1,285 @@
+package mid
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// KeySource gives the key verifying a token signed with alg,
+// kid is the key ID of the token header and can be empty
+type KeySource interface {
+	Key(ctx context.Context, kid, alg string) (interface{}, error)
+}
+
+// StaticKeys are keys set from the configuration
+type StaticKeys struct {
+	// HMACSecret verifies HS256 tokens
+	HMACSecret []byte
+	// PublicKeys verify RS256 and ES256 tokens, by key ID
+	PublicKeys map[string]interface{}
+}
+
+// NewStaticKeys builds StaticKeys from a secret and PEM encoded public keys by key ID
+func NewStaticKeys(hmacSecret string, pemKeys map[string]string) (*StaticKeys, error) {
+	keys := &StaticKeys{PublicKeys: make(map[string]interface{}, len(pemKeys))}
+	if hmacSecret != "" {
+		keys.HMACSecret = []byte(hmacSecret)
+	}
+	for kid, p := range pemKeys {
+		block, _ := pem.Decode([]byte(p))
+		if block == nil {
+			return nil, fmt.Errorf("NewStaticKeys(%s): no PEM data found", kid)
+		}
+		key, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("NewStaticKeys(%s): %v", kid, err)
+		}
+		keys.PublicKeys[kid] = key
+	}
+
+	return keys, nil
+}
+
+// Key implements KeySource
+func (s *StaticKeys) Key(ctx context.Context, kid, alg string) (interface{}, error) {
+	if alg == "HS256" {
+		if s.HMACSecret == nil {
+			return nil, fmt.Errorf("no key for %s", alg)
+		}
+		return s.HMACSecret, nil
+	}
+
+	return lookupKey(s.PublicKeys, kid)
+}
+
+// maxJWKSSize is the size above which a key set fetched from an URL is refused
+const maxJWKSSize = 1 << 20
+
+// JWKS is a JSON Web Key Set read from a file or an URL.
+// The keys are cached for TTL, and fetched again when a token uses an unknown key ID,
+// so that keys can be rotated. If fetching fails, the previous keys are kept.
+// One fetch runs at a time, the requests needing it wait for it until their context is done.
+type JWKS struct {
+	// Source is the URL (http:// or https://) or the file path of the key set
+	Source string
+	// TTL is how long the keys are cached
+	TTL time.Duration
+	// MinRefresh limits how often an unknown key ID triggers a fetch
+	MinRefresh time.Duration
+	// Client fetches URL key sets
+	Client *http.Client
+
+	mu        sync.RWMutex
+	keys      map[string]interface{}
+	fetchedAt time.Time
+	triedAt   time.Time
+	// fetching is closed when the running fetch is done, nil if none runs
+	fetching chan struct{}
+}
+
+// NewJWKS returns a JWKS reading source, cached for ttl
+func NewJWKS(source string, ttl time.Duration) *JWKS {
+	return &JWKS{
+		Source:     source,
+		TTL:        ttl,
+		MinRefresh: 10 * time.Second,
+		Client:     &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// Key implements KeySource
+func (j *JWKS) Key(ctx context.Context, kid, alg string) (interface{}, error) {
+	j.mu.RLock()
+	keys, fetchedAt, triedAt := j.keys, j.fetchedAt, j.triedAt
+	j.mu.RUnlock()
+
+	now := time.Now()
+	_, known := keys[kid]
+	stale := keys == nil || now.Sub(fetchedAt) > j.TTL
+	if (stale || (!known && kid != "")) && now.Sub(triedAt) >= j.MinRefresh {
+		keys = j.refresh(ctx, now)
+	}
+
+	return lookupKey(keys, kid)
+}
+
+// refresh fetches the key set, or waits for the running fetch,
+// returning the keys in use afterwards
+func (j *JWKS) refresh(ctx context.Context, now time.Time) map[string]interface{} {
+	j.mu.Lock()
+	done := j.fetching
+	if done == nil {
+		// Another request may have refreshed the keys meanwhile
+		if now.Sub(j.triedAt) < j.MinRefresh {
+			keys := j.keys
+			j.mu.Unlock()
+			return keys
+		}
+		j.triedAt = now
+		done = make(chan struct{})
+		j.fetching = done
+		// The fetch is shared, it must not be cancelled with the request
+		go j.fetchKeys(detachRequestID(ctx), done, now)
+	}
+	j.mu.Unlock()
+
+	select {
+	case <-done:
+	case <-ctx.Done():
+	}
+
+	j.mu.RLock()
+	defer j.mu.RUnlock()
+	return j.keys
+}
+
+// fetchKeys fetches the key set, keeping the previous keys on error, then closes done
+func (j *JWKS) fetchKeys(ctx context.Context, done chan struct{}, now time.Time) {
+	keys, err := j.fetch(ctx)
+
+	j.mu.Lock()
+	if err == nil {
+		j.keys, j.fetchedAt = keys, now
+	}
+	j.fetching = nil
+	j.mu.Unlock()
+	close(done)
+}
+
+func (j *JWKS) fetch(ctx context.Context) (map[string]interface{}, error) {
+	var data []byte
+	if strings.HasPrefix(j.Source, "http://") || strings.HasPrefix(j.Source, "https://") {
+		req, err := http.NewRequest(http.MethodGet, j.Source, nil)
+		if err != nil {
+			return nil, fmt.Errorf("JWKS.fetch(%s): %v", j.Source, err)
+		}
+		resp, errD := j.Client.Do(req.WithContext(ctx))
+		if errD != nil {
+			return nil, fmt.Errorf("JWKS.fetch(%s): %v", j.Source, errD)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("JWKS.fetch(%s): status %d", j.Source, resp.StatusCode)
+		}
+		if data, err = ioutil.ReadAll(io.LimitReader(resp.Body, maxJWKSSize+1)); err != nil {
+			return nil, fmt.Errorf("JWKS.fetch(%s): %v", j.Source, err)
+		}
+		if len(data) > maxJWKSSize {
+			return nil, fmt.Errorf("JWKS.fetch(%s): more than %d bytes", j.Source, maxJWKSSize)
+		}
+	} else {
+		var err error
+		if data, err = ioutil.ReadFile(j.Source); err != nil {
+			return nil, fmt.Errorf("JWKS.fetch(%s): %v", j.Source, err)
+		}
+	}
+
+	keys, err := ParseJWKS(data)
+	if err != nil {
+		return nil, fmt.Errorf("JWKS.fetch(%s): %v", j.Source, err)
+	}
+
+	return keys, nil
+}
+
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+	K   string `json:"k"`
+}
+
+// ParseJWKS parses a JSON Web Key Set, returning its signature keys by key ID.
+// RSA, EC P-256 and symmetric (oct) keys are supported, others are skipped,
+// as are the invalid keys, so that one bad key doesn't discard the whole set.
+func ParseJWKS(data []byte) (map[string]interface{}, error) {
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, fmt.Errorf("ParseJWKS: %v", err)
+	}
+
+	keys := make(map[string]interface{}, len(set.Keys))
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		if key, err := k.key(); err == nil && key != nil {
+			keys[k.Kid] = key
+		}
+	}
+
+	return keys, nil
+}
+
+func (k jwk) key() (interface{}, error) {
+	switch k.Kty {
+	case "RSA":
+		n, errN := decodeBigInt(k.N)
+		e, errE := decodeBigInt(k.E)
+		if errN != nil || errE != nil || !e.IsInt64() {
+			return nil, fmt.Errorf("invalid RSA key")
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		if k.Crv != "P-256" {
+			return nil, nil
+		}
+		x, errX := decodeBigInt(k.X)
+		y, errY := decodeBigInt(k.Y)
+		if errX != nil || errY != nil || !elliptic.P256().IsOnCurve(x, y) {
+			return nil, fmt.Errorf("invalid EC key")
+		}
+		return &ecdsa.PublicKey{Curve: elliptic.P256(), X: x, Y: y}, nil
+	case "oct":
+		secret, err := base64.RawURLEncoding.DecodeString(k.K)
+		if err != nil {
+			return nil, fmt.Errorf("invalid oct key")
+		}
+		return secret, nil
+	}
+
+	return nil, nil
+}
+
+func decodeBigInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil || len(b) == 0 {
+		return nil, fmt.Errorf("invalid integer")
+	}
+	return new(big.Int).SetBytes(b), nil
+}
+
+// lookupKey returns the key kid, or the only key if the token has no kid
+func lookupKey(keys map[string]interface{}, kid string) (interface{}, error) {
+	if key, ok := keys[kid]; ok {
+		return key, nil
+	}
+	if kid == "" && len(keys) == 1 {
+		for _, key := range keys {
+			return key, nil
+		}
+	}
+
+	return nil, fmt.Errorf("unknown key %q", kid)
+}
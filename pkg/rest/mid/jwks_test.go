@@ -0,0 +1,283 @@
+package mid
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func b64(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func rsaJWK(kid string, k *rsa.PublicKey) map[string]string {
+	return map[string]string{
+		"kty": "RSA", "kid": kid, "use": "sig",
+		"n": b64(k.N.Bytes()), "e": b64(big.NewInt(int64(k.E)).Bytes()),
+	}
+}
+
+func ecJWK(kid string, k *ecdsa.PublicKey) map[string]string {
+	return map[string]string{
+		"kty": "EC", "kid": kid, "crv": "P-256",
+		"x": b64(k.X.Bytes()), "y": b64(k.Y.Bytes()),
+	}
+}
+
+func jwksJSON(keys ...map[string]string) []byte {
+	b, _ := json.Marshal(map[string]interface{}{"keys": keys})
+	return b
+}
+
+func TestParseJWKS(t *testing.T) {
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+
+	tc := []struct {
+		name          string
+		data          []byte
+		expectedKeys  []string
+		expectedError bool
+	}{
+		{
+			name: "all key types",
+			data: jwksJSON(
+				rsaJWK("rsa", &rsaKey.PublicKey),
+				ecJWK("ec", &ecKey.PublicKey),
+				map[string]string{"kty": "oct", "kid": "hmac", "k": b64([]byte("secret"))},
+			),
+			expectedKeys: []string{"rsa", "ec", "hmac"},
+		},
+		{
+			name: "encryption keys and unknown types skipped",
+			data: jwksJSON(
+				map[string]string{"kty": "RSA", "kid": "enc", "use": "enc"},
+				map[string]string{"kty": "OKP", "kid": "okp"},
+			),
+			expectedKeys: []string{},
+		},
+		{
+			name: "invalid key skipped",
+			data: jwksJSON(
+				map[string]string{"kty": "EC", "kid": "ec", "crv": "P-256", "x": b64([]byte{1}), "y": b64([]byte{2})},
+				rsaJWK("rsa", &rsaKey.PublicKey),
+			),
+			expectedKeys: []string{"rsa"},
+		},
+		{
+			name:          "invalid JSON",
+			data:          []byte("{"),
+			expectedError: true,
+		},
+	}
+
+	for _, tt := range tc {
+		t.Run(tt.name, func(t *testing.T) {
+			keys, err := ParseJWKS(tt.data)
+			if (err != nil) != tt.expectedError {
+				t.Errorf("expected error %t, got %v", tt.expectedError, err)
+				return
+			}
+			if tt.expectedError {
+				return
+			}
+			if len(keys) != len(tt.expectedKeys) {
+				t.Errorf("expected %d keys, got %d", len(tt.expectedKeys), len(keys))
+			}
+			for _, kid := range tt.expectedKeys {
+				if _, ok := keys[kid]; !ok {
+					t.Errorf("expected key %s", kid)
+				}
+			}
+		})
+	}
+}
+
+func TestJWKSRotation(t *testing.T) {
+	oldKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	newKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+
+	var fetches int32
+	var rotated int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&fetches, 1)
+		if atomic.LoadInt32(&rotated) == 1 {
+			_, _ = w.Write(jwksJSON(rsaJWK("new", &newKey.PublicKey)))
+			return
+		}
+		_, _ = w.Write(jwksJSON(rsaJWK("old", &oldKey.PublicKey)))
+	}))
+	defer srv.Close()
+
+	jwks := NewJWKS(srv.URL, time.Hour)
+	jwks.MinRefresh = 0
+	ctx := context.Background()
+
+	if _, err := jwks.Key(ctx, "old", "RS256"); err != nil {
+		t.Fatalf("expected the old key, got %v", err)
+	}
+	if _, err := jwks.Key(ctx, "old", "RS256"); err != nil || atomic.LoadInt32(&fetches) != 1 {
+		t.Errorf("expected the key set to be cached, %d fetches", fetches)
+	}
+
+	atomic.StoreInt32(&rotated, 1)
+	if _, err := jwks.Key(ctx, "new", "RS256"); err != nil {
+		t.Errorf("expected the unknown kid to fetch the rotated key, got %v", err)
+	}
+
+	// Fetch failures keep the current keys
+	srv.Close()
+	jwks.fetchedAt = time.Time{}
+	if _, err := jwks.Key(ctx, "new", "RS256"); err != nil {
+		t.Errorf("expected the cached key to be kept, got %v", err)
+	}
+}
+
+func TestJWKSTooLarge(t *testing.T) {
+	key, _ := rsa.GenerateKey(rand.Reader, 2048)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write(jwksJSON(rsaJWK("kid", &key.PublicKey)))
+		_, _ = w.Write(bytes.Repeat([]byte(" "), maxJWKSSize))
+	}))
+	defer srv.Close()
+
+	if _, err := NewJWKS(srv.URL, time.Hour).Key(context.Background(), "kid", "RS256"); err == nil {
+		t.Errorf("expected a key set over %d bytes to be refused", maxJWKSSize)
+	}
+}
+
+func TestJWKSSingleFetch(t *testing.T) {
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+
+	var fetches int32
+	requestID := make(chan string, 1)
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&fetches, 1) == 1 {
+			requestID <- r.Header.Get(RequestIDHeader)
+		}
+		<-release
+		_, _ = w.Write(jwksJSON(rsaJWK("rsa", &rsaKey.PublicKey)))
+	}))
+	defer srv.Close()
+
+	jwks := NewJWKS(srv.URL, time.Hour)
+	jwks.MinRefresh = 0
+	jwks.Client.Transport = NewRequestIDTransport(nil, nil)
+
+	// A request giving up doesn't cancel the fetch, which carries its request ID
+	ctx, cancel := context.WithTimeout(context.WithValue(context.Background(), contextKeyRequestID, "req-1"), 20*time.Millisecond)
+	defer cancel()
+	if _, err := jwks.Key(ctx, "rsa", "RS256"); err == nil {
+		t.Errorf("expected no key before the fetch is done")
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 5)
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := jwks.Key(context.Background(), "rsa", "RS256")
+			errs <- err
+		}()
+	}
+	time.Sleep(20 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	close(errs)
+
+	if id := <-requestID; id != "req-1" {
+		t.Errorf("expected the fetch to carry the request ID, got %q", id)
+	}
+	for err := range errs {
+		if err != nil {
+			t.Errorf("expected the key once fetched, got %v", err)
+		}
+	}
+	if n := atomic.LoadInt32(&fetches); n != 1 {
+		t.Errorf("expected a single fetch, got %d", n)
+	}
+}
+
+func TestJWKSFile(t *testing.T) {
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	dir, _ := ioutil.TempDir("", "jwks")
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "jwks.json")
+	if err := ioutil.WriteFile(file, jwksJSON(rsaJWK("rsa", &rsaKey.PublicKey)), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	conf := &JWTConf{Keys: NewJWKS(file, time.Hour)}
+	h := JWTAuth(conf)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	tc := []struct {
+		name           string
+		token          string
+		expectedStatus int
+	}{
+		{
+			name:           "RS256 without kid",
+			token:          signJWT(t, "RS256", "", rsaKey, Claims{"sub": "user1", "exp": time.Now().Add(time.Hour).Unix()}),
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name: "HS256 signed with the public key",
+			token: signJWT(t, "HS256", "rsa",
+				x509.MarshalPKCS1PublicKey(&rsaKey.PublicKey), Claims{"sub": "user1", "exp": time.Now().Add(time.Hour).Unix()}),
+			expectedStatus: http.StatusUnauthorized,
+		},
+	}
+
+	for _, tt := range tc {
+		t.Run(tt.name, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			req, _ := http.NewRequest(http.MethodGet, "/", nil)
+			req.Header.Set("Authorization", "Bearer "+tt.token)
+			h.ServeHTTP(rr, req)
+
+			if rr.Code != tt.expectedStatus {
+				t.Errorf("expected status %d, got %d", tt.expectedStatus, rr.Code)
+			}
+		})
+	}
+}
+
+func TestNewStaticKeys(t *testing.T) {
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	der, _ := x509.MarshalPKIXPublicKey(&ecKey.PublicKey)
+	pemKey := string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))
+
+	keys, err := NewStaticKeys("secret", map[string]string{"ec": pemKey})
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if _, err := keys.Key(context.Background(), "", "ES256"); err != nil {
+		t.Errorf("expected the only key without kid, got %v", err)
+	}
+	if k, _ := keys.Key(context.Background(), "ec", "HS256"); string(k.([]byte)) != "secret" {
+		t.Errorf("expected the HMAC secret for HS256")
+	}
+
+	if _, err := NewStaticKeys("", map[string]string{"bad": "not a pem"}); err == nil {
+		t.Errorf("expected an error for an invalid PEM key")
+	}
+}
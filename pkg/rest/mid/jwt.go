@@ -0,0 +1,313 @@
+package mid
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	contextKeyClaims = ContextKey("claims")
+)
+
+var (
+	errTokenMalformed   = errors.New("malformed token")
+	errTokenSignature   = errors.New("invalid token signature")
+	errTokenExpired     = errors.New("token expired")
+	errTokenNoExpiry    = errors.New("token expiration missing")
+	errTokenNotYetValid = errors.New("token not valid yet")
+	errTokenAudience    = errors.New("invalid token audience")
+	errTokenIssuer      = errors.New("invalid token issuer")
+	errTokenSubject     = errors.New("token subject missing")
+)
+
+// Claims are the claims of a validated JWT
+type Claims map[string]interface{}
+
+// String returns the claim as a string, "" if absent or not a string
+func (c Claims) String(name string) string {
+	s, _ := c[name].(string)
+	return s
+}
+
+// Strings returns a claim holding a string or a list of strings,
+// a space separated string such as the OAuth2 scope claim is split
+func (c Claims) Strings(name string) []string {
+	switch v := c[name].(type) {
+	case string:
+		return strings.Fields(v)
+	case []interface{}:
+		ss := make([]string, 0, len(v))
+		for _, e := range v {
+			if s, ok := e.(string); ok {
+				ss = append(ss, s)
+			}
+		}
+		return ss
+	}
+	return nil
+}
+
+// Time returns a NumericDate claim, such as exp or nbf, which may have a fraction of second
+func (c Claims) Time(name string) (time.Time, bool) {
+	switch v := c[name].(type) {
+	case float64:
+		return unixTime(v), true
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			return time.Unix(i, 0), true
+		}
+		if f, err := v.Float64(); err == nil {
+			return unixTime(f), true
+		}
+	}
+	return time.Time{}, false
+}
+
+// unixTime returns the time of a number of seconds since the epoch
+func unixTime(seconds float64) time.Time {
+	sec, frac := math.Modf(seconds)
+	return time.Unix(int64(sec), int64(frac*1e9))
+}
+
+// GetClaims will retrieve the validated JWT claims from the context if there are some
+func GetClaims(ctx context.Context) Claims {
+	if c, ok := ctx.Value(contextKeyClaims).(Claims); ok {
+		return c
+	}
+
+	return nil
+}
+
+// JWTConf is the configuration of the JWTAuth middleware
+type JWTConf struct {
+	// Keys gives the keys verifying the token signatures
+	Keys KeySource
+	// Algorithms are the accepted signing algorithms, defaults to HS256, RS256 and ES256
+	Algorithms []string
+	// Issuer, if set, must be the iss claim
+	Issuer string
+	// Audience, if set, must be in the aud claim
+	Audience string
+	// Leeway is the clock skew tolerated on exp and nbf
+	Leeway time.Duration
+	// AllowMissingExp accepts the tokens without exp claim, which never expire
+	AllowMissingExp bool
+	// Realm is sent in the WWW-Authenticate challenge
+	Realm string
+	// ScopesClaim and RolesClaim are the claims mapped to the principal
+	// scopes and roles, default to "scope" and "roles"
+	ScopesClaim string
+	RolesClaim  string
+	// TenantClaim, if set, is the claim binding the principal to a tenant
+	TenantClaim string
+}
+
+// JWTAuth authenticates requests with a JWT bearer token.
+// The claims and the principal are stored in the request context,
+// requests without a valid token get a 401.
+// Requests already authenticated, by APIKeyAuth for example, are let through.
+func JWTAuth(conf *JWTConf) func(http.Handler) http.Handler {
+	algorithms := conf.Algorithms
+	if len(algorithms) == 0 {
+		algorithms = []string{"HS256", "RS256", "ES256"}
+	}
+	realm := conf.Realm
+	if realm == "" {
+		realm = "api"
+	}
+	scopesClaim, rolesClaim := conf.ScopesClaim, conf.RolesClaim
+	if scopesClaim == "" {
+		scopesClaim = "scope"
+	}
+	if rolesClaim == "" {
+		rolesClaim = "roles"
+	}
+
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if GetPrincipal(r.Context()) != nil {
+				h.ServeHTTP(w, r)
+				return
+			}
+
+			token := bearerToken(r)
+			if token == "" {
+				renderUnauthorized(w, r, "Bearer", realm, nil)
+				return
+			}
+
+			claims, err := verifyJWT(r.Context(), conf, algorithms, token, time.Now())
+			if err == nil && claims.String("sub") == "" {
+				err = errTokenSubject
+			}
+			if err != nil {
+				renderUnauthorized(w, r, "Bearer", realm, err)
+				return
+			}
+
+			*r = *r.WithContext(context.WithValue(r.Context(), contextKeyClaims, claims))
+			p := &Principal{
+				ID:     PrincipalID("jwt", claims.String("iss"), claims.String("sub")),
+				Method: "jwt",
+				Scopes: claims.Strings(scopesClaim),
+				Roles:  claims.Strings(rolesClaim),
+			}
+			if conf.TenantClaim != "" {
+				p.Tenant = claims.String(conf.TenantClaim)
+			}
+			setPrincipal(r, p)
+
+			h.ServeHTTP(w, r)
+		})
+	}
+}
+
+// bearerToken extracts the token from the Authorization header
+func bearerToken(r *http.Request) string {
+	auth := r.Header.Get("Authorization")
+	if len(auth) < 7 || !strings.EqualFold(auth[:7], "Bearer ") {
+		return ""
+	}
+	return strings.TrimSpace(auth[7:])
+}
+
+type jwtHeader struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+}
+
+// verifyJWT checks the token signature and its registered claims
+func verifyJWT(
+	ctx context.Context,
+	conf *JWTConf,
+	algorithms []string,
+	token string,
+	now time.Time,
+) (Claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errTokenMalformed
+	}
+
+	var header jwtHeader
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, errTokenMalformed
+	}
+	if !contains(algorithms, header.Alg) {
+		return nil, fmt.Errorf("algorithm %q not accepted", header.Alg)
+	}
+
+	sig, errS := base64.RawURLEncoding.DecodeString(parts[2])
+	if errS != nil {
+		return nil, errTokenMalformed
+	}
+
+	key, errK := conf.Keys.Key(ctx, header.Kid, header.Alg)
+	if errK != nil {
+		return nil, errK
+	}
+	if err := verifySignature(header.Alg, key, []byte(parts[0]+"."+parts[1]), sig); err != nil {
+		return nil, err
+	}
+
+	var claims Claims
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, errTokenMalformed
+	}
+
+	exp, ok := claims.Time("exp")
+	if !ok && !conf.AllowMissingExp {
+		return nil, errTokenNoExpiry
+	}
+	if ok && !now.Before(exp.Add(conf.Leeway)) {
+		return nil, errTokenExpired
+	}
+	if nbf, ok := claims.Time("nbf"); ok && now.Add(conf.Leeway).Before(nbf) {
+		return nil, errTokenNotYetValid
+	}
+	if conf.Issuer != "" && claims.String("iss") != conf.Issuer {
+		return nil, errTokenIssuer
+	}
+	if conf.Audience != "" && !contains(claims.Strings("aud"), conf.Audience) {
+		return nil, errTokenAudience
+	}
+
+	return claims, nil
+}
+
+// decodeSegment decodes a base64url encoded JSON token segment
+func decodeSegment(seg string, v interface{}) error {
+	b, err := base64.RawURLEncoding.DecodeString(seg)
+	if err != nil {
+		return err
+	}
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+	return dec.Decode(v)
+}
+
+// verifySignature checks sig for alg, refusing keys of the wrong type
+func verifySignature(alg string, key interface{}, signingInput, sig []byte) error {
+	hashed := sha256.Sum256(signingInput)
+
+	switch alg {
+	case "HS256":
+		k, ok := key.([]byte)
+		if !ok {
+			return fmt.Errorf("key type %T can't verify %s", key, alg)
+		}
+		mac := hmac.New(sha256.New, k)
+		_, _ = mac.Write(signingInput)
+		if !hmac.Equal(sig, mac.Sum(nil)) {
+			return errTokenSignature
+		}
+	case "RS256":
+		k, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return fmt.Errorf("key type %T can't verify %s", key, alg)
+		}
+		if rsa.VerifyPKCS1v15(k, crypto.SHA256, hashed[:], sig) != nil {
+			return errTokenSignature
+		}
+	case "ES256":
+		k, ok := key.(*ecdsa.PublicKey)
+		if !ok {
+			return fmt.Errorf("key type %T can't verify %s", key, alg)
+		}
+		if len(sig) != 64 {
+			return errTokenSignature
+		}
+		r := new(big.Int).SetBytes(sig[:32])
+		s := new(big.Int).SetBytes(sig[32:])
+		if !ecdsa.Verify(k, hashed[:], r, s) {
+			return errTokenSignature
+		}
+	default:
+		return fmt.Errorf("algorithm %q not supported", alg)
+	}
+
+	return nil
+}
+
+func contains(ss []string, s string) bool {
+	for _, e := range ss {
+		if e == s {
+			return true
+		}
+	}
+	return false
+}
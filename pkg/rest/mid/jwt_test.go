@@ -0,0 +1,306 @@
+package mid
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus/hooks/test"
+)
+
+// signJWT builds a token for the tests, key is the signing key for alg
+func signJWT(t *testing.T, alg, kid string, key interface{}, claims Claims) string {
+	header := map[string]string{"alg": alg, "typ": "JWT"}
+	if kid != "" {
+		header["kid"] = kid
+	}
+	h, _ := json.Marshal(header)
+	c, _ := json.Marshal(claims)
+	input := base64.RawURLEncoding.EncodeToString(h) + "." + base64.RawURLEncoding.EncodeToString(c)
+	hashed := sha256.Sum256([]byte(input))
+
+	var sig []byte
+	switch k := key.(type) {
+	case []byte:
+		mac := hmac.New(sha256.New, k)
+		_, _ = mac.Write([]byte(input))
+		sig = mac.Sum(nil)
+	case *rsa.PrivateKey:
+		var err error
+		if sig, err = rsa.SignPKCS1v15(rand.Reader, k, crypto.SHA256, hashed[:]); err != nil {
+			t.Fatal(err)
+		}
+	case *ecdsa.PrivateKey:
+		r, s, err := ecdsa.Sign(rand.Reader, k, hashed[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		sig = make([]byte, 64)
+		rb, sb := r.Bytes(), s.Bytes()
+		copy(sig[32-len(rb):32], rb)
+		copy(sig[64-len(sb):], sb)
+	}
+
+	return input + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+func TestJWTAuth(t *testing.T) {
+	secret := []byte("secret")
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+
+	keys := &StaticKeys{
+		HMACSecret: secret,
+		PublicKeys: map[string]interface{}{
+			"rsa": &rsaKey.PublicKey,
+			"ec":  &ecKey.PublicKey,
+		},
+	}
+	conf := &JWTConf{
+		Keys:     keys,
+		Issuer:   "issuer",
+		Audience: "api",
+		Leeway:   time.Minute,
+		Realm:    "test",
+	}
+
+	now := time.Now().Unix()
+	valid := Claims{"sub": "user1", "iss": "issuer", "aud": "api", "exp": now + 60, "scope": "read write"}
+
+	tc := []struct {
+		name           string
+		authorization  string
+		expectedStatus int
+		expectedError  string
+	}{
+		{
+			name:           "HS256",
+			authorization:  "Bearer " + signJWT(t, "HS256", "", secret, valid),
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "RS256",
+			authorization:  "Bearer " + signJWT(t, "RS256", "rsa", rsaKey, valid),
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "ES256",
+			authorization:  "Bearer " + signJWT(t, "ES256", "ec", ecKey, valid),
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "audience list",
+			authorization:  "Bearer " + signJWT(t, "HS256", "", secret, Claims{"sub": "user1", "iss": "issuer", "aud": []string{"other", "api"}, "exp": now + 60}),
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "expired within leeway",
+			authorization:  "Bearer " + signJWT(t, "HS256", "", secret, Claims{"sub": "user1", "iss": "issuer", "aud": "api", "exp": now - 30}),
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "no subject",
+			authorization:  "Bearer " + signJWT(t, "HS256", "", secret, Claims{"iss": "issuer", "aud": "api", "exp": now + 60}),
+			expectedStatus: http.StatusUnauthorized,
+			expectedError:  "token subject missing",
+		},
+		{
+			name:           "no expiration",
+			authorization:  "Bearer " + signJWT(t, "HS256", "", secret, Claims{"sub": "user1", "iss": "issuer", "aud": "api"}),
+			expectedStatus: http.StatusUnauthorized,
+			expectedError:  "token expiration missing",
+		},
+		{
+			name:           "no token",
+			expectedStatus: http.StatusUnauthorized,
+		},
+		{
+			name:           "not bearer",
+			authorization:  "Basic dXNlcjpwYXNz",
+			expectedStatus: http.StatusUnauthorized,
+		},
+		{
+			name:           "malformed",
+			authorization:  "Bearer abc.def",
+			expectedStatus: http.StatusUnauthorized,
+			expectedError:  "invalid_token",
+		},
+		{
+			name:           "wrong secret",
+			authorization:  "Bearer " + signJWT(t, "HS256", "", []byte("other"), valid),
+			expectedStatus: http.StatusUnauthorized,
+			expectedError:  "invalid token signature",
+		},
+		{
+			name:           "alg none",
+			authorization:  "Bearer " + signJWT(t, "none", "", nil, valid),
+			expectedStatus: http.StatusUnauthorized,
+			expectedError:  "invalid token signature",
+		},
+		{
+			name:           "HS256 ignores kid",
+			authorization:  "Bearer " + signJWT(t, "HS256", "rsa", secret, valid),
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "ES256 with the RSA key",
+			authorization:  "Bearer " + signJWT(t, "ES256", "rsa", ecKey, valid),
+			expectedStatus: http.StatusUnauthorized,
+			expectedError:  "invalid token signature",
+		},
+		{
+			name:           "unknown kid",
+			authorization:  "Bearer " + signJWT(t, "RS256", "unknown", rsaKey, valid),
+			expectedStatus: http.StatusUnauthorized,
+			expectedError:  "invalid token signature",
+		},
+		{
+			name:           "expired",
+			authorization:  "Bearer " + signJWT(t, "HS256", "", secret, Claims{"iss": "issuer", "aud": "api", "exp": now - 120}),
+			expectedStatus: http.StatusUnauthorized,
+			expectedError:  "token expired",
+		},
+		{
+			name:           "not yet valid",
+			authorization:  "Bearer " + signJWT(t, "HS256", "", secret, Claims{"iss": "issuer", "aud": "api", "exp": now + 600, "nbf": now + 120}),
+			expectedStatus: http.StatusUnauthorized,
+			expectedError:  "token not valid yet",
+		},
+		{
+			name:           "wrong issuer",
+			authorization:  "Bearer " + signJWT(t, "HS256", "", secret, Claims{"iss": "other", "aud": "api", "exp": now + 60}),
+			expectedStatus: http.StatusUnauthorized,
+			expectedError:  "invalid token issuer",
+		},
+		{
+			name:           "wrong audience",
+			authorization:  "Bearer " + signJWT(t, "HS256", "", secret, Claims{"iss": "issuer", "aud": "other", "exp": now + 60}),
+			expectedStatus: http.StatusUnauthorized,
+			expectedError:  "invalid token audience",
+		},
+	}
+
+	for _, tt := range tc {
+		t.Run(tt.name, func(t *testing.T) {
+			var claims Claims
+			var principal *Principal
+			h := JWTAuth(conf)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				claims = GetClaims(r.Context())
+				principal = GetPrincipal(r.Context())
+			}))
+
+			rr := httptest.NewRecorder()
+			req, _ := http.NewRequest(http.MethodGet, "/v1/resource", nil)
+			if tt.authorization != "" {
+				req.Header.Set("Authorization", tt.authorization)
+			}
+			h.ServeHTTP(rr, req)
+
+			if rr.Code != tt.expectedStatus {
+				t.Errorf("expected status %d, got %d: %s", tt.expectedStatus, rr.Code, rr.Body.String())
+				return
+			}
+
+			if tt.expectedStatus != http.StatusOK {
+				challenge := rr.Header().Get("WWW-Authenticate")
+				if !strings.HasPrefix(challenge, `Bearer realm="test"`) {
+					t.Errorf("unexpected WWW-Authenticate %q", challenge)
+				}
+				if !strings.Contains(challenge, tt.expectedError) {
+					t.Errorf("expected %q in WWW-Authenticate %q", tt.expectedError, challenge)
+				}
+				// The internal errors are logged, never answered
+				if strings.Contains(challenge, "key") || strings.Contains(challenge, "alg") {
+					t.Errorf("unexpected internal error in WWW-Authenticate %q", challenge)
+				}
+				return
+			}
+
+			if claims == nil || principal == nil {
+				t.Errorf("expected claims and principal in the context")
+				return
+			}
+			if principal.Method != "jwt" || principal.ID != PrincipalID("jwt", claims.String("iss"), claims.String("sub")) {
+				t.Errorf("unexpected principal %+v", principal)
+			}
+		})
+	}
+}
+
+func TestJWTAuthPrincipal(t *testing.T) {
+	logger, hook := test.NewNullLogger()
+	conf := &JWTConf{Keys: &StaticKeys{HMACSecret: []byte("secret")}, AllowMissingExp: true}
+	token := signJWT(t, "HS256", "", []byte("secret"), Claims{
+		"sub":   "user1",
+		"scope": "read write",
+		"roles": []string{"admin"},
+	})
+
+	var principal *Principal
+	h := Logger(logger)(JWTAuth(conf)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		principal = GetPrincipal(r.Context())
+	})))
+
+	req, _ := http.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Authorization", "bearer "+token)
+	h.ServeHTTP(httptest.NewRecorder(), req)
+
+	if principal == nil {
+		t.Fatalf("expected a principal")
+	}
+	if !principal.HasScope("read") || !principal.HasScope("write") || principal.HasScope("delete") {
+		t.Errorf("unexpected scopes %v", principal.Scopes)
+	}
+	if !principal.HasRole("admin") {
+		t.Errorf("unexpected roles %v", principal.Roles)
+	}
+	if hook.LastEntry().Data["principal"] != "jwt::user1" {
+		t.Errorf("expected the principal to be logged, got %v", hook.LastEntry().Data["principal"])
+	}
+}
+
+func TestClaimsTime(t *testing.T) {
+	tests := []struct {
+		name     string
+		payload  string
+		expected time.Time
+		ok       bool
+	}{
+		{name: "integer", payload: `{"exp":1700000000}`, expected: time.Unix(1700000000, 0), ok: true},
+		{name: "fraction", payload: `{"exp":1700000000.5}`, expected: time.Unix(1700000000, 5e8), ok: true},
+		{name: "exponent", payload: `{"exp":1.7e9}`, expected: time.Unix(1700000000, 0), ok: true},
+		{name: "string", payload: `{"exp":"1700000000"}`},
+		{name: "missing", payload: `{}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var claims Claims
+			if err := decodeSegment(base64.RawURLEncoding.EncodeToString([]byte(tt.payload)), &claims); err != nil {
+				t.Fatalf("decodeSegment() error = %v", err)
+			}
+			got, ok := claims.Time("exp")
+			if ok != tt.ok || !got.Equal(tt.expected) {
+				t.Errorf("expected %v %v, got %v %v", tt.expected, tt.ok, got, ok)
+			}
+		})
+	}
+}
+
+func TestGetClaims(t *testing.T) {
+	if GetClaims(context.Background()) != nil {
+		t.Errorf("expected no claims")
+	}
+}
@@ -1,77 +1,99 @@
 package mid
 
 import (
+	"context"
 	"fmt"
 	"net/http"
 	"time"
 
-	"github.com/segmentio/ksuid"
 	"github.com/sirupsen/logrus"
+
+	"github.com/vincentserpoul/gorestarter/pkg/tracing"
 )
 
 // ErrRequestContextKey will allow the error to be passed down
 const ErrRequestContextKey = ContextKey("error request")
 
-type augmentedResponseWriter struct {
-	http.ResponseWriter
-	length     int
-	httpStatus int
-}
-
-// WriteHeader will not only write b to w but also save the http status in the struct
-func (w *augmentedResponseWriter) WriteHeader(httpStatus int) {
-	w.ResponseWriter.WriteHeader(httpStatus)
-	w.httpStatus = httpStatus
-}
-
-// Write will not only write b to w but also save the byte length in the struct
-func (w *augmentedResponseWriter) Write(b []byte) (int, error) {
-	n, err := w.ResponseWriter.Write(b)
-	w.length = n
-
-	return n, err
-}
-
-func newAugmentedResponseWriter(w http.ResponseWriter) *augmentedResponseWriter {
-	return &augmentedResponseWriter{ResponseWriter: w}
-}
+const contextKeyLogger = ContextKey("logger")
 
 // Logger will return an error if the required params are not there
 func Logger(l *logrus.Logger) func(http.Handler) http.Handler {
 	return func(h http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			logFields := logrus.Fields{}
+			// entryFields are the fields of the logger of the handlers, see LoggerFrom
+			entryFields := logrus.Fields{}
 
-			if reqID := GetRequestID(r.Context()); reqID != ksuid.Nil {
+			if reqID := GetRequestID(r.Context()); reqID != "" {
 				logFields["request_id"] = reqID
+				entryFields["request_id"] = reqID
 			}
-
-			scheme := "http"
-			if r.TLS != nil {
-				scheme = "https"
+			if route := RoutePattern(r); route != "" {
+				entryFields["route"] = route
 			}
-			logFields["http_scheme"] = scheme
+			if sc := tracing.SpanContextFromContext(r.Context()); sc.IsValid() {
+				entryFields["trace_id"] = sc.TraceID.String()
+				entryFields["span_id"] = sc.SpanID.String()
+			}
+			// In place, so that the error set by the handlers is still seen here
+			*r = *r.WithContext(context.WithValue(r.Context(), contextKeyLogger, l.WithFields(entryFields)))
+
 			logFields["http_proto"] = r.Proto
 			logFields["http_method"] = r.Method
 
 			logFields["remote_addr"] = r.RemoteAddr
 			logFields["user_agent"] = r.UserAgent()
 
-			logFields["uri"] = fmt.Sprintf("%s://%s%s", scheme, r.Host, r.RequestURI)
-
 			startTime := time.Now()
 
 			naw := newAugmentedResponseWriter(w)
 
 			// Write log after the request is finished
 			defer func() {
+				// The scheme is known once Security trusted the proxy
+				scheme := Scheme(r)
+				logFields["http_scheme"] = scheme
+				logFields["uri"] = fmt.Sprintf("%s://%s%s", scheme, r.Host, r.RequestURI)
 				logFields["process_time"] = time.Since(startTime)
-				logFields["http_status"] = naw.httpStatus
+				logFields["http_status"] = naw.status()
 				logFields["resp_length"] = naw.length
+				if ttfb := naw.timeToFirstByte(); ttfb > 0 {
+					logFields["time_to_first_byte"] = ttfb
+				}
+				if naw.hijacked {
+					logFields["hijacked"] = true
+				}
+				if r.Context().Err() == context.DeadlineExceeded {
+					logFields["timed_out"] = true
+				}
+				if naw.uncompressedLength > 0 {
+					logFields["resp_uncompressed_length"] = naw.uncompressedLength
+				}
+				if p := GetPrincipal(r.Context()); p != nil {
+					logFields["principal"] = p.ID
+					logFields["auth_method"] = p.Method
+				}
+				if prefix := GetAPIKeyPrefix(r.Context()); prefix != "" {
+					logFields["api_key_prefix"] = prefix
+				}
+				if tenant := GetTenant(r.Context()); tenant != "" {
+					logFields["tenant"] = tenant
+				}
+				if sc := tracing.SpanContextFromContext(r.Context()); sc.IsValid() {
+					logFields["trace_id"] = sc.TraceID.String()
+					logFields["span_id"] = sc.SpanID.String()
+				}
+				if e := GetExchange(r.Context()); e != nil {
+					logFields["request_body"] = e.RequestBody
+					logFields["response_body"] = e.ResponseBody
+					if e.BodiesTruncated {
+						logFields["bodies_truncated"] = true
+					}
+				}
 
-				// Get response status and size
-				if naw.httpStatus == http.StatusNotFound ||
-					naw.httpStatus == http.StatusBadRequest {
+				// Client errors are only warnings
+				if naw.status() >= http.StatusBadRequest &&
+					naw.status() < http.StatusInternalServerError {
 					l.WithFields(logFields).Warnln()
 					return
 				}
@@ -89,3 +111,26 @@ func Logger(l *logrus.Logger) func(http.Handler) http.Handler {
 		})
 	}
 }
+
+// LoggerFrom returns the logger of the request, with its request ID, route, trace,
+// principal and tenant. Outside of a request, it is the standard logger.
+func LoggerFrom(ctx context.Context) *logrus.Entry {
+	entry, ok := ctx.Value(contextKeyLogger).(*logrus.Entry)
+	if !ok {
+		entry = logrus.NewEntry(logrus.StandardLogger())
+	}
+
+	// The principal and the tenant are known once the request is authenticated
+	fields := logrus.Fields{}
+	if p := GetPrincipal(ctx); p != nil {
+		fields["principal"] = p.ID
+	}
+	if tenant := GetTenant(ctx); tenant != "" {
+		fields["tenant"] = tenant
+	}
+	if len(fields) == 0 {
+		return entry
+	}
+
+	return entry.WithFields(fields)
+}
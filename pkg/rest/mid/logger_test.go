@@ -8,6 +8,7 @@ import (
 	"net/http/httptest"
 	"testing"
 
+	"github.com/go-chi/chi"
 	"github.com/segmentio/ksuid"
 	"github.com/sirupsen/logrus"
 	"github.com/sirupsen/logrus/hooks/test"
@@ -124,11 +125,11 @@ func TestLoggerCompleteness(t *testing.T) {
 }
 
 func TestLoggerData(t *testing.T) {
-	reqIDTest := ksuid.New()
+	reqIDTest := ksuid.New().String()
 	tc := []struct {
 		name                string
 		handler             http.HandlerFunc
-		requestID           ksuid.KSUID
+		requestID           string
 		RequestErrorMessage string
 		expectedLen         int
 	}{
@@ -178,7 +179,7 @@ func TestLoggerData(t *testing.T) {
 			r, _ := http.NewRequest("GET", ``, nil)
 			r.RemoteAddr = "127.0.0.1"
 			r.Header.Set("User-Agent", "test")
-			if tt.requestID != ksuid.Nil {
+			if tt.requestID != "" {
 				*r = *r.WithContext(context.WithValue(r.Context(), contextKeyRequestID, reqIDTest))
 			}
 			midWared.ServeHTTP(rr, r)
@@ -188,7 +189,7 @@ func TestLoggerData(t *testing.T) {
 				return
 			}
 
-			if tt.requestID != ksuid.Nil && hook.LastEntry().Data["request_id"] != tt.requestID {
+			if tt.requestID != "" && hook.LastEntry().Data["request_id"] != tt.requestID {
 				t.Errorf("missing requestid, expected %s, got %s", tt.requestID, hook.LastEntry().Data["request_id"])
 				return
 			}
@@ -206,3 +207,37 @@ func TestLoggerData(t *testing.T) {
 		})
 	}
 }
+
+func TestLoggerFrom(t *testing.T) {
+	logger, hook := test.NewNullLogger()
+
+	r := chi.NewRouter()
+	r.Use(RequestID(nil), Logger(logger))
+	r.Get("/v1/resource/{id}", func(w http.ResponseWriter, req *http.Request) {
+		ctx := context.WithValue(req.Context(), contextKeyPrincipal, &Principal{ID: "user-1"})
+		LoggerFrom(ctx).Info("handler")
+	})
+
+	req, _ := http.NewRequest(http.MethodGet, "/v1/resource/1", nil)
+	req.Header.Set("X-Request-ID", "request-1")
+	r.ServeHTTP(httptest.NewRecorder(), req)
+
+	if len(hook.Entries) != 2 {
+		t.Fatalf("expected the handler and the request logs, got %d", len(hook.Entries))
+	}
+	handler := hook.Entries[0]
+	expected := logrus.Fields{"request_id": "request-1", "route": "/v1/resource/{id}", "principal": "user-1"}
+	for k, v := range expected {
+		if handler.Data[k] != v {
+			t.Errorf("expected %s %v, got %v", k, v, handler.Data[k])
+		}
+	}
+	if hook.Entries[1].Data["request_id"] != "request-1" {
+		t.Errorf("expected the request log to have the same request ID, got %v", hook.Entries[1].Data)
+	}
+
+	// Outside of a request
+	if entry := LoggerFrom(context.Background()); entry.Logger != logrus.StandardLogger() {
+		t.Errorf("expected the standard logger outside of a request")
+	}
+}
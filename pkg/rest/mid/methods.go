@@ -0,0 +1,172 @@
+package mid
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/go-chi/chi"
+)
+
+// routableMethods are the methods checked when building the Allow header
+var routableMethods = []string{
+	http.MethodGet,
+	http.MethodPost,
+	http.MethodPut,
+	http.MethodPatch,
+	http.MethodDelete,
+}
+
+// AllowedMethods returns the methods the chi router accepts for the request path,
+// HEAD and OPTIONS being added to any routed path as they are answered automatically
+func AllowedMethods(r *http.Request) []string {
+	rctx, _ := r.Context().Value(chi.RouteCtxKey).(*chi.Context)
+	if rctx == nil || rctx.Routes == nil {
+		return nil
+	}
+	path := routePath(r)
+
+	var methods []string
+	for _, method := range routableMethods {
+		if matchRoute(rctx.Routes, method, path) {
+			methods = append(methods, method)
+			if method == http.MethodGet {
+				methods = append(methods, http.MethodHead)
+			}
+		}
+	}
+	if len(methods) > 0 {
+		methods = append(methods, http.MethodOptions)
+	}
+
+	return methods
+}
+
+// Options answers OPTIONS requests with the allowed methods in the Allow header,
+// unless the path has its own OPTIONS route. It must be used on a chi router.
+func Options() func(http.Handler) http.Handler {
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			rctx, _ := r.Context().Value(chi.RouteCtxKey).(*chi.Context)
+			if r.Method != http.MethodOptions || rctx == nil || rctx.Routes == nil ||
+				matchRoute(rctx.Routes, http.MethodOptions, routePath(r)) {
+				h.ServeHTTP(w, r)
+				return
+			}
+
+			methods := AllowedMethods(r)
+			if len(methods) == 0 {
+				// let the router answer with its not found handler
+				h.ServeHTTP(w, r)
+				return
+			}
+
+			w.Header().Set("Allow", strings.Join(methods, ", "))
+			w.WriteHeader(http.StatusNoContent)
+		})
+	}
+}
+
+// Head routes HEAD requests to the GET handler when the path has no HEAD route.
+// It replaces chi's middleware.GetHead, which can't see through sub routers.
+func Head() func(http.Handler) http.Handler {
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			rctx, _ := r.Context().Value(chi.RouteCtxKey).(*chi.Context)
+			if r.Method == http.MethodHead && rctx != nil && rctx.Routes != nil &&
+				!matchRoute(rctx.Routes, http.MethodHead, routePath(r)) {
+				rctx.RouteMethod = http.MethodGet
+			}
+
+			h.ServeHTTP(w, r)
+		})
+	}
+}
+
+// routePath returns the full path of the request, as routed by chi
+func routePath(r *http.Request) string {
+	if r.URL.RawPath != "" {
+		return r.URL.RawPath
+	}
+	return r.URL.Path
+}
+
+// RoutePattern returns the pattern of the route chi will use for the request,
+// such as /v1/resourceone/{resourceoneID}, or "" if there is none.
+// Unlike chi.Context.RoutePattern, it can be used before routing.
+func RoutePattern(r *http.Request) string {
+	rctx, _ := r.Context().Value(chi.RouteCtxKey).(*chi.Context)
+	if rctx == nil || rctx.Routes == nil {
+		return ""
+	}
+
+	method := r.Method
+	if rctx.RouteMethod != "" {
+		method = rctx.RouteMethod
+	}
+	patterns, ok := findRoute(rctx.Routes, method, routePath(r))
+	if !ok {
+		return ""
+	}
+
+	pattern := strings.Join(patterns, "")
+	for strings.Contains(pattern, "/*/") {
+		pattern = strings.Replace(pattern, "/*/", "/", -1)
+	}
+	if len(pattern) > 1 {
+		pattern = strings.TrimSuffix(pattern, "/")
+	}
+
+	return pattern
+}
+
+// matchRoute reports whether a route exists for method and path
+func matchRoute(routes chi.Routes, method, path string) bool {
+	_, ok := findRoute(routes, method, path)
+	return ok
+}
+
+// findRoute is chi's Routes.Match, following sub routers mount points and
+// returning the patterns matched at each level.
+// Match stops on mount points, as they accept every method, while the sub
+// router would actually route the request on its "/" pattern.
+func findRoute(routes chi.Routes, method, path string) ([]string, bool) {
+	tctx := chi.NewRouteContext()
+	if !routes.Match(tctx, method, path) {
+		return nil, false
+	}
+	patterns := tctx.RoutePatterns
+
+	level := routes
+	for i, pattern := range patterns {
+		if i < len(patterns)-1 {
+			if level = subRoutes(level, pattern); level == nil {
+				break
+			}
+			continue
+		}
+
+		if strings.HasSuffix(pattern, "*") {
+			break
+		}
+		if sub := subRoutes(level, strings.TrimSuffix(pattern, "/")+"/*"); sub != nil {
+			subPatterns, ok := findRoute(sub, method, "/")
+			if !ok {
+				return nil, false
+			}
+			return append(patterns, subPatterns...), true
+		}
+	}
+
+	return patterns, true
+}
+
+// subRoutes returns the sub router mounted on pattern, if any
+func subRoutes(routes chi.Routes, pattern string) chi.Routes {
+	for _, route := range routes.Routes() {
+		if route.Pattern == pattern {
+			return route.SubRoutes
+		}
+	}
+
+	return nil
+}
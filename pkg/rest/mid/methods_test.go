@@ -0,0 +1,166 @@
+package mid
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-chi/chi"
+)
+
+func testOptionsRouter() http.Handler {
+	fakeHandler := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {})
+
+	r := chi.NewRouter()
+	r.Use(Head())
+	r.Use(Options())
+	r.Route("/v1", func(r chi.Router) {
+		r.Get("/resource", func(w http.ResponseWriter, req *http.Request) {
+			_, _ = w.Write([]byte("body"))
+		})
+		r.Post("/resource", fakeHandler)
+		r.Route("/resource/{id}", func(r chi.Router) {
+			r.Get("/", fakeHandler)
+			r.Delete("/", fakeHandler)
+		})
+		r.Options("/custom", func(w http.ResponseWriter, req *http.Request) {
+			w.WriteHeader(http.StatusTeapot)
+		})
+	})
+
+	return r
+}
+
+func TestOptions(t *testing.T) {
+	tc := []struct {
+		name           string
+		method         string
+		path           string
+		expectedStatus int
+		expectedAllow  string
+	}{
+		{
+			name:           "OPTIONS on a GET and POST route",
+			method:         http.MethodOptions,
+			path:           "/v1/resource",
+			expectedStatus: http.StatusNoContent,
+			expectedAllow:  "GET, HEAD, POST, OPTIONS",
+		},
+		{
+			name:           "OPTIONS on a route with params",
+			method:         http.MethodOptions,
+			path:           "/v1/resource/12",
+			expectedStatus: http.StatusNoContent,
+			expectedAllow:  "GET, HEAD, DELETE, OPTIONS",
+		},
+		{
+			name:           "OPTIONS on an explicit OPTIONS route",
+			method:         http.MethodOptions,
+			path:           "/v1/custom",
+			expectedStatus: http.StatusTeapot,
+		},
+		{
+			name:           "OPTIONS on an unknown route",
+			method:         http.MethodOptions,
+			path:           "/v1/unknown",
+			expectedStatus: http.StatusNotFound,
+		},
+		{
+			name:           "HEAD on a GET route",
+			method:         http.MethodHead,
+			path:           "/v1/resource/12",
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "HEAD on a POST only route",
+			method:         http.MethodHead,
+			path:           "/v1/custom",
+			expectedStatus: http.StatusMethodNotAllowed,
+		},
+		{
+			name:           "GET goes through",
+			method:         http.MethodGet,
+			path:           "/v1/resource",
+			expectedStatus: http.StatusOK,
+		},
+	}
+
+	router := testOptionsRouter()
+	for _, tt := range tc {
+		t.Run(tt.name, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			r, _ := http.NewRequest(tt.method, tt.path, nil)
+			router.ServeHTTP(rr, r)
+
+			if rr.Code != tt.expectedStatus {
+				t.Errorf("expected status %d, got %d", tt.expectedStatus, rr.Code)
+				return
+			}
+			if rr.Header().Get("Allow") != tt.expectedAllow {
+				t.Errorf("expected Allow %q, got %q", tt.expectedAllow, rr.Header().Get("Allow"))
+			}
+		})
+	}
+}
+
+func TestAllowedMethods(t *testing.T) {
+	r, _ := http.NewRequest(http.MethodGet, "/v1/resource", nil)
+	if methods := AllowedMethods(r); methods != nil {
+		t.Errorf("expected no methods outside of a chi router, got %v", methods)
+	}
+}
+
+func TestRoutePattern(t *testing.T) {
+	tc := []struct {
+		name            string
+		method          string
+		path            string
+		expectedPattern string
+	}{
+		{
+			name:            "mounted route",
+			method:          http.MethodGet,
+			path:            "/v1/resource",
+			expectedPattern: "/v1/resource",
+		},
+		{
+			name:            "sub router route with params",
+			method:          http.MethodDelete,
+			path:            "/v1/resource/12",
+			expectedPattern: "/v1/resource/{id}",
+		},
+		{
+			name:            "unknown route",
+			method:          http.MethodGet,
+			path:            "/v1/unknown",
+			expectedPattern: "",
+		},
+		{
+			name:            "method not allowed",
+			method:          http.MethodPut,
+			path:            "/v1/resource/12",
+			expectedPattern: "",
+		},
+	}
+
+	for _, tt := range tc {
+		t.Run(tt.name, func(t *testing.T) {
+			var pattern string
+			r := chi.NewRouter()
+			r.Use(func(h http.Handler) http.Handler {
+				return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+					pattern = RoutePattern(req)
+					h.ServeHTTP(w, req)
+				})
+			})
+			r.Mount("/", testOptionsRouter())
+
+			req, _ := http.NewRequest(tt.method, tt.path, nil)
+			r.ServeHTTP(httptest.NewRecorder(), req)
+
+			if pattern != tt.expectedPattern {
+				t.Errorf("expected pattern %q, got %q", tt.expectedPattern, pattern)
+			}
+		})
+	}
+}
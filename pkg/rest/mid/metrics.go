@@ -0,0 +1,95 @@
+package mid
+
+import (
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/vincentserpoul/gorestarter/pkg/metrics"
+)
+
+// unmatchedRoute is the route label of the requests without route, so that
+// unknown paths don't create a series each
+const unmatchedRoute = "unmatched"
+
+// HTTPMetrics are the RED metrics of the requests, by route pattern, method and status
+type HTTPMetrics struct {
+	requests     *metrics.CounterVec
+	duration     *metrics.HistogramVec
+	requestSize  *metrics.HistogramVec
+	responseSize *metrics.HistogramVec
+	inFlight     *metrics.GaugeVec
+}
+
+// NewHTTPMetrics returns the request metrics, registered in reg
+func NewHTTPMetrics(reg *metrics.Registry) *HTTPMetrics {
+	sizeBuckets := metrics.ExponentialBuckets(100, 10, 6)
+	m := &HTTPMetrics{
+		requests: metrics.NewCounterVec("http_requests_total",
+			"Number of HTTP requests.", "method", "route", "status"),
+		duration: metrics.NewHistogramVec("http_request_duration_seconds",
+			"Duration of the HTTP requests.", metrics.DefBuckets, "method", "route", "status"),
+		requestSize: metrics.NewHistogramVec("http_request_size_bytes",
+			"Size of the HTTP request bodies.", sizeBuckets, "method", "route"),
+		responseSize: metrics.NewHistogramVec("http_response_size_bytes",
+			"Size of the HTTP response bodies, as sent.", sizeBuckets, "method", "route"),
+		inFlight: metrics.NewGaugeVec("http_requests_in_flight",
+			"Number of HTTP requests being served."),
+	}
+	reg.Register(m.requests, m.duration, m.requestSize, m.responseSize, m.inFlight)
+
+	return m
+}
+
+// countingReader counts the bytes read from the request body
+type countingReader struct {
+	io.ReadCloser
+	n int
+}
+
+func (r *countingReader) Read(b []byte) (int, error) {
+	n, err := r.ReadCloser.Read(b)
+	r.n += n
+	return n, err
+}
+
+// Metrics records the requests in m, labelled by chi route pattern rather than path.
+// It must be used on a chi router, before Compress to measure the compressed responses.
+func Metrics(m *HTTPMetrics) func(http.Handler) http.Handler {
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			route := RoutePattern(r)
+			if route == "" {
+				route = unmatchedRoute
+			}
+
+			var body *countingReader
+			if r.Body != nil && r.Body != http.NoBody {
+				body = &countingReader{ReadCloser: r.Body}
+				r.Body = body
+			}
+
+			m.inFlight.Add(1)
+			startTime := time.Now()
+			naw := newAugmentedResponseWriter(w)
+
+			defer func() {
+				m.inFlight.Add(-1)
+
+				statusLabel := strconv.Itoa(naw.status())
+
+				m.requests.Inc(r.Method, route, statusLabel)
+				m.duration.Observe(time.Since(startTime).Seconds(), r.Method, route, statusLabel)
+				size := 0
+				if body != nil {
+					size = body.n
+				}
+				m.requestSize.Observe(float64(size), r.Method, route)
+				m.responseSize.Observe(float64(naw.length), r.Method, route)
+			}()
+
+			h.ServeHTTP(naw, r)
+		})
+	}
+}
@@ -0,0 +1,129 @@
+package mid
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi"
+	"github.com/sirupsen/logrus/hooks/test"
+
+	"github.com/vincentserpoul/gorestarter/pkg/metrics"
+)
+
+func TestMetrics(t *testing.T) {
+	reg := metrics.NewRegistry()
+	r := chi.NewRouter()
+	r.Use(Metrics(NewHTTPMetrics(reg)))
+	r.Route("/v1", func(r chi.Router) {
+		r.Get("/resource/{id}", func(w http.ResponseWriter, req *http.Request) {
+			_, _ = w.Write([]byte("hello"))
+		})
+		r.Post("/resource", func(w http.ResponseWriter, req *http.Request) {
+			_, _ = ioutil.ReadAll(req.Body)
+			w.WriteHeader(http.StatusCreated)
+		})
+	})
+
+	requests := []struct {
+		method string
+		path   string
+		body   string
+	}{
+		{method: http.MethodGet, path: "/v1/resource/1"},
+		{method: http.MethodGet, path: "/v1/resource/2"},
+		{method: http.MethodPost, path: "/v1/resource", body: `{"name":"one"}`},
+		{method: http.MethodGet, path: "/unknown/1"},
+		{method: http.MethodGet, path: "/unknown/2"},
+	}
+	for _, request := range requests {
+		req, _ := http.NewRequest(request.method, request.path, strings.NewReader(request.body))
+		if request.body == "" {
+			req.Body = http.NoBody
+		}
+		r.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	var buf bytes.Buffer
+	if err := reg.Collect(&buf); err != nil {
+		t.Fatalf("Collect() error = %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		expected string
+	}{
+		{
+			name:     "requests by route pattern",
+			expected: `http_requests_total{method="GET",route="/v1/resource/{id}",status="200"} 2`,
+		},
+		{
+			name:     "status",
+			expected: `http_requests_total{method="POST",route="/v1/resource",status="201"} 1`,
+		},
+		{
+			name:     "unmatched routes",
+			expected: `http_requests_total{method="GET",route="unmatched",status="404"} 2`,
+		},
+		{
+			name:     "duration",
+			expected: `http_request_duration_seconds_count{method="GET",route="/v1/resource/{id}",status="200"} 2`,
+		},
+		{
+			name:     "request size",
+			expected: `http_request_size_bytes_sum{method="POST",route="/v1/resource"} 14`,
+		},
+		{
+			name:     "response size",
+			expected: `http_response_size_bytes_sum{method="GET",route="/v1/resource/{id}"} 10`,
+		},
+		{
+			name:     "in flight",
+			expected: "http_requests_in_flight 0",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if !strings.Contains(buf.String(), tt.expected+"\n") {
+				t.Errorf("expected %q in\n%s", tt.expected, buf.String())
+			}
+		})
+	}
+}
+
+func TestMetricsCompressedSize(t *testing.T) {
+	reg := metrics.NewRegistry()
+	logger, hook := test.NewNullLogger()
+	body := strings.Repeat("compressible ", 100)
+
+	r := chi.NewRouter()
+	r.Use(Logger(logger))
+	r.Use(Metrics(NewHTTPMetrics(reg)))
+	r.Use(Compress(nil))
+	r.Get("/", func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(body))
+	})
+
+	rr := httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	r.ServeHTTP(rr, req)
+
+	var buf bytes.Buffer
+	_ = reg.Collect(&buf)
+	expected := `http_response_size_bytes_sum{method="GET",route="/"} ` + strconv.Itoa(rr.Body.Len()) + "\n"
+	if !strings.Contains(buf.String(), expected) {
+		t.Errorf("expected the compressed size %q in\n%s", expected, buf.String())
+	}
+
+	// The logger still gets the uncompressed length through the metrics writer
+	if hook.LastEntry() == nil || hook.LastEntry().Data["resp_uncompressed_length"] != len(body) {
+		t.Errorf("expected the uncompressed length %d to be logged, got %v", len(body), hook.LastEntry())
+	}
+}
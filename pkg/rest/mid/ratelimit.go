@@ -0,0 +1,271 @@
+package mid
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Limit is a token bucket, refilled with Requests tokens every Period
+type Limit struct {
+	Requests int
+	Period   time.Duration
+	// Burst is the size of the bucket, Requests if 0
+	Burst int
+}
+
+// ParseLimit parses a limit written as "<requests>/<period>", such as "100/1m"
+func ParseLimit(s string) (Limit, error) {
+	parts := strings.SplitN(s, "/", 2)
+	if len(parts) != 2 {
+		return Limit{}, fmt.Errorf("ParseLimit(%s): expected <requests>/<period>", s)
+	}
+	requests, err := strconv.Atoi(strings.TrimSpace(parts[0]))
+	if err != nil || requests <= 0 {
+		return Limit{}, fmt.Errorf("ParseLimit(%s): invalid requests", s)
+	}
+	period, errP := time.ParseDuration(strings.TrimSpace(parts[1]))
+	if errP != nil || period <= 0 {
+		return Limit{}, fmt.Errorf("ParseLimit(%s): invalid period", s)
+	}
+
+	return Limit{Requests: requests, Period: period}, nil
+}
+
+// BucketSize is the number of tokens of a full bucket
+func (l Limit) BucketSize() int {
+	if l.Burst > 0 {
+		return l.Burst
+	}
+	return l.Requests
+}
+
+// rate is the refill rate, in tokens per second
+func (l Limit) rate() float64 {
+	return float64(l.Requests) / l.Period.Seconds()
+}
+
+func (l Limit) enabled() bool {
+	return l.Requests > 0 && l.Period > 0
+}
+
+// RateLimitResult is the outcome of taking a token
+type RateLimitResult struct {
+	Allowed   bool
+	Limit     int
+	Remaining int
+	// Reset is the time until the bucket is full again
+	Reset time.Duration
+	// RetryAfter is the time until a token is available, when not allowed
+	RetryAfter time.Duration
+}
+
+// Bucket is the state of a token bucket, which stores persist
+type Bucket struct {
+	Tokens float64
+	Time   time.Time
+}
+
+// Take refills the bucket up to now and takes a token from it, if there is one
+func (b *Bucket) Take(l Limit, now time.Time) RateLimitResult {
+	burst := float64(l.BucketSize())
+	rate := l.rate()
+
+	if b.Time.IsZero() {
+		b.Tokens = burst
+		b.Time = now
+	}
+	// Clocks of several replicas may disagree, time never goes backward
+	if elapsed := now.Sub(b.Time); elapsed > 0 {
+		b.Tokens = math.Min(burst, b.Tokens+elapsed.Seconds()*rate)
+		b.Time = now
+	}
+
+	res := RateLimitResult{Limit: l.BucketSize()}
+	if b.Tokens >= 1 {
+		b.Tokens--
+		res.Allowed = true
+	} else {
+		res.RetryAfter = seconds((1 - b.Tokens) / rate)
+	}
+	res.Remaining = int(b.Tokens)
+	res.Reset = seconds((burst - b.Tokens) / rate)
+
+	return res
+}
+
+func seconds(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second))
+}
+
+// RateLimitStore keeps the buckets, shared stores allow several replicas to share limits
+type RateLimitStore interface {
+	Take(ctx context.Context, key string, limit Limit) (RateLimitResult, error)
+}
+
+// MemoryRateLimitStore keeps the buckets in memory, for a single instance
+type MemoryRateLimitStore struct {
+	mu      sync.Mutex
+	buckets map[string]*memoryBucket
+	swept   time.Time
+}
+
+type memoryBucket struct {
+	Bucket
+	limit Limit
+}
+
+// memorySweepInterval is how often full buckets are removed
+const memorySweepInterval = time.Minute
+
+// NewMemoryRateLimitStore returns an empty MemoryRateLimitStore
+func NewMemoryRateLimitStore() *MemoryRateLimitStore {
+	return &MemoryRateLimitStore{buckets: make(map[string]*memoryBucket)}
+}
+
+// Take implements RateLimitStore
+func (s *MemoryRateLimitStore) Take(ctx context.Context, key string, limit Limit) (RateLimitResult, error) {
+	now := time.Now()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if now.Sub(s.swept) > memorySweepInterval {
+		s.sweep(now)
+	}
+
+	b, ok := s.buckets[key]
+	if !ok {
+		b = &memoryBucket{}
+		s.buckets[key] = b
+	}
+	b.limit = limit
+
+	return b.Take(limit, now), nil
+}
+
+// sweep removes the buckets which are full by now, they are the same as new ones
+func (s *MemoryRateLimitStore) sweep(now time.Time) {
+	for key, b := range s.buckets {
+		full := float64(b.limit.BucketSize()) - b.Tokens
+		if now.Sub(b.Time) >= seconds(full/b.limit.rate()) {
+			delete(s.buckets, key)
+		}
+	}
+	s.swept = now
+}
+
+// RateLimitKeyFunc returns the caller a request is counted against
+type RateLimitKeyFunc func(r *http.Request) string
+
+// KeyByIP counts requests per client IP, the peer address unless RealIP trusts its headers
+func KeyByIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return "ip:" + r.RemoteAddr
+	}
+	return "ip:" + host
+}
+
+// KeyByAPIKey counts requests per API key, per client IP without one
+func KeyByAPIKey(r *http.Request) string {
+	if prefix := GetAPIKeyPrefix(r.Context()); prefix != "" {
+		return "apikey:" + prefix
+	}
+	return KeyByIP(r)
+}
+
+// KeyByUser counts requests per authenticated principal, per client IP without one
+func KeyByUser(r *http.Request) string {
+	if p := GetPrincipal(r.Context()); p != nil && p.ID != "" {
+		return "user:" + p.Method + ":" + p.Tenant + ":" + p.ID
+	}
+	return KeyByIP(r)
+}
+
+// RateLimitConf is the configuration of the RateLimit middleware
+type RateLimitConf struct {
+	// Store keeps the buckets, in memory if nil
+	Store RateLimitStore
+	// Key identifies the caller, KeyByIP if nil
+	Key RateLimitKeyFunc
+	// Default is the limit of every route, none if zero
+	Default Limit
+	// Routes overrides Default, keyed by route pattern ("/v1/resourceone")
+	// or method and route pattern ("POST /v1/resourceone"), case insensitive.
+	// Each route has its own buckets.
+	Routes map[string]Limit
+}
+
+// RateLimit limits the request rate of each caller with token buckets.
+// It sends the RateLimit-Limit, RateLimit-Remaining and RateLimit-Reset headers,
+// rejected requests get a 429 with Retry-After.
+// Store errors let the request through, the error is logged.
+// It must be used on a chi router, after the authentication middlewares to key by caller.
+func RateLimit(conf *RateLimitConf) func(http.Handler) http.Handler {
+	if conf == nil {
+		conf = &RateLimitConf{}
+	}
+	store := conf.Store
+	if store == nil {
+		store = NewMemoryRateLimitStore()
+	}
+	key := conf.Key
+	if key == nil {
+		key = KeyByIP
+	}
+	routes := make(map[string]Limit, len(conf.Routes))
+	for route, l := range conf.Routes {
+		routes[strings.ToLower(route)] = l
+	}
+
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			limit, bucket := conf.Default, ""
+			if pattern := strings.ToLower(RoutePattern(r)); pattern != "" {
+				if l, ok := routes[strings.ToLower(r.Method)+" "+pattern]; ok {
+					limit, bucket = l, strings.ToLower(r.Method)+" "+pattern+" "
+				} else if l, ok := routes[pattern]; ok {
+					limit, bucket = l, pattern+" "
+				}
+			}
+			if !limit.enabled() {
+				h.ServeHTTP(w, r)
+				return
+			}
+
+			res, err := store.Take(r.Context(), bucket+key(r), limit)
+			if err != nil {
+				*r = *r.WithContext(context.WithValue(r.Context(), ErrRequestContextKey,
+					fmt.Errorf("RateLimit: %v", err)))
+				h.ServeHTTP(w, r)
+				return
+			}
+
+			w.Header().Set("RateLimit-Limit", strconv.Itoa(res.Limit))
+			w.Header().Set("RateLimit-Remaining", strconv.Itoa(res.Remaining))
+			w.Header().Set("RateLimit-Reset", strconv.Itoa(ceilSeconds(res.Reset)))
+			w.Header().Set("RateLimit-Policy",
+				fmt.Sprintf("%d;w=%d", limit.BucketSize(), ceilSeconds(limit.Period)))
+
+			if !res.Allowed {
+				w.Header().Set("Retry-After", strconv.Itoa(ceilSeconds(res.RetryAfter)))
+				renderError(w, r, http.StatusTooManyRequests, "Too many requests.",
+					fmt.Errorf("RateLimit: %d requests per %s exceeded", limit.Requests, limit.Period))
+				return
+			}
+
+			h.ServeHTTP(w, r)
+		})
+	}
+}
+
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
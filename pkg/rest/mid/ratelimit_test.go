@@ -0,0 +1,260 @@
+package mid
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/sirupsen/logrus/hooks/test"
+)
+
+func TestParseLimit(t *testing.T) {
+	tc := []struct {
+		in       string
+		expected Limit
+		wantErr  bool
+	}{
+		{in: "100/1m", expected: Limit{Requests: 100, Period: time.Minute}},
+		{in: " 5 / 10s ", expected: Limit{Requests: 5, Period: 10 * time.Second}},
+		{in: "100", wantErr: true},
+		{in: "0/1m", wantErr: true},
+		{in: "10/minute", wantErr: true},
+	}
+
+	for _, tt := range tc {
+		t.Run(tt.in, func(t *testing.T) {
+			l, err := ParseLimit(tt.in)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ParseLimit(%s) error = %v, wantErr %v", tt.in, err, tt.wantErr)
+				return
+			}
+			if l != tt.expected {
+				t.Errorf("ParseLimit(%s) = %+v, expected %+v", tt.in, l, tt.expected)
+			}
+		})
+	}
+}
+
+func TestBucketTake(t *testing.T) {
+	l := Limit{Requests: 2, Period: 2 * time.Second, Burst: 3}
+	now := time.Now()
+	b := &Bucket{}
+
+	tc := []struct {
+		name              string
+		after             time.Duration
+		expectedAllowed   bool
+		expectedRemaining int
+		expectedRetry     time.Duration
+	}{
+		{name: "full bucket", expectedAllowed: true, expectedRemaining: 2},
+		{name: "burst", expectedAllowed: true, expectedRemaining: 1},
+		{name: "last token", expectedAllowed: true, expectedRemaining: 0},
+		{name: "empty bucket", expectedAllowed: false, expectedRemaining: 0, expectedRetry: time.Second},
+		{name: "refilled", after: time.Second, expectedAllowed: true, expectedRemaining: 0},
+		{name: "half refilled", after: 500 * time.Millisecond, expectedAllowed: false, expectedRemaining: 0, expectedRetry: 500 * time.Millisecond},
+		{name: "clock going backward", after: time.Second, expectedAllowed: false, expectedRemaining: 0, expectedRetry: 500 * time.Millisecond},
+		{name: "capped by burst", after: time.Hour, expectedAllowed: true, expectedRemaining: 2},
+	}
+
+	for _, tt := range tc {
+		t.Run(tt.name, func(t *testing.T) {
+			now = now.Add(tt.after)
+			if tt.name == "clock going backward" {
+				now = now.Add(-2 * tt.after)
+			}
+			res := b.Take(l, now)
+			if res.Allowed != tt.expectedAllowed || res.Remaining != tt.expectedRemaining {
+				t.Errorf("expected allowed %t and %d remaining, got %+v",
+					tt.expectedAllowed, tt.expectedRemaining, res)
+			}
+			if res.RetryAfter != tt.expectedRetry {
+				t.Errorf("expected a retry after %s, got %s", tt.expectedRetry, res.RetryAfter)
+			}
+			if res.Limit != 3 {
+				t.Errorf("expected a limit of 3, got %d", res.Limit)
+			}
+		})
+	}
+}
+
+func TestMemoryRateLimitStoreSweep(t *testing.T) {
+	s := NewMemoryRateLimitStore()
+	l := Limit{Requests: 10, Period: time.Second}
+	_, _ = s.Take(context.Background(), "a", l)
+
+	s.sweep(time.Now().Add(time.Second))
+	if len(s.buckets) != 0 {
+		t.Errorf("expected refilled buckets to be swept")
+	}
+}
+
+type failingStore struct{}
+
+func (failingStore) Take(ctx context.Context, key string, limit Limit) (RateLimitResult, error) {
+	return RateLimitResult{}, errors.New("store down")
+}
+
+func TestRateLimit(t *testing.T) {
+	tc := []struct {
+		name string
+		conf *RateLimitConf
+		// requests are sent in order, the last one is checked
+		requests          []string
+		remoteAddr        string
+		expectedStatus    int
+		expectedRemaining string
+		expectedReset     string
+		expectedRetry     string
+	}{
+		{
+			name:              "within the limit",
+			conf:              &RateLimitConf{Default: Limit{Requests: 2, Period: time.Minute}},
+			requests:          []string{"GET /v1/resource"},
+			expectedStatus:    http.StatusOK,
+			expectedRemaining: "1",
+			expectedReset:     "30",
+		},
+		{
+			name:              "limit exceeded",
+			conf:              &RateLimitConf{Default: Limit{Requests: 2, Period: time.Minute}},
+			requests:          []string{"GET /v1/resource", "GET /v1/resource/1", "GET /v1/resource"},
+			expectedStatus:    http.StatusTooManyRequests,
+			expectedRemaining: "0",
+			expectedReset:     "60",
+			expectedRetry:     "30",
+		},
+		{
+			name:              "other client",
+			conf:              &RateLimitConf{Default: Limit{Requests: 1, Period: time.Minute}},
+			requests:          []string{"GET /v1/resource", "GET /v1/resource"},
+			remoteAddr:        "10.0.0.2:1234",
+			expectedStatus:    http.StatusOK,
+			expectedRemaining: "0",
+		},
+		{
+			name: "route limit",
+			conf: &RateLimitConf{
+				Default: Limit{Requests: 1, Period: time.Minute},
+				Routes:  map[string]Limit{"POST /v1/resource": {Requests: 10, Period: time.Minute}},
+			},
+			requests:          []string{"GET /v1/resource", "POST /v1/resource"},
+			expectedStatus:    http.StatusOK,
+			expectedRemaining: "9",
+		},
+		{
+			name: "route limit exceeded",
+			conf: &RateLimitConf{
+				Routes: map[string]Limit{"/v1/resource/{ID}": {Requests: 1, Period: time.Second}},
+			},
+			requests:          []string{"GET /v1/resource", "GET /v1/resource/1", "GET /v1/resource/2"},
+			expectedStatus:    http.StatusTooManyRequests,
+			expectedRemaining: "0",
+			expectedRetry:     "1",
+		},
+		{
+			name:           "no limit",
+			conf:           nil,
+			requests:       []string{"GET /v1/resource", "GET /v1/resource"},
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "store failure",
+			conf:           &RateLimitConf{Store: failingStore{}, Default: Limit{Requests: 1, Period: time.Minute}},
+			requests:       []string{"GET /v1/resource"},
+			expectedStatus: http.StatusOK,
+		},
+	}
+
+	for _, tt := range tc {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := func(w http.ResponseWriter, req *http.Request) {}
+
+			r := chi.NewRouter()
+			r.Use(RateLimit(tt.conf))
+			r.Route("/v1", func(r chi.Router) {
+				r.Get("/resource", handler)
+				r.Post("/resource", handler)
+				r.Get("/resource/{id}", handler)
+			})
+
+			var rr *httptest.ResponseRecorder
+			for i, request := range tt.requests {
+				parts := strings.SplitN(request, " ", 2)
+				req, _ := http.NewRequest(parts[0], parts[1], nil)
+				req.RemoteAddr = "10.0.0.1:1234"
+				if i == len(tt.requests)-1 && tt.remoteAddr != "" {
+					req.RemoteAddr = tt.remoteAddr
+				}
+				rr = httptest.NewRecorder()
+				r.ServeHTTP(rr, req)
+			}
+
+			if rr.Code != tt.expectedStatus {
+				t.Errorf("expected status %d, got %d", tt.expectedStatus, rr.Code)
+				return
+			}
+			if rr.Header().Get("RateLimit-Remaining") != tt.expectedRemaining {
+				t.Errorf("expected RateLimit-Remaining %q, got %q",
+					tt.expectedRemaining, rr.Header().Get("RateLimit-Remaining"))
+			}
+			if tt.expectedReset != "" && rr.Header().Get("RateLimit-Reset") != tt.expectedReset {
+				t.Errorf("expected RateLimit-Reset %q, got %q",
+					tt.expectedReset, rr.Header().Get("RateLimit-Reset"))
+			}
+			if rr.Header().Get("Retry-After") != tt.expectedRetry {
+				t.Errorf("expected Retry-After %q, got %q", tt.expectedRetry, rr.Header().Get("Retry-After"))
+			}
+		})
+	}
+}
+
+func TestRateLimitKeys(t *testing.T) {
+	req, _ := http.NewRequest(http.MethodGet, "/", nil)
+	req.RemoteAddr = "10.0.0.1:1234"
+
+	withKey := req.WithContext(context.WithValue(req.Context(), contextKeyAPIKeyPrefix, "abc"))
+	withUser := req.WithContext(WithPrincipal(req.Context(), &Principal{ID: "user1", Method: "jwt", Tenant: "acme"}))
+
+	tc := []struct {
+		name     string
+		key      RateLimitKeyFunc
+		req      *http.Request
+		expected string
+	}{
+		{name: "ip", key: KeyByIP, req: req, expected: "ip:10.0.0.1"},
+		{name: "apikey", key: KeyByAPIKey, req: withKey, expected: "apikey:abc"},
+		{name: "apikey without key", key: KeyByAPIKey, req: req, expected: "ip:10.0.0.1"},
+		{name: "user", key: KeyByUser, req: withUser, expected: "user:jwt:acme:user1"},
+		{name: "user without principal", key: KeyByUser, req: req, expected: "ip:10.0.0.1"},
+	}
+
+	for _, tt := range tc {
+		t.Run(tt.name, func(t *testing.T) {
+			if k := tt.key(tt.req); k != tt.expected {
+				t.Errorf("expected key %q, got %q", tt.expected, k)
+			}
+		})
+	}
+}
+
+func TestRateLimitLogged(t *testing.T) {
+	logger, hook := test.NewNullLogger()
+
+	r := chi.NewRouter()
+	r.Use(Logger(logger))
+	r.Use(RateLimit(&RateLimitConf{Store: failingStore{}, Default: Limit{Requests: 1, Period: time.Minute}}))
+	r.Get("/", func(w http.ResponseWriter, req *http.Request) {})
+
+	req, _ := http.NewRequest(http.MethodGet, "/", nil)
+	r.ServeHTTP(httptest.NewRecorder(), req)
+
+	if hook.LastEntry() == nil || hook.LastEntry().Message != "RateLimit: store down" {
+		t.Errorf("expected the store error to be logged")
+	}
+}
@@ -0,0 +1,92 @@
+package mid
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// ParseCIDRs parses the CIDRs of trusted proxies, a single IP is a CIDR of its own
+func ParseCIDRs(cidrs []string) ([]*net.IPNet, error) {
+	nets := make([]*net.IPNet, 0, len(cidrs))
+	for _, c := range cidrs {
+		if !strings.Contains(c, "/") {
+			ip := net.ParseIP(c)
+			if ip == nil {
+				return nil, fmt.Errorf("ParseCIDRs(%s): invalid IP", c)
+			}
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, n, err := net.ParseCIDR(c)
+		if err != nil {
+			return nil, fmt.Errorf("ParseCIDRs(%s): %v", c, err)
+		}
+		nets = append(nets, n)
+	}
+
+	return nets, nil
+}
+
+// RealIP sets the RemoteAddr of the requests coming from trustedProxies to the client
+// IP they forward, in X-Forwarded-For or X-Real-IP. The headers of the other peers
+// are ignored, anyone could set them to be counted as someone else by the rate limits.
+func RealIP(trustedProxies []*net.IPNet) func(http.Handler) http.Handler {
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if peer := peerIP(r); peer != nil && trusted(trustedProxies, peer) {
+				if ip := forwardedIP(trustedProxies, r); ip != "" {
+					r.RemoteAddr = ip
+				}
+			}
+
+			h.ServeHTTP(w, r)
+		})
+	}
+}
+
+// peerIP returns the IP of the peer of the connection
+func peerIP(r *http.Request) net.IP {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return net.ParseIP(host)
+}
+
+// forwardedIP returns the client IP forwarded by the proxies: the last address of
+// X-Forwarded-For which isn't a trusted proxy, as the first ones can be set by the client
+func forwardedIP(trustedProxies []*net.IPNet, r *http.Request) string {
+	if xff := r.Header.Get("X-Forwarded-For"); xff != "" {
+		hops := strings.Split(xff, ",")
+		for i := len(hops) - 1; i >= 0; i-- {
+			ip := net.ParseIP(strings.TrimSpace(hops[i]))
+			if ip == nil {
+				return ""
+			}
+			if i == 0 || !trusted(trustedProxies, ip) {
+				return ip.String()
+			}
+		}
+	}
+
+	if ip := net.ParseIP(strings.TrimSpace(r.Header.Get("X-Real-IP"))); ip != nil {
+		return ip.String()
+	}
+
+	return ""
+}
+
+func trusted(trustedProxies []*net.IPNet, ip net.IP) bool {
+	for _, n := range trustedProxies {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
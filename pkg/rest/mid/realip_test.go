@@ -0,0 +1,108 @@
+package mid
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRealIP(t *testing.T) {
+	proxies, err := ParseCIDRs([]string{"10.0.0.0/8", "192.168.1.1"})
+	if err != nil {
+		t.Fatalf("ParseCIDRs() error = %v", err)
+	}
+
+	tc := []struct {
+		name       string
+		remoteAddr string
+		headers    map[string]string
+		expected   string
+	}{
+		{
+			name:       "direct client",
+			remoteAddr: "203.0.113.7:4242",
+			expected:   "203.0.113.7:4242",
+		},
+		{
+			name:       "forged X-Forwarded-For",
+			remoteAddr: "203.0.113.7:4242",
+			headers:    map[string]string{"X-Forwarded-For": "198.51.100.1"},
+			expected:   "203.0.113.7:4242",
+		},
+		{
+			name:       "forged X-Real-IP",
+			remoteAddr: "203.0.113.7:4242",
+			headers:    map[string]string{"X-Real-IP": "198.51.100.1"},
+			expected:   "203.0.113.7:4242",
+		},
+		{
+			name:       "trusted proxy",
+			remoteAddr: "10.1.2.3:4242",
+			headers:    map[string]string{"X-Forwarded-For": "198.51.100.1"},
+			expected:   "198.51.100.1",
+		},
+		{
+			name:       "trusted proxies chain, the first hop set by the client",
+			remoteAddr: "10.1.2.3:4242",
+			headers:    map[string]string{"X-Forwarded-For": "1.2.3.4, 198.51.100.1, 192.168.1.1"},
+			expected:   "198.51.100.1",
+		},
+		{
+			name:       "trusted proxy with X-Real-IP",
+			remoteAddr: "192.168.1.1:4242",
+			headers:    map[string]string{"X-Real-IP": "198.51.100.1"},
+			expected:   "198.51.100.1",
+		},
+		{
+			name:       "trusted proxy with an invalid header",
+			remoteAddr: "10.1.2.3:4242",
+			headers:    map[string]string{"X-Forwarded-For": "unknown"},
+			expected:   "10.1.2.3:4242",
+		},
+	}
+
+	for _, tt := range tc {
+		t.Run(tt.name, func(t *testing.T) {
+			var remoteAddr string
+			h := RealIP(proxies)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				remoteAddr = r.RemoteAddr
+			}))
+
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.RemoteAddr = tt.remoteAddr
+			for k, v := range tt.headers {
+				req.Header.Set(k, v)
+			}
+			h.ServeHTTP(httptest.NewRecorder(), req)
+
+			if remoteAddr != tt.expected {
+				t.Errorf("expected RemoteAddr %q, got %q", tt.expected, remoteAddr)
+			}
+		})
+	}
+}
+
+func TestParseCIDRs(t *testing.T) {
+	tests := []struct {
+		name    string
+		cidrs   []string
+		wantErr bool
+	}{
+		{name: "cidrs and ips", cidrs: []string{"10.0.0.0/8", "::1", "fd00::/8", "127.0.0.1"}},
+		{name: "invalid ip", cidrs: []string{"10.0.0"}, wantErr: true},
+		{name: "invalid cidr", cidrs: []string{"10.0.0.0/33"}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			nets, err := ParseCIDRs(tt.cidrs)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ParseCIDRs() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if err == nil && len(nets) != len(tt.cidrs) {
+				t.Errorf("expected %d networks, got %d", len(tt.cidrs), len(nets))
+			}
+		})
+	}
+}
@@ -11,24 +11,140 @@ const (
 	contextKeyRequestID = ContextKey("requestID")
 )
 
-// RequestID adds a requestID to the request context
-// ksuid is a unique global id that is orderable by time (a step up normal uuid)
-func RequestID() func(http.Handler) http.Handler {
+// RequestIDHeader is the header the request ID is echoed in and sent to other services
+const RequestIDHeader = "X-Request-ID"
+
+// RequestIDConf is the configuration of the RequestID middleware
+type RequestIDConf struct {
+	// Headers are the headers an upstream request ID is read from, in order.
+	// The ID is echoed in the first one.
+	Headers []string
+	// MaxLength is the maximum length of an upstream request ID, longer ones are replaced
+	MaxLength int
+}
+
+// DefaultRequestIDConf returns a configuration accepting the common request ID headers
+func DefaultRequestIDConf() *RequestIDConf {
+	return &RequestIDConf{
+		Headers:   []string{RequestIDHeader, "X-Correlation-ID"},
+		MaxLength: 128,
+	}
+}
+
+// EchoHeader returns the header the request ID is echoed in, the first of Headers
+func (conf *RequestIDConf) EchoHeader() string {
+	if conf == nil || len(conf.Headers) == 0 {
+		return RequestIDHeader
+	}
+	return conf.Headers[0]
+}
+
+// RequestID adds a request ID to the request context and echoes it in the response.
+// The ID set by an upstream gateway is kept if valid, otherwise a ksuid is generated,
+// a unique global id that is orderable by time (a step up normal uuid).
+func RequestID(conf *RequestIDConf) func(http.Handler) http.Handler {
+	if conf == nil {
+		conf = DefaultRequestIDConf()
+	}
+	echo := conf.EchoHeader()
+
 	return func(h http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			requestID := ksuid.New()
-			w.Header().Add("requestID", requestID.String())
+			requestID := ""
+			for _, header := range conf.Headers {
+				if id := r.Header.Get(header); validRequestID(id, conf.MaxLength) {
+					requestID = id
+					break
+				}
+			}
+			if requestID == "" {
+				requestID = ksuid.New().String()
+			}
+
+			w.Header().Set(echo, requestID)
 			ctx := context.WithValue(r.Context(), contextKeyRequestID, requestID)
 			h.ServeHTTP(w, r.WithContext(ctx))
 		})
 	}
 }
 
+// validRequestID accepts the IDs made of letters, digits, '-', '_' and '.',
+// so that they are safe in logs, headers and SQL comments
+func validRequestID(id string, maxLength int) bool {
+	if id == "" || (maxLength > 0 && len(id) > maxLength) {
+		return false
+	}
+	for _, c := range id {
+		if !(c >= 'a' && c <= 'z') && !(c >= 'A' && c <= 'Z') && !(c >= '0' && c <= '9') &&
+			c != '-' && c != '_' && c != '.' {
+			return false
+		}
+	}
+	return true
+}
+
 // GetRequestID will retrieve the request id from the context if there is one
-func GetRequestID(ctx context.Context) ksuid.KSUID {
-	if reqID, ok := ctx.Value(contextKeyRequestID).(ksuid.KSUID); ok {
+func GetRequestID(ctx context.Context) string {
+	if reqID, ok := ctx.Value(contextKeyRequestID).(string); ok {
 		return reqID
 	}
 
-	return ksuid.Nil
+	return ""
+}
+
+// detachRequestID returns a context that is never cancelled, holding the request ID of ctx,
+// for the work a request starts but which must outlive it
+func detachRequestID(ctx context.Context) context.Context {
+	detached := context.Background()
+	if reqID := GetRequestID(ctx); reqID != "" {
+		detached = context.WithValue(detached, contextKeyRequestID, reqID)
+	}
+
+	return detached
+}
+
+// SQLComment prefixes query with a comment holding the request ID of ctx, if any,
+// so that the queries can be correlated with the requests on the DB side
+func SQLComment(ctx context.Context, query string) string {
+	reqID := GetRequestID(ctx)
+	if reqID == "" {
+		return query
+	}
+
+	return "/* request_id=" + reqID + " */ " + query
+}
+
+// requestIDTransport sends the request ID of the context to other services
+type requestIDTransport struct {
+	header string
+	base   http.RoundTripper
+}
+
+// NewRequestIDTransport returns a transport setting the header the RequestID middleware
+// echoes the ID in, the first of conf, on the outbound requests to the request ID of
+// their context, with base sending them, http.DefaultTransport if nil
+func NewRequestIDTransport(conf *RequestIDConf, base http.RoundTripper) http.RoundTripper {
+	header := conf.EchoHeader()
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return &requestIDTransport{header: header, base: base}
+}
+
+// RoundTrip sets the header on a copy of the request, which must not be modified
+func (t *requestIDTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	reqID := GetRequestID(r.Context())
+	if reqID == "" || r.Header.Get(t.header) != "" {
+		return t.base.RoundTrip(r)
+	}
+
+	r2 := new(http.Request)
+	*r2 = *r
+	r2.Header = make(http.Header, len(r.Header)+1)
+	for k, v := range r.Header {
+		r2.Header[k] = v
+	}
+	r2.Header.Set(t.header, reqID)
+
+	return t.base.RoundTrip(r2)
 }
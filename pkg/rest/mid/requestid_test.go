@@ -4,45 +4,173 @@ import (
 	"context"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 
 	"github.com/segmentio/ksuid"
 )
 
 func TestRequestID(t *testing.T) {
-	fakeHandler := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {})
-	midWared := RequestID()(fakeHandler)
-	rr := httptest.NewRecorder()
-	request, errR := http.NewRequest("GET", ``, nil)
-	if errR != nil {
-		t.Fatalf("request creation failed %v", errR)
+	tests := []struct {
+		name       string
+		conf       *RequestIDConf
+		headers    map[string]string
+		expectedID string
+		echoHeader string
+	}{
+		{
+			name:       "generated",
+			echoHeader: "X-Request-ID",
+		},
+		{
+			name:       "from X-Request-ID",
+			headers:    map[string]string{"X-Request-ID": "gateway-1.a_b"},
+			expectedID: "gateway-1.a_b",
+			echoHeader: "X-Request-ID",
+		},
+		{
+			name:       "from X-Correlation-ID",
+			headers:    map[string]string{"X-Correlation-ID": "correlation-1"},
+			expectedID: "correlation-1",
+			echoHeader: "X-Request-ID",
+		},
+		{
+			name:       "first header first",
+			headers:    map[string]string{"X-Request-ID": "request-1", "X-Correlation-ID": "correlation-1"},
+			expectedID: "request-1",
+			echoHeader: "X-Request-ID",
+		},
+		{
+			name:       "invalid characters",
+			headers:    map[string]string{"X-Request-ID": "id */ DROP TABLE"},
+			echoHeader: "X-Request-ID",
+		},
+		{
+			name:       "invalid falls back on the next header",
+			headers:    map[string]string{"X-Request-ID": "id:1", "X-Correlation-ID": "correlation-1"},
+			expectedID: "correlation-1",
+			echoHeader: "X-Request-ID",
+		},
+		{
+			name:       "too long",
+			headers:    map[string]string{"X-Request-ID": strings.Repeat("a", 129)},
+			echoHeader: "X-Request-ID",
+		},
+		{
+			name:       "custom headers",
+			conf:       &RequestIDConf{Headers: []string{"X-Trace"}, MaxLength: 4},
+			headers:    map[string]string{"X-Trace": "abcd", "X-Request-ID": "ignored"},
+			expectedID: "abcd",
+			echoHeader: "X-Trace",
+		},
 	}
 
-	midWared.ServeHTTP(rr, request)
-	if rr.Header().Get("requestID") == "" {
-		t.Errorf("expected %s, got nothing", rr.Header().Get("requestID"))
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var reqID string
+			h := RequestID(tt.conf)(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+				reqID = GetRequestID(req.Context())
+			}))
+
+			req, _ := http.NewRequest(http.MethodGet, "/", nil)
+			for k, v := range tt.headers {
+				req.Header.Set(k, v)
+			}
+			rr := httptest.NewRecorder()
+			h.ServeHTTP(rr, req)
+
+			if tt.expectedID == "" {
+				if _, err := ksuid.Parse(reqID); err != nil {
+					t.Errorf("expected a generated ksuid, got %q", reqID)
+				}
+			} else if reqID != tt.expectedID {
+				t.Errorf("expected %q, got %q", tt.expectedID, reqID)
+			}
+			if rr.Header().Get(tt.echoHeader) != reqID {
+				t.Errorf("expected %q echoed in %s, got %q", reqID, tt.echoHeader, rr.Header().Get(tt.echoHeader))
+			}
+		})
 	}
 }
 
 func TestGetRequestID(t *testing.T) {
-	var reqID ksuid.KSUID
+	var reqID string
 
 	ctx := context.Background()
 	reqID = GetRequestID(ctx)
-	if reqID != ksuid.Nil {
+	if reqID != "" {
 		t.Errorf("expected nothing, got %s", reqID)
 	}
 
-	ctx = context.WithValue(ctx, contextKeyRequestID, "testString")
+	ctx = context.WithValue(ctx, contextKeyRequestID, 42)
 	reqID = GetRequestID(ctx)
-	if reqID != ksuid.Nil {
+	if reqID != "" {
 		t.Errorf("expected nothing, got %s", reqID)
 	}
 
-	requestID := ksuid.New()
+	requestID := ksuid.New().String()
 	ctx = context.WithValue(ctx, contextKeyRequestID, requestID)
 	reqID = GetRequestID(ctx)
 	if reqID != requestID {
-		t.Errorf("expected %s, got %s", requestID.String(), reqID)
+		t.Errorf("expected %s, got %s", requestID, reqID)
+	}
+}
+
+func TestSQLComment(t *testing.T) {
+	query := "SELECT 1"
+	if got := SQLComment(context.Background(), query); got != query {
+		t.Errorf("expected the query unchanged without request ID, got %q", got)
+	}
+
+	ctx := context.WithValue(context.Background(), contextKeyRequestID, "abc-1")
+	if got := SQLComment(ctx, query); got != "/* request_id=abc-1 */ SELECT 1" {
+		t.Errorf("expected the request ID comment, got %q", got)
+	}
+}
+
+func TestRequestIDTransport(t *testing.T) {
+	var received string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		received = req.Header.Get("X-Correlation-ID")
+	}))
+	defer srv.Close()
+
+	// The ID is sent in the header it is echoed in
+	client := &http.Client{Transport: NewRequestIDTransport(&RequestIDConf{Headers: []string{"X-Correlation-ID", "X-Request-ID"}}, nil)}
+
+	tests := []struct {
+		name     string
+		ctxID    string
+		header   string
+		expected string
+	}{
+		{name: "without request ID", expected: ""},
+		{name: "request ID propagated", ctxID: "abc-1", expected: "abc-1"},
+		{name: "header kept", ctxID: "abc-1", header: "set-by-caller", expected: "set-by-caller"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, _ := http.NewRequest(http.MethodGet, srv.URL, nil)
+			if tt.ctxID != "" {
+				req = req.WithContext(context.WithValue(req.Context(), contextKeyRequestID, tt.ctxID))
+			}
+			if tt.header != "" {
+				req.Header.Set("X-Correlation-ID", tt.header)
+			}
+
+			resp, err := client.Do(req)
+			if err != nil {
+				t.Fatalf("Do() error = %v", err)
+			}
+			resp.Body.Close()
+
+			if received != tt.expected {
+				t.Errorf("expected %q, got %q", tt.expected, received)
+			}
+			if tt.header == "" && req.Header.Get("X-Correlation-ID") != "" {
+				t.Errorf("expected the request not to be modified")
+			}
+		})
 	}
 }
@@ -0,0 +1,150 @@
+package mid
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"time"
+)
+
+// augmentedResponseWriter records the status, the size and the time to first byte
+// of the response. It keeps the optional interfaces of the wrapped writer:
+// http.Flusher for streams such as SSE, http.Hijacker for WebSockets,
+// http.Pusher for HTTP/2 and io.ReaderFrom for sendfile.
+type augmentedResponseWriter struct {
+	http.ResponseWriter
+	start              time.Time
+	firstByte          time.Time
+	length             int
+	uncompressedLength int
+	httpStatus         int
+	hijacked           bool
+}
+
+func newAugmentedResponseWriter(w http.ResponseWriter) *augmentedResponseWriter {
+	return &augmentedResponseWriter{ResponseWriter: w, start: time.Now()}
+}
+
+// WriteHeader will not only write the header but also save the http status in the struct,
+// only the first final status is kept, as net/http ignores the next ones
+func (w *augmentedResponseWriter) WriteHeader(httpStatus int) {
+	w.ResponseWriter.WriteHeader(httpStatus)
+	if w.httpStatus == 0 && (httpStatus >= http.StatusOK || httpStatus == http.StatusSwitchingProtocols) {
+		w.httpStatus = httpStatus
+		w.sent()
+	}
+}
+
+// Write will not only write b to w but also add its length to the total,
+// without WriteHeader the status is an implicit 200
+func (w *augmentedResponseWriter) Write(b []byte) (int, error) {
+	w.implicitStatus()
+	n, err := w.ResponseWriter.Write(b)
+	w.length += n
+
+	return n, err
+}
+
+// ReadFrom lets the wrapped writer use sendfile, if it can
+func (w *augmentedResponseWriter) ReadFrom(src io.Reader) (int64, error) {
+	w.implicitStatus()
+	var n int64
+	var err error
+	if rf, ok := w.ResponseWriter.(io.ReaderFrom); ok {
+		n, err = rf.ReadFrom(src)
+	} else {
+		n, err = io.Copy(struct{ io.Writer }{w.ResponseWriter}, src)
+	}
+	w.length += int(n)
+
+	return n, err
+}
+
+// Flush sends what has been written so far, with an implicit 200 if nothing was
+func (w *augmentedResponseWriter) Flush() {
+	w.implicitStatus()
+	flush(w.ResponseWriter)
+}
+
+// Hijack takes the connection over, for WebSockets
+func (w *augmentedResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	conn, rw, err := hijack(w.ResponseWriter)
+	if err == nil {
+		w.hijacked = true
+		if w.httpStatus == 0 {
+			w.httpStatus = http.StatusSwitchingProtocols
+			w.sent()
+		}
+	}
+
+	return conn, rw, err
+}
+
+// Push initiates an HTTP/2 server push
+func (w *augmentedResponseWriter) Push(target string, opts *http.PushOptions) error {
+	return push(w.ResponseWriter, target, opts)
+}
+
+// addUncompressedLength is called by Compress with the size of the response before compression,
+// it is passed on to the wrapped augmentedResponseWriter, if any
+func (w *augmentedResponseWriter) addUncompressedLength(n int) {
+	w.uncompressedLength += n
+	if rec, ok := w.ResponseWriter.(uncompressedLengthRecorder); ok {
+		rec.addUncompressedLength(n)
+	}
+}
+
+// status returns the status sent, 200 if the handler wrote nothing,
+// as net/http sends it when the handler returns
+func (w *augmentedResponseWriter) status() int {
+	if w.httpStatus == 0 {
+		return http.StatusOK
+	}
+	return w.httpStatus
+}
+
+// timeToFirstByte returns how long the response took to start, 0 if it didn't
+func (w *augmentedResponseWriter) timeToFirstByte() time.Duration {
+	if w.firstByte.IsZero() {
+		return 0
+	}
+	return w.firstByte.Sub(w.start)
+}
+
+func (w *augmentedResponseWriter) implicitStatus() {
+	if w.httpStatus == 0 {
+		w.httpStatus = http.StatusOK
+		w.sent()
+	}
+}
+
+func (w *augmentedResponseWriter) sent() {
+	if w.firstByte.IsZero() {
+		w.firstByte = time.Now()
+	}
+}
+
+// flush flushes w, if it can
+func flush(w http.ResponseWriter) {
+	if f, ok := w.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// hijack hijacks the connection of w, if it can
+func hijack(w http.ResponseWriter) (net.Conn, *bufio.ReadWriter, error) {
+	if hj, ok := w.(http.Hijacker); ok {
+		return hj.Hijack()
+	}
+	return nil, nil, fmt.Errorf("Hijack: %T is not a http.Hijacker", w)
+}
+
+// push pushes target with w, if it can
+func push(w http.ResponseWriter, target string, opts *http.PushOptions) error {
+	if p, ok := w.(http.Pusher); ok {
+		return p.Push(target, opts)
+	}
+	return http.ErrNotSupported
+}
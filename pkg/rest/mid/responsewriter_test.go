@@ -0,0 +1,196 @@
+package mid
+
+import (
+	"bufio"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/sirupsen/logrus/hooks/test"
+)
+
+func TestAugmentedResponseWriter(t *testing.T) {
+	tests := []struct {
+		name           string
+		write          func(w *augmentedResponseWriter)
+		expectedStatus int
+		expectedLength int
+		expectedTTFB   bool
+		expectedFlush  bool
+	}{
+		{
+			name:           "nothing written",
+			write:          func(w *augmentedResponseWriter) {},
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name: "explicit status",
+			write: func(w *augmentedResponseWriter) {
+				w.WriteHeader(http.StatusCreated)
+				_, _ = w.Write([]byte("abc"))
+				_, _ = w.Write([]byte("de"))
+			},
+			expectedStatus: http.StatusCreated,
+			expectedLength: 5,
+			expectedTTFB:   true,
+		},
+		{
+			name: "implicit 200",
+			write: func(w *augmentedResponseWriter) {
+				_, _ = w.Write([]byte("abc"))
+				w.WriteHeader(http.StatusInternalServerError)
+			},
+			expectedStatus: http.StatusOK,
+			expectedLength: 3,
+			expectedTTFB:   true,
+		},
+		{
+			name: "superfluous status",
+			write: func(w *augmentedResponseWriter) {
+				w.WriteHeader(http.StatusNotFound)
+				w.WriteHeader(http.StatusInternalServerError)
+			},
+			expectedStatus: http.StatusNotFound,
+			expectedTTFB:   true,
+		},
+		{
+			name: "flush",
+			write: func(w *augmentedResponseWriter) {
+				w.Flush()
+			},
+			expectedStatus: http.StatusOK,
+			expectedTTFB:   true,
+			expectedFlush:  true,
+		},
+		{
+			name: "read from",
+			write: func(w *augmentedResponseWriter) {
+				_, _ = io.Copy(w, strings.NewReader("abcdef"))
+			},
+			expectedStatus: http.StatusOK,
+			expectedLength: 6,
+			expectedTTFB:   true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			w := newAugmentedResponseWriter(rr)
+			tt.write(w)
+
+			if w.status() != tt.expectedStatus || w.length != tt.expectedLength {
+				t.Errorf("expected %d with %d bytes, got %d with %d bytes",
+					tt.expectedStatus, tt.expectedLength, w.status(), w.length)
+			}
+			if rr.Body.Len() != tt.expectedLength {
+				t.Errorf("expected %d bytes written, got %d", tt.expectedLength, rr.Body.Len())
+			}
+			if (w.timeToFirstByte() > 0) != tt.expectedTTFB {
+				t.Errorf("expected time to first byte %t, got %s", tt.expectedTTFB, w.timeToFirstByte())
+			}
+			if rr.Flushed != tt.expectedFlush {
+				t.Errorf("expected flushed %t", tt.expectedFlush)
+			}
+		})
+	}
+}
+
+func TestAugmentedResponseWriterUnsupported(t *testing.T) {
+	w := newAugmentedResponseWriter(httptest.NewRecorder())
+
+	if _, _, err := w.Hijack(); err == nil || w.hijacked {
+		t.Errorf("expected an error hijacking a recorder")
+	}
+	if err := w.Push("/style.css", nil); err != http.ErrNotSupported {
+		t.Errorf("expected ErrNotSupported, got %v", err)
+	}
+}
+
+// streamingRouter is a router with the middlewares wrapping the response writer
+func streamingRouter(h http.HandlerFunc) (http.Handler, *test.Hook) {
+	logger, hook := test.NewNullLogger()
+
+	r := chi.NewRouter()
+	r.Use(
+		Logger(logger),
+		Security(DefaultSecurityConf()),
+		Compress(nil),
+		Capture(NewCapturer(&CaptureConf{Routes: []string{"/stream"}})),
+		Timeout(&TimeoutConf{Default: time.Minute}),
+	)
+	r.Get("/stream", h)
+
+	return r, hook
+}
+
+func TestStreaming(t *testing.T) {
+	unblock := make(chan struct{})
+	h, hook := streamingRouter(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		_, _ = w.Write([]byte("data: first\n\n"))
+		w.(http.Flusher).Flush()
+		<-unblock
+		_, _ = w.Write([]byte("data: second\n\n"))
+	})
+	srv := httptest.NewServer(h)
+
+	req, _ := http.NewRequest(http.MethodGet, srv.URL+"/stream", nil)
+	resp, err := http.DefaultTransport.RoundTrip(req)
+	if err != nil {
+		t.Fatalf("RoundTrip() error = %v", err)
+	}
+
+	// The first event arrives while the handler is still running
+	body := bufio.NewReader(resp.Body)
+	line, errR := body.ReadString('\n')
+	close(unblock)
+	if errR != nil || line != "data: first\n" {
+		t.Errorf("expected the first event to be flushed, got %q, %v", line, errR)
+	}
+	_, _ = ioutil.ReadAll(body)
+	resp.Body.Close()
+	srv.Close()
+
+	entry := hook.LastEntry()
+	if entry == nil || entry.Data["resp_uncompressed_length"] != 27 || entry.Data["time_to_first_byte"] == nil {
+		t.Errorf("expected the stream size and time to first byte to be logged, got %v", entry)
+	}
+}
+
+func TestHijack(t *testing.T) {
+	h, hook := streamingRouter(func(w http.ResponseWriter, r *http.Request) {
+		conn, rw, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			t.Errorf("Hijack() error = %v", err)
+			return
+		}
+		defer conn.Close()
+		_, _ = rw.WriteString("HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n\r\n")
+		_ = rw.Flush()
+	})
+	srv := httptest.NewServer(h)
+
+	req, _ := http.NewRequest(http.MethodGet, srv.URL+"/stream", nil)
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", "websocket")
+	resp, err := http.DefaultTransport.RoundTrip(req)
+	if err != nil {
+		t.Fatalf("RoundTrip() error = %v", err)
+	}
+	resp.Body.Close()
+	srv.Close()
+
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Errorf("expected the connection to be upgraded, got %d", resp.StatusCode)
+	}
+	entry := hook.LastEntry()
+	if entry == nil || entry.Data["hijacked"] != true || entry.Data["http_status"] != http.StatusSwitchingProtocols {
+		t.Errorf("expected the hijack to be logged, got %v", entry)
+	}
+}
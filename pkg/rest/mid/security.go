@@ -0,0 +1,187 @@
+package mid
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	contextKeyScheme = ContextKey("scheme")
+)
+
+// HTTPS enforcement modes of SecurityConf
+const (
+	HTTPSRedirect = "redirect"
+	HTTPSReject   = "reject"
+)
+
+// SecurityConf is the configuration of the Security middleware, empty headers are not sent
+type SecurityConf struct {
+	// HSTSMaxAge is the max-age of Strict-Transport-Security, sent over https only, not sent if 0
+	HSTSMaxAge            time.Duration
+	HSTSIncludeSubdomains bool
+	HSTSPreload           bool
+	// ContentTypeOptions is X-Content-Type-Options
+	ContentTypeOptions string
+	// ReferrerPolicy is Referrer-Policy
+	ReferrerPolicy string
+	// FrameOptions is X-Frame-Options
+	FrameOptions string
+	// ContentSecurityPolicy is Content-Security-Policy, sent with HTML responses
+	ContentSecurityPolicy string
+	// TrustForwardedProto uses the X-Forwarded-Proto header to detect https,
+	// only enable it behind a proxy setting it
+	TrustForwardedProto bool
+	// EnforceHTTPS redirects (HTTPSRedirect) or rejects (HTTPSReject) plain-HTTP requests,
+	// only GET and HEAD are redirected, "" to accept them
+	EnforceHTTPS string
+	// HTTPSPort is the port of the redirections, 443 if 0
+	HTTPSPort int
+}
+
+// DefaultSecurityConf returns a configuration with the recommended headers, accepting plain HTTP
+func DefaultSecurityConf() *SecurityConf {
+	return &SecurityConf{
+		HSTSMaxAge:            365 * 24 * time.Hour,
+		HSTSIncludeSubdomains: true,
+		ContentTypeOptions:    "nosniff",
+		ReferrerPolicy:        "no-referrer",
+		FrameOptions:          "DENY",
+		ContentSecurityPolicy: "default-src 'self'; frame-ancestors 'none'; base-uri 'none'",
+	}
+}
+
+// Scheme returns the scheme of the request, https if it came over TLS,
+// or through a proxy trusted by the Security middleware
+func Scheme(r *http.Request) string {
+	if scheme, ok := r.Context().Value(contextKeyScheme).(string); ok {
+		return scheme
+	}
+	if r.TLS != nil {
+		return "https"
+	}
+	return "http"
+}
+
+// Security sets the security headers and enforces HTTPS.
+// It must be used after Logger and before the handlers setting Content-Type.
+func Security(conf *SecurityConf) func(http.Handler) http.Handler {
+	if conf == nil {
+		conf = DefaultSecurityConf()
+	}
+	hsts := ""
+	if conf.HSTSMaxAge > 0 {
+		hsts = "max-age=" + strconv.Itoa(int(conf.HSTSMaxAge.Seconds()))
+		if conf.HSTSIncludeSubdomains {
+			hsts += "; includeSubDomains"
+		}
+		if conf.HSTSPreload {
+			hsts += "; preload"
+		}
+	}
+
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if conf.TrustForwardedProto {
+				proto := strings.ToLower(strings.TrimSpace(r.Header.Get("X-Forwarded-Proto")))
+				if proto == "https" || proto == "http" {
+					// Changing the request in place, so that Logger sees the scheme
+					*r = *r.WithContext(context.WithValue(r.Context(), contextKeyScheme, proto))
+				}
+			}
+			https := Scheme(r) == "https"
+
+			if !https && conf.EnforceHTTPS != "" {
+				if conf.EnforceHTTPS == HTTPSRedirect &&
+					(r.Method == http.MethodGet || r.Method == http.MethodHead) {
+					http.Redirect(w, r, httpsURL(r, conf.HTTPSPort), http.StatusMovedPermanently)
+					return
+				}
+				renderError(w, r, http.StatusForbidden, "HTTPS required.",
+					fmt.Errorf("Security: %s %s over plain HTTP", r.Method, r.URL.Path))
+				return
+			}
+
+			if https && hsts != "" {
+				w.Header().Set("Strict-Transport-Security", hsts)
+			}
+			if conf.ContentTypeOptions != "" {
+				w.Header().Set("X-Content-Type-Options", conf.ContentTypeOptions)
+			}
+			if conf.ReferrerPolicy != "" {
+				w.Header().Set("Referrer-Policy", conf.ReferrerPolicy)
+			}
+			if conf.FrameOptions != "" {
+				w.Header().Set("X-Frame-Options", conf.FrameOptions)
+			}
+			if conf.ContentSecurityPolicy == "" {
+				h.ServeHTTP(w, r)
+				return
+			}
+
+			h.ServeHTTP(&securityResponseWriter{ResponseWriter: w, csp: conf.ContentSecurityPolicy}, r)
+		})
+	}
+}
+
+// httpsURL returns the https URL of the request
+func httpsURL(r *http.Request, port int) string {
+	host := r.Host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	if port != 0 && port != 443 {
+		host = net.JoinHostPort(host, strconv.Itoa(port))
+	}
+
+	return "https://" + host + r.URL.RequestURI()
+}
+
+// securityResponseWriter sets the CSP once the content type of the response is known
+type securityResponseWriter struct {
+	http.ResponseWriter
+	csp         string
+	wroteHeader bool
+}
+
+func (w *securityResponseWriter) WriteHeader(httpStatus int) {
+	if !w.wroteHeader {
+		w.wroteHeader = true
+		if strings.HasPrefix(w.Header().Get("Content-Type"), "text/html") {
+			w.Header().Set("Content-Security-Policy", w.csp)
+		}
+	}
+	w.ResponseWriter.WriteHeader(httpStatus)
+}
+
+func (w *securityResponseWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *securityResponseWriter) Flush() {
+	flush(w.ResponseWriter)
+}
+
+func (w *securityResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return hijack(w.ResponseWriter)
+}
+
+func (w *securityResponseWriter) Push(target string, opts *http.PushOptions) error {
+	return push(w.ResponseWriter, target, opts)
+}
+
+// addUncompressedLength lets Compress reach the logger's response writer
+func (w *securityResponseWriter) addUncompressedLength(n int) {
+	if rec, ok := w.ResponseWriter.(uncompressedLengthRecorder); ok {
+		rec.addUncompressedLength(n)
+	}
+}
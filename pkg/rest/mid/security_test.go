@@ -0,0 +1,176 @@
+package mid
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus/hooks/test"
+)
+
+func TestSecurity(t *testing.T) {
+	tc := []struct {
+		name            string
+		conf            *SecurityConf
+		method          string
+		url             string
+		tls             bool
+		forwardedProto  string
+		contentType     string
+		expectedStatus  int
+		expectedHeaders map[string]string
+	}{
+		{
+			name:           "default headers over http",
+			conf:           nil,
+			method:         http.MethodGet,
+			url:            "http://api.example.com/v1/resource",
+			expectedStatus: http.StatusOK,
+			expectedHeaders: map[string]string{
+				"Strict-Transport-Security": "",
+				"X-Content-Type-Options":    "nosniff",
+				"Referrer-Policy":           "no-referrer",
+				"X-Frame-Options":           "DENY",
+				"Content-Security-Policy":   "",
+			},
+		},
+		{
+			name:           "hsts over https",
+			conf:           &SecurityConf{HSTSMaxAge: time.Hour, HSTSIncludeSubdomains: true, HSTSPreload: true},
+			method:         http.MethodGet,
+			url:            "https://api.example.com/v1/resource",
+			tls:            true,
+			expectedStatus: http.StatusOK,
+			expectedHeaders: map[string]string{
+				"Strict-Transport-Security": "max-age=3600; includeSubDomains; preload",
+				"X-Content-Type-Options":    "",
+			},
+		},
+		{
+			name:           "csp for html",
+			conf:           &SecurityConf{ContentSecurityPolicy: "default-src 'self'"},
+			method:         http.MethodGet,
+			url:            "http://api.example.com/docs",
+			contentType:    "text/html; charset=utf-8",
+			expectedStatus: http.StatusOK,
+			expectedHeaders: map[string]string{
+				"Content-Security-Policy": "default-src 'self'",
+			},
+		},
+		{
+			name:           "redirect to https",
+			conf:           &SecurityConf{EnforceHTTPS: HTTPSRedirect},
+			method:         http.MethodGet,
+			url:            "http://api.example.com:9002/v1/resource?a=b",
+			expectedStatus: http.StatusMovedPermanently,
+			expectedHeaders: map[string]string{
+				"Location": "https://api.example.com/v1/resource?a=b",
+			},
+		},
+		{
+			name:           "redirect to https port",
+			conf:           &SecurityConf{EnforceHTTPS: HTTPSRedirect, HTTPSPort: 9443},
+			method:         http.MethodHead,
+			url:            "http://api.example.com:9002/v1/resource",
+			expectedStatus: http.StatusMovedPermanently,
+			expectedHeaders: map[string]string{
+				"Location": "https://api.example.com:9443/v1/resource",
+			},
+		},
+		{
+			name:           "no redirect of a post",
+			conf:           &SecurityConf{EnforceHTTPS: HTTPSRedirect},
+			method:         http.MethodPost,
+			url:            "http://api.example.com/v1/resource",
+			expectedStatus: http.StatusForbidden,
+		},
+		{
+			name:           "reject http",
+			conf:           &SecurityConf{EnforceHTTPS: HTTPSReject},
+			method:         http.MethodGet,
+			url:            "http://api.example.com/v1/resource",
+			expectedStatus: http.StatusForbidden,
+		},
+		{
+			name:           "trusted forwarded proto",
+			conf:           &SecurityConf{EnforceHTTPS: HTTPSReject, TrustForwardedProto: true, HSTSMaxAge: time.Minute},
+			method:         http.MethodGet,
+			url:            "http://api.example.com/v1/resource",
+			forwardedProto: "https",
+			expectedStatus: http.StatusOK,
+			expectedHeaders: map[string]string{
+				"Strict-Transport-Security": "max-age=60",
+			},
+		},
+		{
+			name:           "untrusted forwarded proto",
+			conf:           &SecurityConf{EnforceHTTPS: HTTPSReject},
+			method:         http.MethodGet,
+			url:            "http://api.example.com/v1/resource",
+			forwardedProto: "https",
+			expectedStatus: http.StatusForbidden,
+		},
+		{
+			name:           "https over tls",
+			conf:           &SecurityConf{EnforceHTTPS: HTTPSReject, TrustForwardedProto: true},
+			method:         http.MethodGet,
+			url:            "https://api.example.com/v1/resource",
+			tls:            true,
+			expectedStatus: http.StatusOK,
+		},
+	}
+
+	for _, tt := range tc {
+		t.Run(tt.name, func(t *testing.T) {
+			h := Security(tt.conf)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if tt.contentType != "" {
+					w.Header().Set("Content-Type", tt.contentType)
+				}
+				_, _ = w.Write([]byte("ok"))
+			}))
+
+			rr := httptest.NewRecorder()
+			req := httptest.NewRequest(tt.method, tt.url, nil)
+			if !tt.tls {
+				req.TLS = nil
+			} else {
+				req.TLS = &tls.ConnectionState{}
+			}
+			if tt.forwardedProto != "" {
+				req.Header.Set("X-Forwarded-Proto", tt.forwardedProto)
+			}
+			h.ServeHTTP(rr, req)
+
+			if rr.Code != tt.expectedStatus {
+				t.Errorf("expected status %d, got %d", tt.expectedStatus, rr.Code)
+				return
+			}
+			for k, v := range tt.expectedHeaders {
+				if rr.Header().Get(k) != v {
+					t.Errorf("expected %s %q, got %q", k, v, rr.Header().Get(k))
+				}
+			}
+		})
+	}
+}
+
+func TestSecurityLoggedScheme(t *testing.T) {
+	logger, hook := test.NewNullLogger()
+
+	h := Logger(logger)(Security(&SecurityConf{TrustForwardedProto: true})(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})))
+
+	req := httptest.NewRequest(http.MethodGet, "http://api.example.com/v1/resource", nil)
+	req.RequestURI = "/v1/resource"
+	req.Header.Set("X-Forwarded-Proto", "https")
+	h.ServeHTTP(httptest.NewRecorder(), req)
+
+	if hook.LastEntry().Data["http_scheme"] != "https" {
+		t.Errorf("expected the forwarded scheme to be logged, got %v", hook.LastEntry().Data["http_scheme"])
+	}
+	if hook.LastEntry().Data["uri"] != "https://api.example.com/v1/resource" {
+		t.Errorf("expected the https uri to be logged, got %v", hook.LastEntry().Data["uri"])
+	}
+}
@@ -0,0 +1,126 @@
+package mid
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+const (
+	contextKeyTenant = ContextKey("tenant")
+)
+
+var (
+	errTenantMissing  = errors.New("tenant missing")
+	errTenantInvalid  = errors.New("invalid tenant")
+	errTenantMismatch = errors.New("tenant does not match the caller tenant")
+	errTenantUnbound  = errors.New("caller not bound to a tenant")
+	errTenantNoCaller = errors.New("tenant chosen without caller")
+)
+
+// validTenant restricts tenant IDs to DNS labels
+var validTenant = regexp.MustCompile(`^[a-z0-9]([a-z0-9-]{0,61}[a-z0-9])?$`)
+
+// TenantConf is the configuration of the Tenant middleware
+type TenantConf struct {
+	// Header carries the tenant, such as "X-Tenant-ID", empty to ignore headers
+	Header string
+	// Domain is the base domain of the tenant subdomains, "acme.api.example.com"
+	// is the tenant acme for the domain "api.example.com", empty to ignore subdomains
+	Domain string
+	// Required refuses requests without tenant
+	Required bool
+	// AllowAnonymous lets the requests without principal choose their tenant with the
+	// header or the subdomain, only do so behind a proxy authenticating the callers
+	AllowAnonymous bool
+}
+
+// GetTenant will retrieve the tenant from the context, "" if there is none
+func GetTenant(ctx context.Context) string {
+	if t, ok := ctx.Value(contextKeyTenant).(string); ok {
+		return t
+	}
+
+	return ""
+}
+
+// WithTenant returns a copy of ctx holding the tenant
+func WithTenant(ctx context.Context, tenant string) context.Context {
+	return context.WithValue(ctx, contextKeyTenant, tenant)
+}
+
+// Tenant resolves the tenant of the request from the principal (JWT claim or API key),
+// the header or the subdomain, in that order. A tenant bound to the principal can't be
+// overridden: a different header or subdomain gets a 403, as does a principal without tenant,
+// and a request without principal unless AllowAnonymous.
+// It must be used after the authentication middlewares.
+func Tenant(conf *TenantConf) func(http.Handler) http.Handler {
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			requested, err := requestedTenant(conf, r)
+			if err != nil {
+				renderError(w, r, http.StatusBadRequest, "Invalid request.", err)
+				return
+			}
+
+			tenant := requested
+			if p := GetPrincipal(r.Context()); p != nil {
+				// It could otherwise pick any tenant
+				if p.Tenant == "" {
+					renderError(w, r, http.StatusForbidden, "Forbidden.",
+						fmt.Errorf("Tenant(%s): %q %v", requested, p.ID, errTenantUnbound))
+					return
+				}
+				if requested != "" && requested != p.Tenant {
+					renderError(w, r, http.StatusForbidden, "Forbidden.",
+						fmt.Errorf("Tenant(%s): %v", requested, errTenantMismatch))
+					return
+				}
+				tenant = p.Tenant
+			} else if requested != "" && !conf.AllowAnonymous {
+				renderError(w, r, http.StatusForbidden, "Forbidden.",
+					fmt.Errorf("Tenant(%s): %v", requested, errTenantNoCaller))
+				return
+			}
+
+			if tenant == "" && conf.Required {
+				renderError(w, r, http.StatusBadRequest, "Invalid request.", errTenantMissing)
+				return
+			}
+
+			// Changing the request in place, so that Logger sees the tenant
+			*r = *r.WithContext(WithTenant(r.Context(), tenant))
+
+			h.ServeHTTP(w, r)
+		})
+	}
+}
+
+// requestedTenant returns the tenant asked by the header or the subdomain
+func requestedTenant(conf *TenantConf, r *http.Request) (string, error) {
+	tenant := ""
+	if conf.Header != "" {
+		tenant = strings.ToLower(strings.TrimSpace(r.Header.Get(conf.Header)))
+	}
+
+	if tenant == "" && conf.Domain != "" {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		host = strings.ToLower(host)
+		if sub := strings.TrimSuffix(host, "."+strings.ToLower(conf.Domain)); sub != host {
+			tenant = sub
+		}
+	}
+
+	if tenant != "" && !validTenant.MatchString(tenant) {
+		return "", fmt.Errorf("requestedTenant(%q): %v", tenant, errTenantInvalid)
+	}
+
+	return tenant, nil
+}
@@ -0,0 +1,171 @@
+package mid
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestTenant(t *testing.T) {
+	conf := &TenantConf{Header: "X-Tenant-ID", Domain: "api.example.com", Required: true}
+	anonymous := &TenantConf{Header: "X-Tenant-ID", Domain: "api.example.com", Required: true, AllowAnonymous: true}
+
+	tc := []struct {
+		name           string
+		conf           *TenantConf
+		host           string
+		header         string
+		principal      *Principal
+		expectedStatus int
+		expectedTenant string
+	}{
+		{
+			name:           "header",
+			conf:           anonymous,
+			host:           "api.example.com",
+			header:         "Acme",
+			expectedStatus: http.StatusOK,
+			expectedTenant: "acme",
+		},
+		{
+			name:           "subdomain",
+			conf:           anonymous,
+			host:           "acme.api.example.com:9002",
+			expectedStatus: http.StatusOK,
+			expectedTenant: "acme",
+		},
+		{
+			name:           "header without principal",
+			conf:           conf,
+			host:           "api.example.com",
+			header:         "acme",
+			expectedStatus: http.StatusForbidden,
+		},
+		{
+			name:           "subdomain without principal",
+			conf:           conf,
+			host:           "acme.api.example.com",
+			expectedStatus: http.StatusForbidden,
+		},
+		{
+			name:           "principal tenant",
+			conf:           conf,
+			host:           "api.example.com",
+			principal:      &Principal{ID: "user", Tenant: "acme"},
+			expectedStatus: http.StatusOK,
+			expectedTenant: "acme",
+		},
+		{
+			name:           "principal tenant matching the subdomain",
+			conf:           conf,
+			host:           "acme.api.example.com",
+			principal:      &Principal{ID: "user", Tenant: "acme"},
+			expectedStatus: http.StatusOK,
+			expectedTenant: "acme",
+		},
+		{
+			name:           "principal tenant overridden by the header",
+			conf:           conf,
+			host:           "api.example.com",
+			header:         "other",
+			principal:      &Principal{ID: "user", Tenant: "acme"},
+			expectedStatus: http.StatusForbidden,
+		},
+		{
+			name:           "principal tenant overridden by the subdomain",
+			conf:           conf,
+			host:           "other.api.example.com",
+			principal:      &Principal{ID: "user", Tenant: "acme"},
+			expectedStatus: http.StatusForbidden,
+		},
+		{
+			name:           "principal without tenant",
+			conf:           conf,
+			host:           "api.example.com",
+			header:         "acme",
+			principal:      &Principal{ID: "user"},
+			expectedStatus: http.StatusForbidden,
+		},
+		{
+			name:           "invalid tenant",
+			conf:           conf,
+			host:           "api.example.com",
+			header:         "acme/../other",
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "nested subdomain",
+			conf:           conf,
+			host:           "a.b.api.example.com",
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "missing tenant",
+			conf:           conf,
+			host:           "api.example.com",
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "optional tenant",
+			conf:           &TenantConf{Header: "X-Tenant-ID"},
+			host:           "api.example.com",
+			expectedStatus: http.StatusOK,
+		},
+	}
+
+	for _, tt := range tc {
+		t.Run(tt.name, func(t *testing.T) {
+			tenant := "unset"
+			h := Tenant(tt.conf)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				tenant = GetTenant(r.Context())
+			}))
+
+			rr := httptest.NewRecorder()
+			req, _ := http.NewRequest(http.MethodGet, "http://"+tt.host+"/v1/resource", nil)
+			if tt.header != "" {
+				req.Header.Set("X-Tenant-ID", tt.header)
+			}
+			if tt.principal != nil {
+				req = req.WithContext(WithPrincipal(req.Context(), tt.principal))
+			}
+			h.ServeHTTP(rr, req)
+
+			if rr.Code != tt.expectedStatus {
+				t.Errorf("expected status %d, got %d", tt.expectedStatus, rr.Code)
+				return
+			}
+			if rr.Code == http.StatusOK && tenant != tt.expectedTenant {
+				t.Errorf("expected tenant %q, got %q", tt.expectedTenant, tenant)
+			}
+		})
+	}
+}
+
+func TestJWTAuthTenant(t *testing.T) {
+	conf := &JWTConf{Keys: &StaticKeys{HMACSecret: []byte("secret")}, TenantClaim: "tenant"}
+	token := signJWT(t, "HS256", "", []byte("secret"), Claims{"sub": "user1", "tenant": "acme", "exp": time.Now().Add(time.Hour).Unix()})
+
+	var principal *Principal
+	h := JWTAuth(conf)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		principal = GetPrincipal(r.Context())
+	}))
+
+	req, _ := http.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	h.ServeHTTP(httptest.NewRecorder(), req)
+
+	if principal == nil || principal.Tenant != "acme" {
+		t.Errorf("expected the principal to be bound to the tenant claim, got %+v", principal)
+	}
+}
+
+func TestGetTenant(t *testing.T) {
+	if GetTenant(context.Background()) != "" {
+		t.Errorf("expected no tenant")
+	}
+	if GetTenant(WithTenant(context.Background(), "acme")) != "acme" {
+		t.Errorf("expected the tenant acme")
+	}
+}
@@ -0,0 +1,93 @@
+package mid
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// TimeoutConf is the configuration of the Timeout middleware
+type TimeoutConf struct {
+	// Default is the deadline of every request, 0 for none
+	Default time.Duration
+	// Routes overrides Default, keyed by route pattern ("/v1/resourceone")
+	// or method and route pattern ("GET /v1/resourceone"), case insensitive
+	Routes map[string]time.Duration
+}
+
+// Timeout sets a deadline on the request context, depending on the route.
+// Handlers are expected to render the context error, if they didn't, a 504 is sent.
+// It must be used on a chi router, after Logger.
+func Timeout(conf *TimeoutConf) func(http.Handler) http.Handler {
+	if conf == nil {
+		conf = &TimeoutConf{}
+	}
+	routes := make(map[string]time.Duration, len(conf.Routes))
+	for route, d := range conf.Routes {
+		routes[strings.ToLower(route)] = d
+	}
+
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			d := conf.Default
+			if pattern := strings.ToLower(RoutePattern(r)); pattern != "" {
+				if rd, ok := routes[strings.ToLower(r.Method)+" "+pattern]; ok {
+					d = rd
+				} else if rd, ok := routes[pattern]; ok {
+					d = rd
+				}
+			}
+			if d <= 0 {
+				h.ServeHTTP(w, r)
+				return
+			}
+
+			ctx, cancel := context.WithTimeout(r.Context(), d)
+			defer cancel()
+			// Changing the request in place, so that Logger sees the deadline
+			*r = *r.WithContext(ctx)
+
+			tw := &timeoutResponseWriter{ResponseWriter: w}
+			h.ServeHTTP(tw, r)
+
+			if !tw.wroteHeader && ctx.Err() == context.DeadlineExceeded {
+				renderError(w, r, http.StatusGatewayTimeout, "Request timed out.",
+					fmt.Errorf("Timeout: %s %v", d, ctx.Err()))
+			}
+		})
+	}
+}
+
+// timeoutResponseWriter records if the handler answered
+type timeoutResponseWriter struct {
+	http.ResponseWriter
+	wroteHeader bool
+}
+
+func (w *timeoutResponseWriter) WriteHeader(httpStatus int) {
+	w.wroteHeader = true
+	w.ResponseWriter.WriteHeader(httpStatus)
+}
+
+func (w *timeoutResponseWriter) Write(b []byte) (int, error) {
+	w.wroteHeader = true
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *timeoutResponseWriter) Flush() {
+	flush(w.ResponseWriter)
+}
+
+// Hijack counts as an answer, the connection isn't answered on timeout once taken over
+func (w *timeoutResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	w.wroteHeader = true
+	return hijack(w.ResponseWriter)
+}
+
+func (w *timeoutResponseWriter) Push(target string, opts *http.PushOptions) error {
+	return push(w.ResponseWriter, target, opts)
+}
@@ -0,0 +1,124 @@
+package mid
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/sirupsen/logrus/hooks/test"
+)
+
+func TestTimeout(t *testing.T) {
+	tc := []struct {
+		name             string
+		conf             *TimeoutConf
+		method           string
+		path             string
+		expectedStatus   int
+		expectedDeadline time.Duration
+	}{
+		{
+			name:             "default timeout",
+			conf:             &TimeoutConf{Default: time.Second},
+			method:           http.MethodGet,
+			path:             "/v1/resource",
+			expectedStatus:   http.StatusOK,
+			expectedDeadline: time.Second,
+		},
+		{
+			name: "route timeout",
+			conf: &TimeoutConf{
+				Default: time.Second,
+				Routes:  map[string]time.Duration{"/v1/resource/{ID}": time.Minute},
+			},
+			method:           http.MethodGet,
+			path:             "/v1/resource/12",
+			expectedStatus:   http.StatusOK,
+			expectedDeadline: time.Minute,
+		},
+		{
+			name: "method and route timeout",
+			conf: &TimeoutConf{
+				Default: time.Second,
+				Routes: map[string]time.Duration{
+					"/v1/resource":     time.Minute,
+					"GET /v1/resource": time.Hour,
+				},
+			},
+			method:           http.MethodGet,
+			path:             "/v1/resource",
+			expectedStatus:   http.StatusOK,
+			expectedDeadline: time.Hour,
+		},
+		{
+			name:           "no timeout",
+			conf:           nil,
+			method:         http.MethodGet,
+			path:           "/v1/resource",
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "timeout hit",
+			conf:           &TimeoutConf{Default: time.Millisecond},
+			method:         http.MethodGet,
+			path:           "/v1/slow",
+			expectedStatus: http.StatusGatewayTimeout,
+		},
+	}
+
+	for _, tt := range tc {
+		t.Run(tt.name, func(t *testing.T) {
+			var deadline time.Duration
+			handler := func(w http.ResponseWriter, req *http.Request) {
+				if d, ok := req.Context().Deadline(); ok {
+					deadline = time.Until(d)
+				}
+			}
+
+			r := chi.NewRouter()
+			r.Use(Timeout(tt.conf))
+			r.Route("/v1", func(r chi.Router) {
+				r.Get("/resource", handler)
+				r.Get("/resource/{id}", handler)
+				r.Get("/slow", func(w http.ResponseWriter, req *http.Request) {
+					<-req.Context().Done()
+				})
+			})
+
+			rr := httptest.NewRecorder()
+			req, _ := http.NewRequest(tt.method, tt.path, nil)
+			r.ServeHTTP(rr, req)
+
+			if rr.Code != tt.expectedStatus {
+				t.Errorf("expected status %d, got %d", tt.expectedStatus, rr.Code)
+				return
+			}
+			if deadline > tt.expectedDeadline || deadline < tt.expectedDeadline-time.Second {
+				t.Errorf("expected a deadline of %s, got %s", tt.expectedDeadline, deadline)
+			}
+		})
+	}
+}
+
+func TestTimeoutLogged(t *testing.T) {
+	logger, hook := test.NewNullLogger()
+
+	r := chi.NewRouter()
+	r.Use(Logger(logger))
+	r.Use(Timeout(&TimeoutConf{Default: time.Millisecond}))
+	r.Get("/slow", func(w http.ResponseWriter, req *http.Request) {
+		<-req.Context().Done()
+	})
+
+	req, _ := http.NewRequest(http.MethodGet, "/slow", nil)
+	r.ServeHTTP(httptest.NewRecorder(), req)
+
+	if hook.LastEntry().Data["http_status"] != http.StatusGatewayTimeout {
+		t.Errorf("expected status %d logged, got %v", http.StatusGatewayTimeout, hook.LastEntry().Data["http_status"])
+	}
+	if hook.LastEntry().Data["timed_out"] != true {
+		t.Errorf("expected the timeout to be logged")
+	}
+}
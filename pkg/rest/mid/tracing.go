@@ -0,0 +1,60 @@
+package mid
+
+import (
+	"net/http"
+
+	"github.com/vincentserpoul/gorestarter/pkg/tracing"
+)
+
+// Tracing starts a server span per request, named after the chi route pattern,
+// continuing the trace of the W3C traceparent header if any.
+// It must be used on a chi router, before Logger so that the trace ID is logged.
+func Tracing(t *tracing.Tracer) func(http.Handler) http.Handler {
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx := r.Context()
+			if parent, err := tracing.ParseTraceparent(r.Header.Get("traceparent")); err == nil {
+				ctx = tracing.ContextWithRemoteSpanContext(ctx, parent)
+			}
+
+			name := r.Method
+			route := RoutePattern(r)
+			if route != "" {
+				name += " " + route
+			}
+			ctx, span := t.Start(ctx, name, tracing.SpanKindServer)
+			span.SetAttribute("http.method", r.Method)
+			span.SetAttribute("http.route", route)
+			span.SetAttribute("http.target", r.URL.Path)
+			if reqID := GetRequestID(ctx); reqID != "" {
+				span.SetAttribute("request_id", reqID)
+			}
+			// In place, so that the error set by the handlers is seen here
+			*r = *r.WithContext(ctx)
+
+			naw := newAugmentedResponseWriter(w)
+
+			defer func() {
+				status := naw.status()
+				span.SetAttribute("http.status_code", status)
+				if status >= http.StatusInternalServerError {
+					err, _ := r.Context().Value(ErrRequestContextKey).(error)
+					if err == nil {
+						err = errStatus(status)
+					}
+					span.SetError(err)
+				}
+				span.End()
+			}()
+
+			h.ServeHTTP(naw, r)
+		})
+	}
+}
+
+// errStatus is the error of a failed request without error in context
+type errStatus int
+
+func (e errStatus) Error() string {
+	return http.StatusText(int(e))
+}
@@ -0,0 +1,149 @@
+package mid
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/go-chi/chi"
+	"github.com/sirupsen/logrus/hooks/test"
+
+	"github.com/vincentserpoul/gorestarter/pkg/tracing"
+)
+
+// spanRecorder is an exporter keeping the spans in memory
+type spanRecorder struct {
+	mu    sync.Mutex
+	spans []tracing.SpanData
+}
+
+func (rec *spanRecorder) Export(ctx context.Context, spans []tracing.SpanData) error {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	rec.spans = append(rec.spans, spans...)
+	return nil
+}
+
+func (rec *spanRecorder) Shutdown(ctx context.Context) error { return nil }
+
+func TestTracing(t *testing.T) {
+	errTest := errors.New("test error")
+	parent := "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+
+	tests := []struct {
+		name            string
+		path            string
+		traceparent     string
+		expectedName    string
+		expectedStatus  int
+		expectedError   string
+		expectedTraceID string
+	}{
+		{
+			name:           "span named after the route",
+			path:           "/v1/resource/1",
+			expectedName:   "GET /v1/resource/{id}",
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:            "trace continued",
+			path:            "/v1/resource/1",
+			traceparent:     parent,
+			expectedName:    "GET /v1/resource/{id}",
+			expectedStatus:  http.StatusOK,
+			expectedTraceID: "4bf92f3577b34da6a3ce929d0e0e4736",
+		},
+		{
+			name:           "invalid traceparent ignored",
+			path:           "/v1/resource/1",
+			traceparent:    "00-zz",
+			expectedName:   "GET /v1/resource/{id}",
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "error of the context",
+			path:           "/v1/fail",
+			expectedName:   "GET /v1/fail",
+			expectedStatus: http.StatusInternalServerError,
+			expectedError:  errTest.Error(),
+		},
+		{
+			name:           "error from the status",
+			path:           "/v1/unavailable",
+			expectedName:   "GET /v1/unavailable",
+			expectedStatus: http.StatusServiceUnavailable,
+			expectedError:  http.StatusText(http.StatusServiceUnavailable),
+		},
+		{
+			name:           "unmatched route",
+			path:           "/unknown",
+			expectedName:   "GET",
+			expectedStatus: http.StatusNotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := &spanRecorder{}
+			tracer := tracing.NewTracer(&tracing.TracerConf{Service: "test", Exporter: rec, SampleRatio: 1})
+			logger, hook := test.NewNullLogger()
+
+			r := chi.NewRouter()
+			r.Use(Tracing(tracer), Logger(logger))
+			r.Get("/v1/resource/{id}", func(w http.ResponseWriter, req *http.Request) {
+				w.WriteHeader(http.StatusOK)
+			})
+			r.Get("/v1/fail", func(w http.ResponseWriter, req *http.Request) {
+				renderError(w, req, http.StatusInternalServerError, "error", errTest)
+			})
+			r.Get("/v1/unavailable", func(w http.ResponseWriter, req *http.Request) {
+				w.WriteHeader(http.StatusServiceUnavailable)
+			})
+
+			req, _ := http.NewRequest(http.MethodGet, tt.path, nil)
+			if tt.traceparent != "" {
+				req.Header.Set("traceparent", tt.traceparent)
+			}
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+
+			if err := tracer.Shutdown(context.Background()); err != nil {
+				t.Fatalf("Shutdown() error = %v", err)
+			}
+			if len(rec.spans) != 1 {
+				t.Fatalf("expected 1 span, got %d", len(rec.spans))
+			}
+			span := rec.spans[0]
+
+			if span.Name != tt.expectedName || span.Kind != tracing.SpanKindServer {
+				t.Errorf("expected server span %s, got %s %s", tt.expectedName, span.Kind, span.Name)
+			}
+			if span.Attributes["http.status_code"] != tt.expectedStatus {
+				t.Errorf("expected status %d, got %v", tt.expectedStatus, span.Attributes["http.status_code"])
+			}
+			if span.Error != tt.expectedError {
+				t.Errorf("expected error %q, got %q", tt.expectedError, span.Error)
+			}
+			if tt.expectedTraceID != "" && span.TraceID.String() != tt.expectedTraceID {
+				t.Errorf("expected trace %s, got %s", tt.expectedTraceID, span.TraceID)
+			}
+
+			entry := hook.LastEntry()
+			if entry == nil || entry.Data["trace_id"] != span.TraceID.String() || entry.Data["span_id"] != span.SpanID.String() {
+				t.Errorf("expected the trace and span IDs to be logged, got %v", entry)
+			}
+
+			if tt.expectedStatus == http.StatusInternalServerError {
+				var body errorResponse
+				_ = json.NewDecoder(w.Body).Decode(&body)
+				if body.TraceID != span.TraceID.String() {
+					t.Errorf("expected the trace ID in the error, got %+v", body)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,184 @@
+package renderer
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"strings"
+
+	"github.com/go-chi/render"
+
+	"github.com/vincentserpoul/gorestarter/pkg/rest/mid"
+)
+
+// DecodeError is returned when a request body can't be decoded.
+// Line and Column are set for syntax errors, Pointer (a JSON pointer) when the
+// faulty value is known.
+type DecodeError struct {
+	HTTPStatusCode int
+	Line           int
+	Column         int
+	Pointer        string
+	Err            error
+}
+
+func (e *DecodeError) Error() string {
+	switch {
+	case e.Pointer != "":
+		return fmt.Sprintf("%v at %s", e.Err, e.Pointer)
+	case e.Line > 0:
+		return fmt.Sprintf("%v at line %d, column %d", e.Err, e.Line, e.Column)
+	}
+	return e.Err.Error()
+}
+
+// DecodeJSON strictly decodes the JSON request body in v.
+// The body must have a JSON content type, hold exactly one JSON value and no
+// field unknown to v. The body size is limited by mid.BodyLimit.
+func DecodeJSON(r *http.Request, v interface{}) error {
+	if !isJSONContentType(r.Header.Get("Content-Type")) {
+		return &DecodeError{
+			HTTPStatusCode: http.StatusUnsupportedMediaType,
+			Err:            fmt.Errorf("content type %q is not JSON", r.Header.Get("Content-Type")),
+		}
+	}
+
+	buf := getBuffer()
+	defer putBuffer(buf)
+
+	if r.Body != nil {
+		if _, err := buf.ReadFrom(r.Body); err != nil {
+			if err == mid.ErrBodyTooLarge {
+				return &DecodeError{HTTPStatusCode: http.StatusRequestEntityTooLarge, Err: err}
+			}
+			return &DecodeError{HTTPStatusCode: http.StatusBadRequest, Err: err}
+		}
+	}
+	body := buf.Bytes()
+
+	if len(bytes.TrimSpace(body)) == 0 {
+		return &DecodeError{HTTPStatusCode: http.StatusBadRequest, Err: errors.New("empty body")}
+	}
+
+	br := bytes.NewReader(body)
+	dec := json.NewDecoder(br)
+	dec.DisallowUnknownFields()
+
+	if err := dec.Decode(v); err != nil {
+		return decodeError(body, err)
+	}
+
+	// Anything but whitespace after the first value is rejected
+	buffered, _ := ioutil.ReadAll(dec.Buffered())
+	tail := body[len(body)-len(buffered)-br.Len():]
+	if trimmed := bytes.TrimLeft(tail, " \t\r\n"); len(trimmed) > 0 {
+		line, col := lineColumn(body, int64(len(body)-len(trimmed)+1))
+		return &DecodeError{
+			HTTPStatusCode: http.StatusBadRequest,
+			Line:           line,
+			Column:         col,
+			Err:            errors.New("body must only contain one JSON value"),
+		}
+	}
+
+	return nil
+}
+
+// decodeError locates a json decoding error in body
+func decodeError(body []byte, err error) *DecodeError {
+	de := &DecodeError{HTTPStatusCode: http.StatusBadRequest, Err: err}
+
+	switch e := err.(type) {
+	case *json.SyntaxError:
+		de.Line, de.Column = lineColumn(body, e.Offset)
+	case *json.UnmarshalTypeError:
+		de.Err = fmt.Errorf("json: cannot use %s as %s", e.Value, e.Type)
+		if e.Field != "" {
+			de.Pointer = "/" + strings.Replace(e.Field, ".", "/", -1)
+		} else {
+			de.Line, de.Column = lineColumn(body, e.Offset)
+		}
+	default:
+		if err == io.ErrUnexpectedEOF {
+			de.Line, de.Column = lineColumn(body, int64(len(body)))
+			break
+		}
+		// unknown fields are only reported through the error message
+		const unknownField = `json: unknown field "`
+		if msg := err.Error(); strings.HasPrefix(msg, unknownField) {
+			de.Err = errors.New("json: unknown field")
+			de.Pointer = "/" + strings.TrimSuffix(strings.TrimPrefix(msg, unknownField), `"`)
+		}
+	}
+
+	return de
+}
+
+// lineColumn converts a 1-based byte offset to a line and column
+func lineColumn(body []byte, offset int64) (int, int) {
+	if offset > int64(len(body)) {
+		offset = int64(len(body))
+	}
+	line, col := 1, 1
+	for _, b := range body[:maxInt64(offset-1, 0)] {
+		if b == '\n' {
+			line++
+			col = 1
+			continue
+		}
+		col++
+	}
+	return line, col
+}
+
+func maxInt64(a, b int64) int64 {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+// isJSONContentType accepts application/json and any +json media type
+func isJSONContentType(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	return mediaType == "application/json" || strings.HasSuffix(mediaType, "+json")
+}
+
+// ErrDecode renders a request decoding error with the matching status
+func ErrDecode(err error) render.Renderer {
+	de, ok := err.(*DecodeError)
+	if !ok {
+		return ErrInvalidRequest(err)
+	}
+
+	switch de.HTTPStatusCode {
+	case http.StatusRequestEntityTooLarge:
+		return &ErrResponse{
+			Err:            err,
+			HTTPStatusCode: http.StatusRequestEntityTooLarge,
+			StatusText:     "Request body too large.",
+		}
+	case http.StatusUnsupportedMediaType:
+		return &ErrResponse{
+			Err:            err,
+			HTTPStatusCode: http.StatusUnsupportedMediaType,
+			StatusText:     "Unsupported media type.",
+			ErrorText:      err.Error(),
+		}
+	}
+
+	return &ErrResponse{
+		Err:            err,
+		HTTPStatusCode: http.StatusBadRequest,
+		StatusText:     "Invalid request.",
+		ErrorText:      err.Error(),
+	}
+}
@@ -0,0 +1,196 @@
+package renderer
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/render"
+
+	"github.com/vincentserpoul/gorestarter/pkg/rest/mid"
+)
+
+func TestDecodeJSON(t *testing.T) {
+	tests := []struct {
+		name          string
+		contentType   string
+		body          string
+		wantedStatus  int
+		wantedLine    int
+		wantedColumn  int
+		wantedPointer string
+		wantedLabel   string
+	}{
+		{
+			name:         "valid body",
+			contentType:  "application/json; charset=utf-8",
+			body:         `{"label": "test"}`,
+			wantedStatus: 0,
+			wantedLabel:  "test",
+		},
+		{
+			name:         "valid body with +json type and trailing whitespace",
+			contentType:  "application/merge-patch+json",
+			body:         "{\"label\": \"test\"}\n\n",
+			wantedStatus: 0,
+			wantedLabel:  "test",
+		},
+		{
+			name:         "no content type",
+			body:         `{"label": "test"}`,
+			wantedStatus: http.StatusUnsupportedMediaType,
+		},
+		{
+			name:         "wrong content type",
+			contentType:  "text/plain",
+			body:         `{"label": "test"}`,
+			wantedStatus: http.StatusUnsupportedMediaType,
+		},
+		{
+			name:         "empty body",
+			contentType:  "application/json",
+			body:         ``,
+			wantedStatus: http.StatusBadRequest,
+		},
+		{
+			name:         "syntax error",
+			contentType:  "application/json",
+			body:         "{\n  \"label\": \"test\",\n  x\n}",
+			wantedStatus: http.StatusBadRequest,
+			wantedLine:   3,
+			wantedColumn: 3,
+		},
+		{
+			name:         "truncated body",
+			contentType:  "application/json",
+			body:         `{"label": "te`,
+			wantedStatus: http.StatusBadRequest,
+			wantedLine:   1,
+			wantedColumn: 13,
+		},
+		{
+			name:          "wrong type",
+			contentType:   "application/json",
+			body:          `{"label": 1}`,
+			wantedStatus:  http.StatusBadRequest,
+			wantedPointer: "/label",
+		},
+		{
+			name:          "unknown field",
+			contentType:   "application/json",
+			body:          `{"label": "test", "other": 1}`,
+			wantedStatus:  http.StatusBadRequest,
+			wantedPointer: "/other",
+		},
+		{
+			name:         "multiple values",
+			contentType:  "application/json",
+			body:         "{\"label\": \"test\"}\n{\"label\": \"test\"}",
+			wantedStatus: http.StatusBadRequest,
+			wantedLine:   2,
+			wantedColumn: 1,
+		},
+		{
+			name:         "trailing garbage",
+			contentType:  "application/json",
+			body:         `{"label": "test"} garbage`,
+			wantedStatus: http.StatusBadRequest,
+			wantedLine:   1,
+			wantedColumn: 19,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, _ := http.NewRequest("POST", ``, bytes.NewBufferString(tt.body))
+			r.Header.Set("Content-Type", tt.contentType)
+
+			v := &testLabel{}
+			err := DecodeJSON(r, v)
+			if tt.wantedStatus == 0 {
+				if err != nil {
+					t.Errorf("DecodeJSON() error = %v", err)
+					return
+				}
+				if v.Label != tt.wantedLabel {
+					t.Errorf("DecodeJSON() label = %s, want %s", v.Label, tt.wantedLabel)
+				}
+				return
+			}
+
+			de, ok := err.(*DecodeError)
+			if !ok {
+				t.Fatalf("DecodeJSON() error = %v, want a *DecodeError", err)
+			}
+			if de.HTTPStatusCode != tt.wantedStatus {
+				t.Errorf("DecodeJSON() status = %d, want %d", de.HTTPStatusCode, tt.wantedStatus)
+			}
+			if de.Line != tt.wantedLine || de.Column != tt.wantedColumn {
+				t.Errorf("DecodeJSON() location = %d:%d, want %d:%d",
+					de.Line, de.Column, tt.wantedLine, tt.wantedColumn)
+			}
+			if de.Pointer != tt.wantedPointer {
+				t.Errorf("DecodeJSON() pointer = %s, want %s", de.Pointer, tt.wantedPointer)
+			}
+		})
+	}
+}
+
+func TestDecodeJSONBodyLimit(t *testing.T) {
+	var err error
+	handler := mid.BodyLimit(10)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		err = DecodeJSON(r, &testLabel{})
+	}))
+
+	r, _ := http.NewRequest("POST", ``, strings.NewReader(`{"label": "too long"}`))
+	r.Header.Set("Content-Type", "application/json")
+	// unknown length, so that the limit is hit while reading
+	r.ContentLength = -1
+	handler.ServeHTTP(httptest.NewRecorder(), r)
+
+	if de, ok := err.(*DecodeError); !ok || de.HTTPStatusCode != http.StatusRequestEntityTooLarge {
+		t.Errorf("DecodeJSON() error = %v, want a 413 *DecodeError", err)
+	}
+}
+
+func TestErrDecode(t *testing.T) {
+	tests := []struct {
+		name         string
+		err          error
+		wantedStatus int
+	}{
+		{
+			name:         "too large",
+			err:          &DecodeError{HTTPStatusCode: http.StatusRequestEntityTooLarge, Err: mid.ErrBodyTooLarge},
+			wantedStatus: http.StatusRequestEntityTooLarge,
+		},
+		{
+			name:         "unsupported media type",
+			err:          &DecodeError{HTTPStatusCode: http.StatusUnsupportedMediaType, Err: mid.ErrBodyTooLarge},
+			wantedStatus: http.StatusUnsupportedMediaType,
+		},
+		{
+			name:         "bad request",
+			err:          &DecodeError{HTTPStatusCode: http.StatusBadRequest, Err: mid.ErrBodyTooLarge},
+			wantedStatus: http.StatusBadRequest,
+		},
+		{
+			name:         "other error",
+			err:          mid.ErrBodyTooLarge,
+			wantedStatus: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			r, _ := http.NewRequest("POST", ``, nil)
+			_ = render.Render(rr, r, ErrDecode(tt.err))
+			if rr.Code != tt.wantedStatus {
+				t.Errorf("ErrDecode() status = %d, want %d", rr.Code, tt.wantedStatus)
+			}
+		})
+	}
+}
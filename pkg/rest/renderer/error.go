@@ -3,8 +3,10 @@ package renderer
 import (
 	"context"
 	"net/http"
+	"strings"
 
 	"github.com/vincentserpoul/gorestarter/pkg/rest/mid"
+	"github.com/vincentserpoul/gorestarter/pkg/tracing"
 
 	"github.com/go-chi/render"
 )
@@ -18,13 +20,17 @@ type ErrResponse struct {
 	Err            error `json:"-"` // low-level runtime error
 	HTTPStatusCode int   `json:"-"` // http response status code
 
-	StatusText string `json:"status"`          // user-level status message
-	ErrorText  string `json:"error,omitempty"` // application-level error message, for debugging
+	StatusText string `json:"status"`            // user-level status message
+	ErrorText  string `json:"error,omitempty"`   // application-level error message, for debugging
+	TraceID    string `json:"traceId,omitempty"` // trace of the request, to find it in the traces
 }
 
 // Render rendering the error
 func (e *ErrResponse) Render(w http.ResponseWriter, r *http.Request) error {
 	render.Status(r, e.HTTPStatusCode)
+	if sc := tracing.SpanContextFromContext(r.Context()); sc.IsValid() {
+		e.TraceID = sc.TraceID.String()
+	}
 	// Putting the request error in context, so it can be picked up by logging
 	*r = *r.WithContext(context.WithValue(r.Context(), mid.ErrRequestContextKey, e.Err))
 	return nil
@@ -48,8 +54,72 @@ func ErrRender(err error) render.Renderer {
 	}
 }
 
-// ErrNotFound is the wrapped error for not found resources
-var ErrNotFound = &ErrResponse{
-	HTTPStatusCode: http.StatusNotFound,
-	StatusText:     "Resource not found.",
+// ErrTimeout when the request deadline has been exceeded
+func ErrTimeout(err error) render.Renderer {
+	return &ErrResponse{
+		Err:            err,
+		HTTPStatusCode: http.StatusGatewayTimeout,
+		StatusText:     "Request timed out.",
+	}
+}
+
+// ErrUnavailable when the server can't handle the request for now
+func ErrUnavailable(err error) render.Renderer {
+	return &ErrResponse{
+		Err:            err,
+		HTTPStatusCode: http.StatusServiceUnavailable,
+		StatusText:     "Service unavailable.",
+	}
+}
+
+// ErrServer when there is a server side issue, it checks the request context
+// so that timeouts and cancellations are not reported as internal errors
+func ErrServer(ctx context.Context, err error) render.Renderer {
+	switch ctx.Err() {
+	case context.DeadlineExceeded:
+		return ErrTimeout(err)
+	case context.Canceled:
+		return ErrUnavailable(err)
+	}
+
+	return ErrRender(err)
+}
+
+// ErrForbidden when the caller is authenticated but not allowed to do the request
+func ErrForbidden(err error) render.Renderer {
+	return &ErrResponse{
+		Err:            err,
+		HTTPStatusCode: http.StatusForbidden,
+		StatusText:     "Forbidden.",
+	}
+}
+
+// ErrNotFound when the resource doesn't exist
+func ErrNotFound() render.Renderer {
+	return &ErrResponse{
+		HTTPStatusCode: http.StatusNotFound,
+		StatusText:     "Resource not found.",
+	}
+}
+
+// ErrMethodNotAllowed when the method isn't routed on the resource
+func ErrMethodNotAllowed() render.Renderer {
+	return &ErrResponse{
+		HTTPStatusCode: http.StatusMethodNotAllowed,
+		StatusText:     "Method not allowed.",
+	}
+}
+
+// NotFoundHandler renders ErrNotFound, to be used as the chi router NotFound handler
+func NotFoundHandler(w http.ResponseWriter, r *http.Request) {
+	renderError(w, r, ErrNotFound())
+}
+
+// MethodNotAllowedHandler renders ErrMethodNotAllowed with the Allow header,
+// to be used as the chi router MethodNotAllowed handler
+func MethodNotAllowedHandler(w http.ResponseWriter, r *http.Request) {
+	if methods := mid.AllowedMethods(r); len(methods) > 0 {
+		w.Header().Set("Allow", strings.Join(methods, ", "))
+	}
+	renderError(w, r, ErrMethodNotAllowed())
 }
@@ -1,12 +1,16 @@
 package renderer
 
 import (
+	"context"
+	"encoding/json"
 	"errors"
 	"net/http"
 	"net/http/httptest"
 	"reflect"
 	"testing"
+	"time"
 
+	"github.com/go-chi/chi"
 	"github.com/go-chi/render"
 )
 
@@ -64,6 +68,16 @@ func TestErrFuncs(t *testing.T) {
 				StatusText:     "Invalid request.",
 			},
 		},
+		{
+			name:      "working error renderer forbidden",
+			funcToUse: ErrForbidden,
+			err:       errors.New("test err"),
+			want: &ErrResponse{
+				Err:            errors.New("test err"),
+				HTTPStatusCode: http.StatusForbidden,
+				StatusText:     "Forbidden.",
+			},
+		},
 	}
 
 	for _, tt := range tests {
@@ -74,3 +88,96 @@ func TestErrFuncs(t *testing.T) {
 		})
 	}
 }
+
+func TestNotFoundAndMethodNotAllowedHandlers(t *testing.T) {
+	router := chi.NewRouter()
+	router.NotFound(NotFoundHandler)
+	router.MethodNotAllowed(MethodNotAllowedHandler)
+	router.Route("/resource", func(r chi.Router) {
+		r.Get("/", func(w http.ResponseWriter, req *http.Request) {})
+		r.Put("/", func(w http.ResponseWriter, req *http.Request) {})
+	})
+
+	tests := []struct {
+		name         string
+		method       string
+		path         string
+		wantedStatus int
+		wantedAllow  string
+	}{
+		{
+			name:         "not found",
+			method:       "GET",
+			path:         "/unknown",
+			wantedStatus: http.StatusNotFound,
+		},
+		{
+			name:         "method not allowed",
+			method:       "DELETE",
+			path:         "/resource/",
+			wantedStatus: http.StatusMethodNotAllowed,
+			wantedAllow:  "GET, HEAD, PUT, OPTIONS",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			r, _ := http.NewRequest(tt.method, tt.path, nil)
+			router.ServeHTTP(rr, r)
+
+			if rr.Code != tt.wantedStatus {
+				t.Errorf("expected status %d, got %d", tt.wantedStatus, rr.Code)
+				return
+			}
+			if rr.Header().Get("Allow") != tt.wantedAllow {
+				t.Errorf("expected Allow %q, got %q", tt.wantedAllow, rr.Header().Get("Allow"))
+			}
+
+			e := &ErrResponse{}
+			if err := json.NewDecoder(rr.Body).Decode(e); err != nil || e.StatusText == "" {
+				t.Errorf("expected a JSON error body, got %s (%v)", rr.Body.String(), err)
+			}
+		})
+	}
+}
+
+func TestErrServer(t *testing.T) {
+	errTest := errors.New("test")
+	deadlineCtx, cancelDeadline := context.WithTimeout(context.Background(), time.Nanosecond)
+	defer cancelDeadline()
+	<-deadlineCtx.Done()
+	canceledCtx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	tests := []struct {
+		name         string
+		ctx          context.Context
+		wantedStatus int
+	}{
+		{
+			name:         "internal error",
+			ctx:          context.Background(),
+			wantedStatus: http.StatusInternalServerError,
+		},
+		{
+			name:         "deadline exceeded",
+			ctx:          deadlineCtx,
+			wantedStatus: http.StatusGatewayTimeout,
+		},
+		{
+			name:         "canceled",
+			ctx:          canceledCtx,
+			wantedStatus: http.StatusServiceUnavailable,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := ErrServer(tt.ctx, errTest).(*ErrResponse)
+			if !ok || got.HTTPStatusCode != tt.wantedStatus || got.Err != errTest {
+				t.Errorf("ErrServer() = %v, want status %d", got, tt.wantedStatus)
+			}
+		})
+	}
+}
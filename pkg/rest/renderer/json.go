@@ -1,33 +1,167 @@
 package renderer
 
 import (
+	"bytes"
 	"encoding/json"
-	"fmt"
+	"mime"
 	"net/http"
-
-	log "github.com/sirupsen/logrus"
+	"reflect"
+	"strings"
+	"sync"
 
 	"github.com/go-chi/render"
+
+	"github.com/vincentserpoul/gorestarter/pkg/rest/mid"
 )
 
+// ContentTypeNDJSON is the media type used for newline delimited JSON lists
+const ContentTypeNDJSON = "application/x-ndjson"
+
+const (
+	// streamFlushSize is the buffered size after which a streamed list is
+	// written to the client
+	streamFlushSize = 32 << 10
+	// maxPooledBufferSize avoids keeping huge buffers alive in the pool
+	maxPooledBufferSize = 256 << 10
+)
+
+var bufferPool = sync.Pool{
+	New: func() interface{} {
+		return new(bytes.Buffer)
+	},
+}
+
+func getBuffer() *bytes.Buffer {
+	buf := bufferPool.Get().(*bytes.Buffer)
+	buf.Reset()
+	return buf
+}
+
+func putBuffer(buf *bytes.Buffer) {
+	if buf.Cap() > maxPooledBufferSize {
+		return
+	}
+	bufferPool.Put(buf)
+}
+
+// encodeTo encodes e in buf, without the trailing newline added by json.Encoder
+func encodeTo(buf *bytes.Buffer, e interface{}) error {
+	if err := json.NewEncoder(buf).Encode(e); err != nil {
+		return err
+	}
+	buf.Truncate(buf.Len() - 1)
+	return nil
+}
+
 // ResponseJSONRender will simply JSON the response and add the specific headers
 func ResponseJSONRender(w http.ResponseWriter, r *http.Request, e interface{}) {
-	// Render JSON
-	eJSON, errJSON := json.Marshal(e)
+	buf := getBuffer()
+	defer putBuffer(buf)
+
+	// Encode first, so that a marshalling error can still be rendered
+	errJSON := encodeTo(buf, e)
 	if errJSON != nil {
-		errRend := render.Render(w, r, ErrRender(errJSON))
-		if errRend != nil {
-			log.Errorf("ResponseJSONRender %s", errRend)
-		}
+		renderError(w, r, ErrRender(errJSON))
 		return
 	}
 
-	_, errW := fmt.Fprintf(w, string(eJSON))
+	_, errW := w.Write(buf.Bytes())
 	if errW != nil {
-		errRend := render.Render(w, r, ErrRender(errW))
-		if errRend != nil {
-			log.Errorf("ResponseJSONRender %s", errRend)
+		renderError(w, r, ErrRender(errW))
+		return
+	}
+}
+
+// ResponseJSONListRender will stream a slice as a JSON array, element by element,
+// so that big lists are never entirely held in memory twice.
+// Anything that is not a slice or an array is rendered with ResponseJSONRender.
+func ResponseJSONListRender(w http.ResponseWriter, r *http.Request, list interface{}) {
+	streamList(w, r, list, []byte("["), []byte(","), []byte("]"))
+}
+
+// ResponseNDJSONRender will stream a slice as newline delimited JSON, one element per line.
+// The Content-Type header must be set by the caller before writing the status.
+func ResponseNDJSONRender(w http.ResponseWriter, r *http.Request, list interface{}) {
+	streamList(w, r, list, nil, []byte("\n"), []byte("\n"))
+}
+
+// streamList writes every element of list, separated by sep and enclosed by open and close
+func streamList(
+	w http.ResponseWriter,
+	r *http.Request,
+	list interface{},
+	open, sep, close []byte,
+) {
+	v := reflect.ValueOf(list)
+	if v.Kind() != reflect.Slice && v.Kind() != reflect.Array {
+		ResponseJSONRender(w, r, list)
+		return
+	}
+
+	buf := getBuffer()
+	defer putBuffer(buf)
+
+	written := false
+	flush := func() error {
+		if buf.Len() == 0 {
+			return nil
+		}
+		_, err := w.Write(buf.Bytes())
+		buf.Reset()
+		written = true
+		return err
+	}
+
+	buf.Write(open)
+	for i := 0; i < v.Len(); i++ {
+		if i > 0 {
+			buf.Write(sep)
+		}
+		if err := encodeTo(buf, v.Index(i).Interface()); err != nil {
+			streamError(w, r, written, err)
+			return
 		}
+		if buf.Len() >= streamFlushSize {
+			if err := flush(); err != nil {
+				streamError(w, r, written, err)
+				return
+			}
+		}
+	}
+	if v.Len() > 0 || open != nil {
+		buf.Write(close)
+	}
+
+	if err := flush(); err != nil {
+		streamError(w, r, written, err)
+	}
+}
+
+// streamError renders the error if nothing reached the client yet, otherwise
+// the response is already broken and the error can only be logged
+func streamError(w http.ResponseWriter, r *http.Request, written bool, err error) {
+	if !written {
+		renderError(w, r, ErrRender(err))
 		return
 	}
+	mid.LoggerFrom(r.Context()).Errorf("ResponseJSONListRender %s", err)
+}
+
+func renderError(w http.ResponseWriter, r *http.Request, rd render.Renderer) {
+	errRend := render.Render(w, r, rd)
+	if errRend != nil {
+		mid.LoggerFrom(r.Context()).Errorf("render.Render %s", errRend)
+	}
+}
+
+// WantsNDJSON returns true if the client explicitly accepts newline delimited JSON
+func WantsNDJSON(r *http.Request) bool {
+	for _, accept := range strings.Split(r.Header.Get("Accept"), ",") {
+		mediaType, _, err := mime.ParseMediaType(strings.TrimSpace(accept))
+		if err == nil && mediaType == ContentTypeNDJSON {
+			return true
+		}
+	}
+
+	return false
 }
@@ -1,6 +1,9 @@
 package renderer
 
 import (
+	"encoding/json"
+	"fmt"
+	"math"
 	"net/http"
 	"net/http/httptest"
 	"testing"
@@ -33,3 +36,208 @@ func TestResponseJSONRender(t *testing.T) {
 		})
 	}
 }
+
+type testLabel struct {
+	Label string `json:"label"`
+}
+
+func TestResponseJSONRenderBody(t *testing.T) {
+	r, _ := http.NewRequest("GET", ``, nil)
+
+	tests := []struct {
+		name         string
+		e            interface{}
+		wantedBody   string
+		wantedStatus int
+	}{
+		{
+			name:         "label with format verbs",
+			e:            testLabel{Label: "100%s %d"},
+			wantedBody:   `{"label":"100%s %d"}`,
+			wantedStatus: http.StatusOK,
+		},
+		{
+			name:         "unmarshallable value",
+			e:            math.Inf(1),
+			wantedStatus: http.StatusInternalServerError,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			ResponseJSONRender(rr, r, tt.e)
+
+			if rr.Code != tt.wantedStatus {
+				t.Errorf("expected status %d, got %d", tt.wantedStatus, rr.Code)
+				return
+			}
+
+			if tt.wantedBody != "" && rr.Body.String() != tt.wantedBody {
+				t.Errorf("expected body %s, got %s", tt.wantedBody, rr.Body.String())
+			}
+		})
+	}
+}
+
+func TestResponseJSONListRender(t *testing.T) {
+	r, _ := http.NewRequest("GET", ``, nil)
+
+	tests := []struct {
+		name       string
+		list       interface{}
+		wantedBody string
+	}{
+		{
+			name:       "empty list",
+			list:       []*testLabel{},
+			wantedBody: `[]`,
+		},
+		{
+			name:       "list",
+			list:       []*testLabel{{Label: "a"}, {Label: "b%"}},
+			wantedBody: `[{"label":"a"},{"label":"b%"}]`,
+		},
+		{
+			name:       "not a list",
+			list:       testLabel{Label: "a"},
+			wantedBody: `{"label":"a"}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			ResponseJSONListRender(rr, r, tt.list)
+			if rr.Body.String() != tt.wantedBody {
+				t.Errorf("expected body %s, got %s", tt.wantedBody, rr.Body.String())
+			}
+		})
+	}
+}
+
+func TestResponseJSONListRenderBigList(t *testing.T) {
+	r, _ := http.NewRequest("GET", ``, nil)
+	list := make([]*testLabel, 10000)
+	for i := range list {
+		list[i] = &testLabel{Label: fmt.Sprintf("label %d", i)}
+	}
+
+	rr := httptest.NewRecorder()
+	ResponseJSONListRender(rr, r, list)
+
+	var got []*testLabel
+	if err := json.Unmarshal(rr.Body.Bytes(), &got); err != nil {
+		t.Fatalf("streamed list is not valid JSON: %v", err)
+	}
+	if len(got) != len(list) {
+		t.Errorf("expected %d elements, got %d", len(list), len(got))
+	}
+}
+
+func TestResponseNDJSONRender(t *testing.T) {
+	r, _ := http.NewRequest("GET", ``, nil)
+
+	tests := []struct {
+		name       string
+		list       interface{}
+		wantedBody string
+	}{
+		{
+			name:       "empty list",
+			list:       []*testLabel{},
+			wantedBody: ``,
+		},
+		{
+			name:       "list",
+			list:       []*testLabel{{Label: "a"}, {Label: "b"}},
+			wantedBody: "{\"label\":\"a\"}\n{\"label\":\"b\"}\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			ResponseNDJSONRender(rr, r, tt.list)
+			if rr.Body.String() != tt.wantedBody {
+				t.Errorf("expected body %q, got %q", tt.wantedBody, rr.Body.String())
+			}
+		})
+	}
+}
+
+func TestWantsNDJSON(t *testing.T) {
+	tests := []struct {
+		name   string
+		accept string
+		want   bool
+	}{
+		{name: "no accept", accept: "", want: false},
+		{name: "json", accept: "application/json", want: false},
+		{name: "ndjson", accept: "application/x-ndjson", want: true},
+		{name: "ndjson among others", accept: "application/json;q=0.9, application/x-ndjson", want: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, _ := http.NewRequest("GET", ``, nil)
+			r.Header.Set("Accept", tt.accept)
+			if got := WantsNDJSON(r); got != tt.want {
+				t.Errorf("WantsNDJSON() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// legacyResponseJSONRender is the previous marshal then Fprintf implementation,
+// kept here as a benchmark baseline
+func legacyResponseJSONRender(w http.ResponseWriter, e interface{}) {
+	eJSON, errJSON := json.Marshal(e)
+	if errJSON != nil {
+		return
+	}
+	_, _ = fmt.Fprint(w, string(eJSON))
+}
+
+func benchmarkList(n int) []*testLabel {
+	list := make([]*testLabel, n)
+	for i := range list {
+		list[i] = &testLabel{Label: fmt.Sprintf("label %d", i)}
+	}
+	return list
+}
+
+func BenchmarkLegacyResponseJSONRender(b *testing.B) {
+	list := benchmarkList(1000)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		legacyResponseJSONRender(httptest.NewRecorder(), list)
+	}
+}
+
+func BenchmarkResponseJSONRender(b *testing.B) {
+	r, _ := http.NewRequest("GET", ``, nil)
+	list := benchmarkList(1000)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		ResponseJSONRender(httptest.NewRecorder(), r, list)
+	}
+}
+
+func BenchmarkResponseJSONListRender(b *testing.B) {
+	r, _ := http.NewRequest("GET", ``, nil)
+	list := benchmarkList(1000)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		ResponseJSONListRender(httptest.NewRecorder(), r, list)
+	}
+}
+
+func BenchmarkResponseNDJSONRender(b *testing.B) {
+	r, _ := http.NewRequest("GET", ``, nil)
+	list := benchmarkList(1000)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		ResponseNDJSONRender(httptest.NewRecorder(), r, list)
+	}
+}
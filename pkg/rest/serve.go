@@ -1,49 +1,372 @@
 package rest
 
 import (
-	"context"
 	"fmt"
-	"log"
+	"net"
 	"net/http"
+	"strings"
+	"time"
 
 	"github.com/go-chi/chi"
-	"github.com/go-chi/chi/middleware"
 	"github.com/jmoiron/sqlx"
 	"github.com/sirupsen/logrus"
 
+	"github.com/vincentserpoul/gorestarter/pkg/apikey"
+	"github.com/vincentserpoul/gorestarter/pkg/health"
+	"github.com/vincentserpoul/gorestarter/pkg/metrics"
+	"github.com/vincentserpoul/gorestarter/pkg/ratelimit"
 	"github.com/vincentserpoul/gorestarter/pkg/resourceone"
 	"github.com/vincentserpoul/gorestarter/pkg/rest/mid"
+	"github.com/vincentserpoul/gorestarter/pkg/rest/renderer"
+	"github.com/vincentserpoul/gorestarter/pkg/tracing"
 )
 
-// New instanciate the http server and return a channel
-func New(httpPort int, db *sqlx.DB, logger *logrus.Logger) *http.Server {
+// Conf is the configuration of the rest server
+type Conf struct {
+	HTTPPort          int
+	MaxBodySize       int64
+	ReadTimeout       time.Duration
+	ReadHeaderTimeout time.Duration
+	WriteTimeout      time.Duration
+	IdleTimeout       time.Duration
+	Compress          *mid.CompressConf
+	Timeout           *mid.TimeoutConf
+	// RequestID accepts the request IDs of the upstream gateways, the defaults if nil
+	RequestID *mid.RequestIDConf
+	// TrustedProxies forward the client IP in X-Forwarded-For or X-Real-IP, the client
+	// IP of the logs and of the rate limits is the peer address for the others
+	TrustedProxies []*net.IPNet
+	// CORS allows browsers of other origins to call the API, nil to disable it
+	CORS *mid.CORSConf
+	// Security sets the security headers and enforces HTTPS, nil to disable it
+	Security *mid.SecurityConf
+	// JWT authenticates the /v1 routes, nil to leave them open
+	JWT *mid.JWTConf
+	// APIKeys enables apikey authentication on the /v1 routes and the /admin/apikeys
+	// endpoints, keys are cached for APIKeysCacheTTL, revoked keys are refused
+	// by the other instances after apikey.MaxRevocationDelay at most
+	APIKeys         bool
+	APIKeysCacheTTL time.Duration
+	// APIKeysBootstrap is a key authenticated as an admin of APIKeysBootstrapTenant,
+	// to create the first keys, and as a super admin without tenant
+	APIKeysBootstrap       string
+	APIKeysBootstrapTenant string
+	// Policy is checked by the routes declaring permissions, they refuse every request
+	// if it is nil, mid.AllowAllPolicy() disables the checks
+	Policy *mid.Policy
+	// Tenant resolves the tenant of the /v1 and /admin routes, nil for a single tenant
+	Tenant *mid.TenantConf
+	// IPRateLimit limits the request rate per client IP on the /v1 and /admin routes
+	// before authentication, so that floods don't reach the authentication, nil to disable it.
+	// RateLimit limits it after authentication, to key by caller, nil to disable it.
+	// RateLimitSQL shares the limits between replicas through the DB, when no store is set.
+	IPRateLimit  *mid.RateLimitConf
+	RateLimit    *mid.RateLimitConf
+	RateLimitSQL bool
+	// TLS serves HTTPS on HTTPPort, nil for plain HTTP. With a client CA, the client
+	// certificates authenticate the callers, as principals with ClientCert roles and scopes,
+	// and are required if neither JWT nor apikeys are enabled.
+	TLS        *TLSConf
+	ClientCert *mid.ClientCertConf
+	// Concurrency sheds requests over the adaptive in-flight limits, nil to disable it.
+	// The limiter state is on /admin/concurrency.
+	Concurrency *mid.ConcurrencyConf
+	// DrainPeriod is how long /readyz fails before the shutdown, so that
+	// the load balancers stop sending requests
+	DrainPeriod time.Duration
+	// HealthCacheTTL is how long the readiness checks are cached, each check is
+	// cancelled after HealthTimeout. The detailed report is on /admin/health.
+	HealthCacheTTL time.Duration
+	HealthTimeout  time.Duration
+	// Metrics exposes the Prometheus metrics on /metrics, nil to disable them
+	Metrics *MetricsConf
+	// Tracing traces the requests and their queries, nil to disable it
+	Tracing *tracing.TracerConf
+	// Capture logs the bodies of some requests, nil to disable it.
+	// The last ones captured are on /admin/captures, as a HAR file.
+	Capture *mid.CaptureConf
+	// Admin adds pprof, the runtime stats, the config and the log level to /admin,
+	// and can serve /admin on its own port, nil to disable them
+	Admin *AdminConf
+}
+
+// New builds the http server, it serves once started
+func New(conf *Conf, db *sqlx.DB, logger *logrus.Logger, opts ...Option) (*Server, error) {
+	s := &Server{
+		db:          db,
+		versions:    dbSchemaVersions{db: db},
+		drainPeriod: conf.DrainPeriod,
+		modules: []Module{
+			{Name: "resourceone", Pattern: "/v1", Router: resourceone.Router(db), DDL: &resourceone.DDL{}},
+		},
+		errs: make(chan error, 1),
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
 
 	r := chi.NewRouter()
-	r.Use(mid.RequestID())
+	r.Use(mid.RequestID(conf.RequestID))
+	if conf.Tracing != nil {
+		s.tracer = tracing.NewTracer(conf.Tracing)
+		r.Use(mid.Tracing(s.tracer))
+	}
 	r.Use(mid.Header("Content-Type", "application/json"))
-	r.Use(middleware.RealIP)
+	r.Use(mid.RealIP(conf.TrustedProxies))
 	r.Use(mid.Logger(logger))
+	var reg *metrics.Registry
+	if conf.Metrics != nil {
+		reg = newMetricsRegistry(conf.Metrics, db)
+		r.Use(mid.Metrics(mid.NewHTTPMetrics(reg)))
+	}
+	if conf.Security != nil {
+		r.Use(mid.Security(conf.Security))
+	}
+	if conf.CORS != nil {
+		if err := conf.CORS.Validate(); err != nil {
+			return nil, fmt.Errorf("New: %v", err)
+		}
+		// Browsers can send and read the request ID in the configured header
+		corsConf := *conf.CORS
+		echo := conf.RequestID.EchoHeader()
+		corsConf.AllowedHeaders = appendHeader(corsConf.AllowedHeaders, echo)
+		corsConf.ExposedHeaders = appendHeader(corsConf.ExposedHeaders, echo)
+		r.Use(mid.CORS(&corsConf))
+	}
+	r.Use(mid.Compress(conf.Compress))
+	r.Use(mid.BodyLimit(conf.MaxBodySize))
+	var capturer *mid.Capturer
+	if conf.Capture != nil {
+		capturer = mid.NewCapturer(conf.Capture)
+		r.Use(mid.Capture(capturer))
+	}
+	r.Use(mid.Head())
+	r.Use(mid.Options())
+	r.Use(mid.Timeout(conf.Timeout))
+	var limiter *mid.ConcurrencyLimiter
+	if conf.Concurrency != nil {
+		limiter = mid.NewConcurrencyLimiter(conf.Concurrency)
+		r.Use(mid.ConcurrencyLimit(limiter))
+	}
+	if conf.Policy != nil {
+		r.Use(mid.Authorization(conf.Policy))
+	}
+	r.Use(s.middlewares...)
 
-	r.Mount("/v1", resourceone.Router(db))
+	r.NotFound(renderer.NotFoundHandler)
+	r.MethodNotAllowed(renderer.MethodNotAllowedHandler)
 
-	// Resourceone related things
-	ddl := &resourceone.DDL{}
-	err := ddl.MigrateUp(context.Background(), db)
-	if err != nil {
-		log.Fatal(err)
+	if reg != nil && conf.Metrics.Port == 0 {
+		r.Get("/metrics", reg.ServeHTTP)
 	}
 
-	srv := &http.Server{
-		Addr:    fmt.Sprintf(":%d", httpPort),
-		Handler: r,
+	var auth []func(http.Handler) http.Handler
+	if conf.IPRateLimit != nil {
+		ipConf := *conf.IPRateLimit
+		s.useRateLimitStore(&ipConf, conf.RateLimitSQL, db)
+		key := ipConf.Key
+		if key == nil {
+			key = mid.KeyByIP
+		}
+		// Its own buckets, the caller limits count the requests without caller by IP too
+		ipConf.Key = func(r *http.Request) string { return "preauth:" + key(r) }
+		auth = append(auth, mid.RateLimit(&ipConf))
+	}
+	// The other authentications let the requests with a client certificate through
+	if conf.TLS != nil && conf.TLS.ClientCAFile != "" {
+		certConf := mid.ClientCertConf{}
+		if conf.ClientCert != nil {
+			certConf = *conf.ClientCert
+		}
+		// Without other authentication, the requests without certificate would be anonymous
+		certConf.Required = conf.JWT == nil && !conf.APIKeys
+		auth = append(auth, mid.ClientCertAuth(&certConf))
+	}
+	var keys *apikey.Store
+	if conf.APIKeys {
+		keys = apikey.NewStore(db, conf.APIKeysCacheTTL)
+		keys.SetBootstrapKey(conf.APIKeysBootstrap, conf.APIKeysBootstrapTenant)
+		// JWT handles the requests without apikey, if enabled
+		auth = append(auth, mid.APIKeyAuth(&mid.APIKeyConf{Keys: keys, Optional: conf.JWT != nil}))
+	}
+	if conf.JWT != nil {
+		auth = append(auth, mid.JWTAuth(conf.JWT))
+	}
+	// The tenant is resolved once the caller is known
+	var tenancy []func(http.Handler) http.Handler
+	if conf.Tenant != nil {
+		tenancy = append(tenancy, mid.Tenant(conf.Tenant))
+	}
+	// Rate limits apply once the caller is known, so they can be keyed by caller
+	var limits []func(http.Handler) http.Handler
+	if conf.RateLimit != nil {
+		s.useRateLimitStore(conf.RateLimit, conf.RateLimitSQL, db)
+		limits = append(limits, mid.RateLimit(conf.RateLimit))
+	}
+
+	// The admin routes are for the admins of their tenant, or for the super admins,
+	// bound to no tenant, when they expose every tenant
+	admin := chi.NewRouter()
+	admin.NotFound(renderer.NotFoundHandler)
+	admin.MethodNotAllowed(renderer.MethodNotAllowedHandler)
+	admin.Use(auth...)
+	tenantAdmin := admin.With(tenancy...).With(limits...).With(mid.RequireRole(apikey.AdminRole))
+	superAdmin := admin.With(limits...).With(mid.RequireUnboundRole(apikey.SuperAdminRole))
+	if keys != nil {
+		tenantAdmin.Mount("/apikeys", apikey.AdminRouter(keys))
+		s.migrations = append(s.migrations, migration{module: "apikey", ddl: &apikey.DDL{}})
+	}
+	if capturer != nil {
+		// Filtered by tenant
+		tenantAdmin.Get("/captures", capturer.ServeHTTP)
+	}
+	if limiter != nil {
+		superAdmin.Get("/concurrency", limiter.ServeHTTP)
+	}
+	if conf.Admin != nil {
+		mountDebug(superAdmin, conf.Admin, db, logger)
+	}
+
+	for _, m := range s.modules {
+		r.With(auth...).With(tenancy...).With(limits...).Mount(m.Pattern, m.Router)
+		if m.DDL != nil {
+			s.migrations = append(s.migrations, migration{module: m.Name, ddl: m.DDL})
+		}
+	}
+
+	s.health = health.NewChecker(conf.HealthCacheTTL, conf.HealthTimeout)
+	if db != nil {
+		s.health.Register("db", health.DBPing(db))
+	}
+	if len(s.migrations) > 0 {
+		s.health.Register("migrations", s.checkSchemaVersions)
+	}
+	for _, c := range s.checks {
+		s.health.Register(c.name, c.check)
 	}
+	superAdmin.Get("/health", s.healthReport)
 
-	go func() {
-		err := srv.ListenAndServe()
+	if conf.Admin != nil && conf.Admin.Port != 0 {
+		// Separate from the API, so that it can be kept private
+		ar := chi.NewRouter()
+		ar.Use(mid.RequestID(conf.RequestID))
+		ar.Use(mid.Header("Content-Type", "application/json"))
+		ar.Use(mid.RealIP(conf.TrustedProxies))
+		ar.Use(mid.Logger(logger))
+		ar.NotFound(renderer.NotFoundHandler)
+		ar.MethodNotAllowed(renderer.MethodNotAllowedHandler)
+		ar.Mount("/admin", admin)
+		s.adminSrv = &http.Server{
+			Addr:              fmt.Sprintf(":%d", conf.Admin.Port),
+			Handler:           ar,
+			ReadTimeout:       conf.ReadTimeout,
+			ReadHeaderTimeout: conf.ReadHeaderTimeout,
+			// No write timeout, the CPU profiles and traces take as long as asked
+			IdleTimeout: conf.IdleTimeout,
+		}
+	} else {
+		r.Mount("/admin", admin)
+	}
+
+	s.srv = &http.Server{
+		Addr:              fmt.Sprintf(":%d", conf.HTTPPort),
+		Handler:           s.probes(r),
+		ReadTimeout:       conf.ReadTimeout,
+		ReadHeaderTimeout: conf.ReadHeaderTimeout,
+		WriteTimeout:      conf.WriteTimeout,
+		IdleTimeout:       conf.IdleTimeout,
+	}
+
+	// Separate from the API, so that it can be kept private
+	if reg != nil && conf.Metrics.Port != 0 {
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", reg)
+		s.metricsSrv = &http.Server{
+			Addr:              fmt.Sprintf(":%d", conf.Metrics.Port),
+			Handler:           mux,
+			ReadHeaderTimeout: conf.ReadHeaderTimeout,
+			WriteTimeout:      conf.WriteTimeout,
+			IdleTimeout:       conf.IdleTimeout,
+		}
+	}
+
+	if conf.TLS != nil {
+		tlsConfig, err := newTLSConfig(conf.TLS, logger)
 		if err != nil {
-			log.Fatal(err)
+			return nil, fmt.Errorf("New: %v", err)
+		}
+		s.srv.TLSConfig = tlsConfig
+		// The admin port exposes profiles and the configuration, never in clear
+		if s.adminSrv != nil {
+			s.adminSrv.TLSConfig = tlsConfig
+		}
+	}
+
+	return s, nil
+}
+
+// appendHeader returns headers with header, added if missing, in a new slice
+func appendHeader(headers []string, header string) []string {
+	for _, h := range headers {
+		if strings.EqualFold(h, header) {
+			return headers
+		}
+	}
+	return append(append([]string{}, headers...), header)
+}
+
+// probes serves /healthz and /readyz ahead of the middlewares of api,
+// so that the probes are never shed, timed out or rate limited
+func (s *Server) probes(api *chi.Mux) http.Handler {
+	var next http.Handler = api
+	// chi can't route without routes, when /admin is served on its own port
+	if len(api.Routes()) == 0 {
+		next = http.HandlerFunc(renderer.NotFoundHandler)
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			switch r.URL.Path {
+			case "/healthz":
+				w.Header().Set("Content-Type", "application/json")
+				s.healthz(w, r)
+				return
+			case "/readyz":
+				w.Header().Set("Content-Type", "application/json")
+				s.readyz(w, r)
+				return
+			}
 		}
-	}()
+		next.ServeHTTP(w, r)
+	})
+}
+
+// useRateLimitStore sets the SQL store of conf if withSQL and no store is set
+func (s *Server) useRateLimitStore(conf *mid.RateLimitConf, withSQL bool, db *sqlx.DB) {
+	if conf.Store != nil || !withSQL {
+		return
+	}
+	conf.Store = ratelimit.NewSQLStore(db, ratelimitIdle(conf))
+	for _, m := range s.migrations {
+		if m.module == "ratelimit" {
+			return
+		}
+	}
+	s.migrations = append(s.migrations, migration{module: "ratelimit", ddl: &ratelimit.DDL{}})
+}
+
+// ratelimitIdle is how long rate limit buckets are kept unused,
+// the longest period of the limits, at least an hour
+func ratelimitIdle(conf *mid.RateLimitConf) time.Duration {
+	idle := time.Hour
+	if conf.Default.Period > idle {
+		idle = conf.Default.Period
+	}
+	for _, l := range conf.Routes {
+		if l.Period > idle {
+			idle = l.Period
+		}
+	}
 
-	return srv
+	return idle
 }
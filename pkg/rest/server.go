@@ -0,0 +1,354 @@
+package rest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-chi/render"
+	"github.com/jmoiron/sqlx"
+
+	"github.com/vincentserpoul/gorestarter/pkg/health"
+	"github.com/vincentserpoul/gorestarter/pkg/rest/renderer"
+	"github.com/vincentserpoul/gorestarter/pkg/storage"
+	"github.com/vincentserpoul/gorestarter/pkg/tracing"
+)
+
+var (
+	errDraining = errors.New("server is draining")
+	errNotReady = errors.New("not ready")
+)
+
+// Migrator creates or updates the tables of a module
+type Migrator interface {
+	MigrateUp(ctx context.Context, db *sqlx.DB) error
+	// Version is the schema version MigrateUp migrates to
+	Version() int
+}
+
+// Module is a router mounted on Pattern behind the authentication middlewares,
+// its DDL, if any, is migrated when the server starts and its schema version
+// is recorded under Name
+type Module struct {
+	Name    string
+	Pattern string
+	Router  http.Handler
+	DDL     Migrator
+}
+
+// migration is the DDL of a module
+type migration struct {
+	module string
+	ddl    Migrator
+}
+
+// Option configures the server
+type Option func(*Server)
+
+// WithListener serves on l rather than on the HTTP port
+func WithListener(l net.Listener) Option {
+	return func(s *Server) {
+		s.listener = l
+	}
+}
+
+// WithMetricsListener serves the metrics on l rather than on the metrics port,
+// when the metrics have their own port
+func WithMetricsListener(l net.Listener) Option {
+	return func(s *Server) {
+		s.metricsListener = l
+	}
+}
+
+// WithAdminListener serves /admin on l rather than on the admin port,
+// when /admin has its own port
+func WithAdminListener(l net.Listener) Option {
+	return func(s *Server) {
+		s.adminListener = l
+	}
+}
+
+// WithMiddlewares adds middlewares after the built-in ones, before the routes
+func WithMiddlewares(mws ...func(http.Handler) http.Handler) Option {
+	return func(s *Server) {
+		s.middlewares = append(s.middlewares, mws...)
+	}
+}
+
+// WithModules replaces the default modules, resourceone on /v1
+func WithModules(modules ...Module) Option {
+	return func(s *Server) {
+		s.modules = modules
+	}
+}
+
+// WithHealthCheck adds a readiness check
+func WithHealthCheck(name string, check health.Check) Option {
+	return func(s *Server) {
+		s.checks = append(s.checks, namedCheck{name: name, check: check})
+	}
+}
+
+type namedCheck struct {
+	name  string
+	check health.Check
+}
+
+// Server is the rest server, its lifecycle is Start then Shutdown
+type Server struct {
+	srv         *http.Server
+	db          *sqlx.DB
+	migrations  []migration
+	versions    schemaVersions
+	health      *health.Checker
+	drainPeriod time.Duration
+
+	// metricsSrv serves the metrics on their own port, if any
+	metricsSrv *http.Server
+	// adminSrv serves /admin on its own port, if any
+	adminSrv *http.Server
+	tracer   *tracing.Tracer
+
+	listener        net.Listener
+	metricsListener net.Listener
+	adminListener   net.Listener
+	middlewares     []func(http.Handler) http.Handler
+	modules         []Module
+	checks          []namedCheck
+
+	draining int32
+	errs     chan error
+}
+
+// Start migrates the modules DDL and serves in the background,
+// serving errors are sent on Errors
+func (s *Server) Start(ctx context.Context) error {
+	if err := s.migrate(ctx); err != nil {
+		return fmt.Errorf("Start: %v", err)
+	}
+
+	if s.listener == nil {
+		l, err := net.Listen("tcp", s.srv.Addr)
+		if err != nil {
+			return fmt.Errorf("Start: %v", err)
+		}
+		s.listener = l
+	}
+	if s.metricsSrv != nil && s.metricsListener == nil {
+		l, err := net.Listen("tcp", s.metricsSrv.Addr)
+		if err != nil {
+			s.listener.Close()
+			return fmt.Errorf("Start: %v", err)
+		}
+		s.metricsListener = l
+	}
+	if s.adminSrv != nil && s.adminListener == nil {
+		l, err := net.Listen("tcp", s.adminSrv.Addr)
+		if err != nil {
+			s.listener.Close()
+			if s.metricsListener != nil {
+				s.metricsListener.Close()
+			}
+			return fmt.Errorf("Start: %v", err)
+		}
+		s.adminListener = l
+	}
+
+	go s.serve(s.srv, s.listener)
+	if s.metricsSrv != nil {
+		go s.serve(s.metricsSrv, s.metricsListener)
+	}
+	if s.adminSrv != nil {
+		go s.serve(s.adminSrv, s.adminListener)
+	}
+
+	return nil
+}
+
+// serve serves srv on l, sending the error that stopped it on Errors
+func (s *Server) serve(srv *http.Server, l net.Listener) {
+	var err error
+	if srv.TLSConfig != nil {
+		// The certificate comes from TLSConfig.GetCertificate
+		err = srv.ServeTLS(l, "", "")
+	} else {
+		err = srv.Serve(l)
+	}
+	if err != nil && err != http.ErrServerClosed {
+		// The first error is enough to stop the server
+		select {
+		case s.errs <- fmt.Errorf("Start: %v", err):
+		default:
+		}
+	}
+}
+
+// migrate migrates the modules DDL and records their schema versions
+func (s *Server) migrate(ctx context.Context) error {
+	if len(s.migrations) == 0 {
+		return nil
+	}
+	if err := s.versions.MigrateUp(ctx); err != nil {
+		return err
+	}
+	for _, m := range s.migrations {
+		if err := m.ddl.MigrateUp(ctx, s.db); err != nil {
+			return fmt.Errorf("%s: %v", m.module, err)
+		}
+		if err := s.versions.Set(ctx, m.module, m.ddl.Version()); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// checkSchemaVersions fails when a module schema isn't the version of this server,
+// before the migration or when a newer server migrated it
+func (s *Server) checkSchemaVersions(ctx context.Context) error {
+	for _, m := range s.migrations {
+		version, err := s.versions.Get(ctx, m.module)
+		if err != nil {
+			return err
+		}
+		if version != m.ddl.Version() {
+			return fmt.Errorf("%s schema version is %d, expected %d", m.module, version, m.ddl.Version())
+		}
+	}
+
+	return nil
+}
+
+// schemaVersions records the schema version of each module
+type schemaVersions interface {
+	MigrateUp(ctx context.Context) error
+	Set(ctx context.Context, module string, version int) error
+	Get(ctx context.Context, module string) (int, error)
+}
+
+// dbSchemaVersions records the schema versions in the DB, see storage
+type dbSchemaVersions struct {
+	db *sqlx.DB
+}
+
+func (v dbSchemaVersions) MigrateUp(ctx context.Context) error {
+	return storage.MigrateSchemaVersion(ctx, v.db)
+}
+
+func (v dbSchemaVersions) Set(ctx context.Context, module string, version int) error {
+	return storage.SetSchemaVersion(ctx, v.db, module, version)
+}
+
+func (v dbSchemaVersions) Get(ctx context.Context, module string) (int, error) {
+	return storage.SchemaVersion(ctx, v.db, module)
+}
+
+// Addr returns the address the server listens on, once started
+func (s *Server) Addr() net.Addr {
+	if s.listener == nil {
+		return nil
+	}
+	return s.listener.Addr()
+}
+
+// Errors returns the errors that stopped the server
+func (s *Server) Errors() <-chan error {
+	return s.errs
+}
+
+// Ready is false once the server is draining
+func (s *Server) Ready() bool {
+	return atomic.LoadInt32(&s.draining) == 0
+}
+
+// Shutdown fails the readiness for the drain period, so that the load balancers
+// stop sending requests, then stops the server once the requests are done
+func (s *Server) Shutdown(ctx context.Context) error {
+	atomic.StoreInt32(&s.draining, 1)
+
+	t := time.NewTimer(s.drainPeriod)
+	defer t.Stop()
+	select {
+	case <-t.C:
+	case <-ctx.Done():
+	}
+
+	// Everything is stopped even if a part fails, the first error is returned
+	var first error
+	if err := s.srv.Shutdown(ctx); err != nil {
+		first = err
+	}
+	// The metrics are served until the end, to see the drain
+	if s.metricsSrv != nil {
+		if err := s.metricsSrv.Shutdown(ctx); err != nil && first == nil {
+			first = err
+		}
+	}
+	if s.adminSrv != nil {
+		if err := s.adminSrv.Shutdown(ctx); err != nil && first == nil {
+			first = err
+		}
+	}
+	// The spans of the last requests are exported
+	if s.tracer != nil {
+		if err := s.tracer.Shutdown(ctx); err != nil && first == nil {
+			first = err
+		}
+	}
+	if first != nil {
+		return fmt.Errorf("Shutdown: %v", first)
+	}
+
+	return nil
+}
+
+// ServeHTTP serves a request without listening, mainly for tests
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.srv.Handler.ServeHTTP(w, r)
+}
+
+// Health returns the readiness report, not ready while draining
+func (s *Server) Health() health.Report {
+	rep := s.health.Report()
+
+	// Draining is not cached, the load balancers must see it at once
+	shutdown := health.Result{Name: "shutdown", Healthy: s.Ready()}
+	if !shutdown.Healthy {
+		shutdown.Error = errDraining.Error()
+		rep.Healthy = false
+	}
+	rep.Checks = append([]health.Result{shutdown}, rep.Checks...)
+
+	return rep
+}
+
+// healthz answers 200 as long as the server serves
+func (s *Server) healthz(w http.ResponseWriter, r *http.Request) {
+	renderer.ResponseJSONRender(w, r, map[string]string{"status": "ok"})
+}
+
+// readyz answers 200 when the server accepts requests, 503 otherwise.
+// It is public, the failed checks are only on /admin/health.
+func (s *Server) readyz(w http.ResponseWriter, r *http.Request) {
+	rep := s.Health()
+	if !rep.Healthy {
+		if err := render.Render(w, r, renderer.ErrUnavailable(errNotReady)); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+	renderer.ResponseJSONRender(w, r, map[string]string{"status": "ready"})
+}
+
+// healthReport answers the detailed readiness report, 503 if not ready
+func (s *Server) healthReport(w http.ResponseWriter, r *http.Request) {
+	rep := s.Health()
+	if !rep.Healthy {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	renderer.ResponseJSONRender(w, r, rep)
+}
@@ -0,0 +1,461 @@
+package rest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/sirupsen/logrus"
+
+	"github.com/vincentserpoul/gorestarter/pkg/resourceone"
+	"github.com/vincentserpoul/gorestarter/pkg/rest/mid"
+)
+
+type testMigrator struct {
+	err      error
+	migrated bool
+}
+
+func (m *testMigrator) MigrateUp(ctx context.Context, db *sqlx.DB) error {
+	m.migrated = true
+	return m.err
+}
+
+func (m *testMigrator) Version() int {
+	return 3
+}
+
+// testVersions records the schema versions in memory
+type testVersions map[string]int
+
+func (v testVersions) MigrateUp(ctx context.Context) error {
+	return nil
+}
+
+func (v testVersions) Set(ctx context.Context, module string, version int) error {
+	v[module] = version
+	return nil
+}
+
+func (v testVersions) Get(ctx context.Context, module string) (int, error) {
+	return v[module], nil
+}
+
+func testServer(t *testing.T, conf *Conf, opts ...Option) *Server {
+	logger := logrus.New()
+	logger.Out = httptest.NewRecorder()
+
+	s, err := New(conf, nil, logger, opts...)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	return s
+}
+
+func TestServerLifecycle(t *testing.T) {
+	l, errL := net.Listen("tcp", "127.0.0.1:0")
+	if errL != nil {
+		t.Fatal(errL)
+	}
+
+	ddl := &testMigrator{}
+	var middlewareCalled bool
+	s := testServer(t, &Conf{DrainPeriod: 100 * time.Millisecond},
+		WithListener(l),
+		WithMiddlewares(func(h http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				middlewareCalled = true
+				h.ServeHTTP(w, r)
+			})
+		}),
+		WithModules(Module{
+			Name:    "test",
+			Pattern: "/test",
+			Router: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusTeapot)
+			}),
+			DDL: ddl,
+		}),
+	)
+	versions := testVersions{}
+	s.versions = versions
+
+	if errS := s.Start(context.Background()); errS != nil {
+		t.Fatalf("Start() error = %v", errS)
+	}
+	if !ddl.migrated || versions["test"] != ddl.Version() {
+		t.Errorf("expected the module DDL to be migrated and its version recorded, got %v", versions)
+	}
+	url := "http://" + s.Addr().String()
+
+	resp, errG := http.Get(url + "/test")
+	if errG != nil {
+		t.Fatalf("Get() error = %v", errG)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusTeapot {
+		t.Errorf("expected the module to answer %d, got %d", http.StatusTeapot, resp.StatusCode)
+	}
+	if !middlewareCalled {
+		t.Errorf("expected the middleware to be called")
+	}
+	resp, errG = http.Get(url + "/readyz")
+	if errG != nil {
+		t.Fatalf("Get() error = %v", errG)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("expected /readyz to answer %d once migrated, got %d", http.StatusOK, resp.StatusCode)
+	}
+
+	done := make(chan error)
+	go func() {
+		done <- s.Shutdown(context.Background())
+	}()
+
+	// Readiness fails first, while the server still serves
+	time.Sleep(20 * time.Millisecond)
+	resp, errG = http.Get(url + "/readyz")
+	if errG != nil {
+		t.Fatalf("Get() error = %v", errG)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("expected /readyz to answer %d while draining, got %d", http.StatusServiceUnavailable, resp.StatusCode)
+	}
+
+	if errS := <-done; errS != nil {
+		t.Errorf("Shutdown() error = %v", errS)
+	}
+	if _, errG := http.Get(url + "/readyz"); errG == nil {
+		t.Errorf("expected the server to be stopped")
+	}
+
+	select {
+	case err := <-s.Errors():
+		t.Errorf("expected no serving error, got %v", err)
+	default:
+	}
+}
+
+func TestServerShutdownError(t *testing.T) {
+	listen := func() net.Listener {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return l
+	}
+	l, ml, al := listen(), listen(), listen()
+
+	// A request outliving the shutdown deadline fails the API server shutdown
+	started, release := make(chan struct{}), make(chan struct{})
+	defer close(release)
+	module := Module{Name: "test", Pattern: "/test", Router: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+	})}
+	s := testServer(t, &Conf{Metrics: &MetricsConf{Port: 1}, Admin: &AdminConf{Port: 1}},
+		WithModules(module), WithListener(l), WithMetricsListener(ml), WithAdminListener(al))
+	if errS := s.Start(context.Background()); errS != nil {
+		t.Fatalf("Start() error = %v", errS)
+	}
+	go http.Get("http://" + l.Addr().String() + "/test")
+	<-started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if errS := s.Shutdown(ctx); errS == nil {
+		t.Errorf("expected a shutdown error")
+	}
+
+	// The other servers are stopped all the same
+	for _, addr := range []string{ml.Addr().String(), al.Addr().String()} {
+		if _, errG := http.Get("http://" + addr + "/"); errG == nil {
+			t.Errorf("expected the server on %s to be stopped", addr)
+		}
+	}
+}
+
+func TestServerHealth(t *testing.T) {
+	var failing error
+	// Without cache TTL, every probe runs the checks
+	s := testServer(t, &Conf{},
+		WithModules(),
+		WithHealthCheck("cache", func(ctx context.Context) error { return failing }),
+	)
+
+	tests := []struct {
+		name           string
+		path           string
+		failing        error
+		draining       bool
+		expectedStatus int
+	}{
+		{name: "liveness", path: "/healthz", expectedStatus: http.StatusOK},
+		{name: "ready", path: "/readyz", expectedStatus: http.StatusOK},
+		{name: "failed check", path: "/readyz", failing: errors.New("down"), expectedStatus: http.StatusServiceUnavailable},
+		{name: "draining", path: "/readyz", draining: true, expectedStatus: http.StatusServiceUnavailable},
+		{name: "liveness while draining", path: "/healthz", draining: true, expectedStatus: http.StatusOK},
+		// The report is an admin route
+		{name: "report", path: "/admin/health", expectedStatus: http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			failing = tt.failing
+			s.draining = 0
+			if tt.draining {
+				s.draining = 1
+			}
+
+			rr := httptest.NewRecorder()
+			req, _ := http.NewRequest(http.MethodGet, tt.path, nil)
+			s.ServeHTTP(rr, req)
+			if rr.Code != tt.expectedStatus {
+				t.Errorf("expected status %d, got %d: %s", tt.expectedStatus, rr.Code, rr.Body.String())
+			}
+			// The failed checks are only on the admin report
+			if tt.failing != nil && strings.Contains(rr.Body.String(), tt.failing.Error()) {
+				t.Errorf("expected a generic body, got %s", rr.Body.String())
+			}
+		})
+	}
+}
+
+func TestServerProbesFirst(t *testing.T) {
+	// Probes must answer when the middlewares shed everything
+	s := testServer(t, &Conf{}, WithModules(), WithMiddlewares(func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		})
+	}))
+
+	tests := []struct {
+		name           string
+		method         string
+		path           string
+		expectedStatus int
+	}{
+		{name: "liveness", method: http.MethodGet, path: "/healthz", expectedStatus: http.StatusOK},
+		{name: "readiness", method: http.MethodGet, path: "/readyz", expectedStatus: http.StatusOK},
+		{name: "other routes", method: http.MethodGet, path: "/v1/resourceone", expectedStatus: http.StatusServiceUnavailable},
+		{name: "other methods", method: http.MethodPost, path: "/healthz", expectedStatus: http.StatusServiceUnavailable},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			s.ServeHTTP(rr, httptest.NewRequest(tt.method, tt.path, nil))
+			if rr.Code != tt.expectedStatus {
+				t.Errorf("expected status %d, got %d", tt.expectedStatus, rr.Code)
+			}
+		})
+	}
+}
+
+func TestServerHealthReport(t *testing.T) {
+	s := testServer(t, &Conf{}, WithModules(),
+		WithHealthCheck("cache", func(ctx context.Context) error { return errors.New("down") }),
+	)
+
+	rep := s.Health()
+	if rep.Healthy || len(rep.Checks) != 2 {
+		t.Fatalf("expected an unhealthy report with 2 checks, got %+v", rep)
+	}
+	if rep.Checks[0].Name != "shutdown" || !rep.Checks[0].Healthy {
+		t.Errorf("expected the shutdown check first and healthy, got %+v", rep.Checks[0])
+	}
+	if rep.Checks[1].Name != "cache" || rep.Checks[1].Error != "down" {
+		t.Errorf("expected the failed cache check, got %+v", rep.Checks[1])
+	}
+}
+
+func TestServerStartErrors(t *testing.T) {
+	// Nothing listens on port 1
+	unreachable, errO := sqlx.Open("mysql", "internal:dev@tcp(127.0.0.1:1)/test")
+	if errO != nil {
+		t.Fatal(errO)
+	}
+
+	tests := []struct {
+		name    string
+		modules []Module
+		addr    func(t *testing.T) (int, func())
+	}{
+		{
+			name:    "migration error",
+			modules: []Module{{Name: "resourceone", Pattern: "/test", Router: http.NotFoundHandler(), DDL: &resourceone.DDL{}}},
+			addr:    func(t *testing.T) (int, func()) { return 0, func() {} },
+		},
+		{
+			name: "port in use",
+			addr: func(t *testing.T) (int, func()) {
+				l, err := net.Listen("tcp", ":0")
+				if err != nil {
+					t.Fatal(err)
+				}
+				return l.Addr().(*net.TCPAddr).Port, func() { l.Close() }
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			port, closeFn := tt.addr(t)
+			defer closeFn()
+
+			logger := logrus.New()
+			logger.Out = httptest.NewRecorder()
+			s, errN := New(&Conf{HTTPPort: port}, unreachable, logger, WithModules(tt.modules...))
+			if errN != nil {
+				t.Fatalf("New() error = %v", errN)
+			}
+			if err := s.Start(context.Background()); err == nil {
+				t.Errorf("expected Start() to fail")
+				_ = s.Shutdown(context.Background())
+			}
+		})
+	}
+}
+
+func TestNewTLSError(t *testing.T) {
+	logger := logrus.New()
+	_, err := New(&Conf{TLS: &TLSConf{CertFile: "missing.pem", KeyFile: "missing.pem"}}, nil, logger, WithModules())
+	if err == nil {
+		t.Errorf("expected New() to fail on missing certificate files")
+	}
+}
+
+func TestServerMetrics(t *testing.T) {
+	tests := []struct {
+		name         string
+		port         int
+		expectedAPI  int
+		expectedPriv bool
+	}{
+		{name: "with the API", expectedAPI: http.StatusOK},
+		{name: "separate port", port: 1, expectedAPI: http.StatusNotFound, expectedPriv: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l, errL := net.Listen("tcp", "127.0.0.1:0")
+			if errL != nil {
+				t.Fatal(errL)
+			}
+			ml, errM := net.Listen("tcp", "127.0.0.1:0")
+			if errM != nil {
+				t.Fatal(errM)
+			}
+			defer ml.Close()
+
+			s := testServer(t, &Conf{Metrics: &MetricsConf{Port: tt.port, Version: "1.0.0"}},
+				WithModules(), WithListener(l), WithMetricsListener(ml),
+			)
+			if errS := s.Start(context.Background()); errS != nil {
+				t.Fatalf("Start() error = %v", errS)
+			}
+			defer s.Shutdown(context.Background())
+
+			resp, errG := http.Get("http://" + s.Addr().String() + "/metrics")
+			if errG != nil {
+				t.Fatalf("Get() error = %v", errG)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != tt.expectedAPI {
+				t.Errorf("expected /metrics to answer %d on the API, got %d", tt.expectedAPI, resp.StatusCode)
+			}
+
+			if !tt.expectedPriv {
+				return
+			}
+			resp, errG = http.Get("http://" + ml.Addr().String() + "/metrics")
+			if errG != nil {
+				t.Fatalf("Get() error = %v", errG)
+			}
+			body, _ := ioutil.ReadAll(resp.Body)
+			resp.Body.Close()
+			// The API request was counted
+			if !strings.Contains(string(body), `http_requests_total{method="GET",route="unmatched",status="404"} 1`) ||
+				!strings.Contains(string(body), `build_info{version="1.0.0"`) {
+				t.Errorf("expected the metrics on the separate port, got\n%s", body)
+			}
+		})
+	}
+}
+
+func TestServerIPRateLimit(t *testing.T) {
+	module := Module{Name: "test", Pattern: "/test", Router: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})}
+	s := testServer(t, &Conf{
+		JWT:         &mid.JWTConf{Keys: &mid.StaticKeys{HMACSecret: []byte("secret")}},
+		IPRateLimit: &mid.RateLimitConf{Default: mid.Limit{Requests: 1, Period: time.Minute}},
+	}, WithModules(module))
+
+	// Refused before authentication once the IP is limited, whatever the IP it claims
+	for i, expected := range []int{http.StatusUnauthorized, http.StatusTooManyRequests, http.StatusTooManyRequests} {
+		rr := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/test", nil)
+		req.Header.Set("X-Forwarded-For", fmt.Sprintf("198.51.100.%d", i))
+		s.ServeHTTP(rr, req)
+		if rr.Code != expected {
+			t.Errorf("expected status %d, got %d", expected, rr.Code)
+		}
+	}
+}
+
+func TestServerIPRateLimitTrustedProxy(t *testing.T) {
+	proxies, _ := mid.ParseCIDRs([]string{"192.0.2.0/24"})
+	ipConf := &mid.RateLimitConf{Default: mid.Limit{Requests: 1, Period: time.Minute}}
+	module := Module{Name: "test", Pattern: "/test", Router: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})}
+	s := testServer(t, &Conf{
+		JWT:            &mid.JWTConf{Keys: &mid.StaticKeys{HMACSecret: []byte("secret")}},
+		IPRateLimit:    ipConf,
+		TrustedProxies: proxies,
+	}, WithModules(module))
+
+	if ipConf.Key != nil || ipConf.Store != nil {
+		t.Errorf("expected the caller configuration to be left unchanged")
+	}
+
+	// Behind the proxy, each client has its own limit
+	for i := 0; i < 2; i++ {
+		rr := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/test", nil)
+		req.Header.Set("X-Forwarded-For", fmt.Sprintf("198.51.100.%d", i))
+		s.ServeHTTP(rr, req)
+		if rr.Code != http.StatusUnauthorized {
+			t.Errorf("expected status %d, got %d", http.StatusUnauthorized, rr.Code)
+		}
+	}
+}
+
+func TestServerCORSRequestID(t *testing.T) {
+	cors := mid.DefaultCORSConf()
+	cors.AllowedOrigins = []string{"https://app.example.com"}
+	module := Module{Name: "test", Pattern: "/test", Router: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})}
+	s := testServer(t, &Conf{
+		CORS:      cors,
+		RequestID: &mid.RequestIDConf{Headers: []string{"X-Correlation-ID"}, MaxLength: 128},
+	}, WithModules(module))
+
+	rr := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/test", nil)
+	req.Header.Set("Origin", "https://app.example.com")
+	s.ServeHTTP(rr, req)
+
+	if exposed := rr.Header().Get("Access-Control-Expose-Headers"); !strings.Contains(exposed, "X-Correlation-ID") {
+		t.Errorf("expected the request ID header to be exposed, got %q", exposed)
+	}
+	if len(cors.ExposedHeaders) != len(mid.DefaultCORSConf().ExposedHeaders) {
+		t.Errorf("expected the CORS configuration to be left unchanged")
+	}
+}
@@ -0,0 +1,143 @@
+package rest
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// TLSConf is the configuration of the TLS server
+type TLSConf struct {
+	CertFile string
+	KeyFile  string
+	// ReloadInterval is how often the certificate files are checked for changes, 1m if 0
+	ReloadInterval time.Duration
+	// ClientCAFile enables mutual TLS, client certificates are verified against this CA bundle
+	ClientCAFile string
+	// ClientCertRequired rejects the connections without a client certificate,
+	// otherwise the other authentications can be used
+	ClientCertRequired bool
+}
+
+// certReloader serves a certificate, reloaded from disk when its files change
+type certReloader struct {
+	certFile string
+	keyFile  string
+	interval time.Duration
+	logger   logrus.FieldLogger
+
+	mu      sync.Mutex
+	cert    *tls.Certificate
+	modTime time.Time
+	checked time.Time
+}
+
+// newCertReloader loads the certificate, checking for changes at most once per interval
+func newCertReloader(certFile, keyFile string, interval time.Duration, logger logrus.FieldLogger) (*certReloader, error) {
+	if interval <= 0 {
+		interval = time.Minute
+	}
+	c := &certReloader{certFile: certFile, keyFile: keyFile, interval: interval, logger: logger}
+
+	modTime, err := c.modified()
+	if err != nil {
+		return nil, fmt.Errorf("newCertReloader(%s): %v", certFile, err)
+	}
+	cert, errL := tls.LoadX509KeyPair(certFile, keyFile)
+	if errL != nil {
+		return nil, fmt.Errorf("newCertReloader(%s): %v", certFile, errL)
+	}
+	c.cert, c.modTime, c.checked = &cert, modTime, time.Now()
+
+	return c, nil
+}
+
+// GetCertificate implements tls.Config.GetCertificate
+func (c *certReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	now := time.Now()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if now.Sub(c.checked) >= c.interval {
+		c.checked = now
+		if err := c.reload(); err != nil {
+			// The files may be in the middle of an update, the current certificate is kept
+			c.logger.Errorf("certReloader: %v", err)
+		}
+	}
+
+	return c.cert, nil
+}
+
+// reload loads the certificate if its files changed
+func (c *certReloader) reload() error {
+	modTime, err := c.modified()
+	if err != nil {
+		return fmt.Errorf("reload(%s): %v", c.certFile, err)
+	}
+	if modTime.Equal(c.modTime) {
+		return nil
+	}
+
+	cert, errL := tls.LoadX509KeyPair(c.certFile, c.keyFile)
+	if errL != nil {
+		return fmt.Errorf("reload(%s): %v", c.certFile, errL)
+	}
+	c.cert, c.modTime = &cert, modTime
+
+	return nil
+}
+
+// modified returns the last modification time of the certificate files
+func (c *certReloader) modified() (time.Time, error) {
+	var modTime time.Time
+	for _, file := range []string{c.certFile, c.keyFile} {
+		fi, err := os.Stat(file)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if fi.ModTime().After(modTime) {
+			modTime = fi.ModTime()
+		}
+	}
+
+	return modTime, nil
+}
+
+// newTLSConfig returns the TLS configuration of the server
+func newTLSConfig(conf *TLSConf, logger logrus.FieldLogger) (*tls.Config, error) {
+	certs, err := newCertReloader(conf.CertFile, conf.KeyFile, conf.ReloadInterval, logger)
+	if err != nil {
+		return nil, fmt.Errorf("newTLSConfig: %v", err)
+	}
+
+	tlsConfig := &tls.Config{
+		GetCertificate: certs.GetCertificate,
+		MinVersion:     tls.VersionTLS12,
+	}
+
+	if conf.ClientCAFile != "" {
+		pem, errR := ioutil.ReadFile(conf.ClientCAFile)
+		if errR != nil {
+			return nil, fmt.Errorf("newTLSConfig: %v", errR)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("newTLSConfig: no certificate in %s", conf.ClientCAFile)
+		}
+		tlsConfig.ClientCAs = pool
+		tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
+		if conf.ClientCertRequired {
+			tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+		}
+	}
+
+	return tlsConfig, nil
+}
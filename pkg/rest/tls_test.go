@@ -0,0 +1,307 @@
+package rest
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/vincentserpoul/gorestarter/pkg/rest/mid"
+)
+
+// testCert is a certificate signed by parent, or self signed if parent is nil
+type testCert struct {
+	cert    *x509.Certificate
+	key     *ecdsa.PrivateKey
+	certPEM []byte
+	keyPEM  []byte
+}
+
+func newTestCert(t *testing.T, cn string, parent *testCert, ou ...string) *testCert {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serial, _ := rand.Int(rand.Reader, big.NewInt(1<<62))
+	tmpl := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: cn, OrganizationalUnit: ou},
+		DNSNames:     []string{cn},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	signer, signerKey := tmpl, key
+	if parent == nil {
+		tmpl.IsCA = true
+		tmpl.BasicConstraintsValid = true
+	} else {
+		signer, signerKey = parent.cert, parent.key
+	}
+
+	der, errC := x509.CreateCertificate(rand.Reader, tmpl, signer, &key.PublicKey, signerKey)
+	if errC != nil {
+		t.Fatal(errC)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	keyDER, _ := x509.MarshalECPrivateKey(key)
+
+	return &testCert{
+		cert:    cert,
+		key:     key,
+		certPEM: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		keyPEM:  pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}),
+	}
+}
+
+// write writes the certificate and key files in dir, with modTime
+func (c *testCert) write(t *testing.T, dir string, modTime time.Time) (string, string) {
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	for file, data := range map[string][]byte{certFile: c.certPEM, keyFile: c.keyPEM} {
+		if err := ioutil.WriteFile(file, data, 0600); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(file, modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return certFile, keyFile
+}
+
+func TestCertReloader(t *testing.T) {
+	dir, err := ioutil.TempDir("", "certs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	first := newTestCert(t, "first.example.com", nil)
+	certFile, keyFile := first.write(t, dir, time.Now().Add(-time.Minute))
+
+	c, errN := newCertReloader(certFile, keyFile, time.Nanosecond, logrus.New())
+	if errN != nil {
+		t.Fatalf("newCertReloader() error = %v", errN)
+	}
+
+	tests := []struct {
+		name     string
+		update   func()
+		expected string
+	}{
+		{
+			name:     "initial certificate",
+			update:   func() {},
+			expected: "first.example.com",
+		},
+		{
+			name: "reloaded certificate",
+			update: func() {
+				newTestCert(t, "second.example.com", nil).write(t, dir, time.Now())
+			},
+			expected: "second.example.com",
+		},
+		{
+			name: "invalid files keep the certificate",
+			update: func() {
+				_ = ioutil.WriteFile(certFile, []byte("partial"), 0600)
+				_ = os.Chtimes(certFile, time.Now().Add(time.Minute), time.Now().Add(time.Minute))
+			},
+			expected: "second.example.com",
+		},
+		{
+			name:     "missing files keep the certificate",
+			update:   func() { _ = os.Remove(keyFile) },
+			expected: "second.example.com",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.update()
+			time.Sleep(time.Millisecond)
+
+			cert, errG := c.GetCertificate(nil)
+			if errG != nil {
+				t.Errorf("GetCertificate() error = %v", errG)
+				return
+			}
+			leaf, _ := x509.ParseCertificate(cert.Certificate[0])
+			if leaf.Subject.CommonName != tt.expected {
+				t.Errorf("expected the certificate of %s, got %s", tt.expected, leaf.Subject.CommonName)
+			}
+		})
+	}
+
+	if _, errM := newCertReloader(certFile, keyFile, 0, logrus.New()); errM == nil {
+		t.Errorf("expected an error when the files are missing")
+	}
+}
+
+func TestMutualTLS(t *testing.T) {
+	dir, err := ioutil.TempDir("", "certs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ca := newTestCert(t, "ca", nil)
+	caFile := filepath.Join(dir, "ca.pem")
+	if errW := ioutil.WriteFile(caFile, ca.certPEM, 0600); errW != nil {
+		t.Fatal(errW)
+	}
+	certFile, keyFile := newTestCert(t, "localhost", ca).write(t, dir, time.Now())
+	client := newTestCert(t, "billing-service", ca)
+	stranger := newTestCert(t, "billing-service", newTestCert(t, "other ca", nil))
+
+	tests := []struct {
+		name              string
+		clientCert        *testCert
+		required          bool
+		expectedPrincipal string
+		wantErr           bool
+	}{
+		{name: "client certificate", clientCert: client, expectedPrincipal: "mtls:billing-service"},
+		{name: "no client certificate", expectedPrincipal: ""},
+		// The client doesn't send a certificate the server CAs don't accept
+		{name: "unknown client CA", clientCert: stranger, expectedPrincipal: ""},
+		{name: "unknown client CA, required", clientCert: stranger, required: true, wantErr: true},
+		{name: "required client certificate", required: true, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tlsConfig, errT := newTLSConfig(&TLSConf{
+				CertFile:           certFile,
+				KeyFile:            keyFile,
+				ClientCAFile:       caFile,
+				ClientCertRequired: tt.required,
+			}, logrus.New())
+			if errT != nil {
+				t.Fatalf("newTLSConfig() error = %v", errT)
+			}
+
+			principal := ""
+			srv := httptest.NewUnstartedServer(mid.ClientCertAuth(nil)(
+				http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					if p := mid.GetPrincipal(r.Context()); p != nil {
+						principal = p.ID
+					}
+				}),
+			))
+			srv.TLS = tlsConfig
+			srv.StartTLS()
+			defer srv.Close()
+
+			roots := x509.NewCertPool()
+			roots.AddCert(ca.cert)
+			// With SNI, the server uses GetCertificate rather than the httptest certificate
+			clientTLS := &tls.Config{RootCAs: roots, ServerName: "localhost"}
+			if tt.clientCert != nil {
+				clientTLS.Certificates = []tls.Certificate{{
+					Certificate: [][]byte{tt.clientCert.cert.Raw},
+					PrivateKey:  tt.clientCert.key,
+				}}
+			}
+			c := &http.Client{Transport: &http.Transport{TLSClientConfig: clientTLS}}
+
+			resp, errG := c.Get(srv.URL)
+			if (errG != nil) != tt.wantErr {
+				t.Errorf("Get() error = %v, wantErr %v", errG, tt.wantErr)
+				return
+			}
+			if errG != nil {
+				return
+			}
+			resp.Body.Close()
+
+			if principal != tt.expectedPrincipal {
+				t.Errorf("expected the principal %q, got %q", tt.expectedPrincipal, principal)
+			}
+		})
+	}
+}
+
+func TestServerMutualTLSTenant(t *testing.T) {
+	dir, err := ioutil.TempDir("", "certs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ca := newTestCert(t, "ca", nil)
+	caFile := filepath.Join(dir, "ca.pem")
+	if errW := ioutil.WriteFile(caFile, ca.certPEM, 0600); errW != nil {
+		t.Fatal(errW)
+	}
+	certFile, keyFile := newTestCert(t, "localhost", ca).write(t, dir, time.Now())
+
+	l, errL := net.Listen("tcp", "127.0.0.1:0")
+	if errL != nil {
+		t.Fatal(errL)
+	}
+	var tenant string
+	module := Module{Name: "test", Pattern: "/test", Router: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tenant = mid.GetTenant(r.Context())
+	})}
+	s := testServer(t, &Conf{
+		TLS:        &TLSConf{CertFile: certFile, KeyFile: keyFile, ClientCAFile: caFile},
+		ClientCert: &mid.ClientCertConf{TenantFromOU: true},
+		Tenant:     &mid.TenantConf{Header: "X-Tenant-ID", Required: true},
+		Policy:     mid.AllowAllPolicy(),
+	}, WithModules(module), WithListener(l))
+	if errS := s.Start(context.Background()); errS != nil {
+		t.Fatalf("Start() error = %v", errS)
+	}
+	defer s.Shutdown(context.Background())
+
+	tests := []struct {
+		name           string
+		clientCert     *testCert
+		expectedStatus int
+		expectedTenant string
+	}{
+		{name: "tenant of the certificate", clientCert: newTestCert(t, "billing", ca, "acme"), expectedStatus: http.StatusOK, expectedTenant: "acme"},
+		{name: "certificate without tenant", clientCert: newTestCert(t, "billing", ca), expectedStatus: http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			roots := x509.NewCertPool()
+			roots.AddCert(ca.cert)
+			clientTLS := &tls.Config{RootCAs: roots, ServerName: "localhost", Certificates: []tls.Certificate{{
+				Certificate: [][]byte{tt.clientCert.cert.Raw},
+				PrivateKey:  tt.clientCert.key,
+			}}}
+			c := &http.Client{Transport: &http.Transport{TLSClientConfig: clientTLS}}
+
+			tenant = ""
+			resp, errG := c.Get("https://" + l.Addr().String() + "/test")
+			if errG != nil {
+				t.Fatalf("Get() error = %v", errG)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != tt.expectedStatus {
+				t.Errorf("expected status %d, got %d", tt.expectedStatus, resp.StatusCode)
+			}
+			if tenant != tt.expectedTenant {
+				t.Errorf("expected the tenant %q, got %q", tt.expectedTenant, tenant)
+			}
+		})
+	}
+}
@@ -0,0 +1,60 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// MigrateSchemaVersion creates the table of the modules schema versions
+func MigrateSchemaVersion(ctx context.Context, db *sqlx.DB) error {
+	_, errExec := db.ExecContext(
+		ctx,
+		`
+            CREATE TABLE IF NOT EXISTS schema_version (
+                module VARCHAR(100) NOT NULL,
+                version INT NOT NULL,
+                time_updated DATETIME NOT NULL DEFAULT NOW(),
+                PRIMARY KEY (module)
+            );
+    `)
+	if errExec != nil {
+		return fmt.Errorf("MigrateSchemaVersion: %v", errExec)
+	}
+
+	return nil
+}
+
+// SetSchemaVersion records the schema version of a module, a version is never lowered
+// so that older replicas can tell they are behind
+func SetSchemaVersion(ctx context.Context, db *sqlx.DB, module string, version int) error {
+	_, errExec := db.ExecContext(
+		ctx,
+		`
+            INSERT INTO schema_version (module, version) VALUES (?, ?)
+            ON DUPLICATE KEY UPDATE
+                time_updated = IF(VALUES(version) > version, NOW(), time_updated),
+                version = GREATEST(version, VALUES(version))
+    `, module, version)
+	if errExec != nil {
+		return fmt.Errorf("SetSchemaVersion(%s, %d): %v", module, version, errExec)
+	}
+
+	return nil
+}
+
+// SchemaVersion returns the schema version of a module, 0 if it was never migrated
+func SchemaVersion(ctx context.Context, db *sqlx.DB, module string) (int, error) {
+	var version int
+	err := db.GetContext(ctx, &version, `SELECT version FROM schema_version WHERE module = ?`, module)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("SchemaVersion(%s): %v", module, err)
+	}
+
+	return version, nil
+}
@@ -0,0 +1,59 @@
+package storage
+
+import (
+	"context"
+	"testing"
+)
+
+func TestSchemaVersion(t *testing.T) {
+	ctx := context.Background()
+	pool, err := NewMySQLDBConnPool(&MySQLDBConf{
+		Protocol: "tcp",
+		Host:     "127.0.0.1",
+		Port:     "3306",
+		User:     "internal",
+		Password: "dev",
+		DbName:   "test",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pool.Close()
+
+	if errM := MigrateSchemaVersion(ctx, pool); errM != nil {
+		t.Fatalf("MigrateSchemaVersion() error = %v", errM)
+	}
+	if _, errD := pool.ExecContext(ctx, `DELETE FROM schema_version WHERE module = 'test'`); errD != nil {
+		t.Fatal(errD)
+	}
+
+	tests := []struct {
+		name     string
+		set      int
+		expected int
+	}{
+		{name: "never migrated", expected: 0},
+		{name: "first version", set: 1, expected: 1},
+		{name: "upgrade", set: 3, expected: 3},
+		{name: "no downgrade", set: 2, expected: 3},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.set > 0 {
+				if errS := SetSchemaVersion(ctx, pool, "test", tt.set); errS != nil {
+					t.Errorf("SetSchemaVersion() error = %v", errS)
+					return
+				}
+			}
+			version, errV := SchemaVersion(ctx, pool, "test")
+			if errV != nil {
+				t.Errorf("SchemaVersion() error = %v", errV)
+				return
+			}
+			if version != tt.expected {
+				t.Errorf("expected version %d, got %d", tt.expected, version)
+			}
+		})
+	}
+}
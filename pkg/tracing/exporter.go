@@ -0,0 +1,262 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Exporter sends the ended spans to a tracing backend
+type Exporter interface {
+	Export(ctx context.Context, spans []SpanData) error
+	Shutdown(ctx context.Context) error
+}
+
+// writerExporter writes the spans as JSON lines
+type writerExporter struct {
+	mu sync.Mutex
+	w  io.Writer
+	c  io.Closer
+}
+
+// NewWriterExporter returns an exporter writing the spans to w as JSON lines, such as os.Stdout
+func NewWriterExporter(w io.Writer) Exporter {
+	return &writerExporter{w: w}
+}
+
+// NewFileExporter returns an exporter appending the spans to a file as JSON lines
+func NewFileExporter(path string) (Exporter, error) {
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("NewFileExporter(%s): %v", path, err)
+	}
+
+	return &writerExporter{w: f, c: f}, nil
+}
+
+// Export writes a line per span
+func (e *writerExporter) Export(ctx context.Context, spans []SpanData) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	enc := json.NewEncoder(e.w)
+	for _, s := range spans {
+		if err := enc.Encode(s); err != nil {
+			return fmt.Errorf("Export: %v", err)
+		}
+	}
+	return nil
+}
+
+// Shutdown closes the file, if any
+func (e *writerExporter) Shutdown(ctx context.Context) error {
+	if e.c == nil {
+		return nil
+	}
+	if err := e.c.Close(); err != nil {
+		return fmt.Errorf("Shutdown: %v", err)
+	}
+	return nil
+}
+
+// OTLPConf is the configuration of the OTLP exporter
+type OTLPConf struct {
+	// Endpoint is the OTLP/HTTP traces URL, such as http://localhost:4318/v1/traces
+	Endpoint string
+	// Headers are sent with each export, for authentication
+	Headers map[string]string
+	// Timeout of an export, 10s if 0
+	Timeout time.Duration
+	// Transport sends the exports, http.DefaultTransport if nil
+	Transport http.RoundTripper
+}
+
+// otlpExporter sends the spans with OTLP/HTTP, JSON encoded
+type otlpExporter struct {
+	conf   OTLPConf
+	client *http.Client
+}
+
+// NewOTLPExporter returns an exporter sending the spans to an OpenTelemetry collector,
+// with the JSON encoding of OTLP/HTTP
+func NewOTLPExporter(conf *OTLPConf) Exporter {
+	timeout := conf.Timeout
+	if timeout <= 0 {
+		timeout = 10 * time.Second
+	}
+
+	return &otlpExporter{conf: *conf, client: &http.Client{Timeout: timeout, Transport: conf.Transport}}
+}
+
+// Export posts the spans, grouped by service
+func (e *otlpExporter) Export(ctx context.Context, spans []SpanData) error {
+	body, err := json.Marshal(otlpRequest(spans))
+	if err != nil {
+		return fmt.Errorf("Export: %v", err)
+	}
+
+	req, errR := http.NewRequest(http.MethodPost, e.conf.Endpoint, bytes.NewReader(body))
+	if errR != nil {
+		return fmt.Errorf("Export: %v", errR)
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range e.conf.Headers {
+		req.Header.Set(k, v)
+	}
+
+	resp, errD := e.client.Do(req)
+	if errD != nil {
+		return fmt.Errorf("Export: %v", errD)
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(ioutil.Discard, resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("Export: %s answered %d", e.conf.Endpoint, resp.StatusCode)
+	}
+	return nil
+}
+
+// Shutdown has nothing to release
+func (e *otlpExporter) Shutdown(ctx context.Context) error {
+	return nil
+}
+
+// The OTLP JSON encoding, see opentelemetry-proto ExportTraceServiceRequest
+type otlpTraces struct {
+	ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+}
+
+type otlpResourceSpans struct {
+	Resource   otlpResource     `json:"resource"`
+	ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+}
+
+type otlpResource struct {
+	Attributes []otlpKeyValue `json:"attributes"`
+}
+
+type otlpScopeSpans struct {
+	Scope otlpScope  `json:"scope"`
+	Spans []otlpSpan `json:"spans"`
+}
+
+type otlpScope struct {
+	Name string `json:"name"`
+}
+
+type otlpSpan struct {
+	TraceID           string         `json:"traceId"`
+	SpanID            string         `json:"spanId"`
+	ParentSpanID      string         `json:"parentSpanId,omitempty"`
+	Name              string         `json:"name"`
+	Kind              int            `json:"kind"`
+	StartTimeUnixNano string         `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string         `json:"endTimeUnixNano"`
+	Attributes        []otlpKeyValue `json:"attributes,omitempty"`
+	Status            otlpStatus     `json:"status"`
+}
+
+type otlpStatus struct {
+	Code    int    `json:"code,omitempty"`
+	Message string `json:"message,omitempty"`
+}
+
+type otlpKeyValue struct {
+	Key   string                 `json:"key"`
+	Value map[string]interface{} `json:"value"`
+}
+
+// otlpSpanKinds are the OTLP values of the span kinds
+var otlpSpanKinds = map[SpanKind]int{
+	SpanKindInternal: 1,
+	SpanKindServer:   2,
+	SpanKindClient:   3,
+}
+
+// otlpStatusError is the OTLP error status code
+const otlpStatusError = 2
+
+// otlpRequest groups the spans by service
+func otlpRequest(spans []SpanData) otlpTraces {
+	byService := map[string][]otlpSpan{}
+	var services []string
+	for _, s := range spans {
+		if _, ok := byService[s.Service]; !ok {
+			services = append(services, s.Service)
+		}
+		byService[s.Service] = append(byService[s.Service], toOTLPSpan(s))
+	}
+
+	req := otlpTraces{ResourceSpans: []otlpResourceSpans{}}
+	for _, service := range services {
+		req.ResourceSpans = append(req.ResourceSpans, otlpResourceSpans{
+			Resource: otlpResource{Attributes: []otlpKeyValue{otlpAttribute("service.name", service)}},
+			ScopeSpans: []otlpScopeSpans{{
+				Scope: otlpScope{Name: "github.com/vincentserpoul/gorestarter/pkg/tracing"},
+				Spans: byService[service],
+			}},
+		})
+	}
+
+	return req
+}
+
+func toOTLPSpan(s SpanData) otlpSpan {
+	span := otlpSpan{
+		TraceID:           s.TraceID.String(),
+		SpanID:            s.SpanID.String(),
+		Name:              s.Name,
+		Kind:              otlpSpanKinds[s.Kind],
+		StartTimeUnixNano: strconv.FormatInt(s.Start.UnixNano(), 10),
+		EndTimeUnixNano:   strconv.FormatInt(s.End.UnixNano(), 10),
+	}
+	if s.ParentSpanID.IsValid() {
+		span.ParentSpanID = s.ParentSpanID.String()
+	}
+	if s.Error != "" {
+		span.Status = otlpStatus{Code: otlpStatusError, Message: s.Error}
+	}
+
+	keys := make([]string, 0, len(s.Attributes))
+	for k := range s.Attributes {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		span.Attributes = append(span.Attributes, otlpAttribute(k, s.Attributes[k]))
+	}
+
+	return span
+}
+
+// otlpAttribute encodes an attribute value, 64 bits integers are strings in OTLP JSON
+func otlpAttribute(k string, v interface{}) otlpKeyValue {
+	var value map[string]interface{}
+	switch v := v.(type) {
+	case string:
+		value = map[string]interface{}{"stringValue": v}
+	case bool:
+		value = map[string]interface{}{"boolValue": v}
+	case int:
+		value = map[string]interface{}{"intValue": strconv.Itoa(v)}
+	case int64:
+		value = map[string]interface{}{"intValue": strconv.FormatInt(v, 10)}
+	case float64:
+		value = map[string]interface{}{"doubleValue": v}
+	default:
+		value = map[string]interface{}{"stringValue": fmt.Sprint(v)}
+	}
+
+	return otlpKeyValue{Key: k, Value: value}
+}
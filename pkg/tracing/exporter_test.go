@@ -0,0 +1,112 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func testSpans() []SpanData {
+	start := time.Unix(1500000000, 0)
+	return []SpanData{{
+		Service:      "test",
+		Name:         "GET /v1/resource",
+		Kind:         SpanKindServer,
+		TraceID:      TraceID{1},
+		SpanID:       SpanID{2},
+		ParentSpanID: SpanID{3},
+		Start:        start,
+		End:          start.Add(time.Second),
+		Attributes:   map[string]interface{}{"http.status_code": 500, "http.method": "GET"},
+		Error:        "failed",
+	}}
+}
+
+func TestWriterExporter(t *testing.T) {
+	var buf bytes.Buffer
+	if err := NewWriterExporter(&buf).Export(context.Background(), testSpans()); err != nil {
+		t.Fatalf("Export() error = %v", err)
+	}
+
+	var span map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &span); err != nil {
+		t.Fatalf("expected a JSON line, got %s: %v", buf.String(), err)
+	}
+	if span["traceId"] != "01000000000000000000000000000000" || span["name"] != "GET /v1/resource" {
+		t.Errorf("unexpected span %s", buf.String())
+	}
+}
+
+func TestFileExporter(t *testing.T) {
+	dir, err := ioutil.TempDir("", "traces")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "traces.jsonl")
+
+	for i := 0; i < 2; i++ {
+		e, errN := NewFileExporter(path)
+		if errN != nil {
+			t.Fatalf("NewFileExporter() error = %v", errN)
+		}
+		if errE := e.Export(context.Background(), testSpans()); errE != nil {
+			t.Fatalf("Export() error = %v", errE)
+		}
+		if errS := e.Shutdown(context.Background()); errS != nil {
+			t.Fatalf("Shutdown() error = %v", errS)
+		}
+	}
+
+	data, _ := ioutil.ReadFile(path)
+	if lines := strings.Count(string(data), "\n"); lines != 2 {
+		t.Errorf("expected the spans to be appended, got %d lines", lines)
+	}
+
+	if _, errN := NewFileExporter(filepath.Join(dir, "missing", "traces.jsonl")); errN == nil {
+		t.Errorf("expected an error in a missing directory")
+	}
+}
+
+func TestOTLPExporter(t *testing.T) {
+	var body []byte
+	var auth string
+	status := http.StatusOK
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ = ioutil.ReadAll(r.Body)
+		auth = r.Header.Get("Authorization")
+		w.WriteHeader(status)
+	}))
+	defer srv.Close()
+
+	e := NewOTLPExporter(&OTLPConf{Endpoint: srv.URL + "/v1/traces", Headers: map[string]string{"Authorization": "Bearer x"}})
+	if err := e.Export(context.Background(), testSpans()); err != nil {
+		t.Fatalf("Export() error = %v", err)
+	}
+	if auth != "Bearer x" {
+		t.Errorf("expected the headers to be sent")
+	}
+
+	expected := `{"resourceSpans":[{"resource":{"attributes":[{"key":"service.name","value":{"stringValue":"test"}}]},` +
+		`"scopeSpans":[{"scope":{"name":"github.com/vincentserpoul/gorestarter/pkg/tracing"},"spans":[{` +
+		`"traceId":"01000000000000000000000000000000","spanId":"0200000000000000","parentSpanId":"0300000000000000",` +
+		`"name":"GET /v1/resource","kind":2,"startTimeUnixNano":"1500000000000000000","endTimeUnixNano":"1500000001000000000",` +
+		`"attributes":[{"key":"http.method","value":{"stringValue":"GET"}},{"key":"http.status_code","value":{"intValue":"500"}}],` +
+		`"status":{"code":2,"message":"failed"}}]}]}]}`
+	if string(body) != expected {
+		t.Errorf("expected\n%s\ngot\n%s", expected, body)
+	}
+
+	status = http.StatusBadRequest
+	if err := e.Export(context.Background(), testSpans()); err == nil {
+		t.Errorf("expected an error when the collector refuses the spans")
+	}
+}
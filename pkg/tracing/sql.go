@@ -0,0 +1,101 @@
+package tracing
+
+import (
+	"context"
+	"strings"
+)
+
+// StartSQL starts a client span for a query, child of the span of ctx,
+// with the sanitized query as db.statement
+func StartSQL(ctx context.Context, name, query string) (context.Context, *Span) {
+	ctx, span := StartSpan(ctx, name, SpanKindClient)
+	span.SetAttribute("db.system", "mysql")
+	if span != nil && span.sc.Sampled {
+		span.SetAttribute("db.statement", SanitizeSQL(query))
+	}
+
+	return ctx, span
+}
+
+// SanitizeSQL replaces the literals of a query with ?, so that no data leaks
+// in the traces, and collapses its whitespaces.
+// Placeholders, named parameters and quoted identifiers are kept.
+func SanitizeSQL(query string) string {
+	var b strings.Builder
+	b.Grow(len(query))
+
+	space := false
+	for i := 0; i < len(query); i++ {
+		c := query[i]
+
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			space = true
+			continue
+		case c == '\'' || c == '"':
+			i = skipString(query, i)
+			c = '?'
+		case c >= '0' && c <= '9' && !inWord(query, i):
+			for i+1 < len(query) && isNumberPart(query[i+1]) {
+				i++
+			}
+			c = '?'
+		case c == '`':
+			// Quoted identifiers are kept as is
+			end := len(query) - 1
+			if j := strings.IndexByte(query[i+1:], '`'); j >= 0 {
+				end = i + 1 + j
+			}
+			if space && b.Len() > 0 {
+				b.WriteByte(' ')
+			}
+			space = false
+			b.WriteString(query[i : end+1])
+			i = end
+			continue
+		}
+
+		if space && b.Len() > 0 {
+			b.WriteByte(' ')
+		}
+		space = false
+		b.WriteByte(c)
+	}
+
+	return b.String()
+}
+
+// skipString returns the index of the closing quote of the string starting at i,
+// quotes being escaped by a backslash or doubled
+func skipString(query string, i int) int {
+	quote := query[i]
+	for j := i + 1; j < len(query); j++ {
+		switch query[j] {
+		case '\\':
+			j++
+		case quote:
+			if j+1 < len(query) && query[j+1] == quote {
+				j++
+				continue
+			}
+			return j
+		}
+	}
+	return len(query) - 1
+}
+
+// inWord reports whether the character at i is part of an identifier or a named parameter
+func inWord(query string, i int) bool {
+	if i == 0 {
+		return false
+	}
+	c := query[i-1]
+	return c == '_' || c == ':' || c == '.' || c == '$' ||
+		(c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9')
+}
+
+// isNumberPart reports whether c continues a numeric literal, such as 1.5e10 or 0x1F
+func isNumberPart(c byte) bool {
+	return c == '.' || c == 'x' || c == 'X' ||
+		(c >= '0' && c <= '9') || (c >= 'a' && c <= 'f') || (c >= 'A' && c <= 'F')
+}
@@ -0,0 +1,53 @@
+package tracing
+
+import "testing"
+
+func TestSanitizeSQL(t *testing.T) {
+	tests := []struct {
+		name     string
+		query    string
+		expected string
+	}{
+		{
+			name: "named parameters",
+			query: `
+				SELECT r.id FROM resourceone r
+				WHERE r.tenant_id = :tenantID AND r.id = :resourceoneID
+			`,
+			expected: "SELECT r.id FROM resourceone r WHERE r.tenant_id = :tenantID AND r.id = :resourceoneID",
+		},
+		{
+			name:     "strings",
+			query:    `SELECT 1 FROM user WHERE name = 'O''Brien' AND email = "a\"b@c.com" AND x = 'it\'s'`,
+			expected: "SELECT ? FROM user WHERE name = ? AND email = ? AND x = ?",
+		},
+		{
+			name:     "numbers",
+			query:    "UPDATE t SET a = 42, b = 1.5e10, c = 0x1F, d = -3 WHERE id IN (1, 2)",
+			expected: "UPDATE t SET a = ?, b = ?, c = ?, d = -? WHERE id IN (?, ?)",
+		},
+		{
+			name:     "identifiers with digits",
+			query:    "SELECT col1, t2.col_3 FROM table2 t2 WHERE a = ?",
+			expected: "SELECT col1, t2.col_3 FROM table2 t2 WHERE a = ?",
+		},
+		{
+			name:     "quoted identifiers",
+			query:    "SELECT `select 1` FROM `table 'x'`",
+			expected: "SELECT `select 1` FROM `table 'x'`",
+		},
+		{
+			name:     "unterminated string",
+			query:    "SELECT 'secret",
+			expected: "SELECT ?",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := SanitizeSQL(tt.query); got != tt.expected {
+				t.Errorf("SanitizeSQL() = %q, expected %q", got, tt.expected)
+			}
+		})
+	}
+}
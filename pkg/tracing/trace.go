@@ -0,0 +1,427 @@
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"strings"
+	"sync"
+	"time"
+)
+
+var errTraceparent = errors.New("invalid traceparent")
+
+// TraceID identifies a trace
+type TraceID [16]byte
+
+// IsValid is false for the zero trace ID
+func (t TraceID) IsValid() bool {
+	return t != TraceID{}
+}
+
+func (t TraceID) String() string {
+	return hex.EncodeToString(t[:])
+}
+
+// MarshalText encodes the trace ID in hex
+func (t TraceID) MarshalText() ([]byte, error) {
+	return []byte(t.String()), nil
+}
+
+// SpanID identifies a span
+type SpanID [8]byte
+
+// IsValid is false for the zero span ID
+func (s SpanID) IsValid() bool {
+	return s != SpanID{}
+}
+
+func (s SpanID) String() string {
+	return hex.EncodeToString(s[:])
+}
+
+// MarshalText encodes the span ID in hex
+func (s SpanID) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+// SpanContext is the part of a span propagated to other services
+type SpanContext struct {
+	TraceID TraceID
+	SpanID  SpanID
+	Sampled bool
+}
+
+// IsValid is true when both IDs are set
+func (sc SpanContext) IsValid() bool {
+	return sc.TraceID.IsValid() && sc.SpanID.IsValid()
+}
+
+// Traceparent returns the W3C traceparent header of the span context
+func (sc SpanContext) Traceparent() string {
+	flags := 0
+	if sc.Sampled {
+		flags = 1
+	}
+	return fmt.Sprintf("00-%s-%s-%02x", sc.TraceID, sc.SpanID, flags)
+}
+
+// ParseTraceparent parses a W3C traceparent header, version-traceid-parentid-flags.
+// Future versions are accepted, as long as they start like version 00.
+func ParseTraceparent(h string) (SpanContext, error) {
+	var sc SpanContext
+
+	h = strings.TrimSpace(h)
+	if len(h) < 55 || h[2] != '-' || h[35] != '-' || h[52] != '-' ||
+		(len(h) > 55 && h[55] != '-') || (h[:2] == "00" && len(h) != 55) || h[:2] == "ff" {
+		return sc, errTraceparent
+	}
+
+	version, traceID, spanID, flags := h[:2], h[3:35], h[36:52], h[53:55]
+	var f [1]byte
+	if !isLowerHex(version) || !isLowerHex(traceID) || !isLowerHex(spanID) || !isLowerHex(flags) {
+		return sc, errTraceparent
+	}
+	_, _ = hex.Decode(sc.TraceID[:], []byte(traceID))
+	_, _ = hex.Decode(sc.SpanID[:], []byte(spanID))
+	_, _ = hex.Decode(f[:], []byte(flags))
+	if !sc.IsValid() {
+		return SpanContext{}, errTraceparent
+	}
+	sc.Sampled = f[0]&1 == 1
+
+	return sc, nil
+}
+
+// isLowerHex reports whether s only has lowercase hex digits
+func isLowerHex(s string) bool {
+	for _, c := range s {
+		if !(c >= '0' && c <= '9') && !(c >= 'a' && c <= 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+// SpanKind is the role of a span in a trace
+type SpanKind string
+
+// Span kinds
+const (
+	SpanKindInternal SpanKind = "internal"
+	SpanKindServer   SpanKind = "server"
+	SpanKindClient   SpanKind = "client"
+)
+
+// SpanData is an ended span, as exported
+type SpanData struct {
+	Service      string                 `json:"service"`
+	Name         string                 `json:"name"`
+	Kind         SpanKind               `json:"kind"`
+	TraceID      TraceID                `json:"traceId"`
+	SpanID       SpanID                 `json:"spanId"`
+	ParentSpanID SpanID                 `json:"parentSpanId"`
+	Start        time.Time              `json:"start"`
+	End          time.Time              `json:"end"`
+	Attributes   map[string]interface{} `json:"attributes,omitempty"`
+	// Error is the error status of the span, if any
+	Error string `json:"error,omitempty"`
+}
+
+// Span is an operation of a trace. A nil span can be used, it records nothing,
+// so that the code doesn't depend on tracing being enabled.
+type Span struct {
+	tracer *Tracer
+	sc     SpanContext
+
+	mu    sync.Mutex
+	data  SpanData
+	ended bool
+}
+
+// SpanContext returns the span context, to propagate it
+func (s *Span) SpanContext() SpanContext {
+	if s == nil {
+		return SpanContext{}
+	}
+	return s.sc
+}
+
+// SetAttribute records an attribute of the span, v must be a string, a bool, an int or a float
+func (s *Span) SetAttribute(k string, v interface{}) {
+	if s == nil || !s.sc.Sampled {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// The attributes of an ended span are being exported
+	if s.ended {
+		return
+	}
+	if s.data.Attributes == nil {
+		s.data.Attributes = map[string]interface{}{}
+	}
+	s.data.Attributes[k] = v
+}
+
+// SetError sets the error status of the span, if err is not nil
+func (s *Span) SetError(err error) {
+	if s == nil || err == nil || !s.sc.Sampled {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.ended {
+		s.data.Error = err.Error()
+	}
+}
+
+// End ends the span and queues it for export, if sampled
+func (s *Span) End() {
+	if s == nil {
+		return
+	}
+
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended = true
+	s.data.End = time.Now()
+	data := s.data
+	s.mu.Unlock()
+
+	if s.sc.Sampled {
+		s.tracer.enqueue(data)
+	}
+}
+
+// TracerConf is the configuration of a Tracer
+type TracerConf struct {
+	// Service is the service.name of the spans
+	Service  string
+	Exporter Exporter
+	// SampleRatio is the ratio of the traces started here that are exported, the
+	// traces started by the callers are exported if they were sampled
+	SampleRatio float64
+	// Spans are exported by batches of BatchSize, 512 if 0, or every BatchTimeout, 5s if 0.
+	// At most QueueSize spans, 2048 if 0, wait for export, the others are dropped.
+	BatchSize    int
+	BatchTimeout time.Duration
+	QueueSize    int
+}
+
+// Tracer starts spans and exports them in the background
+type Tracer struct {
+	conf TracerConf
+
+	mu     sync.RWMutex
+	closed bool
+	queue  chan SpanData
+	done   chan struct{}
+}
+
+// NewTracer returns a tracer exporting its spans until Shutdown
+func NewTracer(conf *TracerConf) *Tracer {
+	t := &Tracer{conf: *conf}
+	if t.conf.BatchSize <= 0 {
+		t.conf.BatchSize = 512
+	}
+	if t.conf.BatchTimeout <= 0 {
+		t.conf.BatchTimeout = 5 * time.Second
+	}
+	if t.conf.QueueSize <= 0 {
+		t.conf.QueueSize = 2048
+	}
+	t.queue = make(chan SpanData, t.conf.QueueSize)
+	t.done = make(chan struct{})
+
+	go t.run()
+
+	return t
+}
+
+// Start starts a span, child of the span or of the remote span context of ctx, if any
+func (t *Tracer) Start(ctx context.Context, name string, kind SpanKind) (context.Context, *Span) {
+	parent := SpanContextFromContext(ctx)
+
+	sc := SpanContext{TraceID: parent.TraceID, Sampled: parent.Sampled}
+	if !parent.IsValid() {
+		sc.TraceID = newTraceID()
+		sc.Sampled = t.sample()
+	}
+	sc.SpanID = newSpanID()
+
+	s := &Span{
+		tracer: t,
+		sc:     sc,
+		data: SpanData{
+			Service:      t.conf.Service,
+			Name:         name,
+			Kind:         kind,
+			TraceID:      sc.TraceID,
+			SpanID:       sc.SpanID,
+			ParentSpanID: parent.SpanID,
+			Start:        time.Now(),
+		},
+	}
+
+	return ContextWithSpan(ctx, s), s
+}
+
+// sample decides if a new trace is exported
+func (t *Tracer) sample() bool {
+	switch {
+	case t.conf.SampleRatio >= 1:
+		return true
+	case t.conf.SampleRatio <= 0:
+		return false
+	}
+
+	n, err := rand.Int(rand.Reader, big.NewInt(1<<53))
+	if err != nil {
+		return false
+	}
+	return float64(n.Int64())/(1<<53) < t.conf.SampleRatio
+}
+
+// enqueue queues a span for export, dropping it if the queue is full
+func (t *Tracer) enqueue(data SpanData) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	if t.closed {
+		return
+	}
+	select {
+	case t.queue <- data:
+	default:
+	}
+}
+
+// run exports the queued spans by batches
+func (t *Tracer) run() {
+	defer close(t.done)
+
+	ticker := time.NewTicker(t.conf.BatchTimeout)
+	defer ticker.Stop()
+
+	batch := make([]SpanData, 0, t.conf.BatchSize)
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		if err := t.conf.Exporter.Export(ctx, batch); err != nil {
+			log.Printf("Tracer: %v", err)
+		}
+		batch = make([]SpanData, 0, t.conf.BatchSize)
+	}
+
+	for {
+		select {
+		case data, ok := <-t.queue:
+			if !ok {
+				flush()
+				return
+			}
+			batch = append(batch, data)
+			if len(batch) >= t.conf.BatchSize {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		}
+	}
+}
+
+// Shutdown exports the queued spans and shuts the exporter down
+func (t *Tracer) Shutdown(ctx context.Context) error {
+	t.mu.Lock()
+	if !t.closed {
+		t.closed = true
+		close(t.queue)
+	}
+	t.mu.Unlock()
+
+	select {
+	case <-t.done:
+	case <-ctx.Done():
+		return fmt.Errorf("Shutdown: %v", ctx.Err())
+	}
+
+	if err := t.conf.Exporter.Shutdown(ctx); err != nil {
+		return fmt.Errorf("Shutdown: %v", err)
+	}
+
+	return nil
+}
+
+func newTraceID() TraceID {
+	var id TraceID
+	for !id.IsValid() {
+		_, _ = rand.Read(id[:])
+	}
+	return id
+}
+
+func newSpanID() SpanID {
+	var id SpanID
+	for !id.IsValid() {
+		_, _ = rand.Read(id[:])
+	}
+	return id
+}
+
+type contextKey string
+
+const (
+	contextKeySpan   = contextKey("span")
+	contextKeyRemote = contextKey("remote span context")
+)
+
+// ContextWithSpan returns a context with the span, the parent of the next spans
+func ContextWithSpan(ctx context.Context, s *Span) context.Context {
+	return context.WithValue(ctx, contextKeySpan, s)
+}
+
+// ContextWithRemoteSpanContext returns a context with the span context of a caller,
+// the parent of the next span
+func ContextWithRemoteSpanContext(ctx context.Context, sc SpanContext) context.Context {
+	return context.WithValue(ctx, contextKeyRemote, sc)
+}
+
+// SpanFromContext returns the span of the context, nil if there is none
+func SpanFromContext(ctx context.Context) *Span {
+	s, _ := ctx.Value(contextKeySpan).(*Span)
+	return s
+}
+
+// SpanContextFromContext returns the span context of the span or of the caller in the context
+func SpanContextFromContext(ctx context.Context) SpanContext {
+	if s := SpanFromContext(ctx); s != nil {
+		return s.sc
+	}
+	sc, _ := ctx.Value(contextKeyRemote).(SpanContext)
+	return sc
+}
+
+// StartSpan starts a child of the span of ctx, with the same tracer.
+// Without span in ctx, it returns a nil span, recording nothing.
+func StartSpan(ctx context.Context, name string, kind SpanKind) (context.Context, *Span) {
+	parent := SpanFromContext(ctx)
+	if parent == nil {
+		return ctx, nil
+	}
+	return parent.tracer.Start(ctx, name, kind)
+}
@@ -0,0 +1,189 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// recorder is an exporter keeping the spans in memory
+type recorder struct {
+	mu    sync.Mutex
+	spans []SpanData
+}
+
+func (rec *recorder) Export(ctx context.Context, spans []SpanData) error {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	rec.spans = append(rec.spans, spans...)
+	return nil
+}
+
+func (rec *recorder) Shutdown(ctx context.Context) error { return nil }
+
+func TestParseTraceparent(t *testing.T) {
+	tests := []struct {
+		name        string
+		header      string
+		wantErr     bool
+		wantTrace   string
+		wantSpan    string
+		wantSampled bool
+	}{
+		{
+			name:        "sampled",
+			header:      "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+			wantTrace:   "4bf92f3577b34da6a3ce929d0e0e4736",
+			wantSpan:    "00f067aa0ba902b7",
+			wantSampled: true,
+		},
+		{
+			name:      "not sampled",
+			header:    "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00",
+			wantTrace: "4bf92f3577b34da6a3ce929d0e0e4736",
+			wantSpan:  "00f067aa0ba902b7",
+		},
+		{
+			name:        "future version with more fields",
+			header:      "01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra",
+			wantTrace:   "4bf92f3577b34da6a3ce929d0e0e4736",
+			wantSpan:    "00f067aa0ba902b7",
+			wantSampled: true,
+		},
+		{name: "empty", header: "", wantErr: true},
+		{name: "version 00 too long", header: "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra", wantErr: true},
+		{name: "forbidden version", header: "ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", wantErr: true},
+		{name: "uppercase", header: "00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01", wantErr: true},
+		{name: "zero trace", header: "00-00000000000000000000000000000000-00f067aa0ba902b7-01", wantErr: true},
+		{name: "zero span", header: "00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01", wantErr: true},
+		{name: "bad separator", header: "00_4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sc, err := ParseTraceparent(tt.header)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ParseTraceparent() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if err != nil {
+				return
+			}
+			if sc.TraceID.String() != tt.wantTrace || sc.SpanID.String() != tt.wantSpan || sc.Sampled != tt.wantSampled {
+				t.Errorf("ParseTraceparent() = %s, want %s %s %t", sc.Traceparent(), tt.wantTrace, tt.wantSpan, tt.wantSampled)
+			}
+		})
+	}
+}
+
+func TestTraceparentRoundTrip(t *testing.T) {
+	sc := SpanContext{TraceID: newTraceID(), SpanID: newSpanID(), Sampled: true}
+	parsed, err := ParseTraceparent(sc.Traceparent())
+	if err != nil || parsed != sc {
+		t.Errorf("expected %+v, got %+v, %v", sc, parsed, err)
+	}
+}
+
+func TestTracer(t *testing.T) {
+	rec := &recorder{}
+	tracer := NewTracer(&TracerConf{Service: "test", Exporter: rec, SampleRatio: 1})
+
+	remote := SpanContext{TraceID: newTraceID(), SpanID: newSpanID(), Sampled: true}
+	ctx := ContextWithRemoteSpanContext(context.Background(), remote)
+
+	ctx, server := tracer.Start(ctx, "GET /v1/resource", SpanKindServer)
+	_, query := StartSQL(ctx, "resource.Select", "SELECT * FROM resource WHERE id = 42")
+	query.SetError(errors.New("deadlock"))
+	query.End()
+	server.SetAttribute("http.status_code", 500)
+	server.End()
+	server.End()
+
+	if err := tracer.Shutdown(context.Background()); err != nil {
+		t.Fatalf("Shutdown() error = %v", err)
+	}
+
+	if len(rec.spans) != 2 {
+		t.Fatalf("expected 2 spans, got %d", len(rec.spans))
+	}
+	sql, srv := rec.spans[0], rec.spans[1]
+	if srv.TraceID != remote.TraceID || srv.ParentSpanID != remote.SpanID || srv.Service != "test" {
+		t.Errorf("expected the server span to continue the remote trace, got %+v", srv)
+	}
+	if sql.TraceID != remote.TraceID || sql.ParentSpanID != srv.SpanID || sql.Kind != SpanKindClient {
+		t.Errorf("expected the query span to be a child of the server span, got %+v", sql)
+	}
+	if sql.Attributes["db.statement"] != "SELECT * FROM resource WHERE id = ?" || sql.Error != "deadlock" {
+		t.Errorf("expected the sanitized query and its error, got %+v", sql)
+	}
+	if srv.Attributes["http.status_code"] != 500 || srv.End.Before(srv.Start) {
+		t.Errorf("expected the server span attributes, got %+v", srv)
+	}
+}
+
+func TestTracerSampling(t *testing.T) {
+	tests := []struct {
+		name        string
+		ratio       float64
+		parent      *SpanContext
+		wantSampled bool
+	}{
+		{name: "always", ratio: 1, wantSampled: true},
+		{name: "never", ratio: 0, wantSampled: false},
+		{name: "sampled parent", ratio: 0, parent: &SpanContext{TraceID: newTraceID(), SpanID: newSpanID(), Sampled: true}, wantSampled: true},
+		{name: "not sampled parent", ratio: 1, parent: &SpanContext{TraceID: newTraceID(), SpanID: newSpanID()}, wantSampled: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := &recorder{}
+			tracer := NewTracer(&TracerConf{Exporter: rec, SampleRatio: tt.ratio})
+
+			ctx := context.Background()
+			if tt.parent != nil {
+				ctx = ContextWithRemoteSpanContext(ctx, *tt.parent)
+			}
+			_, span := tracer.Start(ctx, "span", SpanKindInternal)
+			span.End()
+			_ = tracer.Shutdown(context.Background())
+
+			if span.SpanContext().Sampled != tt.wantSampled || (len(rec.spans) == 1) != tt.wantSampled {
+				t.Errorf("expected sampled %t, got %t with %d exported spans",
+					tt.wantSampled, span.SpanContext().Sampled, len(rec.spans))
+			}
+		})
+	}
+}
+
+func TestTracerBatch(t *testing.T) {
+	rec := &recorder{}
+	tracer := NewTracer(&TracerConf{Exporter: rec, SampleRatio: 1, BatchSize: 1000, BatchTimeout: 10 * time.Millisecond})
+	defer tracer.Shutdown(context.Background())
+
+	_, span := tracer.Start(context.Background(), "span", SpanKindInternal)
+	span.End()
+
+	time.Sleep(50 * time.Millisecond)
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	if len(rec.spans) != 1 {
+		t.Errorf("expected the span to be exported after the batch timeout, got %d spans", len(rec.spans))
+	}
+}
+
+func TestStartSpanWithoutTracer(t *testing.T) {
+	ctx, span := StartSpan(context.Background(), "span", SpanKindInternal)
+	if span != nil || SpanFromContext(ctx) != nil {
+		t.Errorf("expected no span without tracer")
+	}
+
+	// A nil span records nothing
+	span.SetAttribute("k", "v")
+	span.SetError(errors.New("error"))
+	span.End()
+	if span.SpanContext().IsValid() {
+		t.Errorf("expected an invalid span context")
+	}
+}
@@ -1,20 +1,44 @@
 package main
 
 import (
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+
 	"github.com/spf13/viper"
+
+	"github.com/vincentserpoul/gorestarter/pkg/logging"
+	"github.com/vincentserpoul/gorestarter/pkg/rest"
+	"github.com/vincentserpoul/gorestarter/pkg/rest/mid"
 	"github.com/vincentserpoul/gorestarter/pkg/storage"
+	"github.com/vincentserpoul/gorestarter/pkg/tracing"
 )
 
 // config is the app configuration
 type config struct {
 	MySQLDBConf *storage.MySQLDBConf
-	HTTPPort    int
+	RESTConf    *rest.Conf
+	LoggingConf *logging.Conf
+	// ShutdownTimeout is how long the requests in flight have to finish, after the drain period
+	ShutdownTimeout time.Duration
 }
 
 // newConfig will retrieve the current config
-func newConfig() *config {
+func newConfig() (*config, error) {
+
+	// An optional config file overrides the defaults
+	viper.SetConfigName("config")
+	viper.AddConfigPath(".")
+	viper.AddConfigPath("/etc/gorestarter")
+	if err := viper.ReadInConfig(); err != nil {
+		if _, ok := err.(viper.ConfigFileNotFoundError); !ok {
+			return nil, fmt.Errorf("newConfig: %v", err)
+		}
+	}
 
 	viper.SetDefault("httpport", int(9002))
+	viper.SetDefault("maxbodysize", int64(1<<20))
 	viper.SetDefault("mysqldb", map[string]string{
 		"protocol": "tcp",
 		"host":     "127.0.0.1",
@@ -24,6 +48,347 @@ func newConfig() *config {
 		"dbname":   "dev",
 	})
 
+	defaultCompress := mid.DefaultCompressConf()
+	viper.SetDefault("compress", map[string]interface{}{
+		"level":        defaultCompress.Level,
+		"minsize":      defaultCompress.MinSize,
+		"contenttypes": defaultCompress.ContentTypes,
+	})
+
+	defaultCORS := mid.DefaultCORSConf()
+	viper.SetDefault("cors", map[string]interface{}{
+		"enabled":          false,
+		"allowedorigins":   []string{},
+		"allowedmethods":   []string{},
+		"allowedheaders":   defaultCORS.AllowedHeaders,
+		"exposedheaders":   defaultCORS.ExposedHeaders,
+		"allowcredentials": false,
+		"maxage":           defaultCORS.MaxAge.String(),
+	})
+
+	defaultRequestID := mid.DefaultRequestIDConf()
+	viper.SetDefault("requestid", map[string]interface{}{
+		"headers":   defaultRequestID.Headers,
+		"maxlength": defaultRequestID.MaxLength,
+	})
+
+	// Proxies, by CIDR or IP, trusted to forward the client IP in X-Forwarded-For or X-Real-IP
+	viper.SetDefault("trustedproxies", []string{})
+
+	defaultSecurity := mid.DefaultSecurityConf()
+	viper.SetDefault("security", map[string]interface{}{
+		"enabled":               true,
+		"hstsmaxage":            defaultSecurity.HSTSMaxAge.String(),
+		"hstsincludesubdomains": defaultSecurity.HSTSIncludeSubdomains,
+		"hstspreload":           defaultSecurity.HSTSPreload,
+		"contenttypeoptions":    defaultSecurity.ContentTypeOptions,
+		"referrerpolicy":        defaultSecurity.ReferrerPolicy,
+		"frameoptions":          defaultSecurity.FrameOptions,
+		"contentsecuritypolicy": defaultSecurity.ContentSecurityPolicy,
+		"trustforwardedproto":   false,
+		"enforcehttps":          "",
+		"httpsport":             443,
+	})
+
+	viper.SetDefault("timeouts", map[string]interface{}{
+		"read":       "10s",
+		"readheader": "5s",
+		"write":      "30s",
+		"idle":       "120s",
+		"route":      "20s",
+		"routes":     map[string]string{},
+		"drain":      "5s",
+		"shutdown":   "5s",
+	})
+
+	defaultCapture := mid.DefaultCaptureConf()
+	viper.SetDefault("capture", map[string]interface{}{
+		"enabled":       false,
+		"routes":        []string{},
+		"header":        defaultCapture.Header,
+		"trustedroles":  defaultCapture.TrustedRoles,
+		"maxbodysize":   defaultCapture.MaxBodySize,
+		"redactfields":  defaultCapture.RedactFields,
+		"redactheaders": defaultCapture.RedactHeaders,
+		"keep":          defaultCapture.Keep,
+	})
+
+	defaultRedact := logging.DefaultRedactConf()
+	viper.SetDefault("logging", map[string]interface{}{
+		"format":  logging.FormatText,
+		"level":   "info",
+		"outputs": []map[string]interface{}{{"type": logging.OutputStdout}},
+		"redact": map[string]interface{}{
+			"fields":      defaultRedact.Fields,
+			"queryparams": defaultRedact.QueryParams,
+		},
+		"sampling": map[string]interface{}{},
+	})
+
+	viper.SetDefault("health", map[string]interface{}{
+		"cachettl": "1s",
+		"timeout":  "2s",
+	})
+
+	viper.SetDefault("metrics", map[string]interface{}{
+		"enabled": true,
+		"port":    0,
+	})
+
+	defaultAdmin := rest.DefaultAdminConf()
+	viper.SetDefault("admin", map[string]interface{}{
+		"enabled":    true,
+		"port":       0,
+		"redactkeys": defaultAdmin.RedactKeys,
+	})
+
+	viper.SetDefault("tracing", map[string]interface{}{
+		"enabled":     false,
+		"service":     "gorestarter",
+		"exporter":    "otlp",
+		"endpoint":    "http://localhost:4318/v1/traces",
+		"headers":     map[string]string{},
+		"file":        "traces.jsonl",
+		"sampleratio": 1.0,
+	})
+
+	viper.SetDefault("tls", map[string]interface{}{
+		"enabled":            false,
+		"certfile":           "",
+		"keyfile":            "",
+		"reloadinterval":     "1m",
+		"clientcafile":       "",
+		"clientcertrequired": false,
+		"clientroles":        map[string][]string{},
+		"clientscopes":       map[string][]string{},
+		// Tenant of the clients by subject, the organizational unit of the others if clienttenantfromou
+		"clienttenants":      map[string]string{},
+		"clienttenantfromou": false,
+	})
+
+	viper.SetDefault("jwt", map[string]interface{}{
+		"enabled":    false,
+		"issuer":     "",
+		"audience":   "",
+		"hmacsecret": "",
+		"publickeys": map[string]string{},
+		"jwks":       "",
+		"jwksttl":    "1h",
+		"leeway":     "30s",
+		"realm":      "gorestarter",
+		// Tokens without exp never expire, they are refused unless allowed
+		"allowmissingexp": false,
+	})
+
+	viper.SetDefault("apikeys", map[string]interface{}{
+		"enabled": false,
+		// Revoked keys are still accepted by the other instances for 10s at most
+		"cachettl": "30s",
+		// Admin key to create the first keys with, to remove once they are
+		"bootstrapkey": "",
+		// Tenant of the bootstrap key, needed if tenancy is enabled
+		"bootstraptenant": "",
+	})
+
+	viper.SetDefault("authorization", map[string]interface{}{
+		"enabled":    false,
+		"policyfile": "",
+	})
+
+	viper.SetDefault("tenancy", map[string]interface{}{
+		"enabled":  false,
+		"header":   "X-Tenant-ID",
+		"domain":   "",
+		"claim":    "tenant",
+		"required": true,
+		// Lets unauthenticated requests choose their tenant, only behind an authenticating proxy
+		"allowanonymous": false,
+	})
+
+	viper.SetDefault("ratelimit", map[string]interface{}{
+		"enabled": false,
+		"store":   "memory",
+		"by":      "ip",
+		"limit":   "100/1m",
+		"burst":   0,
+		"routes":  map[string]string{},
+		// Limit per client IP before authentication, when limiting by apikey or user
+		"iplimit": "1000/1m",
+		"ipburst": 0,
+	})
+
+	viper.SetDefault("concurrency", map[string]interface{}{
+		"enabled":    false,
+		"retryafter": "1s",
+		"classes": map[string]interface{}{
+			mid.ConcurrencyRead: map[string]interface{}{
+				"priority": 1, "initial": 50, "min": 5, "max": 500, "target": "500ms",
+			},
+			mid.ConcurrencyWrite: map[string]interface{}{
+				"priority": 0, "initial": 20, "min": 2, "max": 200, "target": "500ms",
+			},
+		},
+		"routes": map[string]string{},
+	})
+
+	var tenantConf *mid.TenantConf
+	if viper.GetBool("tenancy.enabled") {
+		tenantConf = &mid.TenantConf{
+			Header:         viper.GetString("tenancy.header"),
+			Domain:         viper.GetString("tenancy.domain"),
+			Required:       viper.GetBool("tenancy.required"),
+			AllowAnonymous: viper.GetBool("tenancy.allowanonymous"),
+		}
+	}
+
+	requestIDConf := &mid.RequestIDConf{
+		Headers:   viper.GetStringSlice("requestid.headers"),
+		MaxLength: viper.GetInt("requestid.maxlength"),
+	}
+	// The calls to other services carry the request ID
+	transport := mid.NewRequestIDTransport(requestIDConf, nil)
+
+	jwtConf, errJ := newJWTConf(transport)
+	if errJ != nil {
+		return nil, errJ
+	}
+
+	// Disabling authorization has to be explicit
+	policy := mid.AllowAllPolicy()
+	if viper.GetBool("authorization.enabled") {
+		policy = &mid.Policy{}
+		if file := viper.GetString("authorization.policyfile"); file != "" {
+			var errP error
+			if policy, errP = mid.LoadPolicy(file); errP != nil {
+				return nil, fmt.Errorf("newConfig: %v", errP)
+			}
+		}
+	}
+
+	var tlsConf *rest.TLSConf
+	var clientCertConf *mid.ClientCertConf
+	if viper.GetBool("tls.enabled") {
+		tlsConf = &rest.TLSConf{
+			CertFile:           viper.GetString("tls.certfile"),
+			KeyFile:            viper.GetString("tls.keyfile"),
+			ReloadInterval:     viper.GetDuration("tls.reloadinterval"),
+			ClientCAFile:       viper.GetString("tls.clientcafile"),
+			ClientCertRequired: viper.GetBool("tls.clientcertrequired"),
+		}
+		clientCertConf = &mid.ClientCertConf{
+			Roles:        viper.GetStringMapStringSlice("tls.clientroles"),
+			Scopes:       viper.GetStringMapStringSlice("tls.clientscopes"),
+			Tenants:      viper.GetStringMapString("tls.clienttenants"),
+			TenantFromOU: viper.GetBool("tls.clienttenantfromou"),
+		}
+	}
+
+	var securityConf *mid.SecurityConf
+	if viper.GetBool("security.enabled") {
+		securityConf = &mid.SecurityConf{
+			HSTSMaxAge:            viper.GetDuration("security.hstsmaxage"),
+			HSTSIncludeSubdomains: viper.GetBool("security.hstsincludesubdomains"),
+			HSTSPreload:           viper.GetBool("security.hstspreload"),
+			ContentTypeOptions:    viper.GetString("security.contenttypeoptions"),
+			ReferrerPolicy:        viper.GetString("security.referrerpolicy"),
+			FrameOptions:          viper.GetString("security.frameoptions"),
+			ContentSecurityPolicy: viper.GetString("security.contentsecuritypolicy"),
+			TrustForwardedProto:   viper.GetBool("security.trustforwardedproto"),
+			EnforceHTTPS:          viper.GetString("security.enforcehttps"),
+			HTTPSPort:             viper.GetInt("security.httpsport"),
+		}
+		switch securityConf.EnforceHTTPS {
+		case "", mid.HTTPSRedirect, mid.HTTPSReject:
+		default:
+			return nil, fmt.Errorf("newConfig: unknown security.enforcehttps %s", securityConf.EnforceHTTPS)
+		}
+	}
+
+	var corsConf *mid.CORSConf
+	if viper.GetBool("cors.enabled") {
+		corsConf = &mid.CORSConf{
+			AllowedOrigins:   viper.GetStringSlice("cors.allowedorigins"),
+			AllowedMethods:   viper.GetStringSlice("cors.allowedmethods"),
+			AllowedHeaders:   viper.GetStringSlice("cors.allowedheaders"),
+			ExposedHeaders:   viper.GetStringSlice("cors.exposedheaders"),
+			AllowCredentials: viper.GetBool("cors.allowcredentials"),
+			MaxAge:           viper.GetDuration("cors.maxage"),
+		}
+		if tenantConf != nil && tenantConf.Header != "" {
+			corsConf.AllowedHeaders = append(corsConf.AllowedHeaders, tenantConf.Header)
+		}
+		if err := corsConf.Validate(); err != nil {
+			return nil, fmt.Errorf("newConfig: cors: %v", err)
+		}
+	}
+
+	trustedProxies, errP := mid.ParseCIDRs(viper.GetStringSlice("trustedproxies"))
+	if errP != nil {
+		return nil, fmt.Errorf("newConfig: trustedproxies: %v", errP)
+	}
+
+	ipRateLimitConf, rateLimitConf, errR := newRateLimitConf()
+	if errR != nil {
+		return nil, errR
+	}
+
+	concurrencyConf, errC := newConcurrencyConf()
+	if errC != nil {
+		return nil, errC
+	}
+
+	tracingConf, errT := newTracingConf(transport)
+	if errT != nil {
+		return nil, errT
+	}
+
+	loggingConf, errL := newLoggingConf()
+	if errL != nil {
+		return nil, errL
+	}
+
+	var captureConf *mid.CaptureConf
+	if viper.GetBool("capture.enabled") {
+		captureConf = &mid.CaptureConf{
+			Routes:        viper.GetStringSlice("capture.routes"),
+			Header:        viper.GetString("capture.header"),
+			TrustedRoles:  viper.GetStringSlice("capture.trustedroles"),
+			MaxBodySize:   viper.GetInt("capture.maxbodysize"),
+			RedactFields:  viper.GetStringSlice("capture.redactfields"),
+			RedactHeaders: viper.GetStringSlice("capture.redactheaders"),
+			Keep:          viper.GetInt("capture.keep"),
+		}
+	}
+
+	var metricsConf *rest.MetricsConf
+	if viper.GetBool("metrics.enabled") {
+		metricsConf = &rest.MetricsConf{
+			Port:     viper.GetInt("metrics.port"),
+			Version:  version,
+			Revision: revision,
+		}
+	}
+
+	var adminConf *rest.AdminConf
+	if viper.GetBool("admin.enabled") {
+		adminConf = &rest.AdminConf{
+			Port:       viper.GetInt("admin.port"),
+			Version:    version,
+			Revision:   revision,
+			Config:     viper.AllSettings(),
+			RedactKeys: viper.GetStringSlice("admin.redactkeys"),
+		}
+	}
+
+	routeTimeouts := make(map[string]time.Duration)
+	for route, timeout := range viper.GetStringMapString("timeouts.routes") {
+		d, err := time.ParseDuration(timeout)
+		if err != nil {
+			return nil, fmt.Errorf("newConfig: timeouts.routes %s: %v", route, err)
+		}
+		routeTimeouts[route] = d
+	}
+
 	return &config{
 		MySQLDBConf: &storage.MySQLDBConf{
 			Protocol: viper.GetStringMapString("mysqldb")["protocol"],
@@ -33,6 +398,293 @@ func newConfig() *config {
 			Password: viper.GetStringMapString("mysqldb")["password"],
 			DbName:   viper.GetStringMapString("mysqldb")["dbname"],
 		},
-		HTTPPort: viper.GetInt("httpport"),
+		RESTConf: &rest.Conf{
+			HTTPPort:          viper.GetInt("httpport"),
+			MaxBodySize:       viper.GetInt64("maxbodysize"),
+			ReadTimeout:       viper.GetDuration("timeouts.read"),
+			ReadHeaderTimeout: viper.GetDuration("timeouts.readheader"),
+			WriteTimeout:      viper.GetDuration("timeouts.write"),
+			IdleTimeout:       viper.GetDuration("timeouts.idle"),
+			Compress: &mid.CompressConf{
+				Level:        viper.GetInt("compress.level"),
+				MinSize:      viper.GetInt("compress.minsize"),
+				ContentTypes: viper.GetStringSlice("compress.contenttypes"),
+				Encoders:     defaultCompress.Encoders,
+			},
+			CORS:     corsConf,
+			Security: securityConf,
+			Timeout: &mid.TimeoutConf{
+				Default: viper.GetDuration("timeouts.route"),
+				Routes:  routeTimeouts,
+			},
+			RequestID:              requestIDConf,
+			TrustedProxies:         trustedProxies,
+			JWT:                    jwtConf,
+			APIKeys:                viper.GetBool("apikeys.enabled"),
+			APIKeysCacheTTL:        viper.GetDuration("apikeys.cachettl"),
+			APIKeysBootstrap:       viper.GetString("apikeys.bootstrapkey"),
+			APIKeysBootstrapTenant: viper.GetString("apikeys.bootstraptenant"),
+			Policy:                 policy,
+			Tenant:                 tenantConf,
+			IPRateLimit:            ipRateLimitConf,
+			RateLimit:              rateLimitConf,
+			RateLimitSQL:           viper.GetString("ratelimit.store") == "sql",
+			TLS:                    tlsConf,
+			ClientCert:             clientCertConf,
+			Concurrency:            concurrencyConf,
+			DrainPeriod:            viper.GetDuration("timeouts.drain"),
+			HealthCacheTTL:         viper.GetDuration("health.cachettl"),
+			HealthTimeout:          viper.GetDuration("health.timeout"),
+			Metrics:                metricsConf,
+			Tracing:                tracingConf,
+			Capture:                captureConf,
+			Admin:                  adminConf,
+		},
+		LoggingConf:     loggingConf,
+		ShutdownTimeout: viper.GetDuration("timeouts.shutdown"),
+	}, nil
+}
+
+// newLoggingConf returns the logging configuration
+func newLoggingConf() (*logging.Conf, error) {
+	var outputs []struct {
+		Type       string
+		Path       string
+		MaxSize    int64
+		MaxAge     string
+		MaxBackups int
+		Network    string
+		Address    string
+		Tag        string
+	}
+	if err := viper.UnmarshalKey("logging.outputs", &outputs); err != nil {
+		return nil, fmt.Errorf("newLoggingConf: %v", err)
+	}
+
+	var sampling map[string]struct {
+		Tick       string
+		First      int
+		Thereafter int
+	}
+	if err := viper.UnmarshalKey("logging.sampling", &sampling); err != nil {
+		return nil, fmt.Errorf("newLoggingConf: %v", err)
+	}
+
+	conf := &logging.Conf{
+		Format: viper.GetString("logging.format"),
+		Level:  viper.GetString("logging.level"),
+		Redact: &logging.RedactConf{
+			Fields:      viper.GetStringSlice("logging.redact.fields"),
+			QueryParams: viper.GetStringSlice("logging.redact.queryparams"),
+		},
+		Sampling: make(map[string]*logging.SamplingConf, len(sampling)),
+	}
+
+	for _, o := range outputs {
+		var maxAge time.Duration
+		if o.MaxAge != "" {
+			var err error
+			if maxAge, err = time.ParseDuration(o.MaxAge); err != nil {
+				return nil, fmt.Errorf("newLoggingConf: logging.outputs %s: %v", o.Path, err)
+			}
+		}
+		conf.Outputs = append(conf.Outputs, logging.OutputConf{
+			Type:       o.Type,
+			Path:       o.Path,
+			MaxSize:    o.MaxSize,
+			MaxAge:     maxAge,
+			MaxBackups: o.MaxBackups,
+			Network:    o.Network,
+			Address:    o.Address,
+			Tag:        o.Tag,
+		})
+	}
+
+	for level, sc := range sampling {
+		tick := time.Second
+		if sc.Tick != "" {
+			var err error
+			if tick, err = time.ParseDuration(sc.Tick); err != nil {
+				return nil, fmt.Errorf("newLoggingConf: logging.sampling %s: %v", level, err)
+			}
+		}
+		conf.Sampling[level] = &logging.SamplingConf{Tick: tick, First: sc.First, Thereafter: sc.Thereafter}
+	}
+
+	return conf, nil
+}
+
+// newTracingConf returns the tracing configuration, nil if tracing is disabled
+func newTracingConf(transport http.RoundTripper) (*tracing.TracerConf, error) {
+	if !viper.GetBool("tracing.enabled") {
+		return nil, nil
+	}
+
+	var exporter tracing.Exporter
+	switch e := viper.GetString("tracing.exporter"); e {
+	case "otlp":
+		exporter = tracing.NewOTLPExporter(&tracing.OTLPConf{
+			Endpoint:  viper.GetString("tracing.endpoint"),
+			Headers:   viper.GetStringMapString("tracing.headers"),
+			Transport: transport,
+		})
+	case "stdout":
+		exporter = tracing.NewWriterExporter(os.Stdout)
+	case "file":
+		fileExporter, err := tracing.NewFileExporter(viper.GetString("tracing.file"))
+		if err != nil {
+			return nil, fmt.Errorf("newTracingConf: %v", err)
+		}
+		exporter = fileExporter
+	default:
+		return nil, fmt.Errorf("newTracingConf: unknown tracing.exporter %s", e)
+	}
+
+	return &tracing.TracerConf{
+		Service:     viper.GetString("tracing.service"),
+		Exporter:    exporter,
+		SampleRatio: viper.GetFloat64("tracing.sampleratio"),
+	}, nil
+}
+
+// newJWTConf returns the JWT configuration, nil if JWT auth is disabled
+func newJWTConf(transport http.RoundTripper) (*mid.JWTConf, error) {
+	if !viper.GetBool("jwt.enabled") {
+		return nil, nil
+	}
+
+	var keys mid.KeySource
+	if jwks := viper.GetString("jwt.jwks"); jwks != "" {
+		j := mid.NewJWKS(jwks, viper.GetDuration("jwt.jwksttl"))
+		j.Client.Transport = transport
+		keys = j
+	} else {
+		static, err := mid.NewStaticKeys(
+			viper.GetString("jwt.hmacsecret"),
+			viper.GetStringMapString("jwt.publickeys"),
+		)
+		if err != nil {
+			return nil, fmt.Errorf("newJWTConf: %v", err)
+		}
+		if static.HMACSecret == nil && len(static.PublicKeys) == 0 {
+			return nil, fmt.Errorf("newJWTConf: jwt enabled without any key")
+		}
+		keys = static
+	}
+
+	conf := &mid.JWTConf{
+		Keys:     keys,
+		Issuer:   viper.GetString("jwt.issuer"),
+		Audience: viper.GetString("jwt.audience"),
+		Leeway:   viper.GetDuration("jwt.leeway"),
+		Realm:    viper.GetString("jwt.realm"),
+
+		AllowMissingExp: viper.GetBool("jwt.allowmissingexp"),
+	}
+	if viper.GetBool("tenancy.enabled") {
+		conf.TenantClaim = viper.GetString("tenancy.claim")
+	}
+
+	return conf, nil
+}
+
+// newRateLimitConf returns the rate limit configurations before and after authentication,
+// nil if rate limiting is disabled. Limits by IP all apply before authentication.
+func newRateLimitConf() (ipConf *mid.RateLimitConf, conf *mid.RateLimitConf, err error) {
+	if !viper.GetBool("ratelimit.enabled") {
+		return nil, nil, nil
 	}
+
+	conf = &mid.RateLimitConf{Routes: make(map[string]mid.Limit)}
+
+	by := viper.GetString("ratelimit.by")
+	switch by {
+	case "ip":
+		conf.Key = mid.KeyByIP
+	case "apikey":
+		conf.Key = mid.KeyByAPIKey
+	case "user":
+		conf.Key = mid.KeyByUser
+	default:
+		return nil, nil, fmt.Errorf("newRateLimitConf: unknown ratelimit.by %s", by)
+	}
+
+	switch store := viper.GetString("ratelimit.store"); store {
+	case "memory", "sql":
+	default:
+		return nil, nil, fmt.Errorf("newRateLimitConf: unknown ratelimit.store %s", store)
+	}
+
+	if limit := viper.GetString("ratelimit.limit"); limit != "" {
+		l, errL := mid.ParseLimit(limit)
+		if errL != nil {
+			return nil, nil, fmt.Errorf("newRateLimitConf: %v", errL)
+		}
+		l.Burst = viper.GetInt("ratelimit.burst")
+		conf.Default = l
+	}
+
+	for route, limit := range viper.GetStringMapString("ratelimit.routes") {
+		l, errL := mid.ParseLimit(limit)
+		if errL != nil {
+			return nil, nil, fmt.Errorf("newRateLimitConf: ratelimit.routes %s: %v", route, errL)
+		}
+		conf.Routes[route] = l
+	}
+
+	if by == "ip" {
+		return conf, nil, nil
+	}
+
+	if limit := viper.GetString("ratelimit.iplimit"); limit != "" {
+		l, errL := mid.ParseLimit(limit)
+		if errL != nil {
+			return nil, nil, fmt.Errorf("newRateLimitConf: ratelimit.iplimit: %v", errL)
+		}
+		l.Burst = viper.GetInt("ratelimit.ipburst")
+		ipConf = &mid.RateLimitConf{Default: l}
+	}
+
+	return ipConf, conf, nil
+}
+
+// newConcurrencyConf returns the concurrency limits configuration, nil if they are disabled
+func newConcurrencyConf() (*mid.ConcurrencyConf, error) {
+	if !viper.GetBool("concurrency.enabled") {
+		return nil, nil
+	}
+
+	var classes map[string]struct {
+		Priority int
+		Initial  int
+		Min      int
+		Max      int
+		Target   string
+		Backoff  float64
+	}
+	if err := viper.UnmarshalKey("concurrency.classes", &classes); err != nil {
+		return nil, fmt.Errorf("newConcurrencyConf: %v", err)
+	}
+
+	conf := &mid.ConcurrencyConf{
+		Classes:    make(map[string]mid.ConcurrencyClass, len(classes)),
+		Routes:     viper.GetStringMapString("concurrency.routes"),
+		RetryAfter: viper.GetDuration("concurrency.retryafter"),
+	}
+	for name, c := range classes {
+		target, err := time.ParseDuration(c.Target)
+		if err != nil {
+			return nil, fmt.Errorf("newConcurrencyConf: concurrency.classes %s: %v", name, err)
+		}
+		conf.Classes[name] = mid.ConcurrencyClass{
+			Priority: c.Priority,
+			Initial:  c.Initial,
+			Min:      c.Min,
+			Max:      c.Max,
+			Target:   target,
+			Backoff:  c.Backoff,
+		}
+	}
+
+	return conf, nil
 }
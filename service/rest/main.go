@@ -2,22 +2,31 @@ package main
 
 import (
 	"context"
-	"fmt"
 	"log"
 	"os"
 	"os/signal"
-	"time"
+	"syscall"
 
 	"github.com/sirupsen/logrus"
 
+	"github.com/vincentserpoul/gorestarter/pkg/logging"
 	"github.com/vincentserpoul/gorestarter/pkg/rest"
 	"github.com/vincentserpoul/gorestarter/pkg/storage"
 )
 
+// version and revision are set at build time, with -ldflags "-X main.version=..."
+var (
+	version  = "dev"
+	revision = ""
+)
+
 func main() {
 
 	// Get the config
-	conf := newConfig()
+	conf, errC := newConfig()
+	if errC != nil {
+		log.Fatal(errC)
+	}
 
 	// Get the MySQL conn pool
 	sqlConnPool, errQ := storage.NewMySQLDBConnPool(conf.MySQLDBConf)
@@ -26,25 +35,44 @@ func main() {
 	}
 
 	// Initiate the logger
-	logger := logrus.New()
-	// logger.Formatter = &logrus.JSONFormatter{}
+	logger, logCloser, errL := logging.New(conf.LoggingConf)
+	if errL != nil {
+		log.Fatal(errL)
+	}
+	defer logCloser.Close()
+	// The logs outside of the requests go to the same outputs
+	logrus.SetOutput(logger.Out)
+	logrus.SetFormatter(logger.Formatter)
+	logrus.SetLevel(logger.Level)
+	log.SetFlags(0)
+	log.SetOutput(logger.Writer())
 
-	srv := rest.New(conf.HTTPPort, sqlConnPool, logger)
-	fmt.Printf("Listening on port :%d\n", conf.HTTPPort)
+	srv, errN := rest.New(conf.RESTConf, sqlConnPool, logger)
+	if errN != nil {
+		logger.Fatal(errN)
+	}
+	if errS := srv.Start(context.Background()); errS != nil {
+		logger.Fatal(errS)
+	}
+	logger.Infof("Listening on port :%d", conf.RESTConf.HTTPPort)
 
-	// subscribe to SIGINT signals
+	// subscribe to SIGINT and SIGTERM signals
 	stopChan := make(chan os.Signal, 1)
-	signal.Notify(stopChan, os.Interrupt)
-	<-stopChan // wait for SIGINT
-	log.Println("Shutting down server...")
+	signal.Notify(stopChan, os.Interrupt, syscall.SIGTERM)
+	select {
+	case <-stopChan:
+	case err := <-srv.Errors():
+		logger.Fatal(err)
+	}
+	logger.Info("Shutting down server...")
 
-	// shut down gracefully, but wait no longer than 5 seconds before halting
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	// drain, then shut down gracefully, but wait no longer than the shutdown timeout before halting
+	ctx, cancel := context.WithTimeout(context.Background(), conf.RESTConf.DrainPeriod+conf.ShutdownTimeout)
 	defer cancel()
 	errS := srv.Shutdown(ctx)
 	if errS != nil {
-		log.Fatal(errS)
+		logger.Fatal(errS)
 	}
 
-	log.Println("Server gracefully stopped")
+	logger.Info("Server gracefully stopped")
 }